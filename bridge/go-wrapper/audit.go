@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// runAudit implements `audit verify`, which checks the hash chain of each
+// audit log given, exiting 1 if any is broken
+func runAudit(args []string) {
+	text := fmt.Sprintf("Usage: %s audit verify <audit_log>...", os.Args[0])
+	if len(args) < 1 || args[0] != "verify" {
+		usage(text)
+	}
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	fs.Parse(args[1:])
+	if fs.NArg() < 1 {
+		usage(text)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		entries, err := logveil.VerifyAuditLog(path)
+		if err != nil {
+			slog.Error("Audit log does not verify", "path", path, "verified_entries", entries, "error", err)
+			failed = true
+			continue
+		}
+		slog.Info("Audit log verified", "path", path, "entries", entries)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// rulesBundle returns the --rules-url bundle, cached in --rules-cache-dir
+// or else below the user cache directory, and signed by the keys in
+// --rules-public-key if set
+func rulesBundle(opts *settings) (logveil.RulesBundle, error) {
+	dir := opts.RulesBundle.CacheDir
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return logveil.RulesBundle{}, fmt.Errorf("no cache directory, set --rules-cache-dir: %v", err)
+		}
+		dir = filepath.Join(cache, "logveil", "rules")
+	}
+	bundle := logveil.RulesBundle{URL: opts.RulesBundle.URL, CacheDir: dir, SignatureURL: opts.RulesBundle.SignatureURL}
+	if opts.RulesBundle.PublicKey != "" {
+		keys, err := logveil.LoadBundleKeys(opts.RulesBundle.PublicKey)
+		if err != nil {
+			return logveil.RulesBundle{}, err
+		}
+		bundle.PublicKeys = keys
+	}
+	return bundle, nil
+}
+
+// fetchRulesBundle fetches bundle, warning when a cached copy stands in
+// for the server's
+func fetchRulesBundle(ctx context.Context, bundle logveil.RulesBundle) (logveil.RulesBundleResult, error) {
+	result, err := bundle.Fetch(ctx)
+	if err != nil {
+		return result, err
+	}
+	switch {
+	case result.Stale != nil:
+		slog.Warn("Using the cached rules bundle", "url", bundle.URL, "etag", result.ETag, "error", result.Stale)
+	case result.Changed:
+		slog.Info("Fetched rules bundle", "url", bundle.URL, "etag", result.ETag, "rules", len(result.Rules))
+	}
+	return result, nil
+}
+
+// refreshRulesBundle checks the --rules-url bundle for updates every
+// --rules-refresh until ctx is cancelled, reloading the configuration as
+// SIGHUP does whenever a new copy is fetched
+func refreshRulesBundle(ctx context.Context, opts *settings, redactor *logveil.Redactor, tokenizer *logveil.Tokenizer, tenants []servedTenant, command string, args []string) error {
+	if opts.RulesBundle.URL == "" {
+		return nil
+	}
+	interval, err := time.ParseDuration(opts.RulesBundle.Refresh)
+	if err != nil || interval < 0 {
+		return errors.New("expected a duration such as 15m, or 0")
+	}
+	if interval == 0 {
+		return nil
+	}
+	bundle, err := rulesBundle(opts)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			result, err := fetchRulesBundle(ctx, bundle)
+			if err != nil {
+				slog.Error("Rules bundle refresh failed", "url", bundle.URL, "error", err)
+				continue
+			}
+			if !result.Changed {
+				continue
+			}
+			if err := reload(redactor, tokenizer, tenants, command, args); err != nil {
+				slog.Error("Reload failed, keeping the running configuration", "error", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runCloudWatch implements `cloudwatch`, which takes CloudWatch Logs
+// subscription payloads over HTTP and writes their events to the forward
+// target, redacted, until interrupted
+func runCloudWatch(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics) error {
+	if opts.CloudWatch.Addr == "" {
+		return fmt.Errorf("--cloudwatch-addr is required, e.g. --cloudwatch-addr :8443")
+	}
+	relay, closeRelay, err := cloudWatchRelay(ctx, redactor, opts, metrics)
+	if err != nil {
+		return err
+	}
+	defer closeRelay()
+	if opts.CloudWatch.AccessKeyFile != "" || os.Getenv("LOGVEIL_CLOUDWATCH_ACCESS_KEY") != "" {
+		key, err := readSecret(opts.CloudWatch.AccessKeyFile, "LOGVEIL_CLOUDWATCH_ACCESS_KEY", "--cloudwatch-access-key-file")
+		if err != nil {
+			return fmt.Errorf("cloudwatch access key: %v", err)
+		}
+		relay.AccessKey = string(key)
+	}
+
+	listener, err := net.Listen("tcp", opts.CloudWatch.Addr)
+	if err != nil {
+		return fmt.Errorf("listen on tcp %s: %v", opts.CloudWatch.Addr, err)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Receiving cloudwatch subscription payloads", "addr", listener.Addr().String(), "access_key", relay.AccessKey != "")
+	serveErr := server.Serve(ctx, listener, relay.Handler())
+	if err := opts.writeResult(os.Stderr, relay.Stats()); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	return serveErr
+}
+
+// cloudWatchRelay returns the relay writing subscription payloads to the
+// cloudwatch forward target, and a function closing the target
+func cloudWatchRelay(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics) (*server.CloudWatchRelay, func() error, error) {
+	var sink server.CloudWatchSink
+	var err error
+	if sinkTarget(opts.CloudWatch.Forward) {
+		var lines server.Forwarder
+		lines, err = dialForward(opts, "cloudwatch", opts.CloudWatch.Forward)
+		sink = server.NewCloudWatchLineSink(lines)
+	} else {
+		sink, err = server.DialCloudWatchSink(ctx, opts.CloudWatch.Forward)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("forward to %s: %v", opts.CloudWatch.Forward, err)
+	}
+	relay := server.NewCloudWatchRelay(redactor, sink)
+	relay.Metrics = metrics
+	relay.ErrorLog = errorLog()
+	return relay, sink.Close, nil
+}
@@ -0,0 +1,1033 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// settings holds every CLI option. Values are layered in order: built-in
+// defaults, the --config file, LOGVEIL_* environment variables, and finally
+// flags given on the command line.
+type settings struct {
+	Engine          string             `yaml:"engine" toml:"engine"`
+	Timeout         string             `yaml:"timeout" toml:"timeout"`
+	Workers         int                `yaml:"workers" toml:"workers"`
+	Parallel        int                `yaml:"parallel" toml:"parallel"`
+	Mmap            bool               `yaml:"mmap" toml:"mmap"`
+	CacheFile       string             `yaml:"cache_file" toml:"cache_file"`
+	Quarantine      quarantineSettings `yaml:"quarantine" toml:"quarantine"`
+	Audit           auditSettings      `yaml:"audit" toml:"audit"`
+	Resume          bool               `yaml:"resume" toml:"resume"`
+	InPlace         bool               `yaml:"in_place" toml:"in_place"`
+	Include         []string           `yaml:"include" toml:"include"`
+	Exclude         []string           `yaml:"exclude" toml:"exclude"`
+	MaxDepth        int                `yaml:"max_depth" toml:"max_depth"`
+	Symlinks        string             `yaml:"symlinks" toml:"symlinks"`
+	Backup          bool               `yaml:"backup" toml:"backup"`
+	Python          string             `yaml:"python" toml:"python"`
+	Agent           string             `yaml:"agent" toml:"agent"`
+	AgentSHA256     string             `yaml:"agent_sha256" toml:"agent_sha256"`
+	PythonWorker    bool               `yaml:"python_worker" toml:"python_worker"`
+	NoFallback      bool               `yaml:"no_native_fallback" toml:"no_native_fallback"`
+	Sandbox         sandboxSettings    `yaml:"sandbox" toml:"sandbox"`
+	Retry           retrySettings      `yaml:"retry" toml:"retry"`
+	Rules           []string           `yaml:"rules" toml:"rules"`
+	Profile         string             `yaml:"profile" toml:"profile"`
+	RulesFile       string             `yaml:"rules_file" toml:"rules_file"`
+	RulesBundle     bundleSettings     `yaml:"rules_bundle" toml:"rules_bundle"`
+	Plugins         []string           `yaml:"plugins" toml:"plugins"`
+	Policy          policySettings     `yaml:"policy" toml:"policy"`
+	IPAllowlist     []string           `yaml:"ip_allowlist" toml:"ip_allowlist"`
+	AllowlistFile   string             `yaml:"allowlist_file" toml:"allowlist_file"`
+	DecodePayloads  bool               `yaml:"decode_payloads" toml:"decode_payloads"`
+	Overlap         string             `yaml:"overlap" toml:"overlap"`
+	Format          string             `yaml:"format" toml:"format"`
+	JSONFields      []string           `yaml:"json_fields" toml:"json_fields"`
+	JSONNested      bool               `yaml:"json_nested" toml:"json_nested"`
+	LogfmtKeys      []string           `yaml:"logfmt_keys" toml:"logfmt_keys"`
+	QueryParams     []string           `yaml:"query_params" toml:"query_params"`
+	JournalFields   []string           `yaml:"journal_fields" toml:"journal_fields"`
+	CEFKeys         []string           `yaml:"cef_keys" toml:"cef_keys"`
+	CSV             csvSettings        `yaml:"csv" toml:"csv"`
+	Multiline       multilineSettings  `yaml:"multiline" toml:"multiline"`
+	Lines           lineSettings       `yaml:"lines" toml:"lines"`
+	PreserveFormat  bool               `yaml:"preserve_format" toml:"preserve_format"`
+	FakeData        bool               `yaml:"fake_data" toml:"fake_data"`
+	FakeSeed        string             `yaml:"fake_seed" toml:"fake_seed"`
+	Placeholder     string             `yaml:"placeholder_template" toml:"placeholder_template"`
+	Tokenize        bool               `yaml:"tokenize" toml:"tokenize"`
+	TokenizeKey     string             `yaml:"tokenize_key" toml:"tokenize_key"`
+	TokenStore      string             `yaml:"token_store" toml:"token_store"`
+	SealMap         string             `yaml:"seal_map" toml:"seal_map"`
+	SealKeyFile     string             `yaml:"seal_key_file" toml:"seal_key_file"`
+	Follow          bool               `yaml:"follow" toml:"follow"`
+	FollowFromStart bool               `yaml:"follow_from_start" toml:"follow_from_start"`
+	DryRun          bool               `yaml:"dry_run" toml:"dry_run"`
+	FailOn          string             `yaml:"fail_on" toml:"fail_on"`
+	Watch           string             `yaml:"watch" toml:"watch"`
+	WatchDebounce   string             `yaml:"watch_debounce" toml:"watch_debounce"`
+	WatchState      string             `yaml:"watch_state" toml:"watch_state"`
+	OTLPEndpoint    string             `yaml:"otlp_endpoint" toml:"otlp_endpoint"`
+	NotifyURL       string             `yaml:"notify_url" toml:"notify_url"`
+	Entropy         entropySettings    `yaml:"entropy" toml:"entropy"`
+	DateShift       dateShiftSettings  `yaml:"date_shift" toml:"date_shift"`
+	Numeric         []string           `yaml:"numeric" toml:"numeric"`
+	Log             logSettings        `yaml:"log" toml:"log"`
+	Output          outputSettings     `yaml:"output" toml:"output"`
+	Server          serverSettings     `yaml:"server" toml:"server"`
+	Listen          listenSettings     `yaml:"listen" toml:"listen"`
+	Fluent          fluentSettings     `yaml:"fluent" toml:"fluent"`
+	Loki            lokiSettings       `yaml:"loki" toml:"loki"`
+	OTLP            otlpSettings       `yaml:"otlp" toml:"otlp"`
+	CloudWatch      cloudWatchSettings `yaml:"cloudwatch" toml:"cloudwatch"`
+	Lambda          lambdaSettings     `yaml:"lambda" toml:"lambda"`
+	Queue           queueSettings      `yaml:"queue" toml:"queue"`
+	SFTP            sftpSettings       `yaml:"sftp" toml:"sftp"`
+	Kafka           kafkaSettings      `yaml:"kafka" toml:"kafka"`
+	K8s             k8sSettings        `yaml:"k8s" toml:"k8s"`
+	Splunk          splunkSettings     `yaml:"splunk" toml:"splunk"`
+	Elasticsearch   bulkSettings       `yaml:"elasticsearch" toml:"elasticsearch"`
+}
+
+// sandboxSettings limits each Python agent process
+type sandboxSettings struct {
+	// CPUTime is a Go duration
+	CPUTime string `yaml:"cpu_time" toml:"cpu_time"`
+	// Memory is a size in bytes, optionally with a K, M or G suffix
+	Memory          string `yaml:"memory" toml:"memory"`
+	OpenFiles       int    `yaml:"open_files" toml:"open_files"`
+	NoNewPrivileges bool   `yaml:"no_new_privileges" toml:"no_new_privileges"`
+	Seccomp         bool   `yaml:"seccomp" toml:"seccomp"`
+}
+
+// retrySettings repeats agent runs that failed transiently
+type retrySettings struct {
+	// Attempts counts the first run
+	Attempts int `yaml:"attempts" toml:"attempts"`
+	// Backoff is a Go duration, doubled after each retry
+	Backoff string `yaml:"backoff" toml:"backoff"`
+}
+
+// multilineSettings groups continuation lines into records
+type multilineSettings struct {
+	// Start matches the first line of a record
+	Start string `yaml:"start" toml:"start"`
+	// MaxLines caps the lines in one record
+	MaxLines int `yaml:"max_lines" toml:"max_lines"`
+}
+
+// lineSettings bounds very long lines
+type lineSettings struct {
+	// MaxSize is a size in bytes, optionally with a K, M or G suffix;
+	// empty reads lines of any length whole
+	MaxSize string `yaml:"max_size" toml:"max_size"`
+	// Policy is split or truncate
+	Policy string `yaml:"policy" toml:"policy"`
+}
+
+// csvSettings selects the columns the csv format redacts
+type csvSettings struct {
+	// Columns are header names or 1-based indexes
+	Columns []string `yaml:"columns" toml:"columns"`
+	// NoHeader treats the first row as data
+	NoHeader bool `yaml:"no_header" toml:"no_header"`
+}
+
+// entropySettings tunes the high_entropy detector
+type entropySettings struct {
+	// Threshold is the minimum Shannon entropy in bits per character
+	Threshold float64 `yaml:"threshold" toml:"threshold"`
+	// MinLength is the shortest token considered
+	MinLength int `yaml:"min_length" toml:"min_length"`
+	// Allowlist holds patterns for benign tokens that are never redacted
+	Allowlist []string `yaml:"allowlist" toml:"allowlist"`
+}
+
+// dateShiftSettings move every date in the output by one random offset
+type dateShiftSettings struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// MaxDays is the largest shift either way
+	MaxDays int `yaml:"max_days" toml:"max_days"`
+	// Seed makes runs sharing it shift alike
+	Seed string `yaml:"seed" toml:"seed"`
+}
+
+// quarantineSettings set aside the inputs a batch fails to redact
+type quarantineSettings struct {
+	// Dir receives failed inputs with an error report beside each
+	Dir string `yaml:"dir" toml:"dir"`
+	// Move moves rather than copies them
+	Move bool `yaml:"move" toml:"move"`
+}
+
+// auditSettings configure the audit log of redactions run
+type auditSettings struct {
+	// Log is the hash-chained JSON Lines file entries are appended to
+	Log string `yaml:"log" toml:"log"`
+	// Actor is who entries name as running the redactions outside serve
+	// mode, by default the OS user and host
+	Actor string `yaml:"actor" toml:"actor"`
+}
+
+// bundleSettings fetch custom rules published at a URL
+type bundleSettings struct {
+	// URL is the https URL of a rules file
+	URL string `yaml:"url" toml:"url"`
+	// Refresh is how often the long-running modes check it for updates, as
+	// a duration; 0 checks only at startup and on SIGHUP
+	Refresh string `yaml:"refresh" toml:"refresh"`
+	// CacheDir keeps the last copy fetched, for revalidation and for when
+	// the URL cannot be reached
+	CacheDir string `yaml:"cache_dir" toml:"cache_dir"`
+	// PublicKey is a PEM file of the keys trusted to sign the bundle;
+	// when set, unsigned and tampered bundles are rejected
+	PublicKey string `yaml:"public_key" toml:"public_key"`
+	// SignatureURL is where the detached signature is, by default URL
+	// with .sig appended
+	SignatureURL string `yaml:"signature_url" toml:"signature_url"`
+}
+
+// policySettings delegates redaction decisions to an OPA policy
+type policySettings struct {
+	// Paths are Rego files, data files or directories of them
+	Paths []string `yaml:"paths" toml:"paths"`
+	// Query is the decision evaluated, data.logveil.decision by default
+	Query string `yaml:"query" toml:"query"`
+}
+
+// logSettings controls the diagnostics written to stderr
+type logSettings struct {
+	// Level is the least severe level logged: debug, info, warn or error
+	Level string `yaml:"level" toml:"level"`
+	// Format is text or json
+	Format string `yaml:"format" toml:"format"`
+}
+
+// outputSettings controls how results are reported
+type outputSettings struct {
+	// Pretty indents the JSON result
+	Pretty bool `yaml:"pretty" toml:"pretty"`
+	// SummaryFile receives the JSON result instead of stdout/stderr
+	SummaryFile string `yaml:"summary_file" toml:"summary_file"`
+	// Compress is the codec for redacted output: gzip, zstd or bzip2
+	Compress string `yaml:"compress" toml:"compress"`
+	// Report is the format of the dry-run findings report: json, sarif,
+	// html or csv
+	Report string `yaml:"report" toml:"report"`
+}
+
+// serverSettings configures the serve subcommand
+type serverSettings struct {
+	// GRPC is the listen address of the streaming gRPC service
+	GRPC string `yaml:"grpc" toml:"grpc"`
+	// Metrics is the listen address of the Prometheus /metrics endpoint in
+	// the long-running modes; empty disables it
+	Metrics string `yaml:"metrics" toml:"metrics"`
+	// Pprof serves runtime profiles on the metrics address at /debug/pprof/
+	// to clients presenting the bearer token in PprofTokenFile, or in
+	// $LOGVEIL_PPROF_TOKEN
+	Pprof          bool   `yaml:"pprof" toml:"pprof"`
+	PprofTokenFile string `yaml:"pprof_token_file" toml:"pprof_token_file"`
+	// HTTP is the listen address of the job API, which redacts uploaded
+	// files in the background; empty disables it
+	HTTP string `yaml:"http" toml:"http"`
+	// JobDir holds uploads and results; empty uses a temporary directory
+	// removed on exit
+	JobDir string `yaml:"job_dir" toml:"job_dir"`
+	// JobTTL is a Go duration for which finished jobs are kept
+	JobTTL string `yaml:"job_ttl" toml:"job_ttl"`
+	// JobMaxSize is the largest upload, in bytes optionally with a K, M or
+	// G suffix; empty is unlimited
+	JobMaxSize string `yaml:"job_max_size" toml:"job_max_size"`
+	// APIKeysFile holds "<client> <key>" lines; when set, gRPC and job API
+	// clients must present one of the keys as a bearer token
+	APIKeysFile string `yaml:"api_keys_file" toml:"api_keys_file"`
+	// TLSCert and TLSKey serve gRPC and the job API over TLS, and
+	// TLSClientCA requires client certificates issued by its CAs
+	TLSCert     string `yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey      string `yaml:"tls_key" toml:"tls_key"`
+	TLSClientCA string `yaml:"tls_client_ca" toml:"tls_client_ca"`
+	// ClientRate, ClientBurst and ClientMaxJobs limit every client alike
+	// (zero is unlimited), and Clients sets limits of its own for the
+	// clients it names
+	ClientRate    float64                        `yaml:"client_rate" toml:"client_rate"`
+	ClientBurst   int                            `yaml:"client_burst" toml:"client_burst"`
+	ClientMaxJobs int                            `yaml:"client_max_jobs" toml:"client_max_jobs"`
+	Clients       map[string]clientLimitSettings `yaml:"clients" toml:"clients"`
+	// Tenants are namespaces of serve mode, keyed by name, each selected
+	// by the clients in it
+	Tenants map[string]tenantSettings `yaml:"tenants" toml:"tenants"`
+}
+
+// tenantSettings configure one serve mode tenant. Its rules, rules file
+// and policy take the place of the server-wide ones; everything else is
+// shared.
+type tenantSettings struct {
+	// Clients are the client names, from the API keys file or client
+	// certificates, that redact as the tenant
+	Clients   []string `yaml:"clients" toml:"clients"`
+	Rules     []string `yaml:"rules" toml:"rules"`
+	RulesFile string   `yaml:"rules_file" toml:"rules_file"`
+	Policy    []string `yaml:"policy" toml:"policy"`
+	// TokenStore is the tenant's own token store, and AuditLog its own
+	// audit log, each required when the server has one
+	TokenStore string `yaml:"token_store" toml:"token_store"`
+	AuditLog   string `yaml:"audit_log" toml:"audit_log"`
+	// Limits cap the tenant's clients together, on top of their own
+	Limits clientLimitSettings `yaml:"limits" toml:"limits"`
+}
+
+// clientLimitSettings are the limits of one serve mode client; zero fields
+// take the server-wide value
+type clientLimitSettings struct {
+	// Rate is requests, and lines streamed over gRPC, per second
+	Rate    float64 `yaml:"rate" toml:"rate"`
+	Burst   int     `yaml:"burst" toml:"burst"`
+	MaxJobs int     `yaml:"max_jobs" toml:"max_jobs"`
+	// MaxSize is the largest job upload, in bytes optionally with a K, M
+	// or G suffix
+	MaxSize string `yaml:"max_size" toml:"max_size"`
+}
+
+// listenSettings configures the listen subcommand
+type listenSettings struct {
+	// Syslog is the address syslog messages are received on
+	Syslog string `yaml:"syslog" toml:"syslog"`
+	// Protocol is udp, tcp or both
+	Protocol string `yaml:"protocol" toml:"protocol"`
+	// Forward is udp://host:port, tcp://host:port, a file or "-"
+	Forward string `yaml:"forward" toml:"forward"`
+}
+
+// fluentSettings configures the fluent subcommand
+type fluentSettings struct {
+	// Addr is the address the forward protocol is received on
+	Addr string `yaml:"addr" toml:"addr"`
+	// Forward is fluent://host:port, udp://host:port, tcp://host:port, a
+	// file or "-"
+	Forward string `yaml:"forward" toml:"forward"`
+}
+
+// lokiSettings configures the loki subcommand
+type lokiSettings struct {
+	// Addr is the address the push API is served on
+	Addr string `yaml:"addr" toml:"addr"`
+	// Forward is the URL of a Loki, udp://host:port, tcp://host:port, a
+	// file or "-"
+	Forward string `yaml:"forward" toml:"forward"`
+}
+
+// otlpSettings configures the otlp subcommand, which receives logs over
+// OTLP; OTLPEndpoint is where logveil's own telemetry goes
+type otlpSettings struct {
+	// GRPC and HTTP are the addresses OTLP/gRPC and OTLP/HTTP are received
+	// on; either may be empty, not both
+	GRPC string `yaml:"grpc" toml:"grpc"`
+	HTTP string `yaml:"http" toml:"http"`
+	// Forward is grpc://host:port, grpcs://host:port, an OTLP/HTTP URL,
+	// udp://host:port, tcp://host:port, a file or "-"
+	Forward string `yaml:"forward" toml:"forward"`
+}
+
+// cloudWatchSettings configures the cloudwatch subcommand
+type cloudWatchSettings struct {
+	// Addr is the address subscription payloads are received on
+	Addr string `yaml:"addr" toml:"addr"`
+	// Forward is cloudwatch:<log group>, s3://bucket/prefix,
+	// udp://host:port, tcp://host:port, a file or "-"
+	Forward string `yaml:"forward" toml:"forward"`
+	// AccessKeyFile holds the access key Firehose deliveries must carry,
+	// read from $LOGVEIL_CLOUDWATCH_ACCESS_KEY when empty; with neither,
+	// deliveries are taken without one
+	AccessKeyFile string `yaml:"access_key_file" toml:"access_key_file"`
+}
+
+// lambdaSettings configures the lambda subcommand; subscription payloads
+// go to the cloudwatch forward target
+type lambdaSettings struct {
+	// Output is the object storage prefix, such as s3://bucket/prefix,
+	// objects of S3 notifications are redacted into; empty refuses them
+	Output string `yaml:"output" toml:"output"`
+	// Forward is kinesis:<stream>, udp://host:port, tcp://host:port, a
+	// file or "-", where the records of Kinesis events go
+	Forward string `yaml:"forward" toml:"forward"`
+}
+
+// queueSettings configures the queue subcommand
+type queueSettings struct {
+	// Source is sqs:<queue URL> or
+	// pubsub:projects/<project>/subscriptions/<subscription>
+	Source string `yaml:"source" toml:"source"`
+	// Output is the prefix objects are redacted under, keeping their keys,
+	// unless a message names the output itself
+	Output string `yaml:"output" toml:"output"`
+	// Notify is sqs:<queue URL>, pubsub:projects/<project>/topics/<topic>,
+	// udp://host:port, tcp://host:port, a file or "-"; empty publishes no
+	// completion messages
+	Notify string `yaml:"notify" toml:"notify"`
+}
+
+// sftpSettings configures how sftp:// inputs authenticate
+type sftpSettings struct {
+	// KeyFile is the private key to offer; empty offers those of
+	// ssh-agent and ~/.ssh
+	KeyFile string `yaml:"key_file" toml:"key_file"`
+	// KnownHosts verifies host keys; empty is ~/.ssh/known_hosts
+	KnownHosts string `yaml:"known_hosts" toml:"known_hosts"`
+}
+
+// kafkaSettings configures the kafka subcommand
+type kafkaSettings struct {
+	Brokers     []string `yaml:"brokers" toml:"brokers"`
+	InputTopic  string   `yaml:"input_topic" toml:"input_topic"`
+	OutputTopic string   `yaml:"output_topic" toml:"output_topic"`
+	Group       string   `yaml:"group" toml:"group"`
+	BatchSize   int      `yaml:"batch_size" toml:"batch_size"`
+	// BatchTimeout is a Go duration
+	BatchTimeout string `yaml:"batch_timeout" toml:"batch_timeout"`
+}
+
+// k8sSettings configures the k8s subcommand
+type k8sSettings struct {
+	Namespace string `yaml:"namespace" toml:"namespace"`
+	Selector  string `yaml:"selector" toml:"selector"`
+	Container string `yaml:"container" toml:"container"`
+	// Since is a Go duration
+	Since      string `yaml:"since" toml:"since"`
+	Kubeconfig string `yaml:"kubeconfig" toml:"kubeconfig"`
+	Context    string `yaml:"context" toml:"context"`
+	// Forward is udp://host:port, tcp://host:port, a file or "-", used
+	// when no output directory is given
+	Forward string `yaml:"forward" toml:"forward"`
+}
+
+// splunkSettings configures the Splunk HTTP Event Collector that listen,
+// fluent, loki, otlp, cloudwatch, lambda and k8s modes send to when their forward target is an
+// hec+https:// or hec+http:// URL
+type splunkSettings struct {
+	// TokenFile holds the HEC token, read from $LOGVEIL_SPLUNK_TOKEN when
+	// empty
+	TokenFile string `yaml:"token_file" toml:"token_file"`
+	// Index and Sourcetype are set on every event unless the input's
+	// entry in Inputs sets its own
+	Index      string `yaml:"index" toml:"index"`
+	Sourcetype string `yaml:"sourcetype" toml:"sourcetype"`
+	BatchSize  int    `yaml:"batch_size" toml:"batch_size"`
+	// FlushInterval is a Go duration
+	FlushInterval string `yaml:"flush_interval" toml:"flush_interval"`
+	Retries       int    `yaml:"retries" toml:"retries"`
+	// Inputs maps listen, fluent, loki, otlp, cloudwatch, lambda and k8s to the fields of their
+	// events
+	Inputs map[string]hecInputSettings `yaml:"inputs" toml:"inputs"`
+}
+
+// bulkSettings configures the Elasticsearch or OpenSearch cluster that
+// listen, fluent, loki, otlp, cloudwatch, lambda and k8s modes write to when their forward target is
+// an es+https:// or es+http:// URL
+type bulkSettings struct {
+	// Username authenticates with the password in PasswordFile, or in
+	// $LOGVEIL_ELASTICSEARCH_PASSWORD, unless an API key is given in
+	// APIKeyFile or $LOGVEIL_ELASTICSEARCH_API_KEY
+	Username     string `yaml:"username" toml:"username"`
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+	APIKeyFile   string `yaml:"api_key_file" toml:"api_key_file"`
+	// Action is index or create
+	Action    string `yaml:"action" toml:"action"`
+	BatchSize int    `yaml:"batch_size" toml:"batch_size"`
+	// FlushInterval is a Go duration
+	FlushInterval string `yaml:"flush_interval" toml:"flush_interval"`
+	Retries       int    `yaml:"retries" toml:"retries"`
+	// DeadLetter is the file refused documents are appended to
+	DeadLetter string `yaml:"dead_letter" toml:"dead_letter"`
+}
+
+// hecInputSettings are the fields set on the HEC events of one input
+type hecInputSettings struct {
+	Index      string `yaml:"index" toml:"index"`
+	Sourcetype string `yaml:"sourcetype" toml:"sourcetype"`
+	Source     string `yaml:"source" toml:"source"`
+	Host       string `yaml:"host" toml:"host"`
+}
+
+func defaultSettings() settings {
+	return settings{
+		Engine:        "python",
+		Timeout:       "auto",
+		Workers:       runtime.NumCPU(),
+		WatchDebounce: "2s",
+		Symlinks:      logveil.SymlinkSkip,
+		Retry: retrySettings{
+			Attempts: 1,
+			Backoff:  "1s",
+		},
+		Log: logSettings{
+			Level:  "info",
+			Format: "text",
+		},
+		Multiline: multilineSettings{
+			MaxLines: logveil.DefaultMultilineMaxLines,
+		},
+		Lines: lineSettings{
+			Policy: logveil.LongLineSplit,
+		},
+		Entropy: entropySettings{
+			Threshold: logveil.DefaultEntropyThreshold,
+			MinLength: logveil.DefaultEntropyMinLength,
+		},
+		RulesBundle: bundleSettings{
+			Refresh: "15m",
+		},
+		Server: serverSettings{
+			GRPC:   "localhost:50051",
+			JobTTL: "1h",
+		},
+		Listen: listenSettings{
+			Protocol: "both",
+			Forward:  "-",
+		},
+		Fluent: fluentSettings{
+			Forward: "-",
+		},
+		Loki: lokiSettings{
+			Forward: "-",
+		},
+		OTLP: otlpSettings{
+			Forward: "-",
+		},
+		CloudWatch: cloudWatchSettings{
+			Forward: "-",
+		},
+		Lambda: lambdaSettings{
+			Forward: "-",
+		},
+		Kafka: kafkaSettings{
+			Group:        "logveil",
+			BatchSize:    100,
+			BatchTimeout: "1s",
+		},
+		K8s: k8sSettings{
+			Forward: "-",
+		},
+		Splunk: splunkSettings{
+			BatchSize:     100,
+			FlushInterval: "1s",
+			Retries:       3,
+		},
+		Elasticsearch: bulkSettings{
+			Action:        "index",
+			BatchSize:     500,
+			FlushInterval: "1s",
+			Retries:       3,
+		},
+	}
+}
+
+// cliFlags is the command line bound to a settings value
+type cliFlags struct {
+	fs         *flag.FlagSet
+	lists      []*listFlag
+	configPath *string
+}
+
+// registerFlags binds s to fs
+func registerFlags(fs *flag.FlagSet, s *settings) *cliFlags {
+	c := &cliFlags{fs: fs}
+	list := func(target *[]string, name, usage string) {
+		l := &listFlag{values: target}
+		c.lists = append(c.lists, l)
+		fs.Var(l, name, usage)
+	}
+
+	c.configPath = fs.String("config", "", "YAML or TOML config file (default $LOGVEIL_CONFIG)")
+	fs.StringVar(&s.Engine, "engine", s.Engine, "redaction engine: native or python")
+	fs.StringVar(&s.Timeout, "timeout", s.Timeout, "processing timeout (e.g. 90s, 10m); 0 disables, auto scales with input size")
+	fs.IntVar(&s.Workers, "workers", s.Workers, "number of files processed concurrently in batch and queue modes")
+	fs.IntVar(&s.Parallel, "parallel", s.Parallel, "redact each file in line-aligned chunks on this many goroutines, keeping line order; 0 or 1 is sequential")
+	fs.BoolVar(&s.Mmap, "mmap", s.Mmap, "read local input files through memory mappings instead of buffered reads; pipes and remote inputs are read as usual")
+	fs.StringVar(&s.CacheFile, "cache-file", s.CacheFile, "manifest of files redacted in batch mode; files whose content and rules are unchanged since, with outputs intact, are skipped")
+	fs.StringVar(&s.Quarantine.Dir, "quarantine-dir", s.Quarantine.Dir, "directory receiving a copy of each input batch mode fails to redact, under its absolute path, with a .error.json report")
+	fs.BoolVar(&s.Quarantine.Move, "quarantine-move", s.Quarantine.Move, "move failed inputs into --quarantine-dir rather than copying them")
+	fs.StringVar(&s.Audit.Log, "audit-log", s.Audit.Log, "append a hash-chained record of who redacted which input, with what rules and result, to this file; check it with `audit verify`")
+	fs.StringVar(&s.Audit.Actor, "audit-actor", s.Audit.Actor, "who --audit-log entries name as running the redaction (default: the OS user and host; serve mode names the client)")
+	list(&s.Include, "include", "comma-separated globs; only files in input directories and glob matches that match one are processed, e.g. '*.log'")
+	list(&s.Exclude, "exclude", "comma-separated globs of files and directories to leave out of input directories and glob matches, e.g. '*.gz,archive'")
+	fs.IntVar(&s.MaxDepth, "max-depth", s.MaxDepth, "directory levels searched below an input directory, 1 for only the files directly in it; 0 is unlimited")
+	fs.StringVar(&s.Symlinks, "symlinks", s.Symlinks, "symlinks found in input directories: skip, files (follow links to files) or follow (files and directories, each directory once)")
+	fs.BoolVar(&s.InPlace, "in-place", s.InPlace, "replace each input with its redacted copy, atomically and keeping its mode, owner and modification time")
+	fs.BoolVar(&s.Backup, "backup", s.Backup, "with --in-place, keep each original as <file>.bak")
+	fs.BoolVar(&s.Resume, "resume", s.Resume, "checkpoint progress next to each output file and continue an interrupted run from its checkpoint")
+	fs.StringVar(&s.Python, "python", s.Python, "Python interpreter that runs the agent; a bare name is looked up in PATH (default python3)")
+	fs.StringVar(&s.Agent, "agent", s.Agent, "path to logveil_agent.py; relative paths missing from the working directory are tried next to the executable (default: the bundled agent)")
+	fs.StringVar(&s.AgentSHA256, "agent-sha256", s.AgentSHA256, "hex SHA-256 the agent script must have; a mismatch refuses to run it, e.g. the output of sha256sum logveil_agent.py")
+	fs.BoolVar(&s.PythonWorker, "python-worker", s.PythonWorker, "run one persistent Python agent and stream lines to it instead of spawning it per file")
+	fs.BoolVar(&s.NoFallback, "no-native-fallback", s.NoFallback, "fail when the Python interpreter or agent is missing instead of redacting with the native engine")
+	fs.StringVar(&s.Sandbox.CPUTime, "sandbox-cpu-time", s.Sandbox.CPUTime, "CPU time after which an agent process is killed, e.g. 5m (Linux)")
+	fs.StringVar(&s.Sandbox.Memory, "sandbox-memory", s.Sandbox.Memory, "address space limit of an agent process, e.g. 2G (Linux)")
+	fs.IntVar(&s.Sandbox.OpenFiles, "sandbox-open-files", s.Sandbox.OpenFiles, "open file limit of an agent process (Linux)")
+	fs.BoolVar(&s.Sandbox.NoNewPrivileges, "sandbox-no-new-privileges", s.Sandbox.NoNewPrivileges, "stop the agent gaining privileges through setuid binaries or file capabilities (Linux)")
+	fs.BoolVar(&s.Sandbox.Seccomp, "sandbox-seccomp", s.Sandbox.Seccomp, "deny the agent network sockets and system calls for tracing, mounts, namespaces and kernel modules; implies --sandbox-no-new-privileges (Linux amd64 and arm64)")
+	fs.IntVar(&s.Retry.Attempts, "retry-attempts", s.Retry.Attempts, "times the agent is run for a file when it is killed, e.g. by the OOM killer, or fails to start; 1 disables retries")
+	fs.StringVar(&s.Retry.Backoff, "retry-backoff", s.Retry.Backoff, "wait before the first retry, doubled for each one after it")
+	list(&s.Rules, "rules", "comma-separated detectors or rule packs (default, cloud-secrets, pci, card-data, health, eu-personal) for the native engine")
+	fs.StringVar(&s.Profile, "profile", s.Profile, "compliance profile whose rules are added to --rules and whose coverage is reported: gdpr, hipaa or pci (native engine)")
+	fs.StringVar(&s.RulesFile, "rules-file", s.RulesFile, "YAML or JSON file of custom redaction rules")
+	fs.StringVar(&s.RulesBundle.URL, "rules-url", s.RulesBundle.URL, "https URL of a YAML or JSON rules bundle, applied before --rules-file")
+	fs.StringVar(&s.RulesBundle.Refresh, "rules-refresh", s.RulesBundle.Refresh, "how often serve, listen, fluent, loki, otlp, cloudwatch, kafka, k8s and watch modes check --rules-url for updates; 0 disables")
+	fs.StringVar(&s.RulesBundle.CacheDir, "rules-cache-dir", s.RulesBundle.CacheDir, "directory caching the --rules-url bundle (default: logveil/rules in the user cache directory)")
+	fs.StringVar(&s.RulesBundle.PublicKey, "rules-public-key", s.RulesBundle.PublicKey, "PEM file of Ed25519 or ECDSA public keys; --rules-url bundles must carry a detached signature by one of them")
+	fs.StringVar(&s.RulesBundle.SignatureURL, "rules-signature-url", s.RulesBundle.SignatureURL, "URL of the --rules-url bundle's signature, raw or base64 (default: the bundle URL with .sig appended)")
+	list(&s.Plugins, "plugin", "name=path of a WebAssembly detector plugin, e.g. customer_id=plugins/customer.wasm (repeatable, native engine)")
+	list(&s.Policy.Paths, "policy", "Rego file, data file or directory of an OPA policy deciding whether and how each match is redacted (repeatable, native engine)")
+	fs.StringVar(&s.Policy.Query, "policy-query", s.Policy.Query, "decision the --policy is asked for (default data.logveil.decision)")
+	fs.StringVar(&s.AllowlistFile, "allowlist", s.AllowlistFile, "YAML or JSON file of values and patterns that rules never redact (native engine)")
+	list(&s.IPAllowlist, "ip-allow", "CIDR range or address that ip_address leaves unredacted, or private for RFC 1918 and loopback ranges (repeatable)")
+	fs.StringVar(&s.Overlap, "overlap", s.Overlap, "which rule redacts text several rules match: priority (first rule in order), longest (longest match) or merge (one span covering all of them) (native engine)")
+	fs.BoolVar(&s.DecodePayloads, "decode-payloads", s.DecodePayloads, "also decode base64 and percent-encoded substrings and redact those whose decoded text matches a rule (native engine)")
+	fs.BoolVar(&s.Output.Pretty, "pretty", s.Output.Pretty, "indent the JSON result")
+	fs.StringVar(&s.Output.SummaryFile, "summary-file", s.Output.SummaryFile, "write the JSON result to this file")
+	fs.StringVar(&s.Output.Compress, "compress-output", s.Output.Compress, "compress redacted output with gzip, zstd or bzip2")
+	fs.StringVar(&s.Output.Report, "report", s.Output.Report, "write the findings report as json, sarif, html or csv; implies --dry-run")
+	fs.StringVar(&s.Format, "format", s.Format, "input format: text, json, docker (json-file logs), journal (journalctl -o export), journal-json, syslog, logfmt, access (Apache/Nginx common and combined) winevent (Windows event XML), cef (ArcSight), leef (QRadar), csv or har (HTTP Archive)")
+	list(&s.JSONFields, "json-field", "dotted JSON field path to redact outright in json and docker formats (repeatable)")
+	fs.BoolVar(&s.JSONNested, "json-nested", s.JSONNested, "parse string values holding serialized JSON and redact the inner document, in json and docker formats")
+	list(&s.LogfmtKeys, "logfmt-key", "key, optionally with * wildcards, whose value is redacted outright in logfmt format (repeatable)")
+	list(&s.JournalFields, "journal-field", "journal field redacted along with MESSAGE in journal and journal-json formats, e.g. _CMDLINE (repeatable)")
+	list(&s.CEFKeys, "cef-key", "extension key, optionally with * wildcards, whose value is redacted outright in cef and leef formats, e.g. suser (repeatable)")
+	list(&s.CSV.Columns, "columns", "header name or 1-based index of a column redacted in csv format (repeatable)")
+	fs.BoolVar(&s.CSV.NoHeader, "csv-no-header", s.CSV.NoHeader, "csv input has no header row; --columns must be indexes")
+	list(&s.QueryParams, "query-param", "query parameter, optionally with * wildcards, whose value is redacted outright in access format (repeatable)")
+	fs.StringVar(&s.Multiline.Start, "multiline-start", s.Multiline.Start, "regexp matching the first line of a record; other lines join the record before them, e.g. stack traces (native engine)")
+	fs.IntVar(&s.Multiline.MaxLines, "multiline-max-lines", s.Multiline.MaxLines, "most lines in one multi-line record")
+	fs.StringVar(&s.Lines.MaxSize, "max-line-size", s.Lines.MaxSize, "longest line redacted in one go, e.g. 1M; longer lines are handled by --long-lines (default: no limit)")
+	fs.StringVar(&s.Lines.Policy, "long-lines", s.Lines.Policy, "what to do with lines over --max-line-size: split redacts them in pieces, truncate drops all but the start")
+	fs.Float64Var(&s.Entropy.Threshold, "entropy-threshold", s.Entropy.Threshold, "bits of Shannon entropy per character at which high_entropy flags a token")
+	fs.IntVar(&s.Entropy.MinLength, "entropy-min-length", s.Entropy.MinLength, "shortest token high_entropy considers")
+	list(&s.Entropy.Allowlist, "entropy-allow", "RE2 pattern for benign tokens high_entropy must leave alone, matched against the whole token (repeatable)")
+	list(&s.Numeric, "numeric", "field=transform:amount generalizing a numeric field, e.g. age=bucket:10, age=range:10, lat=round:2, latency_ms=noise:0.1 or price=jitter:5 (repeatable, native engine)")
+	fs.BoolVar(&s.DateShift.Enabled, "date-shift", s.DateShift.Enabled, "move every date by one random number of days per run, keeping intervals and times of day (native engine)")
+	fs.IntVar(&s.DateShift.MaxDays, "date-shift-max-days", s.DateShift.MaxDays, "largest --date-shift either way (default 365)")
+	fs.StringVar(&s.DateShift.Seed, "date-shift-seed", s.DateShift.Seed, "secret that picks the --date-shift offset, so runs sharing it shift alike")
+	fs.BoolVar(&s.PreserveFormat, "preserve-format", s.PreserveFormat, "replace values with substitutes of the same length and character classes (native engine)")
+	fs.BoolVar(&s.FakeData, "fake-data", s.FakeData, "replace values with plausible fake ones, the same fake for the same value (native engine)")
+	fs.StringVar(&s.FakeSeed, "fake-seed", s.FakeSeed, "secret seed for --fake-data; the built-in seed lets anyone confirm a guessed original")
+	fs.StringVar(&s.Placeholder, "placeholder-template", s.Placeholder, "Go template for placeholders numbering each file's distinct values, e.g. '[{{.Rule}}_{{.Index}}]' for [EMAIL_1], [EMAIL_2] (native engine)")
+	fs.BoolVar(&s.Tokenize, "tokenize", s.Tokenize, "replace values with stable pseudonyms instead of placeholders (native engine)")
+	fs.StringVar(&s.TokenizeKey, "tokenize-key", s.TokenizeKey, "secret key for --tokenize so pseudonyms match across runs (default: random per run)")
+	fs.StringVar(&s.TokenStore, "token-store", s.TokenStore, "BoltDB file holding the tokenization key and issued pseudonyms; implies --tokenize")
+	fs.StringVar(&s.SealMap, "seal-map", s.SealMap, "write the original of every pseudonym, encrypted, to this file for the unveil command; implies --tokenize")
+	fs.StringVar(&s.SealKeyFile, "seal-key-file", s.SealKeyFile, "file holding the --seal-map key (default $LOGVEIL_SEAL_KEY)")
+	fs.BoolVar(&s.Follow, "follow", s.Follow, "keep redacting lines appended to the input, like tail -F, until interrupted")
+	fs.BoolVar(&s.FollowFromStart, "from-start", s.FollowFromStart, "with --follow, redact the input's existing lines first")
+	fs.BoolVar(&s.DryRun, "dry-run", s.DryRun, "report what would be redacted, with line and column, without writing output")
+	fs.StringVar(&s.FailOn, "fail-on", s.FailOn, "exit 3 when detections exceed a count (0 = any), a severity (low, medium, high, critical) or both, e.g. high:5")
+	fs.StringVar(&s.Watch, "watch", s.Watch, "redact files created or modified under this directory into the output directory until interrupted")
+	fs.StringVar(&s.WatchDebounce, "watch-debounce", s.WatchDebounce, "how long a watched file must be quiet before it is redacted")
+	fs.StringVar(&s.WatchState, "watch-state", s.WatchState, "file recording what --watch has redacted (default <output_dir>/.logveil-watch-state.json)")
+	fs.StringVar(&s.Log.Level, "log-level", s.Log.Level, "least severe diagnostics written to stderr: debug, info, warn or error")
+	fs.StringVar(&s.Log.Format, "log-format", s.Log.Format, "format of diagnostics on stderr: text or json")
+	fs.StringVar(&s.OTLPEndpoint, "otlp-endpoint", s.OTLPEndpoint, "export OpenTelemetry spans and metrics over OTLP/gRPC to this URL, e.g. http://localhost:4317 (default from OTEL_EXPORTER_OTLP_ENDPOINT; unset disables export)")
+	fs.StringVar(&s.NotifyURL, "notify-url", s.NotifyURL, "POST the result of a file, batch or dry run as JSON to this webhook when it finishes or fails")
+	fs.StringVar(&s.Server.GRPC, "grpc", s.Server.GRPC, "listen address of the gRPC service in serve mode")
+	fs.StringVar(&s.Server.Metrics, "metrics-addr", s.Server.Metrics, "serve Prometheus metrics on /metrics at this address in serve, listen, fluent, loki, otlp, cloudwatch, kafka, k8s and watch modes, e.g. :9090; serve mode answers /healthz and /readyz probes there too")
+	fs.BoolVar(&s.Server.Pprof, "pprof", s.Server.Pprof, "also serve CPU, heap and other runtime profiles on /debug/pprof/ at --metrics-addr, to requests bearing the --pprof-token-file token")
+	fs.StringVar(&s.Server.PprofTokenFile, "pprof-token-file", s.Server.PprofTokenFile, "file holding the bearer token --pprof requires (default $LOGVEIL_PPROF_TOKEN)")
+	fs.StringVar(&s.Server.HTTP, "http-addr", s.Server.HTTP, "also serve the job API at this address in serve mode: POST a file to /jobs and poll /jobs/{id} until its result is ready; /healthz and /readyz probes are answered there too")
+	fs.StringVar(&s.Server.JobDir, "job-dir", s.Server.JobDir, "directory holding job uploads and results (default a temporary directory removed on exit)")
+	fs.StringVar(&s.Server.JobTTL, "job-ttl", s.Server.JobTTL, "how long finished jobs and their results are kept; 0 keeps them until deleted")
+	fs.StringVar(&s.Server.JobMaxSize, "job-max-size", s.Server.JobMaxSize, "largest job upload, e.g. 2G (default unlimited)")
+	fs.StringVar(&s.Server.APIKeysFile, "api-keys-file", s.Server.APIKeysFile, "file of \"<client> <key>\" lines; serve mode clients must send one key as \"Authorization: Bearer <key>\"")
+	fs.StringVar(&s.Server.TLSCert, "tls-cert", s.Server.TLSCert, "PEM certificate serving gRPC and the job API over TLS")
+	fs.StringVar(&s.Server.TLSKey, "tls-key", s.Server.TLSKey, "PEM private key of --tls-cert")
+	fs.Float64Var(&s.Server.ClientRate, "client-rate", s.Server.ClientRate, "requests, and lines streamed over gRPC, per second each serve mode client may sustain (default unlimited)")
+	fs.IntVar(&s.Server.ClientBurst, "client-burst", s.Server.ClientBurst, "requests a client may make at once above --client-rate (default the rate)")
+	fs.IntVar(&s.Server.ClientMaxJobs, "client-max-jobs", s.Server.ClientMaxJobs, "jobs each client may have queued or running at once (default unlimited)")
+	fs.StringVar(&s.Server.TLSClientCA, "tls-client-ca", s.Server.TLSClientCA, "PEM CA certificates; serve mode clients must present a certificate they issued (mutual TLS)")
+	fs.StringVar(&s.Listen.Syslog, "syslog", s.Listen.Syslog, "address to receive syslog on in listen mode, e.g. :5514")
+	fs.StringVar(&s.Listen.Protocol, "syslog-protocol", s.Listen.Protocol, "syslog transport in listen mode: udp, tcp or both")
+	fs.StringVar(&s.Listen.Forward, "forward", s.Listen.Forward, "where listen mode sends redacted messages: udp://host:port, tcp://host:port, hec+https://host:port for a Splunk HEC, es+https://host:port/index for Elasticsearch, a file or - for stdout")
+	fs.StringVar(&s.Fluent.Addr, "fluent", s.Fluent.Addr, "address to receive the Fluent Forward protocol on in fluent mode, e.g. :24224")
+	fs.StringVar(&s.Fluent.Forward, "fluent-forward", s.Fluent.Forward, "where fluent mode sends redacted events: fluent://host:port, or as JSON lines to udp://host:port, tcp://host:port, hec+https://host:port for a Splunk HEC, es+https://host:port/index for Elasticsearch, a file or - for stdout")
+	fs.StringVar(&s.Loki.Addr, "loki-addr", s.Loki.Addr, "address to serve Loki's push API on in loki mode, e.g. :3100")
+	fs.StringVar(&s.Loki.Forward, "loki-forward", s.Loki.Forward, "where loki mode pushes redacted lines: a Loki URL such as http://loki:3100, or as JSON lines to udp://host:port, tcp://host:port, hec+https://host:port for a Splunk HEC, es+https://host:port/index for Elasticsearch, a file or - for stdout")
+	fs.StringVar(&s.OTLP.GRPC, "otlp-grpc", s.OTLP.GRPC, "address to receive OTLP/gRPC logs on in otlp mode, e.g. :4317")
+	fs.StringVar(&s.OTLP.HTTP, "otlp-http", s.OTLP.HTTP, "address to receive OTLP/HTTP logs on in otlp mode, e.g. :4318")
+	fs.StringVar(&s.OTLP.Forward, "otlp-forward", s.OTLP.Forward, "where otlp mode exports redacted logs: grpc://host:port, grpcs://host:port or an OTLP/HTTP URL such as http://collector:4318, or as JSON lines to udp://host:port, tcp://host:port, hec+https://host:port for a Splunk HEC, es+https://host:port/index for Elasticsearch, a file or - for stdout")
+	fs.StringVar(&s.CloudWatch.Addr, "cloudwatch-addr", s.CloudWatch.Addr, "address to receive CloudWatch Logs subscription payloads on in cloudwatch mode, e.g. :8443")
+	fs.StringVar(&s.CloudWatch.Forward, "cloudwatch-forward", s.CloudWatch.Forward, "where cloudwatch mode writes redacted events: cloudwatch:<log group>, s3://bucket/prefix, or as JSON lines to udp://host:port, tcp://host:port, hec+https://host:port for a Splunk HEC, es+https://host:port/index for Elasticsearch, a file or - for stdout")
+	fs.StringVar(&s.CloudWatch.AccessKeyFile, "cloudwatch-access-key-file", s.CloudWatch.AccessKeyFile, "file holding the access key Firehose deliveries must carry in cloudwatch mode (default $LOGVEIL_CLOUDWATCH_ACCESS_KEY; unset takes deliveries without one)")
+	fs.StringVar(&s.Lambda.Output, "lambda-output", s.Lambda.Output, "object storage prefix lambda mode redacts the objects of S3 notifications into, under their keys, e.g. s3://logs-redacted/incoming or gs://logs-redacted/incoming")
+	fs.StringVar(&s.Lambda.Forward, "lambda-forward", s.Lambda.Forward, "where lambda mode puts the redacted records of Kinesis events: kinesis:<stream>, or as lines to udp://host:port, tcp://host:port, hec+https://host:port for a Splunk HEC, es+https://host:port/index for Elasticsearch, a file or - for stdout")
+	fs.StringVar(&s.Queue.Source, "queue", s.Queue.Source, "where queue mode receives file ready messages: sqs:<queue URL> or pubsub:projects/<project>/subscriptions/<subscription>")
+	fs.StringVar(&s.Queue.Output, "queue-output", s.Queue.Output, "prefix queue mode redacts objects under, keeping their keys, e.g. s3://logs-redacted/incoming, gs://logs-redacted/incoming or azblob://logs-redacted/incoming, unless a message names the output")
+	fs.StringVar(&s.SFTP.KeyFile, "sftp-key-file", s.SFTP.KeyFile, "unencrypted private key sftp://[user@]host[:port]/path inputs authenticate with; empty offers the keys of ssh-agent and ~/.ssh/id_ed25519, id_ecdsa and id_rsa")
+	fs.StringVar(&s.SFTP.KnownHosts, "sftp-known-hosts", s.SFTP.KnownHosts, "known_hosts file that verifies the host keys of sftp:// inputs; empty is ~/.ssh/known_hosts")
+	fs.StringVar(&s.Queue.Notify, "queue-notify", s.Queue.Notify, "where queue mode publishes a completion message for each object: sqs:<queue URL>, pubsub:projects/<project>/topics/<topic>, or as JSON lines to udp://host:port, tcp://host:port, a file or - for stdout")
+	list(&s.Kafka.Brokers, "kafka-brokers", "comma-separated Kafka bootstrap brokers in kafka mode")
+	fs.StringVar(&s.Kafka.InputTopic, "kafka-input-topic", s.Kafka.InputTopic, "topic consumed in kafka mode")
+	fs.StringVar(&s.Kafka.OutputTopic, "kafka-output-topic", s.Kafka.OutputTopic, "topic redacted messages are produced to in kafka mode")
+	fs.StringVar(&s.Kafka.Group, "kafka-group", s.Kafka.Group, "consumer group that tracks offsets in kafka mode")
+	fs.IntVar(&s.Kafka.BatchSize, "kafka-batch-size", s.Kafka.BatchSize, "messages produced and committed together in kafka mode")
+	fs.StringVar(&s.Kafka.BatchTimeout, "kafka-batch-timeout", s.Kafka.BatchTimeout, "how long a partial batch waits for more messages in kafka mode")
+	fs.StringVar(&s.K8s.Namespace, "namespace", s.K8s.Namespace, "namespace of the pods in k8s mode (default: the kubeconfig context's)")
+	fs.StringVar(&s.K8s.Selector, "selector", s.K8s.Selector, "label selector of the pods in k8s mode, e.g. app=foo")
+	fs.StringVar(&s.K8s.Container, "container", s.K8s.Container, "only collect containers of this name in k8s mode")
+	fs.StringVar(&s.K8s.Since, "since", s.K8s.Since, "only collect lines newer than this duration in k8s mode, e.g. 1h")
+	fs.StringVar(&s.K8s.Kubeconfig, "kubeconfig", s.K8s.Kubeconfig, "kubeconfig file for k8s mode (default $KUBECONFIG, ~/.kube/config or in-cluster)")
+	fs.StringVar(&s.K8s.Context, "kube-context", s.K8s.Context, "kubeconfig context for k8s mode")
+	fs.StringVar(&s.K8s.Forward, "k8s-forward", s.K8s.Forward, "where k8s mode sends redacted lines without an output directory: udp://host:port, tcp://host:port, hec+https://host:port for a Splunk HEC, es+https://host:port/index for Elasticsearch, a file or - for stdout")
+	fs.StringVar(&s.Splunk.TokenFile, "splunk-token-file", s.Splunk.TokenFile, "file holding the Splunk HEC token for hec+https:// forward targets (default $LOGVEIL_SPLUNK_TOKEN)")
+	fs.StringVar(&s.Splunk.Index, "splunk-index", s.Splunk.Index, "index of the events sent to a Splunk HEC, unless splunk.inputs sets one for the input")
+	fs.StringVar(&s.Splunk.Sourcetype, "splunk-sourcetype", s.Splunk.Sourcetype, "sourcetype of the events sent to a Splunk HEC, unless splunk.inputs sets one for the input")
+	fs.IntVar(&s.Splunk.BatchSize, "splunk-batch-size", s.Splunk.BatchSize, "events sent to a Splunk HEC per request")
+	fs.StringVar(&s.Splunk.FlushInterval, "splunk-flush-interval", s.Splunk.FlushInterval, "how long a partial batch of Splunk HEC events waits for more")
+	fs.IntVar(&s.Splunk.Retries, "splunk-retries", s.Splunk.Retries, "times a batch the Splunk HEC could not take is sent again before it is dropped")
+	fs.StringVar(&s.Elasticsearch.Username, "es-username", s.Elasticsearch.Username, "user writing to es+https:// forward targets, with the password in --es-password-file or $LOGVEIL_ELASTICSEARCH_PASSWORD")
+	fs.StringVar(&s.Elasticsearch.PasswordFile, "es-password-file", s.Elasticsearch.PasswordFile, "file holding the password of --es-username")
+	fs.StringVar(&s.Elasticsearch.APIKeyFile, "es-api-key-file", s.Elasticsearch.APIKeyFile, "file holding the API key for es+https:// forward targets, used over --es-username (default $LOGVEIL_ELASTICSEARCH_API_KEY)")
+	fs.StringVar(&s.Elasticsearch.Action, "es-action", s.Elasticsearch.Action, "bulk action writing each document: index, or create for data streams")
+	fs.IntVar(&s.Elasticsearch.BatchSize, "es-batch-size", s.Elasticsearch.BatchSize, "documents written to Elasticsearch per bulk request")
+	fs.StringVar(&s.Elasticsearch.FlushInterval, "es-flush-interval", s.Elasticsearch.FlushInterval, "how long a partial batch of Elasticsearch documents waits for more")
+	fs.IntVar(&s.Elasticsearch.Retries, "es-retries", s.Elasticsearch.Retries, "times documents Elasticsearch could not take for now are sent again")
+	fs.StringVar(&s.Elasticsearch.DeadLetter, "es-dead-letter", s.Elasticsearch.DeadLetter, "file that documents Elasticsearch refused, or still could not take after the retries, are appended to; empty drops them")
+	return c
+}
+
+// parse parses args into the bound settings. It may be called more than
+// once; list flags given on the command line replace, rather than extend,
+// whatever an earlier layer set.
+func (c *cliFlags) parse(args []string) error {
+	for _, l := range c.lists {
+		l.set = false
+	}
+	return c.fs.Parse(args)
+}
+
+// applyCommandDefaults fills in the settings whose defaults depend on the
+// subcommand run
+func (s *settings) applyCommandDefaults(command string) {
+	// Relayed messages are syslog, and Kafka values and fluent records JSON,
+	// unless configured otherwise; the json format passes other values
+	// through as text
+	if s.Format == "" {
+		switch command {
+		case "listen":
+			s.Format = "syslog"
+		case "kafka", "fluent":
+			s.Format = "json"
+		}
+	}
+}
+
+// load applies the config file at path (or $LOGVEIL_CONFIG) and then the
+// environment overrides
+func (s *settings) load(path string) error {
+	if path == "" {
+		path = os.Getenv("LOGVEIL_CONFIG")
+	}
+	if path != "" {
+		if err := loadConfigFile(path, s); err != nil {
+			return fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	return s.applyEnv()
+}
+
+// loadConfigFile decodes path into s, choosing the format by extension.
+// Unknown keys are rejected so typos don't silently fall back to defaults.
+func loadConfigFile(path string, s *settings) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(s); err != nil && err != io.EOF {
+			return err
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), s)
+		if err != nil {
+			return err
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unknown key %q", undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("unsupported config format %q (expected .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	return nil
+}
+
+// envOverrides maps environment variables onto settings fields
+var envOverrides = []struct {
+	name  string
+	apply func(s *settings, value string) error
+}{
+	{"LOGVEIL_ENGINE", func(s *settings, v string) error { s.Engine = v; return nil }},
+	{"LOGVEIL_TIMEOUT", func(s *settings, v string) error { s.Timeout = v; return nil }},
+	{"LOGVEIL_WORKERS", func(s *settings, v string) (err error) { s.Workers, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_PARALLEL", func(s *settings, v string) (err error) { s.Parallel, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_MMAP", func(s *settings, v string) (err error) { s.Mmap, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_CACHE_FILE", func(s *settings, v string) error { s.CacheFile = v; return nil }},
+	{"LOGVEIL_QUARANTINE_DIR", func(s *settings, v string) error { s.Quarantine.Dir = v; return nil }},
+	{"LOGVEIL_QUARANTINE_MOVE", func(s *settings, v string) (err error) { s.Quarantine.Move, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_AUDIT_LOG", func(s *settings, v string) error { s.Audit.Log = v; return nil }},
+	{"LOGVEIL_AUDIT_ACTOR", func(s *settings, v string) error { s.Audit.Actor = v; return nil }},
+	{"LOGVEIL_INCLUDE", func(s *settings, v string) error { s.Include = splitList(v); return nil }},
+	{"LOGVEIL_EXCLUDE", func(s *settings, v string) error { s.Exclude = splitList(v); return nil }},
+	{"LOGVEIL_MAX_DEPTH", func(s *settings, v string) (err error) { s.MaxDepth, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_SYMLINKS", func(s *settings, v string) error { s.Symlinks = v; return nil }},
+	{"LOGVEIL_IN_PLACE", func(s *settings, v string) (err error) { s.InPlace, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_BACKUP", func(s *settings, v string) (err error) { s.Backup, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_RESUME", func(s *settings, v string) (err error) { s.Resume, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_PYTHON", func(s *settings, v string) error { s.Python = v; return nil }},
+	{"LOGVEIL_AGENT", func(s *settings, v string) error { s.Agent = v; return nil }},
+	{"LOGVEIL_AGENT_SHA256", func(s *settings, v string) error { s.AgentSHA256 = v; return nil }},
+	{"LOGVEIL_PYTHON_WORKER", func(s *settings, v string) (err error) { s.PythonWorker, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_NO_NATIVE_FALLBACK", func(s *settings, v string) (err error) { s.NoFallback, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_RETRY_ATTEMPTS", func(s *settings, v string) (err error) { s.Retry.Attempts, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_RETRY_BACKOFF", func(s *settings, v string) error { s.Retry.Backoff = v; return nil }},
+	{"LOGVEIL_SANDBOX_CPU_TIME", func(s *settings, v string) error { s.Sandbox.CPUTime = v; return nil }},
+	{"LOGVEIL_SANDBOX_MEMORY", func(s *settings, v string) error { s.Sandbox.Memory = v; return nil }},
+	{"LOGVEIL_SANDBOX_OPEN_FILES", func(s *settings, v string) (err error) { s.Sandbox.OpenFiles, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_SANDBOX_NO_NEW_PRIVILEGES", func(s *settings, v string) (err error) { s.Sandbox.NoNewPrivileges, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_SANDBOX_SECCOMP", func(s *settings, v string) (err error) { s.Sandbox.Seccomp, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_RULES", func(s *settings, v string) error { s.Rules = splitList(v); return nil }},
+	{"LOGVEIL_PROFILE", func(s *settings, v string) error { s.Profile = v; return nil }},
+	{"LOGVEIL_RULES_FILE", func(s *settings, v string) error { s.RulesFile = v; return nil }},
+	{"LOGVEIL_RULES_URL", func(s *settings, v string) error { s.RulesBundle.URL = v; return nil }},
+	{"LOGVEIL_RULES_REFRESH", func(s *settings, v string) error { s.RulesBundle.Refresh = v; return nil }},
+	{"LOGVEIL_RULES_CACHE_DIR", func(s *settings, v string) error { s.RulesBundle.CacheDir = v; return nil }},
+	{"LOGVEIL_RULES_PUBLIC_KEY", func(s *settings, v string) error { s.RulesBundle.PublicKey = v; return nil }},
+	{"LOGVEIL_RULES_SIGNATURE_URL", func(s *settings, v string) error { s.RulesBundle.SignatureURL = v; return nil }},
+	{"LOGVEIL_PLUGINS", func(s *settings, v string) error { s.Plugins = splitList(v); return nil }},
+	{"LOGVEIL_POLICY", func(s *settings, v string) error { s.Policy.Paths = splitList(v); return nil }},
+	{"LOGVEIL_POLICY_QUERY", func(s *settings, v string) error { s.Policy.Query = v; return nil }},
+	{"LOGVEIL_ALLOWLIST_FILE", func(s *settings, v string) error { s.AllowlistFile = v; return nil }},
+	{"LOGVEIL_IP_ALLOWLIST", func(s *settings, v string) error { s.IPAllowlist = splitList(v); return nil }},
+	{"LOGVEIL_DECODE_PAYLOADS", func(s *settings, v string) (err error) { s.DecodePayloads, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_OVERLAP", func(s *settings, v string) error { s.Overlap = v; return nil }},
+	{"LOGVEIL_FORMAT", func(s *settings, v string) error { s.Format = v; return nil }},
+	{"LOGVEIL_JSON_FIELDS", func(s *settings, v string) error { s.JSONFields = splitList(v); return nil }},
+	{"LOGVEIL_JSON_NESTED", func(s *settings, v string) (err error) { s.JSONNested, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_LOGFMT_KEYS", func(s *settings, v string) error { s.LogfmtKeys = splitList(v); return nil }},
+	{"LOGVEIL_JOURNAL_FIELDS", func(s *settings, v string) error { s.JournalFields = splitList(v); return nil }},
+	{"LOGVEIL_CEF_KEYS", func(s *settings, v string) error { s.CEFKeys = splitList(v); return nil }},
+	{"LOGVEIL_CSV_COLUMNS", func(s *settings, v string) error { s.CSV.Columns = splitList(v); return nil }},
+	{"LOGVEIL_CSV_NO_HEADER", func(s *settings, v string) (err error) { s.CSV.NoHeader, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_QUERY_PARAMS", func(s *settings, v string) error { s.QueryParams = splitList(v); return nil }},
+	{"LOGVEIL_MULTILINE_START", func(s *settings, v string) error { s.Multiline.Start = v; return nil }},
+	{"LOGVEIL_MULTILINE_MAX_LINES", func(s *settings, v string) (err error) { s.Multiline.MaxLines, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_MAX_LINE_SIZE", func(s *settings, v string) error { s.Lines.MaxSize = v; return nil }},
+	{"LOGVEIL_LONG_LINES", func(s *settings, v string) error { s.Lines.Policy = v; return nil }},
+	{"LOGVEIL_ENTROPY_THRESHOLD", func(s *settings, v string) (err error) { s.Entropy.Threshold, err = strconv.ParseFloat(v, 64); return }},
+	{"LOGVEIL_ENTROPY_MIN_LENGTH", func(s *settings, v string) (err error) { s.Entropy.MinLength, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_ENTROPY_ALLOWLIST", func(s *settings, v string) error { s.Entropy.Allowlist = splitList(v); return nil }},
+	{"LOGVEIL_NUMERIC", func(s *settings, v string) error { s.Numeric = splitList(v); return nil }},
+	{"LOGVEIL_DATE_SHIFT", func(s *settings, v string) (err error) { s.DateShift.Enabled, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_DATE_SHIFT_MAX_DAYS", func(s *settings, v string) (err error) { s.DateShift.MaxDays, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_DATE_SHIFT_SEED", func(s *settings, v string) error { s.DateShift.Seed = v; return nil }},
+	{"LOGVEIL_PRESERVE_FORMAT", func(s *settings, v string) (err error) { s.PreserveFormat, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_FAKE_DATA", func(s *settings, v string) (err error) { s.FakeData, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_FAKE_SEED", func(s *settings, v string) error { s.FakeSeed = v; return nil }},
+	{"LOGVEIL_PLACEHOLDER_TEMPLATE", func(s *settings, v string) error { s.Placeholder = v; return nil }},
+	{"LOGVEIL_TOKENIZE", func(s *settings, v string) (err error) { s.Tokenize, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_TOKENIZE_KEY", func(s *settings, v string) error { s.TokenizeKey = v; return nil }},
+	{"LOGVEIL_TOKEN_STORE", func(s *settings, v string) error { s.TokenStore = v; return nil }},
+	{"LOGVEIL_SEAL_MAP", func(s *settings, v string) error { s.SealMap = v; return nil }},
+	{"LOGVEIL_SEAL_KEY_FILE", func(s *settings, v string) error { s.SealKeyFile = v; return nil }},
+	{"LOGVEIL_FOLLOW", func(s *settings, v string) (err error) { s.Follow, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_FOLLOW_FROM_START", func(s *settings, v string) (err error) { s.FollowFromStart, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_DRY_RUN", func(s *settings, v string) (err error) { s.DryRun, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_FAIL_ON", func(s *settings, v string) error { s.FailOn = v; return nil }},
+	{"LOGVEIL_WATCH", func(s *settings, v string) error { s.Watch = v; return nil }},
+	{"LOGVEIL_WATCH_DEBOUNCE", func(s *settings, v string) error { s.WatchDebounce = v; return nil }},
+	{"LOGVEIL_WATCH_STATE", func(s *settings, v string) error { s.WatchState = v; return nil }},
+	{"LOGVEIL_LOG_LEVEL", func(s *settings, v string) error { s.Log.Level = v; return nil }},
+	{"LOGVEIL_LOG_FORMAT", func(s *settings, v string) error { s.Log.Format = v; return nil }},
+	{"LOGVEIL_OTLP_ENDPOINT", func(s *settings, v string) error { s.OTLPEndpoint = v; return nil }},
+	{"LOGVEIL_NOTIFY_URL", func(s *settings, v string) error { s.NotifyURL = v; return nil }},
+	{"LOGVEIL_OUTPUT_PRETTY", func(s *settings, v string) (err error) { s.Output.Pretty, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_SUMMARY_FILE", func(s *settings, v string) error { s.Output.SummaryFile = v; return nil }},
+	{"LOGVEIL_COMPRESS_OUTPUT", func(s *settings, v string) error { s.Output.Compress = v; return nil }},
+	{"LOGVEIL_REPORT", func(s *settings, v string) error { s.Output.Report = v; return nil }},
+	{"LOGVEIL_SERVER_GRPC", func(s *settings, v string) error { s.Server.GRPC = v; return nil }},
+	{"LOGVEIL_SERVER_METRICS", func(s *settings, v string) error { s.Server.Metrics = v; return nil }},
+	{"LOGVEIL_SERVER_PPROF", func(s *settings, v string) (err error) { s.Server.Pprof, err = strconv.ParseBool(v); return }},
+	{"LOGVEIL_SERVER_PPROF_TOKEN_FILE", func(s *settings, v string) error { s.Server.PprofTokenFile = v; return nil }},
+	{"LOGVEIL_SERVER_HTTP", func(s *settings, v string) error { s.Server.HTTP = v; return nil }},
+	{"LOGVEIL_SERVER_JOB_DIR", func(s *settings, v string) error { s.Server.JobDir = v; return nil }},
+	{"LOGVEIL_SERVER_JOB_TTL", func(s *settings, v string) error { s.Server.JobTTL = v; return nil }},
+	{"LOGVEIL_SERVER_JOB_MAX_SIZE", func(s *settings, v string) error { s.Server.JobMaxSize = v; return nil }},
+	{"LOGVEIL_SERVER_API_KEYS_FILE", func(s *settings, v string) error { s.Server.APIKeysFile = v; return nil }},
+	{"LOGVEIL_SERVER_TLS_CERT", func(s *settings, v string) error { s.Server.TLSCert = v; return nil }},
+	{"LOGVEIL_SERVER_TLS_KEY", func(s *settings, v string) error { s.Server.TLSKey = v; return nil }},
+	{"LOGVEIL_SERVER_TLS_CLIENT_CA", func(s *settings, v string) error { s.Server.TLSClientCA = v; return nil }},
+	{"LOGVEIL_SERVER_CLIENT_RATE", func(s *settings, v string) (err error) { s.Server.ClientRate, err = strconv.ParseFloat(v, 64); return }},
+	{"LOGVEIL_SERVER_CLIENT_BURST", func(s *settings, v string) (err error) { s.Server.ClientBurst, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_SERVER_CLIENT_MAX_JOBS", func(s *settings, v string) (err error) { s.Server.ClientMaxJobs, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_LISTEN_SYSLOG", func(s *settings, v string) error { s.Listen.Syslog = v; return nil }},
+	{"LOGVEIL_LISTEN_PROTOCOL", func(s *settings, v string) error { s.Listen.Protocol = v; return nil }},
+	{"LOGVEIL_LISTEN_FORWARD", func(s *settings, v string) error { s.Listen.Forward = v; return nil }},
+	{"LOGVEIL_FLUENT_ADDR", func(s *settings, v string) error { s.Fluent.Addr = v; return nil }},
+	{"LOGVEIL_FLUENT_FORWARD", func(s *settings, v string) error { s.Fluent.Forward = v; return nil }},
+	{"LOGVEIL_LOKI_ADDR", func(s *settings, v string) error { s.Loki.Addr = v; return nil }},
+	{"LOGVEIL_LOKI_FORWARD", func(s *settings, v string) error { s.Loki.Forward = v; return nil }},
+	{"LOGVEIL_OTLP_GRPC", func(s *settings, v string) error { s.OTLP.GRPC = v; return nil }},
+	{"LOGVEIL_OTLP_HTTP", func(s *settings, v string) error { s.OTLP.HTTP = v; return nil }},
+	{"LOGVEIL_OTLP_FORWARD", func(s *settings, v string) error { s.OTLP.Forward = v; return nil }},
+	{"LOGVEIL_CLOUDWATCH_ADDR", func(s *settings, v string) error { s.CloudWatch.Addr = v; return nil }},
+	{"LOGVEIL_CLOUDWATCH_FORWARD", func(s *settings, v string) error { s.CloudWatch.Forward = v; return nil }},
+	{"LOGVEIL_CLOUDWATCH_ACCESS_KEY_FILE", func(s *settings, v string) error { s.CloudWatch.AccessKeyFile = v; return nil }},
+	{"LOGVEIL_LAMBDA_OUTPUT", func(s *settings, v string) error { s.Lambda.Output = v; return nil }},
+	{"LOGVEIL_LAMBDA_FORWARD", func(s *settings, v string) error { s.Lambda.Forward = v; return nil }},
+	{"LOGVEIL_QUEUE", func(s *settings, v string) error { s.Queue.Source = v; return nil }},
+	{"LOGVEIL_QUEUE_OUTPUT", func(s *settings, v string) error { s.Queue.Output = v; return nil }},
+	{"LOGVEIL_QUEUE_NOTIFY", func(s *settings, v string) error { s.Queue.Notify = v; return nil }},
+	{"LOGVEIL_SFTP_KEY_FILE", func(s *settings, v string) error { s.SFTP.KeyFile = v; return nil }},
+	{"LOGVEIL_SFTP_KNOWN_HOSTS", func(s *settings, v string) error { s.SFTP.KnownHosts = v; return nil }},
+	{"LOGVEIL_KAFKA_BROKERS", func(s *settings, v string) error { s.Kafka.Brokers = splitList(v); return nil }},
+	{"LOGVEIL_KAFKA_INPUT_TOPIC", func(s *settings, v string) error { s.Kafka.InputTopic = v; return nil }},
+	{"LOGVEIL_KAFKA_OUTPUT_TOPIC", func(s *settings, v string) error { s.Kafka.OutputTopic = v; return nil }},
+	{"LOGVEIL_KAFKA_GROUP", func(s *settings, v string) error { s.Kafka.Group = v; return nil }},
+	{"LOGVEIL_KAFKA_BATCH_SIZE", func(s *settings, v string) (err error) { s.Kafka.BatchSize, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_KAFKA_BATCH_TIMEOUT", func(s *settings, v string) error { s.Kafka.BatchTimeout = v; return nil }},
+	{"LOGVEIL_K8S_NAMESPACE", func(s *settings, v string) error { s.K8s.Namespace = v; return nil }},
+	{"LOGVEIL_K8S_SELECTOR", func(s *settings, v string) error { s.K8s.Selector = v; return nil }},
+	{"LOGVEIL_K8S_CONTAINER", func(s *settings, v string) error { s.K8s.Container = v; return nil }},
+	{"LOGVEIL_K8S_SINCE", func(s *settings, v string) error { s.K8s.Since = v; return nil }},
+	{"LOGVEIL_K8S_KUBECONFIG", func(s *settings, v string) error { s.K8s.Kubeconfig = v; return nil }},
+	{"LOGVEIL_K8S_CONTEXT", func(s *settings, v string) error { s.K8s.Context = v; return nil }},
+	{"LOGVEIL_K8S_FORWARD", func(s *settings, v string) error { s.K8s.Forward = v; return nil }},
+	{"LOGVEIL_SPLUNK_TOKEN_FILE", func(s *settings, v string) error { s.Splunk.TokenFile = v; return nil }},
+	{"LOGVEIL_SPLUNK_INDEX", func(s *settings, v string) error { s.Splunk.Index = v; return nil }},
+	{"LOGVEIL_SPLUNK_SOURCETYPE", func(s *settings, v string) error { s.Splunk.Sourcetype = v; return nil }},
+	{"LOGVEIL_SPLUNK_BATCH_SIZE", func(s *settings, v string) (err error) { s.Splunk.BatchSize, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_SPLUNK_FLUSH_INTERVAL", func(s *settings, v string) error { s.Splunk.FlushInterval = v; return nil }},
+	{"LOGVEIL_SPLUNK_RETRIES", func(s *settings, v string) (err error) { s.Splunk.Retries, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_ELASTICSEARCH_USERNAME", func(s *settings, v string) error { s.Elasticsearch.Username = v; return nil }},
+	{"LOGVEIL_ELASTICSEARCH_PASSWORD_FILE", func(s *settings, v string) error { s.Elasticsearch.PasswordFile = v; return nil }},
+	{"LOGVEIL_ELASTICSEARCH_API_KEY_FILE", func(s *settings, v string) error { s.Elasticsearch.APIKeyFile = v; return nil }},
+	{"LOGVEIL_ELASTICSEARCH_ACTION", func(s *settings, v string) error { s.Elasticsearch.Action = v; return nil }},
+	{"LOGVEIL_ELASTICSEARCH_BATCH_SIZE", func(s *settings, v string) (err error) { s.Elasticsearch.BatchSize, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_ELASTICSEARCH_FLUSH_INTERVAL", func(s *settings, v string) error { s.Elasticsearch.FlushInterval = v; return nil }},
+	{"LOGVEIL_ELASTICSEARCH_RETRIES", func(s *settings, v string) (err error) { s.Elasticsearch.Retries, err = strconv.Atoi(v); return }},
+	{"LOGVEIL_ELASTICSEARCH_DEAD_LETTER", func(s *settings, v string) error { s.Elasticsearch.DeadLetter = v; return nil }},
+}
+
+func (s *settings) applyEnv() error {
+	for _, override := range envOverrides {
+		value, ok := os.LookupEnv(override.name)
+		if !ok {
+			continue
+		}
+		if err := override.apply(s, value); err != nil {
+			return fmt.Errorf("%s: %v", override.name, err)
+		}
+	}
+	return nil
+}
+
+// walkOptions returns the filters for files found in input directories
+func (s *settings) walkOptions() logveil.WalkOptions {
+	return logveil.WalkOptions{Include: s.Include, Exclude: s.Exclude, MaxDepth: s.MaxDepth, Symlinks: s.Symlinks}
+}
+
+// writeResult reports v as JSON to the summary file when one is configured,
+// otherwise to w
+func (s *settings) writeResult(w io.Writer, v any) error {
+	var data []byte
+	var err error
+	if s.Output.Pretty {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	return s.writeSummary(w, append(data, '\n'))
+}
+
+// writeReport writes a dry-run report in the configured report format
+func (s *settings) writeReport(w io.Writer, report *logveil.ScanReport) error {
+	var buf bytes.Buffer
+	var err error
+	switch s.Output.Report {
+	case "", "json":
+		return s.writeResult(w, report)
+	case "sarif":
+		err = report.WriteSARIF(&buf)
+	case "html":
+		err = report.WriteHTML(&buf)
+	case "csv":
+		err = report.WriteCSV(&buf)
+	default:
+		return fmt.Errorf("unknown report format %q (expected json, sarif, html or csv)", s.Output.Report)
+	}
+	if err != nil {
+		return err
+	}
+	return s.writeSummary(w, buf.Bytes())
+}
+
+// writeSummary writes data to the summary file when one is configured and
+// to w otherwise
+func (s *settings) writeSummary(w io.Writer, data []byte) error {
+	if s.Output.SummaryFile != "" {
+		return os.WriteFile(s.Output.SummaryFile, data, 0o644)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// listFlag is a repeatable, comma-separated string list. The first
+// occurrence in each parse replaces the list so command-line values
+// override the config file instead of extending it.
+type listFlag struct {
+	values *[]string
+	set    bool
+}
+
+func (l *listFlag) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	if !l.set {
+		*l.values = nil
+		l.set = true
+	}
+	*l.values = append(*l.values, splitList(value)...)
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// bulkForwarder returns the forwarder to the Elasticsearch or OpenSearch
+// index target names, set up from the elasticsearch settings
+func bulkForwarder(opts *settings, target string) (server.Forwarder, error) {
+	interval, err := time.ParseDuration(opts.Elasticsearch.FlushInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --es-flush-interval: %v", err)
+	}
+	bulk := server.BulkOptions{
+		Username:      opts.Elasticsearch.Username,
+		Action:        opts.Elasticsearch.Action,
+		BatchSize:     opts.Elasticsearch.BatchSize,
+		FlushInterval: interval,
+		Retries:       opts.Elasticsearch.Retries,
+		DeadLetter:    opts.Elasticsearch.DeadLetter,
+	}
+	switch {
+	case opts.Elasticsearch.APIKeyFile != "" || os.Getenv("LOGVEIL_ELASTICSEARCH_API_KEY") != "":
+		key, err := readSecret(opts.Elasticsearch.APIKeyFile, "LOGVEIL_ELASTICSEARCH_API_KEY", "--es-api-key-file")
+		if err != nil {
+			return nil, fmt.Errorf("elasticsearch API key: %v", err)
+		}
+		bulk.APIKey = string(key)
+	case bulk.Username != "":
+		password, err := readSecret(opts.Elasticsearch.PasswordFile, "LOGVEIL_ELASTICSEARCH_PASSWORD", "--es-password-file")
+		if err != nil {
+			return nil, fmt.Errorf("elasticsearch password: %v", err)
+		}
+		bulk.Password = string(password)
+	}
+	forward, err := server.NewBulkForwarder(target, bulk)
+	if err != nil {
+		return nil, err
+	}
+	forward.ErrorLog = errorLog()
+	return forward, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runFluent implements `fluent`, which relays events received over the
+// Fluent Forward protocol to the forward target, redacted, until
+// interrupted
+func runFluent(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics) error {
+	if opts.Fluent.Addr == "" {
+		return fmt.Errorf("--fluent is required, e.g. --fluent :24224")
+	}
+	var forward server.FluentForwarder
+	var err error
+	if sinkTarget(opts.Fluent.Forward) {
+		var lines server.Forwarder
+		lines, err = dialForward(opts, "fluent", opts.Fluent.Forward)
+		forward = server.NewFluentLineForwarder(lines)
+	} else {
+		forward, err = server.DialFluentForwarder(opts.Fluent.Forward)
+	}
+	if err != nil {
+		return fmt.Errorf("forward to %s: %v", opts.Fluent.Forward, err)
+	}
+	defer forward.Close()
+	relay := server.NewFluentRelay(redactor, forward)
+	relay.Metrics = metrics
+	relay.ErrorLog = errorLog()
+
+	listener, err := net.Listen("tcp", opts.Fluent.Addr)
+	if err != nil {
+		return fmt.Errorf("listen on tcp %s: %v", opts.Fluent.Addr, err)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Receiving fluent forward protocol", "addr", listener.Addr().String())
+	serveErr := relay.ServeTCP(ctx, listener)
+	if err := opts.writeResult(os.Stderr, relay.Stats()); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	return serveErr
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// sinkTarget reports whether target is a Splunk HTTP Event Collector or an
+// Elasticsearch index rather than a target server.DialForwarder dials
+func sinkTarget(target string) bool {
+	return server.IsHECTarget(target) || server.IsBulkTarget(target)
+}
+
+// dialForward returns the forwarder for target of the input command: a
+// Splunk HTTP Event Collector for hec+https:// and hec+http:// URLs, an
+// Elasticsearch or OpenSearch index for es+https:// and es+http:// ones,
+// and what server.DialForwarder returns otherwise
+func dialForward(opts *settings, command, target string) (server.Forwarder, error) {
+	switch {
+	case server.IsHECTarget(target):
+		return hecForwarder(opts, command, target)
+	case server.IsBulkTarget(target):
+		return bulkForwarder(opts, target)
+	}
+	return server.DialForwarder(target)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// exitFindings is the exit status when --fail-on is exceeded, distinct
+// from 1 for processing failures so CI can tell them apart
+const exitFindings = 3
+
+// failThreshold is a parsed --fail-on value: the run fails when more than
+// count detections are at least as severe as severity
+type failThreshold struct {
+	spec     string
+	severity logveil.Severity
+	count    int
+}
+
+// parseFailOn accepts a count ("0" fails on any detection), a severity
+// ("high" fails on any high or critical detection) or both ("medium:10")
+func parseFailOn(value string) (*failThreshold, error) {
+	threshold := &failThreshold{spec: value, severity: logveil.SeverityLow}
+	severity, count, hasCount := strings.Cut(value, ":")
+	if _, err := strconv.Atoi(value); err == nil {
+		severity, count, hasCount = "", value, true
+	}
+
+	if severity != "" {
+		s, err := logveil.ParseSeverity(severity)
+		if err != nil {
+			return nil, err
+		}
+		threshold.severity = s
+	}
+	if hasCount {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid count %q", count)
+		}
+		threshold.count = n
+	}
+	return threshold, nil
+}
+
+// exceeded reports whether detections break the threshold, with a summary
+// of what counted against it
+func (t *failThreshold) exceeded(redactor *logveil.Redactor, detections map[string]int) (bool, string) {
+	total := 0
+	for rule, n := range detections {
+		if redactor.Severity(rule) >= t.severity {
+			total += n
+		}
+	}
+	if total <= t.count {
+		return false, ""
+	}
+	return true, fmt.Sprintf("%d detections of severity %s or higher, more than the %d allowed", total, t.severity, t.count)
+}
+
+// checkFailOn exits with exitFindings when detections exceed failOn, which
+// may be nil, or when skipped inputs, such as binary ones, went unscanned
+// and so cannot be shown to be within it
+func checkFailOn(failOn *failThreshold, redactor *logveil.Redactor, detections map[string]int, skipped int, shutdown func()) {
+	if failOn == nil {
+		return
+	}
+	if skipped > 0 {
+		slog.Error("Inputs were skipped unscanned, so --fail-on cannot be met", "threshold", failOn.spec, "skipped", skipped)
+		shutdown()
+		os.Exit(exitFindings)
+	}
+	if exceeded, summary := failOn.exceeded(redactor, detections); exceeded {
+		slog.Error("Findings exceed --fail-on", "threshold", failOn.spec, "findings", summary)
+		shutdown()
+		os.Exit(exitFindings)
+	}
+}
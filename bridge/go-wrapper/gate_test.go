@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+func TestFailOn(t *testing.T) {
+	redactor, err := logveil.NewRedactor(logveil.Config{Engine: "native"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redactor.Close()
+
+	tests := []struct {
+		spec       string
+		detections map[string]int
+		wantErr    bool
+		want       bool
+	}{
+		{spec: "0", detections: nil, want: false},
+		{spec: "0", detections: map[string]int{"email": 1}, want: true},
+		{spec: "2", detections: map[string]int{"email": 1, "ip_address": 1}, want: false},
+		{spec: "high", detections: map[string]int{"email": 5}, want: false},
+		{spec: "high", detections: map[string]int{"password": 1}, want: true},
+		{spec: "medium:1", detections: map[string]int{"email": 1, "ip_address": 1}, want: true},
+		{spec: "critical:1", detections: map[string]int{"password": 1}, want: false},
+		{spec: "-1", wantErr: true},
+		{spec: "high:x", wantErr: true},
+		{spec: "severe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			threshold, err := parseFailOn(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFailOn(%q) accepted an invalid threshold", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, summary := threshold.exceeded(redactor, tt.detections); got != tt.want {
+				t.Errorf("exceeded(%v) = %v (%s), want %v", tt.detections, got, summary, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// healthCheck is one check of `health`
+type healthCheck struct {
+	Name  string `json:"name"`
+	Path  string `json:"path,omitempty"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// healthReport is what `health` prints
+type healthReport struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []healthCheck `json:"checks"`
+}
+
+// add records the outcome of a check; err nil passes it
+func (r *healthReport) add(name, path string, err error) {
+	check := healthCheck{Name: name, Path: path, OK: err == nil}
+	if err != nil {
+		check.Error = err.Error()
+		r.Healthy = false
+		slog.Error("Health check failed", "check", name, "path", path, "error", err)
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// runHealth implements `health`, which checks a configuration before it is
+// deployed: that the config file, environment and flags load, the rules
+// compile, the Python agent can run when it is the engine, and every file
+// and directory the configuration writes, and each output given, can be
+// written. It prints a healthReport and exits 1 unless every check passed.
+func runHealth(args []string) {
+	opts := defaultSettings()
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	cli := registerFlags(fs, &opts)
+	mode := fs.String("mode", "", "subcommand to check the configuration of, such as serve or listen; empty checks file and batch runs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s health [--mode <command>] [flags] [output_dir|output_file]...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	report := &healthReport{Healthy: true}
+	defer writeHealth(report)
+
+	cli.parse(args)
+	switch *mode {
+	case "", "serve", "listen", "fluent", "loki", "otlp", "cloudwatch", "lambda", "queue", "kafka", "k8s":
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+	err := opts.load(*cli.configPath)
+	if err == nil {
+		// Explicit flags win over the config file and environment
+		err = cli.parse(args)
+	}
+	report.add("config", *cli.configPath, err)
+	if err != nil {
+		return
+	}
+	opts.applyCommandDefaults(*mode)
+	if *mode == "serve" {
+		_, _, err := serverSecurity(&opts)
+		report.add("server security", "", err)
+	}
+
+	redactor := checkRules(report, "rules", &opts)
+	if redactor != nil {
+		defer redactor.Close()
+	}
+	names := make([]string, 0, len(opts.Server.Tenants))
+	for name := range opts.Server.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if *mode == "serve" {
+		for _, name := range names {
+			own, err := tenantOptions(&opts, name)
+			if err != nil {
+				report.add("tenant "+name+" rules", "", err)
+				continue
+			}
+			if tenant := checkRules(report, "tenant "+name+" rules", own); tenant != nil {
+				tenant.Close()
+			}
+		}
+	}
+
+	switch {
+	case opts.Engine != "python":
+		report.add("agent", "", nil)
+	case redactor == nil:
+	case redactor.Fallback() != "":
+		report.add("agent", opts.Agent, errors.New(redactor.Fallback()))
+	default:
+		report.add("agent", opts.Agent, redactor.Check(context.Background()))
+	}
+
+	for _, path := range healthWrites(&opts, *mode, names, fs.Args()) {
+		if path == stdioPath || logveil.IsRemote(path) {
+			continue
+		}
+		report.add("write", path, checkWritable(path))
+	}
+}
+
+// checkRules builds the redactor opts describe to check its rules, formats
+// and engine, leaving its audit log unopened, and returns it if it built
+func checkRules(report *healthReport, name string, opts *settings) *logveil.Redactor {
+	own := *opts
+	own.Audit.Log = ""
+	redactor, err := newRedactor(&own, nil)
+	report.add(name, opts.RulesFile, err)
+	if err != nil {
+		return nil
+	}
+	return redactor
+}
+
+// healthWrites lists the files and directories opts writes in mode, those
+// of tenants included, followed by outputs
+func healthWrites(opts *settings, mode string, tenants, outputs []string) []string {
+	var paths []string
+	for _, path := range []string{opts.CacheFile, opts.Quarantine.Dir, opts.Audit.Log, opts.TokenStore, opts.SealMap} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if mode == "serve" {
+		if opts.Server.JobDir != "" {
+			paths = append(paths, opts.Server.JobDir)
+		}
+		for _, name := range tenants {
+			tenant := opts.Server.Tenants[name]
+			for _, path := range []string{tenant.TokenStore, tenant.AuditLog} {
+				if path != "" {
+					paths = append(paths, path)
+				}
+			}
+		}
+	}
+	return append(paths, outputs...)
+}
+
+// checkWritable reports why path cannot be written: an existing file is
+// opened for appending, and a file is made and removed in an existing
+// directory, or in the nearest parent of one yet to be created
+func checkWritable(path string) error {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && !info.IsDir():
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	case err == nil:
+	case errors.Is(err, os.ErrNotExist):
+		// The nearest directory that exists is where path would be created
+		for {
+			parent := filepath.Dir(path)
+			if parent == path {
+				return err
+			}
+			path = parent
+			if info, statErr := os.Stat(path); statErr == nil {
+				if !info.IsDir() {
+					return fmt.Errorf("%s is not a directory", path)
+				}
+				break
+			}
+		}
+	default:
+		return err
+	}
+	f, err := os.CreateTemp(path, ".logveil-health-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// writeHealth prints report and exits 1 unless it is healthy
+func writeHealth(report *healthReport) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		fatal("Failed to write result", "error", err)
+	}
+	fmt.Println(string(data))
+	if !report.Healthy {
+		os.Exit(1)
+	}
+}
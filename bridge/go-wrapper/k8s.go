@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/pipeline"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runK8s implements `k8s`, which redacts the logs of the selected pods
+// into outputDir, one file per container, or to the forward target when
+// outputDir is empty. With --follow it runs until interrupted.
+func runK8s(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics, outputDir string) error {
+	var since time.Duration
+	if opts.K8s.Since != "" {
+		var err error
+		if since, err = time.ParseDuration(opts.K8s.Since); err != nil {
+			return fmt.Errorf("invalid --since: %v", err)
+		}
+	}
+
+	client, namespace, err := pipeline.NewK8sClient(opts.K8s.Kubeconfig, opts.K8s.Context)
+	if err != nil {
+		return err
+	}
+	if opts.K8s.Namespace != "" {
+		namespace = opts.K8s.Namespace
+	}
+
+	var sink pipeline.LineSink
+	if outputDir != "" {
+		if sink, err = pipeline.NewDirSink(outputDir); err != nil {
+			return err
+		}
+	} else {
+		forward, err := dialForward(opts, "k8s", opts.K8s.Forward)
+		if err != nil {
+			return fmt.Errorf("forward to %s: %v", opts.K8s.Forward, err)
+		}
+		sink = pipeline.NewForwardSink(forward)
+	}
+	defer sink.Close()
+
+	collector, err := pipeline.NewK8sCollector(redactor, client, pipeline.K8sOptions{
+		Namespace: namespace,
+		Selector:  opts.K8s.Selector,
+		Container: opts.K8s.Container,
+		Since:     since,
+		Follow:    opts.Follow,
+	}, sink)
+	if err != nil {
+		return err
+	}
+	collector.Metrics = metrics
+	collector.ErrorLog = errorLog()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Redacting pod logs", "selector", opts.K8s.Selector, "namespace", namespace)
+	runErr := collector.Run(ctx)
+	if err := opts.writeResult(os.Stderr, collector.Stats()); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	return runErr
+}
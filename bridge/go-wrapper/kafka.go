@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/pipeline"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runKafka implements `kafka`, which redacts messages from one topic into
+// another until interrupted
+func runKafka(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics) error {
+	batchTimeout, err := time.ParseDuration(opts.Kafka.BatchTimeout)
+	if err != nil {
+		return err
+	}
+	p, err := pipeline.NewKafkaPipeline(redactor, pipeline.KafkaOptions{
+		Brokers:      opts.Kafka.Brokers,
+		InputTopic:   opts.Kafka.InputTopic,
+		OutputTopic:  opts.Kafka.OutputTopic,
+		GroupID:      opts.Kafka.Group,
+		BatchSize:    opts.Kafka.BatchSize,
+		BatchTimeout: batchTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	p.Metrics = metrics
+	p.ErrorLog = errorLog()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Redacting kafka topic", "input_topic", opts.Kafka.InputTopic, "output_topic", opts.Kafka.OutputTopic)
+	runErr := p.Run(ctx)
+	if err := opts.writeResult(os.Stderr, p.Stats()); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	return runErr
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runLambda implements `lambda`, which serves the invocations of an AWS
+// Lambda function, redacting the objects of S3 notifications and the
+// records of Kinesis events, until the runtime stops it
+func runLambda(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics) error {
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") == "" {
+		return fmt.Errorf("lambda mode runs in the Lambda runtime, which sets AWS_LAMBDA_RUNTIME_API")
+	}
+	if opts.Lambda.Output != "" && !logveil.IsRemote(opts.Lambda.Output) {
+		return fmt.Errorf("--lambda-output must be an object storage URI such as s3://bucket/prefix")
+	}
+	handler := server.NewLambdaHandler(redactor)
+	handler.ObjectOutput = opts.Lambda.Output
+	handler.Metrics = metrics
+	handler.ErrorLog = errorLog()
+
+	var records server.RecordSink
+	var err error
+	if sinkTarget(opts.Lambda.Forward) {
+		var lines server.Forwarder
+		lines, err = dialForward(opts, "lambda", opts.Lambda.Forward)
+		records = server.NewRecordLineSink(lines)
+	} else {
+		records, err = server.DialRecordSink(ctx, opts.Lambda.Forward)
+	}
+	if err != nil {
+		return fmt.Errorf("forward to %s: %v", opts.Lambda.Forward, err)
+	}
+	handler.Records = records
+
+	relay, closeRelay, err := cloudWatchRelay(ctx, redactor, opts, metrics)
+	if err != nil {
+		records.Close()
+		return err
+	}
+	handler.CloudWatch = relay
+
+	slog.Info("Serving lambda invocations", "output", opts.Lambda.Output, "forward", opts.Lambda.Forward, "cloudwatch_forward", opts.CloudWatch.Forward)
+	// StartWithOptions does not return; the runtime sends SIGTERM before
+	// shutting the function down
+	lambda.StartWithOptions(handler.Handle, lambda.WithContext(ctx), lambda.WithEnableSIGTERM(func() {
+		if err := opts.writeResult(os.Stderr, handler.Stats()); err != nil {
+			slog.Error("Failed to write result", "error", err)
+		}
+		records.Close()
+		closeRelay()
+	}))
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runListen implements `listen`, which relays syslog messages received on
+// UDP and/or TCP to the forward target, redacted, until interrupted
+func runListen(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics) error {
+	if opts.Listen.Syslog == "" {
+		return fmt.Errorf("--syslog is required, e.g. --syslog :5514")
+	}
+	serveUDP, serveTCP := false, false
+	switch opts.Listen.Protocol {
+	case "udp":
+		serveUDP = true
+	case "tcp":
+		serveTCP = true
+	case "", "both":
+		serveUDP, serveTCP = true, true
+	default:
+		return fmt.Errorf("unknown syslog protocol %q (expected udp, tcp or both)", opts.Listen.Protocol)
+	}
+
+	forward, err := dialForward(opts, "listen", opts.Listen.Forward)
+	if err != nil {
+		return fmt.Errorf("forward to %s: %v", opts.Listen.Forward, err)
+	}
+	defer forward.Close()
+	relay := server.NewSyslogRelay(redactor, forward)
+	relay.Metrics = metrics
+	relay.ErrorLog = errorLog()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The first listener to fail stops the other
+	var wg sync.WaitGroup
+	var serveErr error
+	var once sync.Once
+	serve := func(run func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run(); err != nil {
+				once.Do(func() { serveErr = err })
+				stop()
+			}
+		}()
+	}
+
+	if serveUDP {
+		conn, err := net.ListenPacket("udp", opts.Listen.Syslog)
+		if err != nil {
+			return fmt.Errorf("listen on udp %s: %v", opts.Listen.Syslog, err)
+		}
+		slog.Info("Receiving syslog", "protocol", "udp", "addr", conn.LocalAddr().String())
+		serve(func() error { return relay.ServeUDP(ctx, conn) })
+	}
+	if serveTCP {
+		listener, err := net.Listen("tcp", opts.Listen.Syslog)
+		if err != nil {
+			stop()
+			wg.Wait()
+			return fmt.Errorf("listen on tcp %s: %v", opts.Listen.Syslog, err)
+		}
+		slog.Info("Receiving syslog", "protocol", "tcp", "addr", listener.Addr().String())
+		serve(func() error { return relay.ServeTCP(ctx, listener) })
+	}
+	wg.Wait()
+
+	if err := opts.writeResult(os.Stderr, relay.Stats()); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	return serveErr
+}
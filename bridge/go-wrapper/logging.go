@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogging sends diagnostics to stderr as text or JSON records at level
+// and above, keeping stdout for redacted output and results. The standard
+// logger, which the engine's dependencies and the server and pipeline
+// packages fall back to, goes through the same handler.
+func setupLogging(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", level)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text", "":
+		handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+	default:
+		return fmt.Errorf("invalid log format %q (expected text or json)", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// errorLog returns a standard logger for services' per-message errors,
+// which it records as warnings
+func errorLog() *log.Logger {
+	return slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn)
+}
+
+// fatal logs msg and its attributes as an error and exits with status 1
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// usage prints text to stderr, unadorned, and exits with status 1
+func usage(text string) {
+	fmt.Fprintln(os.Stderr, text)
+	os.Exit(1)
+}
@@ -0,0 +1,182 @@
+package logveil
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// AccessLogOptions configures the access format
+type AccessLogOptions struct {
+	// QueryParams are query-string parameters whose values are replaced
+	// outright, matched like LogfmtOptions.Keys
+	QueryParams []string
+}
+
+// accessLogFormat redacts Apache and Nginx access logs in the common and
+// combined formats. The client address and user are replaced by position,
+// query parameters in the request and referer are decoded and redacted one
+// by one, and the user agent and anything after the combined fields go
+// through the engine. Timestamps, status and size are kept, and lines in
+// any other layout are redacted as plain text.
+type accessLogFormat struct {
+	params   keyPatterns
+	replacer replacer
+}
+
+func newAccessLogFormat(opts AccessLogOptions, repl replacer) *accessLogFormat {
+	return &accessLogFormat{params: newKeyPatterns(opts.QueryParams), replacer: repl}
+}
+
+// accessLogLine matches %h %l %u %t "%r" %>s %b, optionally followed by
+// "%{Referer}i" "%{User-Agent}i" and further fields
+var accessLogLine = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[[^\]]*\] "((?:[^"\\]|\\.)*)" (?:\d{3}|-) (?:\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?(.*)$`)
+
+// Submatch numbers of accessLogLine
+const (
+	accessHost = 1 + iota
+	accessIdent
+	accessUser
+	accessRequest
+	accessReferer
+	accessUserAgent
+	accessRest
+)
+
+func (f *accessLogFormat) redactLine(line string, redact redactFunc) (string, []Detection, error) {
+	m := accessLogLine.FindStringSubmatchIndex(line)
+	if m == nil {
+		return redact("", line)
+	}
+	field := func(group int) (string, int, int, bool) {
+		start, end := m[2*group], m[2*group+1]
+		if start < 0 {
+			return "", 0, 0, false
+		}
+		return line[start:end], start, end, true
+	}
+
+	var edits []jsonEdit
+	var detections []Detection
+	replace := func(group int, rule string) {
+		if value, start, end, ok := field(group); ok && value != "-" {
+			edits = append(edits, jsonEdit{start: start, end: end, text: f.replacer.replace(rule, value)})
+			detections = append(detections, Detection{Rule: rule})
+		}
+	}
+	through := func(group int, redactValue func(string) (string, []Detection, error)) error {
+		value, start, end, ok := field(group)
+		if !ok || value == "" || value == "-" {
+			return nil
+		}
+		redacted, found, err := redactValue(value)
+		if err != nil {
+			return err
+		}
+		detections = append(detections, found...)
+		if redacted != value {
+			edits = append(edits, jsonEdit{start: start, end: end, text: redacted})
+		}
+		return nil
+	}
+	redactURL := func(value string) (string, []Detection, error) {
+		return f.redactURL(value, redact)
+	}
+
+	replace(accessHost, "ip_address")
+	replace(accessIdent, "username")
+	replace(accessUser, "username")
+	request := func(value string) (string, []Detection, error) {
+		method, rest, ok := strings.Cut(value, " ")
+		target, protocol, ok2 := strings.Cut(rest, " ")
+		if !ok || !ok2 {
+			return redact("request", value)
+		}
+		redacted, found, err := f.redactURL(target, redact)
+		return method + " " + redacted + " " + protocol, found, err
+	}
+	for _, step := range []struct {
+		group  int
+		redact func(string) (string, []Detection, error)
+	}{
+		{accessRequest, request},
+		{accessReferer, redactURL},
+		{accessUserAgent, func(value string) (string, []Detection, error) { return redact("user_agent", value) }},
+		{accessRest, func(value string) (string, []Detection, error) { return redact("", value) }},
+	} {
+		if err := through(step.group, step.redact); err != nil {
+			return "", nil, err
+		}
+	}
+	return applyEdits(line, edits), detections, nil
+}
+
+// redactURL redacts the path of target through the engine and each query
+// parameter value by itself, decoded. Targeted parameters are replaced
+// outright. The parameter order and any fragment are kept.
+func (f *accessLogFormat) redactURL(target string, redact redactFunc) (string, []Detection, error) {
+	base, query, hasQuery := strings.Cut(target, "?")
+	fragment := ""
+	if i := strings.IndexByte(query, '#'); i >= 0 {
+		query, fragment = query[:i], query[i:]
+	}
+
+	redactedBase, detections, err := redact("path", base)
+	if err != nil {
+		return "", nil, err
+	}
+	if !hasQuery {
+		return redactedBase, detections, nil
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, value, hasValue := strings.Cut(param, "=")
+		if !hasValue || value == "" {
+			continue
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		decoded, err := url.QueryUnescape(value)
+		if err != nil {
+			decoded = value
+		}
+
+		var redacted string
+		if f.params.matches(name) {
+			redacted = f.replacer.replace(name, decoded)
+			detections = append(detections, Detection{Rule: "query_param"})
+		} else {
+			var found []Detection
+			redacted, found, err = redactKeyedValue(name, decoded, redact)
+			if err != nil {
+				return "", nil, err
+			}
+			detections = append(detections, found...)
+		}
+		if redacted != decoded {
+			params[i] = key + "=" + queryEscape(redacted)
+		}
+	}
+	return redactedBase + "?" + strings.Join(params, "&") + fragment, detections, nil
+}
+
+// queryEscape escapes a query value, leaving the brackets of placeholders
+// readable
+func queryEscape(value string) string {
+	escape := func(s string) string {
+		return strings.NewReplacer("%5B", "[", "%5D", "]").Replace(url.QueryEscape(s))
+	}
+	// Placeholder markers are resolved later and must survive intact
+	var out strings.Builder
+	last := 0
+	for _, m := range markerPattern.FindAllStringIndex(value, -1) {
+		out.WriteString(escape(value[last:m[0]]))
+		out.WriteString(value[m[0]:m[1]])
+		last = m[1]
+	}
+	out.WriteString(escape(value[last:]))
+	return out.String()
+}
@@ -0,0 +1,140 @@
+package logveil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AllowEntry exempts a known-benign value, such as a support address or a
+// documentation IP, from redaction. Exactly one of Value and Pattern is
+// set; either is compared with the whole text a rule would replace.
+type AllowEntry struct {
+	// Value is left visible wherever it occurs exactly
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	// Pattern is an RE2 regular expression that must match all of a value
+	// to exempt it
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	// Rules limits the entry to the named rules; it applies to every rule
+	// when empty
+	Rules []string `json:"rules,omitempty" yaml:"rules,omitempty"`
+	// Comment records why the value is safe; it is not used
+	Comment string `json:"comment,omitempty" yaml:"comment,omitempty"`
+}
+
+// allowlistFile is the on-disk layout of an allowlist file
+type allowlistFile struct {
+	Allow []AllowEntry `json:"allow" yaml:"allow"`
+}
+
+// LoadAllowlist reads a YAML or JSON allowlist file and validates every
+// entry
+func LoadAllowlist(path string) ([]AllowEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file allowlistFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	default:
+		return nil, fmt.Errorf("%s: unsupported allowlist format %q (expected .yaml, .yml or .json)", path, filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, err := compileAllowlist(file.Allow); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return file.Allow, nil
+}
+
+// allowMatcher is a compiled AllowEntry
+type allowMatcher struct {
+	value   string
+	pattern *regexp.Regexp
+	// rules is nil when the entry applies to every rule
+	rules map[string]bool
+}
+
+// compileAllowlist checks and compiles entries
+func compileAllowlist(entries []AllowEntry) ([]allowMatcher, error) {
+	matchers := make([]allowMatcher, 0, len(entries))
+	for i, entry := range entries {
+		if (entry.Value == "") == (entry.Pattern == "") {
+			return nil, fmt.Errorf("allowlist entry %d needs exactly one of value and pattern", i+1)
+		}
+		m := allowMatcher{value: entry.Value}
+		if entry.Pattern != "" {
+			pattern, err := regexp.Compile(`\A(?:` + entry.Pattern + `)\z`)
+			if err != nil {
+				return nil, fmt.Errorf("allowlist entry %d: invalid pattern: %v", i+1, err)
+			}
+			m.pattern = pattern
+		}
+		if len(entry.Rules) > 0 {
+			m.rules = make(map[string]bool, len(entry.Rules))
+			for _, rule := range entry.Rules {
+				m.rules[rule] = true
+			}
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// allows reports whether m exempts value from rule
+func (m allowMatcher) allows(rule, value string) bool {
+	if m.rules != nil && !m.rules[rule] {
+		return false
+	}
+	if m.pattern != nil {
+		return m.pattern.MatchString(value)
+	}
+	return value == m.value
+}
+
+// allowValues makes every detector in detectors skip the values matchers
+// exempt from it
+func allowValues(detectors []detector, matchers []allowMatcher) {
+	if len(matchers) == 0 {
+		return
+	}
+	for i, d := range detectors {
+		var applicable []allowMatcher
+		for _, m := range matchers {
+			if m.rules == nil || m.rules[d.name] {
+				applicable = append(applicable, m)
+			}
+		}
+		if applicable == nil {
+			continue
+		}
+		validate, rule := d.validate, d.name
+		detectors[i].validate = func(value string) bool {
+			if validate != nil && !validate(value) {
+				return false
+			}
+			for _, m := range applicable {
+				if m.allows(rule, value) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+}
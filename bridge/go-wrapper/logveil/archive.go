@@ -0,0 +1,314 @@
+package logveil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Archive kinds recognised by extension
+const (
+	archiveTar = "tar"
+	archiveZip = "zip"
+)
+
+// tarExtensions name tar files, compressed or not; the compression itself
+// is sniffed
+var tarExtensions = []string{".tar", ".tar.gz", ".tgz", ".tar.zst", ".tar.bz2", ".tbz2"}
+
+// archiveKind returns the kind of archive path names by its extension, or
+// "" for any other file
+func archiveKind(path string) string {
+	name := strings.ToLower(path)
+	if strings.HasSuffix(name, ".zip") {
+		return archiveZip
+	}
+	for _, ext := range tarExtensions {
+		if strings.HasSuffix(name, ext) {
+			return archiveTar
+		}
+	}
+	return ""
+}
+
+// processArchive redacts every regular file in the tar or zip archive at
+// inputPath into an archive of the same kind at outputPath. Names, modes,
+// times, directories and links are kept, a tar keeps its compression, and
+// members compressed on their own are redacted inside and compressed again.
+// Each member is staged in a temporary directory so any engine can handle
+// it.
+func (r *Redactor) processArchive(ctx context.Context, kind, inputPath, outputPath string) (*ProcessResult, error) {
+	if r.compression != "" {
+		return failedResult(newError(CodeConfig, StageSetup, "compressed output does not apply to archives, which keep their own compression"))
+	}
+	startTime := time.Now()
+	result := &ProcessResult{}
+	fail := func(err error) (*ProcessResult, error) {
+		result.addError(err)
+		result.finish(startTime)
+		return result, err
+	}
+
+	dir, err := os.MkdirTemp("", "logveil-archive-")
+	if err != nil {
+		return fail(newError(CodeIO, StageSetup, "create staging dir: %v", err))
+	}
+	defer os.RemoveAll(dir)
+	stage := archiveStage{redactor: r, dir: dir, result: result}
+
+	if kind == archiveZip {
+		err = stage.zip(ctx, inputPath, outputPath)
+	} else {
+		err = stage.tar(ctx, inputPath, outputPath)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	result.Success = true
+	result.finish(startTime)
+	return result, nil
+}
+
+// archiveStage redacts archive members through files in dir, adding up
+// their results
+type archiveStage struct {
+	redactor *Redactor
+	dir      string
+	result   *ProcessResult
+}
+
+func (s archiveStage) tar(ctx context.Context, inputPath, outputPath string) error {
+	codec, err := fileCompression(inputPath)
+	if err != nil {
+		return newError(CodeIO, StageRead, "open input: %v", err)
+	}
+	in, err := openInput(inputPath)
+	if err != nil {
+		return newError(CodeIO, StageRead, "open input: %v", err)
+	}
+	defer in.Close()
+	out, err := createOutput(outputPath, codec)
+	if err != nil {
+		return newError(CodeIO, StageWrite, "create output: %v", err)
+	}
+	defer out.Close()
+
+	tr := tar.NewReader(in)
+	tw := tar.NewWriter(out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newError(CodeIO, StageRead, "read input: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return newError(CodeIO, StageWrite, "write output: %v", err)
+			}
+			continue
+		}
+
+		redacted, err := s.member(ctx, hdr.Name, tr)
+		if err != nil {
+			return err
+		}
+		if err := copyTarMember(tw, hdr, redacted); err != nil {
+			return newError(CodeIO, StageWrite, "%s: write output: %v", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return newError(CodeIO, StageWrite, "write output: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return newError(CodeIO, StageWrite, "write output: %v", err)
+	}
+	return nil
+}
+
+// copyTarMember writes hdr, resized, and the contents of path to tw
+func copyTarMember(tw *tar.Writer, hdr *tar.Header, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr.Size = info.Size()
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func (s archiveStage) zip(ctx context.Context, inputPath, outputPath string) error {
+	zr, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return newError(CodeIO, StageRead, "open input: %v", err)
+	}
+	defer zr.Close()
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return newError(CodeIO, StageWrite, "create output: %v", err)
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	zw.SetComment(zr.Comment)
+	for _, file := range zr.File {
+		hdr := file.FileHeader
+		if !file.Mode().IsRegular() {
+			// Directories and symlinks, whose target is their content
+			if err := zw.Copy(file); err != nil {
+				return newError(CodeIO, StageWrite, "write output: %v", err)
+			}
+			continue
+		}
+
+		member, err := file.Open()
+		if err != nil {
+			return newError(CodeIO, StageRead, "%s: read input: %v", file.Name, err)
+		}
+		redacted, err := s.member(ctx, file.Name, member)
+		member.Close()
+		if err != nil {
+			return err
+		}
+		if err := copyZipMember(zw, &hdr, redacted); err != nil {
+			return newError(CodeIO, StageWrite, "%s: write output: %v", file.Name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return newError(CodeIO, StageWrite, "write output: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return newError(CodeIO, StageWrite, "write output: %v", err)
+	}
+	return nil
+}
+
+// copyZipMember writes the contents of path to zw under hdr, whose sizes
+// and checksum are recomputed
+func copyZipMember(zw *zip.Writer, hdr *zip.FileHeader, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hdr.CRC32, hdr.CompressedSize64, hdr.UncompressedSize64 = 0, 0, 0
+	hdr.CompressedSize, hdr.UncompressedSize = 0, 0
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// member redacts one archive member, decompressing it first and
+// compressing the result the same way when it is compressed, and returns
+// the path of the redacted copy
+func (s archiveStage) member(ctx context.Context, name string, r io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", cancelError(StageRedact, err)
+	}
+	buffered := bufio.NewReader(r)
+	header, _ := buffered.Peek(len(zstdMagic))
+	codec := sniffCompression(header)
+
+	stagedInput := filepath.Join(s.dir, "input.log")
+	stagedOutput := filepath.Join(s.dir, "output.log")
+	if err := stageMember(stagedInput, buffered); err != nil {
+		return "", newError(CodeIO, StageRead, "%s: stage input: %v", name, err)
+	}
+	redacted, err := s.redactMember(ctx, name, stagedInput, stagedOutput)
+	if err != nil {
+		return "", err
+	}
+
+	if codec == "" {
+		return redacted, nil
+	}
+	compressed := filepath.Join(s.dir, "output.compressed")
+	if err := copyFile(compressed, codec, redacted); err != nil {
+		return "", newError(CodeIO, StageWrite, "%s: write output: %v", name, err)
+	}
+	return compressed, nil
+}
+
+// redactMember redacts the staged member stagedInput into stagedOutput and
+// returns the file holding its new content. Binary members are kept as
+// they are, and members in another encoding are converted to UTF-8 for
+// engines that read the file themselves.
+func (s archiveStage) redactMember(ctx context.Context, name, stagedInput, stagedOutput string) (string, error) {
+	engine := s.redactor.engine
+	if !streamsLines(engine) {
+		encoding, err := sniffFile(stagedInput)
+		if err != nil {
+			return "", newError(CodeIO, StageRead, "%s: stage input: %v", name, err)
+		}
+		switch encoding {
+		case SkippedBinary:
+			return stagedInput, nil
+		case "":
+		default:
+			converted := filepath.Join(s.dir, "input.utf8")
+			if _, err := stageText(converted, stagedInput); err != nil {
+				return "", newError(CodeIO, StageRead, "%s: stage input: %v", name, err)
+			}
+			stagedInput = converted
+		}
+	}
+
+	result, err := engine.ProcessFile(ctx, stagedInput, stagedOutput)
+	if err != nil {
+		return "", newError(CodeRedact, StageRedact, "%s: %v", name, err)
+	}
+	if result.Skipped != "" {
+		return stagedInput, nil
+	}
+	s.add(result)
+	return stagedOutput, nil
+}
+
+// stageMember writes r, decompressed, to path
+func stageMember(path string, r io.Reader) error {
+	in, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// add counts a member's result in the archive's
+func (s archiveStage) add(member *ProcessResult) {
+	s.result.LinesProcessed += member.LinesProcessed
+	s.result.BytesRead += member.BytesRead
+	s.result.BytesWritten += member.BytesWritten
+	for rule, n := range member.Detections {
+		if s.result.Detections == nil {
+			s.result.Detections = make(map[string]int)
+		}
+		s.result.Detections[rule] += n
+	}
+}
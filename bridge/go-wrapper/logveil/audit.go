@@ -0,0 +1,360 @@
+package logveil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+const (
+	// auditHashSuffix ends every audit entry, the hash being its last field
+	auditHashSuffix = `,"hash":""}`
+	// maxAuditEntry is the longest audit entry read back
+	maxAuditEntry = 1 << 20
+)
+
+// auditMu serialises appends by this process; file locks keep other
+// processes sharing the log from interleaving with them
+var auditMu sync.Mutex
+
+// AuditOptions enables the audit log: a record, appended to Path, of every
+// file and stream a Redactor redacts, for evidence of how data was
+// handled. Each entry is chained to the one before it by hash, so that
+// VerifyAuditLog detects entries edited, removed or reordered.
+type AuditOptions struct {
+	// Path is the JSON Lines file entries are appended to; empty disables
+	// the log
+	Path string
+	// Actor names who runs the redactions when their context names no one
+	// else, as WithActor does; empty uses the OS user and host
+	Actor string
+}
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	// Seq numbers entries from 1
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	// Actor is who ran the redaction, and Tenant the serve mode tenant it
+	// ran for
+	Actor  string `json:"actor"`
+	Tenant string `json:"tenant,omitempty"`
+	// Operation is file, stream, follow, in-place or the name of a server
+	// method
+	Operation string `json:"operation"`
+	Input     string `json:"input,omitempty"`
+	Output    string `json:"output,omitempty"`
+	// InputSHA256 and OutputSHA256 are the hex SHA-256 of the data read
+	// and written, where it could be hashed
+	InputSHA256  string `json:"input_sha256,omitempty"`
+	OutputSHA256 string `json:"output_sha256,omitempty"`
+	Engine       string `json:"engine"`
+	// Ruleset fingerprints the rules and settings the output was redacted
+	// with, so that entries redacted alike share it
+	Ruleset      string         `json:"ruleset"`
+	Lines        int            `json:"lines"`
+	Detections   map[string]int `json:"detections,omitempty"`
+	BytesRead    int64          `json:"bytes_read"`
+	BytesWritten int64          `json:"bytes_written"`
+	DurationMS   int64          `json:"duration_ms"`
+	Success      bool           `json:"success"`
+	Error        string         `json:"error,omitempty"`
+	// Prev is the hash of the entry before, "" for the first, and Hash
+	// the hex SHA-256 of this entry's JSON with Hash empty
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// actorKey is the context key of who redacts
+type actorKey struct{}
+
+// WithActor returns ctx attributing the redaction done under it to actor
+// in the audit log, such as the server client that asked for it
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// auditLog appends entries to an audit log
+type auditLog struct {
+	path  string
+	actor string
+}
+
+// openAuditLog returns the audit log opts configures, or nil, checking
+// that it can be appended to and that its last entry reads back
+func openAuditLog(opts AuditOptions) (*auditLog, error) {
+	if opts.Path == "" {
+		return nil, nil
+	}
+	actor := opts.Actor
+	if actor == "" {
+		actor = defaultActor()
+	}
+	a := &auditLog{path: opts.Path, actor: actor}
+	f, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit log: %v", err)
+	}
+	defer f.Close()
+	if _, err := lastAuditEntry(f); err != nil {
+		return nil, fmt.Errorf("audit log %s: %v", opts.Path, err)
+	}
+	return a, nil
+}
+
+// defaultActor names the OS user running the process, and its host
+func defaultActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
+
+// record appends entry, filling in who ran it and its place in the chain
+func (a *auditLog) record(ctx context.Context, entry AuditEntry) error {
+	if a == nil {
+		return nil
+	}
+	entry.Actor = a.actor
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		entry.Actor = actor
+	}
+	entry.Tenant, _ = ctx.Value(tenantKey{}).(string)
+	entry.Time = entry.Time.UTC()
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	last, err := lastAuditEntry(f)
+	if err != nil {
+		return err
+	}
+	entry.Seq, entry.Prev = last.Seq+1, last.Hash
+	line, err := sealAuditEntry(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// sealAuditEntry returns the line of entry, with its hash set
+func sealAuditEntry(entry AuditEntry) ([]byte, error) {
+	entry.Hash = ""
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	line := append(body[:len(body)-len(auditHashSuffix)], `,"hash":"`+hex.EncodeToString(sum[:])+`"}`+"\n"...)
+	return line, nil
+}
+
+// entryHash returns the hash line should carry, checking that it ends in
+// its own
+func entryHash(line []byte) (string, error) {
+	// ,"hash":"<64 hex digits>"}
+	const sealed = len(auditHashSuffix) + 2*sha256.Size
+	if len(line) < sealed || !bytes.HasPrefix(line[len(line)-sealed:], []byte(`,"hash":"`)) {
+		return "", errors.New("entry does not end in its hash")
+	}
+	body := append(append([]byte{}, line[:len(line)-sealed]...), auditHashSuffix...)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastAuditEntry reads the last entry of the log in f, the zero entry when
+// it has none
+func lastAuditEntry(f *os.File) (AuditEntry, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return AuditEntry{}, nil
+	}
+	n := min(size, maxAuditEntry+1)
+	tail := make([]byte, n)
+	if _, err := f.ReadAt(tail, size-n); err != nil && err != io.EOF {
+		return AuditEntry{}, err
+	}
+	if tail[len(tail)-1] != '\n' {
+		return AuditEntry{}, errors.New("last entry is incomplete")
+	}
+	tail = tail[:len(tail)-1]
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	} else if n < size {
+		return AuditEntry{}, errors.New("last entry is too long")
+	}
+	var last AuditEntry
+	if err := json.Unmarshal(tail, &last); err != nil {
+		return AuditEntry{}, fmt.Errorf("last entry: %v", err)
+	}
+	return last, nil
+}
+
+// VerifyAuditLog checks the hash chain of the audit log at path, returning
+// how many entries it holds. An error names the first entry that does not
+// follow from the one before it.
+func VerifyAuditLog(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), maxAuditEntry)
+	var prev AuditEntry
+	for n := int64(1); scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return n - 1, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		digest, err := entryHash(line)
+		switch {
+		case err != nil:
+			return n - 1, fmt.Errorf("%s:%d: %v", path, n, err)
+		case digest != entry.Hash:
+			return n - 1, fmt.Errorf("%s:%d: entry %d was altered", path, n, entry.Seq)
+		case entry.Seq != prev.Seq+1 || entry.Prev != prev.Hash:
+			return n - 1, fmt.Errorf("%s:%d: entry %d does not follow entry %d", path, n, entry.Seq, prev.Seq)
+		}
+		prev = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return prev.Seq, fmt.Errorf("%s: %v", path, err)
+	}
+	return prev.Seq, nil
+}
+
+// auditResult records an operation of r ending with result and err, and
+// fails result when the record cannot be written, as the redaction would
+// otherwise go unaccounted for
+func (r *Redactor) auditResult(ctx context.Context, entry AuditEntry, start time.Time, result *ProcessResult, err error) (*ProcessResult, error) {
+	if r.audit == nil {
+		return result, err
+	}
+	if result != nil {
+		entry.Lines = result.LinesProcessed
+		entry.Detections = result.Detections
+		entry.BytesRead, entry.BytesWritten = result.BytesRead, result.BytesWritten
+		entry.Success = result.Success
+	}
+	if err != nil {
+		entry.Success, entry.Error = false, err.Error()
+	}
+	if auditErr := r.recordAudit(ctx, entry, start); auditErr != nil {
+		auditErr = newError(CodeIO, StageWrite, "audit log: %v", auditErr)
+		if result == nil {
+			return failedResult(auditErr)
+		}
+		result.Success = false
+		result.addError(auditErr)
+		if err == nil {
+			err = auditErr
+		}
+	}
+	return result, err
+}
+
+// Audit records in r's audit log, if it has one, a redaction that began at
+// start and went through r's engine rather than ProcessFile or
+// ProcessStream, such as a stream of lines served over the network. The
+// entry's time, duration, engine, ruleset, actor and tenant are filled in.
+func (r *Redactor) Audit(ctx context.Context, entry AuditEntry, start time.Time) error {
+	return r.snapshot().recordAudit(ctx, entry, start)
+}
+
+// recordAudit fills in and appends entry, for a redaction that began at
+// start
+func (r *Redactor) recordAudit(ctx context.Context, entry AuditEntry, start time.Time) error {
+	if r.audit == nil {
+		return nil
+	}
+	entry.Time = start
+	entry.DurationMS = time.Since(start).Milliseconds()
+	entry.Engine = engineName(r.engine)
+	entry.Ruleset = r.ruleset
+	return r.audit.record(ctx, entry)
+}
+
+// engineName names the engine that redacts for engine
+func engineName(engine Engine) string {
+	if f, ok := engine.(*formatEngine); ok {
+		engine = f.Engine
+	}
+	switch engine.(type) {
+	case *NativeEngine:
+		return "native"
+	case *PythonEngine:
+		return "python"
+	}
+	return fmt.Sprintf("%T", engine)
+}
+
+// sumHex returns the hex digest of h
+func sumHex(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashingReader hashes what is read through it
+type hashingReader struct {
+	io.Reader
+	hash hash.Hash
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{Reader: r, hash: sha256.New()}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.Reader.Read(p)
+	h.hash.Write(p[:n])
+	return n, err
+}
+
+// hashingWriter hashes what is written through it
+type hashingWriter struct {
+	io.Writer
+	hash hash.Hash
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{Writer: w, hash: sha256.New()}
+}
+
+func (h *hashingWriter) Write(p []byte) (int, error) {
+	n, err := h.Writer.Write(p)
+	h.hash.Write(p[:n])
+	return n, err
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package logveil
+
+import "os"
+
+// lockFile does nothing where files cannot be locked portably; appends
+// from within one process are still serialised
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing, as lockFile took no lock
+func unlockFile(f *os.File) error {
+	return nil
+}
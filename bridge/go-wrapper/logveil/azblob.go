@@ -0,0 +1,110 @@
+package logveil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// azblobBlockSize is the block size of streamed uploads, buffered per
+// concurrent block
+const azblobBlockSize = 16 << 20
+
+// azblobStore serves azblob://container/blob URIs in the storage account
+// $AZURE_STORAGE_ACCOUNT, under $AZURE_STORAGE_DOMAIN (default
+// blob.core.windows.net). Credentials come from DefaultAzureCredential
+// (environment, workload identity, managed identity, the Azure CLI), or a
+// connection string in $AZURE_STORAGE_CONNECTION_STRING, which also names
+// the account.
+type azblobStore struct {
+	client *azblob.Client
+}
+
+func newAzblobStore(context.Context) (objectStore, error) {
+	if conn := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); conn != "" {
+		client, err := azblob.NewClientFromConnectionString(conn, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &azblobStore{client: client}, nil
+	}
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	if account == "" {
+		return nil, fmt.Errorf("set AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING")
+	}
+	domain := os.Getenv("AZURE_STORAGE_DOMAIN")
+	if domain == "" {
+		domain = "blob.core.windows.net"
+	}
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := azblob.NewClient(fmt.Sprintf("https://%s.%s/", account, domain), credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &azblobStore{client: client}, nil
+}
+
+// azblobLocation splits an azblob:// URI into container and blob
+func azblobLocation(u *url.URL) (string, string, error) {
+	container, blob := u.Host, strings.TrimPrefix(u.Path, "/")
+	if container == "" || blob == "" {
+		return "", "", fmt.Errorf("invalid Azure Blob URI %q (expected azblob://container/blob)", u.String())
+	}
+	return container, blob, nil
+}
+
+func (s *azblobStore) open(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	container, blob, err := azblobLocation(u)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.DownloadStream(ctx, container, blob, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *azblobStore) size(ctx context.Context, u *url.URL) (int64, error) {
+	container, blob, err := azblobLocation(u)
+	if err != nil {
+		return 0, err
+	}
+	props, err := s.client.ServiceClient().NewContainerClient(container).NewBlobClient(blob).GetProperties(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if props.ContentLength == nil {
+		return 0, fmt.Errorf("%s has no content length", u.String())
+	}
+	return *props.ContentLength, nil
+}
+
+// create streams writes into a block blob upload through a pipe. Blocks are
+// only committed once the pipe is closed, so an aborted upload leaves no
+// blob behind.
+func (s *azblobStore) create(ctx context.Context, u *url.URL) (objectWriter, error) {
+	container, blob, err := azblobLocation(u)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	upload := &pipeUpload{pipe: writer, done: make(chan error, 1)}
+	go func() {
+		_, err := s.client.UploadStream(ctx, container, blob, reader, &azblob.UploadStreamOptions{BlockSize: azblobBlockSize})
+		// Unblock writers if the upload fails first
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+	return upload, nil
+}
@@ -0,0 +1,305 @@
+package logveil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// FileJob pairs an input file with the path its redacted copy is written to
+type FileJob struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+}
+
+// FileResult is the outcome of a single FileJob
+type FileResult struct {
+	FileJob
+	Result *ProcessResult `json:"result,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	// Cached reports that the file was unchanged since an earlier batch
+	// and was not redacted again; Result is the one recorded then
+	Cached bool `json:"cached,omitempty"`
+	// Quarantined is where the failed input was set aside, when a
+	// quarantine directory is configured
+	Quarantined string `json:"quarantined,omitempty"`
+}
+
+// BatchResult aggregates the results of a batch run
+type BatchResult struct {
+	Success        bool `json:"success"`
+	FilesProcessed int  `json:"files_processed"`
+	FilesFailed    int  `json:"files_failed"`
+	// FilesCached counts the processed files skipped as unchanged
+	FilesCached int `json:"files_cached,omitempty"`
+	// FilesSkipped counts the files left unredacted, such as binary ones,
+	// which keep the batch from succeeding
+	FilesSkipped int `json:"files_skipped,omitempty"`
+	// FilesQuarantined counts the failed files set aside for inspection
+	FilesQuarantined int            `json:"files_quarantined,omitempty"`
+	LinesProcessed   int            `json:"lines_processed"`
+	Detections       map[string]int `json:"detections,omitempty"`
+	BytesRead        int64          `json:"bytes_read"`
+	BytesWritten     int64          `json:"bytes_written"`
+	Files            []FileResult   `json:"files"`
+	Duration         string         `json:"duration"`
+	LinesPerSecond   float64        `json:"lines_per_second,omitempty"`
+	BytesPerSecond   float64        `json:"bytes_per_second,omitempty"`
+	// PeakRSSBytes and SubprocessCPUSeconds are as in ProcessResult, for
+	// the whole batch
+	PeakRSSBytes         int64   `json:"peak_rss_bytes,omitempty"`
+	SubprocessCPUSeconds float64 `json:"subprocess_cpu_seconds,omitempty"`
+	// CacheError reports why the results could not be saved to the cache
+	CacheError string `json:"cache_error,omitempty"`
+}
+
+// ExpandInputs resolves files, directories and glob patterns into jobs that
+// write under outputDir, mirroring each input's path relative to the
+// directory or glob root it was found in. Inputs that would write the same
+// output, such as a/app.log and b/app.log, fail with a CodeConfig
+// ProcessError naming both; an empty outputDir, for callers that only want
+// the inputs, allows them.
+func ExpandInputs(inputs []string, outputDir string) ([]FileJob, error) {
+	return ExpandInputsWith(inputs, outputDir, WalkOptions{})
+}
+
+// ExpandInputsWith is ExpandInputs taking only the files in directories and
+// glob matches that opts select
+func ExpandInputsWith(inputs []string, outputDir string, opts WalkOptions) ([]FileJob, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	var jobs []FileJob
+	seen := make(map[string]bool)
+	// writers maps each output to the input written to it
+	writers := make(map[string]string)
+
+	add := func(root, file string) error {
+		file = filepath.Clean(file)
+		if seen[file] {
+			return nil
+		}
+		seen[file] = true
+
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		output := filepath.Join(outputDir, rel)
+		if other, ok := writers[output]; ok && outputDir != "" {
+			return newError(CodeConfig, StageSetup, "%s and %s would both be written to %s; pass their common parent directory instead", other, file, output)
+		}
+		writers[output] = file
+		jobs = append(jobs, FileJob{Input: file, Output: output})
+		return nil
+	}
+
+	for _, input := range inputs {
+		if hasGlobMeta(input) {
+			root, matches, err := expandGlob(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, match := range matches {
+				if rel, err := filepath.Rel(root, match); err != nil || !opts.selected(filepath.ToSlash(rel)) {
+					continue
+				}
+				if err := add(root, match); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		info, err := os.Stat(input)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if err := add(filepath.Dir(input), input); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = opts.walk(input, func(file string) error {
+			return add(input, file)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return jobs, nil
+}
+
+// ProcessBatch runs jobs on a pool of workers goroutines and collects the
+// per-file results in job order. Compression extensions on output paths are
+// replaced to match the configured output codec, so a redacted app.log.gz is
+// written as app.log unless gzip output was requested. Once ctx is
+// cancelled, jobs not yet started fail as skipped.
+func (r *Redactor) ProcessBatch(ctx context.Context, jobs []FileJob, workers int) *BatchResult {
+	r, release := r.acquire()
+	defer release()
+	batch := r.processBatch(ctx, r.renameCompressedOutputs(jobs), workers, r.processJob)
+	if r.cache != nil {
+		if err := r.cache.save(); err != nil {
+			batch.CacheError = err.Error()
+		}
+	}
+	return batch
+}
+
+// processBatch runs each job through run on a pool of workers goroutines
+func (r *Redactor) processBatch(ctx context.Context, jobs []FileJob, workers int, run func(context.Context, FileJob) FileResult) *BatchResult {
+	startTime := time.Now()
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, span := startSpan(ctx, "logveil.ProcessBatch",
+		attribute.Int("logveil.files", len(jobs)), attribute.Int("logveil.workers", workers))
+	defer span.End()
+
+	results := make([]FileResult, len(jobs))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				if err := ctx.Err(); err != nil {
+					// Interrupted: files not yet started are left alone
+					results[index] = FileResult{FileJob: jobs[index], Error: fmt.Sprintf("skipped: processing cancelled: %v", err)}
+					continue
+				}
+				results[index] = run(ctx, jobs[index])
+			}
+		}()
+	}
+
+	for index := range jobs {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	batch := &BatchResult{Files: results}
+	for _, file := range results {
+		switch {
+		case file.Error != "":
+			batch.FilesFailed++
+		case file.Result != nil && file.Result.Skipped != "":
+			batch.FilesSkipped++
+		default:
+			batch.FilesProcessed++
+		}
+		if file.Cached {
+			batch.FilesCached++
+		}
+		if file.Quarantined != "" {
+			batch.FilesQuarantined++
+		}
+		if file.Result != nil {
+			batch.LinesProcessed += file.Result.LinesProcessed
+			batch.BytesRead += file.Result.BytesRead
+			batch.BytesWritten += file.Result.BytesWritten
+			batch.SubprocessCPUSeconds += file.Result.SubprocessCPUSeconds
+			for rule, count := range file.Result.Detections {
+				if batch.Detections == nil {
+					batch.Detections = make(map[string]int)
+				}
+				batch.Detections[rule] += count
+			}
+		}
+	}
+	batch.Success = batch.FilesFailed == 0 && batch.FilesSkipped == 0
+	elapsed := time.Since(startTime)
+	batch.Duration = elapsed.String()
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		batch.LinesPerSecond = float64(batch.LinesProcessed) / seconds
+		batch.BytesPerSecond = float64(batch.BytesRead) / seconds
+	}
+	batch.PeakRSSBytes = peakRSS()
+
+	span.SetAttributes(
+		attribute.Int("logveil.files_processed", batch.FilesProcessed),
+		attribute.Int("logveil.files_failed", batch.FilesFailed),
+		attribute.Int("logveil.lines_processed", batch.LinesProcessed),
+		attribute.Int("logveil.detections", totalDetections(batch.Detections)),
+	)
+	if !batch.Success {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d files failed, %d skipped", batch.FilesFailed, len(jobs), batch.FilesSkipped))
+	}
+	return batch
+}
+
+// renameCompressedOutputs returns a copy of jobs with output extensions
+// matching the output codec. Outputs that would collide after renaming,
+// such as app.log.gz and app.log.bz2, keep their original names.
+func (r *Redactor) renameCompressedOutputs(jobs []FileJob) []FileJob {
+	renamed := make([]FileJob, len(jobs))
+	counts := make(map[string]int, len(jobs))
+	for i, job := range jobs {
+		renamed[i] = job
+		renamed[i].Output = compressedName(job.Output, r.compression)
+		counts[renamed[i].Output]++
+	}
+	for i := range renamed {
+		if counts[renamed[i].Output] > 1 {
+			renamed[i].Output = jobs[i].Output
+		}
+	}
+	return renamed
+}
+
+// processJob redacts a single batch entry, creating its output directory
+func (r *Redactor) processJob(ctx context.Context, job FileJob) FileResult {
+	file := FileResult{FileJob: job}
+
+	if err := os.MkdirAll(filepath.Dir(job.Output), 0o755); err != nil {
+		file.Error = fmt.Sprintf("create output directory: %v", err)
+		return file
+	}
+
+	// Unchanged files are skipped, and others hashed for the cache first
+	var digest string
+	cached := r.cache != nil && !IsRemote(job.Input) && !IsRemote(job.Output)
+	if cached {
+		var hit bool
+		if file.Result, digest, hit = r.cache.lookup(job, r.ruleset); hit {
+			file.Cached = true
+			return file
+		}
+	}
+
+	result, err := r.ProcessFile(ctx, job.Input, job.Output)
+	file.Result = result
+	if err != nil {
+		file.Error = err.Error()
+		r.quarantineFailed(ctx, &file)
+	} else if cached && result.Success && result.Skipped == "" {
+		r.cache.record(job, digest, r.ruleset, result)
+	}
+	return file
+}
+
+// quarantineFailed sets the input of a failed file aside when a quarantine
+// directory is configured. Inputs are not set aside for an interruption,
+// which is no fault of theirs, nor when remote.
+func (r *Redactor) quarantineFailed(ctx context.Context, file *FileResult) {
+	if r.quarantine.Dir == "" || ctx.Err() != nil || IsRemote(file.Input) {
+		return
+	}
+	var err error
+	if file.Quarantined, err = r.quarantine.quarantine(*file); err != nil {
+		file.Error += fmt.Sprintf("; quarantine: %v", err)
+	}
+}
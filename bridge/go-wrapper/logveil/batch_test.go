@@ -0,0 +1,116 @@
+package logveil
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandInputsOutputCollision(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a/app.log", "b/app.log", "b/other.log"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("line\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := filepath.Join(dir, "a", "app.log")
+	b := filepath.Join(dir, "b", "app.log")
+	other := filepath.Join(dir, "b", "other.log")
+	out := filepath.Join(dir, "out")
+
+	tests := []struct {
+		name      string
+		inputs    []string
+		outputDir string
+		// want lists the outputs, relative to outputDir, when no error is
+		// expected
+		want    []string
+		wantErr bool
+	}{
+		{name: "same base name", inputs: []string{a, b}, outputDir: out, wantErr: true},
+		{name: "same base name by glob", inputs: []string{filepath.Join(dir, "*", "app.log")}, outputDir: out, want: []string{"a/app.log", "b/app.log"}},
+		{name: "files and glob", inputs: []string{a, filepath.Join(dir, "b", "*.log")}, outputDir: out, wantErr: true},
+		{name: "different names", inputs: []string{a, other}, outputDir: out, want: []string{"app.log", "other.log"}},
+		{name: "parent directory", inputs: []string{dir}, outputDir: out, want: []string{"a/app.log", "b/app.log", "b/other.log"}},
+		{name: "one file twice", inputs: []string{a, filepath.Join(dir, "a", ".", "app.log")}, outputDir: out, want: []string{"app.log"}},
+		{name: "no output directory", inputs: []string{a, b}, outputDir: "", want: []string{"app.log", "app.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := ExpandInputs(tt.inputs, tt.outputDir)
+			if tt.wantErr {
+				var perr *ProcessError
+				if !errors.As(err, &perr) || perr.Code != CodeConfig {
+					t.Fatalf("ExpandInputs error = %v, want a CodeConfig ProcessError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, job := range jobs {
+				rel, err := filepath.Rel(tt.outputDir, job.Output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("outputs = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("outputs = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessBatchSkippedInput(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantSuccess bool
+		wantSkipped int
+	}{
+		{name: "text", files: map[string]string{"a.log": "mail alice@example.com\n"}, wantSuccess: true},
+		{name: "binary", files: map[string]string{"a.log": "mail alice@example.com\n", "b.bin": "\x00\x01\x02"}, wantSkipped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRedactor(Config{Engine: "native"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			dir := t.TempDir()
+			in := filepath.Join(dir, "in")
+			if err := os.Mkdir(in, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range tt.files {
+				if err := os.WriteFile(filepath.Join(in, name), []byte(data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			jobs, err := ExpandInputs([]string{in}, filepath.Join(dir, "out"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			batch := r.ProcessBatch(context.Background(), jobs, 2)
+			if batch.Success != tt.wantSuccess || batch.FilesSkipped != tt.wantSkipped || batch.FilesFailed != 0 {
+				t.Errorf("batch = success %v, %d skipped, %d failed; want success %v, %d skipped, 0 failed",
+					batch.Success, batch.FilesSkipped, batch.FilesFailed, tt.wantSuccess, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package logveil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// resultCache is the on-disk manifest of files redacted successfully,
+// keyed by absolute input path. A file whose content and ruleset match
+// its entry, and whose output is still as it was written, is not
+// redacted again.
+type resultCache struct {
+	path  string
+	mu    sync.Mutex
+	Files map[string]cachedFile `json:"files"`
+}
+
+type cachedFile struct {
+	InputSHA256 string `json:"input_sha256"`
+	// Ruleset fingerprints the configuration the file was redacted with
+	Ruleset       string         `json:"ruleset"`
+	Output        string         `json:"output"`
+	OutputSize    int64          `json:"output_size"`
+	OutputModTime time.Time      `json:"output_mod_time"`
+	Result        *ProcessResult `json:"result"`
+}
+
+// loadResultCache reads the manifest at path, or starts an empty one when
+// there is none yet
+func loadResultCache(path string) (*resultCache, error) {
+	cache := &resultCache{path: path, Files: make(map[string]cachedFile)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cache %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, fmt.Errorf("cache %s: %v", path, err)
+	}
+	if cache.Files == nil {
+		cache.Files = make(map[string]cachedFile)
+	}
+	return cache, nil
+}
+
+func (c *resultCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, append(data, '\n'), 0o644)
+}
+
+// lookup returns the cached result for job under ruleset, if its entry
+// still holds. It also returns the input's hash for record, which is empty
+// when the input could not be read.
+func (c *resultCache) lookup(job FileJob, ruleset string) (*ProcessResult, string, bool) {
+	key, err := filepath.Abs(job.Input)
+	if err != nil {
+		return nil, "", false
+	}
+	digest, err := fileSHA256(job.Input)
+	if err != nil {
+		return nil, "", false
+	}
+	c.mu.Lock()
+	entry, ok := c.Files[key]
+	c.mu.Unlock()
+	if !ok || entry.InputSHA256 != digest || entry.Ruleset != ruleset || entry.Output != job.Output || entry.Result == nil {
+		return nil, digest, false
+	}
+	info, err := os.Stat(job.Output)
+	if err != nil || info.Size() != entry.OutputSize || !info.ModTime().Equal(entry.OutputModTime) {
+		return nil, digest, false
+	}
+	return entry.Result, digest, true
+}
+
+// record notes that job, whose input hashed to digest, was redacted under
+// ruleset with result
+func (c *resultCache) record(job FileJob, digest, ruleset string, result *ProcessResult) {
+	key, err := filepath.Abs(job.Input)
+	if err != nil || digest == "" {
+		return
+	}
+	info, err := os.Stat(job.Output)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Files[key] = cachedFile{
+		InputSHA256:   digest,
+		Ruleset:       ruleset,
+		Output:        job.Output,
+		OutputSize:    info.Size(),
+		OutputModTime: info.ModTime(),
+		Result:        result,
+	}
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// rulesetFingerprint hashes everything in cfg that shapes the output, with
+// the detectors and agent script engine runs, so a cached result is only
+// reused when redacting again would produce the same output. It returns ""
+// when cfg cannot be fingerprinted, which nothing matches.
+func rulesetFingerprint(cfg Config, engine Engine) string {
+	// Settings that change how, not what, is written
+	cfg.Timeout, cfg.Parallel, cfg.Mmap, cfg.Resume, cfg.CacheFile = 0, 0, false, false, ""
+	cfg.Quarantine = QuarantineOptions{}
+	cfg.Audit = AuditOptions{}
+	tokenizer := cfg.Tokenizer
+	cfg.Tokenizer, cfg.Native.Tokenizer = nil, nil
+
+	h := sha256.New()
+	if err := json.NewEncoder(h).Encode(cfg); err != nil {
+		return ""
+	}
+	if tokenizer != nil {
+		// Pseudonyms depend on the key, which is hashed rather than kept
+		h.Write(tokenizer.key)
+	}
+	if f, ok := engine.(*formatEngine); ok {
+		engine = f.Engine
+	}
+	switch e := engine.(type) {
+	case *NativeEngine:
+		for _, d := range e.detectors {
+			if d.plugin != nil {
+				fmt.Fprintf(h, "%s\x00plugin %s\x00%s\n", d.name, d.plugin.digest, d.severity)
+				continue
+			}
+			fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\n", d.name, d.pattern, d.template, d.severity)
+			if d.when != nil {
+				fmt.Fprintf(h, "when %s\n", d.when.source)
+			}
+			if d.policy != nil {
+				fmt.Fprintf(h, "policy %s\n", d.policy.digest)
+			}
+		}
+	case *PythonEngine:
+		digest, err := fileSHA256(e.agent)
+		if err != nil {
+			return ""
+		}
+		fmt.Fprintf(h, "agent %s\n", digest)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,257 @@
+package logveil
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CEFOptions configures the cef and leef formats
+type CEFOptions struct {
+	// Keys are extension keys, such as suser or src, whose values are
+	// replaced outright, matched without regard to case. A * matches any
+	// run of characters.
+	Keys []string
+}
+
+// cefFormat redacts ArcSight CEF or, with leef set, QRadar LEEF lines.
+// Header fields and delimiters are kept so the result can be ingested
+// again; only the CEF Name goes through the engine. Targeted extension keys
+// have their values replaced with [REDACTED_<KEY>], and every other value
+// goes through the engine together with its key. Any syslog prefix before
+// the header is redacted as plain text, as are lines without a header.
+type cefFormat struct {
+	leef     bool
+	keys     keyPatterns
+	replacer replacer
+}
+
+func newCEFFormat(opts CEFOptions, leef bool, repl replacer) *cefFormat {
+	return &cefFormat{leef: leef, keys: newKeyPatterns(opts.Keys), replacer: repl}
+}
+
+// cefPair is one extension key=value; start and end span the value
+type cefPair struct {
+	key        string
+	start, end int
+}
+
+func (f *cefFormat) redactLine(line string, redact redactFunc) (string, []Detection, error) {
+	marker, headerFields := "CEF:", 7
+	if f.leef {
+		marker, headerFields = "LEEF:", 5
+	}
+	start := strings.Index(line, marker)
+	if start < 0 {
+		return redact("", line)
+	}
+	if f.leef && strings.HasPrefix(line[start:], "LEEF:2.0|") {
+		// LEEF 2.0 adds the delimiter to the header
+		headerFields = 6
+	}
+	fields, extStart, ok := splitCEFHeader(line, start, headerFields)
+	if !ok {
+		return redact("", line)
+	}
+
+	var edits []jsonEdit
+	var detections []Detection
+	if start > 0 {
+		prefix, found, err := redact("", line[:start])
+		if err != nil {
+			return "", nil, err
+		}
+		detections = append(detections, found...)
+		if prefix != line[:start] {
+			edits = append(edits, jsonEdit{start: 0, end: start, text: prefix})
+		}
+	}
+
+	if !f.leef {
+		// The Name describes the event and may quote a user or host
+		name := fields[5]
+		value := unescapeCEF(line[name[0]:name[1]], "|")
+		redacted, found, err := redact("name", value)
+		if err != nil {
+			return "", nil, err
+		}
+		detections = append(detections, found...)
+		if redacted != value {
+			edits = append(edits, jsonEdit{start: name[0], end: name[1], text: escapeCEF(redacted, "|")})
+		}
+	}
+
+	var pairs []cefPair
+	if f.leef {
+		delimiter := "\t"
+		if headerFields == 6 {
+			spec := fields[5]
+			delimiter = leefDelimiter(line[spec[0]:spec[1]])
+		}
+		pairs = parseLEEFExtension(line, extStart, delimiter)
+	} else {
+		pairs = parseCEFExtension(line, extStart)
+	}
+
+	for _, p := range pairs {
+		raw := line[p.start:p.end]
+		value := raw
+		if !f.leef {
+			value = unescapeCEF(raw, "=")
+		}
+		var redacted string
+		if f.keys.matches(p.key) {
+			redacted = f.replacer.replace(p.key, value)
+			detections = append(detections, Detection{Rule: "cef_field"})
+		} else {
+			var found []Detection
+			var err error
+			redacted, found, err = redactKeyedValue(p.key, value, redact)
+			if err != nil {
+				return "", nil, err
+			}
+			detections = append(detections, found...)
+		}
+		if redacted == value {
+			continue
+		}
+		if !f.leef {
+			redacted = escapeCEF(redacted, "=")
+		}
+		edits = append(edits, jsonEdit{start: p.start, end: p.end, text: redacted})
+	}
+	return applyEdits(line, edits), detections, nil
+}
+
+// splitCEFHeader returns the spans of the n pipe-delimited header fields
+// starting at start, the first being the version, and the offset of the
+// extension after them. ok is false when the header is incomplete.
+func splitCEFHeader(line string, start, n int) ([][2]int, int, bool) {
+	fields := make([][2]int, 0, n)
+	fieldStart := strings.IndexByte(line[start:], ':') + start + 1
+	for i := fieldStart; i < len(line) && len(fields) < n; i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			fields = append(fields, [2]int{fieldStart, i})
+			fieldStart = i + 1
+		}
+	}
+	return fields, fieldStart, len(fields) == n
+}
+
+// parseCEFExtension splits a CEF extension into pairs. A key is a run of
+// key characters followed by an unescaped = at the start of the extension
+// or after a space; its value runs to the space before the next key, so
+// values may hold spaces.
+func parseCEFExtension(line string, start int) []cefPair {
+	var pairs []cefPair
+	for i := start; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '=':
+			keyStart := i
+			for keyStart > start && isCEFKeyChar(line[keyStart-1]) {
+				keyStart--
+			}
+			if keyStart == i || (keyStart > start && line[keyStart-1] != ' ') {
+				continue
+			}
+			if len(pairs) > 0 {
+				pairs[len(pairs)-1].end = valueEnd(line, pairs[len(pairs)-1].start, keyStart)
+			}
+			pairs = append(pairs, cefPair{key: line[keyStart:i], start: i + 1})
+		}
+	}
+	if len(pairs) > 0 {
+		pairs[len(pairs)-1].end = valueEnd(line, pairs[len(pairs)-1].start, len(line))
+	}
+	return pairs
+}
+
+// valueEnd trims the spaces separating a CEF value from what follows it
+func valueEnd(line string, start, end int) int {
+	for end > start && line[end-1] == ' ' {
+		end--
+	}
+	return end
+}
+
+func isCEFKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-' || c == '[' || c == ']'
+}
+
+// parseLEEFExtension splits a LEEF extension on delimiter into pairs.
+// Attributes without an = are skipped.
+func parseLEEFExtension(line string, start int, delimiter string) []cefPair {
+	var pairs []cefPair
+	pos := start
+	for pos <= len(line) {
+		end := strings.Index(line[pos:], delimiter)
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += pos
+		}
+		if key, _, ok := strings.Cut(line[pos:end], "="); ok && key != "" {
+			pairs = append(pairs, cefPair{key: key, start: pos + len(key) + 1, end: end})
+		}
+		pos = end + len(delimiter)
+	}
+	return pairs
+}
+
+// leefDelimiter decodes the LEEF 2.0 delimiter field: one character, or
+// its code as x09 or 0x09. An empty field means a tab.
+func leefDelimiter(spec string) string {
+	if hex, ok := strings.CutPrefix(strings.ToLower(spec), "0x"); ok {
+		spec = "x" + hex
+	}
+	if len(spec) > 1 && (spec[0] == 'x' || spec[0] == 'X') {
+		if code, err := strconv.ParseUint(spec[1:], 16, 16); err == nil {
+			return string(rune(code))
+		}
+	}
+	if spec == "" {
+		return "\t"
+	}
+	return spec
+}
+
+// unescapeCEF decodes \\, \n, \r and a backslash before special, which is
+// | in header fields and = in extension values
+func unescapeCEF(text, special string) string {
+	if !strings.Contains(text, `\`) {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i+1 == len(text) {
+			b.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch c := text[i]; {
+		case c == 'n' && special == "=":
+			b.WriteByte('\n')
+		case c == 'r' && special == "=":
+			b.WriteByte('\r')
+		case c == '\\' || string(c) == special:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// escapeCEF reverses unescapeCEF
+func escapeCEF(text, special string) string {
+	replacements := []string{`\`, `\\`, special, `\` + special}
+	if special == "=" {
+		replacements = append(replacements, "\n", `\n`, "\r", `\r`)
+	}
+	return strings.NewReplacer(replacements...).Replace(text)
+}
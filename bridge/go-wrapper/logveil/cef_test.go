@@ -0,0 +1,72 @@
+package logveil
+
+import "testing"
+
+func TestCEFFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		keys   []string
+		line   string
+		want   string
+	}{
+		{
+			name:   "cef name and extension",
+			format: "cef",
+			keys:   []string{"suser"},
+			line:   `CEF:0|Vendor|Product|1.0|100|Login by alice@example.com|5|suser=bob src=10.0.0.1 msg=hello world`,
+			want:   `CEF:0|Vendor|Product|1.0|100|Login by [REDACTED_EMAIL]|5|suser=[REDACTED_SUSER] src=[REDACTED_IP_ADDRESS] msg=hello world`,
+		},
+		{
+			name:   "cef escaped equals",
+			format: "cef",
+			line:   `CEF:0|V|P|1|1|n|3|msg=a\=b alice@example.com cs1=x`,
+			want:   `CEF:0|V|P|1|1|n|3|msg=a\=b [REDACTED_EMAIL] cs1=x`,
+		},
+		{
+			name:   "cef syslog prefix",
+			format: "cef",
+			line:   `Jan  2 03:04:05 10.0.0.5 CEF:0|V|P|1|1|n|3|dst=10.0.0.1`,
+			want:   `Jan  2 03:04:05 [REDACTED_IP_ADDRESS] CEF:0|V|P|1|1|n|3|dst=[REDACTED_IP_ADDRESS]`,
+		},
+		{
+			name:   "cef header kept",
+			format: "cef",
+			line:   `CEF:0|10.0.0.9|P|1|1|n|3|`,
+			want:   `CEF:0|10.0.0.9|P|1|1|n|3|`,
+		},
+		{
+			name:   "cef incomplete header is text",
+			format: "cef",
+			line:   `CEF:0|V|P alice@example.com`,
+			want:   `CEF:0|V|P [REDACTED_EMAIL]`,
+		},
+		{
+			name:   "leef 1.0 tab delimited",
+			format: "leef",
+			keys:   []string{"usrName"},
+			line:   "LEEF:1.0|V|P|1|ev|usrName=bob\tsrc=10.0.0.1",
+			want:   "LEEF:1.0|V|P|1|ev|usrName=[REDACTED_USRNAME]\tsrc=[REDACTED_IP_ADDRESS]",
+		},
+		{
+			name:   "leef 2.0 delimiter",
+			format: "leef",
+			line:   "LEEF:2.0|V|P|1|ev|x5E|src=10.0.0.1^msg=hi alice@example.com",
+			want:   "LEEF:2.0|V|P|1|ev|x5E|src=[REDACTED_IP_ADDRESS]^msg=hi [REDACTED_EMAIL]",
+		},
+		{
+			name:   "leef without header is text",
+			format: "leef",
+			line:   `plain 10.0.0.1`,
+			want:   `plain [REDACTED_IP_ADDRESS]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Format: tt.format, CEF: CEFOptions{Keys: tt.keys}}
+			if got, _ := redactLine(t, cfg, tt.line); got != tt.want {
+				t.Errorf("redacted %s\n got %s\nwant %s", tt.line, got, tt.want)
+			}
+		})
+	}
+}
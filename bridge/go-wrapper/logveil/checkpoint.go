@@ -0,0 +1,241 @@
+package logveil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// CheckpointSuffix is appended to the output path to name the
+	// checkpoint of a resumable run
+	CheckpointSuffix = ".logveil-checkpoint"
+	// checkpointInterval is how often a resumable run records its progress
+	checkpointInterval = 10 * time.Second
+)
+
+// checkpoint is how far a resumable run got: the input up to Offset, whose
+// hash guards against resuming over a different file, was redacted into
+// the first BytesWritten bytes of the output
+type checkpoint struct {
+	Input        string `json:"input"`
+	Offset       int64  `json:"offset"`
+	InputSHA256  string `json:"input_sha256"`
+	BytesWritten int64  `json:"bytes_written"`
+	// Lines counts input lines, LinesProcessed those redacted
+	Lines          int            `json:"lines"`
+	LinesProcessed int            `json:"lines_processed"`
+	Detections     map[string]int `json:"detections,omitempty"`
+	FailedLines    []RedactedLine `json:"failed_lines,omitempty"`
+}
+
+// checkpointer records a resumable run's checkpoint as it goes. A nil
+// *checkpointer does nothing, so plain streams can take one.
+type checkpointer struct {
+	path string
+	// base is where this run started, zero for a fresh one
+	base checkpoint
+	// hash covers the input from its start to the last record consumed
+	hash     hash.Hash
+	offset   int64
+	lines    int
+	lastSave time.Time
+}
+
+// consumed records that record, of lines input lines, is in the output
+func (c *checkpointer) consumed(record string, lines int) {
+	if c == nil {
+		return
+	}
+	c.hash.Write([]byte(record))
+	c.offset += int64(len(record))
+	c.lines += lines
+}
+
+// flushed saves the checkpoint if the last save is old enough, now that
+// everything consumed has been written
+func (c *checkpointer) flushed(result *ProcessResult) {
+	if c != nil && time.Since(c.lastSave) >= checkpointInterval {
+		c.save(result)
+	}
+}
+
+// save writes the checkpoint for the consumed input. It must only be
+// called with the output flushed. A failed save keeps the previous one,
+// which a resume can still use.
+func (c *checkpointer) save(result *ProcessResult) {
+	if c == nil {
+		return
+	}
+	c.lastSave = time.Now()
+	cp := checkpoint{
+		Input:          c.base.Input,
+		Offset:         c.offset,
+		InputSHA256:    hex.EncodeToString(c.hash.Sum(nil)),
+		BytesWritten:   result.BytesWritten,
+		Lines:          c.lines,
+		LinesProcessed: result.LinesProcessed,
+		Detections:     result.Detections,
+		FailedLines:    result.FailedLines,
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// complete removes the checkpoint once the whole input has been read
+func (c *checkpointer) complete() {
+	if c != nil {
+		os.Remove(c.path)
+	}
+}
+
+// resumedResult returns a result holding the counts of the runs before
+// this one
+func (c *checkpointer) resumedResult() *ProcessResult {
+	result := &ProcessResult{}
+	if c == nil {
+		return result
+	}
+	result.BytesRead = c.base.Offset
+	result.BytesWritten = c.base.BytesWritten
+	result.LinesProcessed = c.base.LinesProcessed
+	result.FailedLines = c.base.FailedLines
+	for rule, n := range c.base.Detections {
+		if result.Detections == nil {
+			result.Detections = make(map[string]int)
+		}
+		result.Detections[rule] = n
+	}
+	return result
+}
+
+// resumedLines returns the input lines read before this run
+func (c *checkpointer) resumedLines() int {
+	if c == nil {
+		return 0
+	}
+	return c.base.Lines
+}
+
+// processResumable redacts inputPath into outputPath, continuing from the
+// checkpoint next to outputPath if there is one. The input before the
+// checkpoint is hashed rather than redacted again, and the output is cut
+// back to what the checkpoint covers.
+func (r *Redactor) processResumable(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error) {
+	if IsRemote(inputPath) || IsRemote(outputPath) || archiveKind(inputPath) != "" {
+		return failedResult(newError(CodeConfig, StageSetup, "resume needs local, plain input and output files"))
+	}
+	if codec, err := fileCompression(inputPath); err != nil {
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	} else if codec != "" || r.compression != "" {
+		return failedResult(newError(CodeConfig, StageSetup, "resume needs uncompressed input and output"))
+	}
+	if !streamsLines(r.engine) || forStream(r.engine) != r.engine {
+		return failedResult(newError(CodeConfig, StageSetup, "resume needs an engine and format that redact line by line, such as the native engine or a python worker"))
+	}
+	if enginePlaceholders(r.engine) != nil {
+		// A resumed run never sees the values numbered before it
+		return failedResult(newError(CodeConfig, StageSetup, "resume cannot be combined with a placeholder template"))
+	}
+	switch encoding, err := sniffFile(inputPath); {
+	case err != nil:
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	case readsBinary(r.engine):
+		// Binary framing is read as it is
+	case encoding == SkippedBinary:
+		return skippedResult(SkippedBinary)
+	case encoding != "":
+		return failedResult(newError(CodeConfig, StageSetup, "resume needs UTF-8 input, not %s", encoding))
+	}
+	absInput, err := filepath.Abs(inputPath)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	}
+	cp := &checkpointer{path: outputPath + CheckpointSuffix, hash: sha256.New(), lastSave: time.Now()}
+	cp.base.Input = absInput
+
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	}
+	defer in.Close()
+
+	out, err := resumeOutput(cp, in, outputPath)
+	if err != nil {
+		return failedResult(err)
+	}
+	defer out.Close()
+
+	result, err := redactCheckpointed(ctx, r.engine, in, out, cp)
+	if closeErr := out.Close(); closeErr != nil && err == nil {
+		result.Success = false
+		result.addError(newError(CodeIO, StageWrite, "write output: %v", closeErr))
+		err = closeErr
+	}
+	return result, err
+}
+
+// resumeOutput loads the checkpoint for cp, if any, checks it against in
+// and leaves in and the returned output positioned to carry on from it
+func resumeOutput(cp *checkpointer, in *os.File, outputPath string) (*os.File, error) {
+	data, err := os.ReadFile(cp.path)
+	if errors.Is(err, os.ErrNotExist) {
+		out, err := os.Create(outputPath)
+		if err != nil {
+			return nil, newError(CodeIO, StageWrite, "create output: %v", err)
+		}
+		return out, nil
+	}
+	if err != nil {
+		return nil, newError(CodeIO, StageSetup, "read checkpoint: %v", err)
+	}
+
+	input := cp.base.Input
+	if err := json.Unmarshal(data, &cp.base); err != nil {
+		return nil, newError(CodeConfig, StageSetup, "checkpoint %s is corrupt: %v; delete it to start over", cp.path, err)
+	}
+	if cp.base.Input != input {
+		return nil, newError(CodeConfig, StageSetup, "checkpoint %s is for %s; delete it to start over", cp.path, cp.base.Input)
+	}
+	if n, err := io.CopyN(cp.hash, in, cp.base.Offset); err != nil || n != cp.base.Offset ||
+		hex.EncodeToString(cp.hash.Sum(nil)) != cp.base.InputSHA256 {
+		return nil, newError(CodeConfig, StageSetup, "input %s changed since checkpoint %s was taken; delete it to start over", input, cp.path)
+	}
+	cp.offset, cp.lines = cp.base.Offset, cp.base.Lines
+
+	out, err := os.OpenFile(outputPath, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, newError(CodeIO, StageWrite, "open output: %v", err)
+	}
+	info, err := out.Stat()
+	if err == nil && info.Size() < cp.base.BytesWritten {
+		err = errors.New("output is shorter than the checkpoint")
+	}
+	if err == nil {
+		err = out.Truncate(cp.base.BytesWritten)
+	}
+	if err == nil {
+		_, err = out.Seek(cp.base.BytesWritten, io.SeekStart)
+	}
+	if err != nil {
+		out.Close()
+		return nil, newError(CodeConfig, StageSetup, "cannot resume %s from checkpoint %s: %v; delete it to start over", outputPath, cp.path, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package logveil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	first, second := "mail alice@example.com\n", "mail bob@example.com\n"
+	sum := sha256.Sum256([]byte(first))
+	// kept stands in for the output of the interrupted run, so a resume
+	// that redacted the first line again would show
+	kept := "kept\n"
+
+	tests := []struct {
+		name string
+		// checkpoint is written next to the output unless empty, and
+		// the output then holds kept and a partly written line
+		checkpoint func(input string) any
+		want       string
+		wantLines  int
+		wantEmails int
+		wantErr    bool
+	}{
+		{
+			name:       "fresh run",
+			want:       "mail [REDACTED_EMAIL]\nmail [REDACTED_EMAIL]\n",
+			wantLines:  2,
+			wantEmails: 2,
+		},
+		{
+			name: "resumed run",
+			checkpoint: func(input string) any {
+				return checkpoint{Input: input, Offset: int64(len(first)), InputSHA256: hex.EncodeToString(sum[:]),
+					BytesWritten: int64(len(kept)), Lines: 1, LinesProcessed: 1, Detections: map[string]int{"email": 1}}
+			},
+			want:       kept + "mail [REDACTED_EMAIL]\n",
+			wantLines:  2,
+			wantEmails: 2,
+		},
+		{
+			name: "input changed",
+			checkpoint: func(input string) any {
+				return checkpoint{Input: input, Offset: int64(len(first)), InputSHA256: hex.EncodeToString(make([]byte, sha256.Size)),
+					BytesWritten: int64(len(kept)), Lines: 1, LinesProcessed: 1}
+			},
+			wantErr: true,
+		},
+		{
+			name: "another input",
+			checkpoint: func(string) any {
+				return checkpoint{Input: "/elsewhere/app.log"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "output shorter than the checkpoint",
+			checkpoint: func(input string) any {
+				return checkpoint{Input: input, Offset: int64(len(first)), InputSHA256: hex.EncodeToString(sum[:]),
+					BytesWritten: 1 << 20, Lines: 1, LinesProcessed: 1}
+			},
+			wantErr: true,
+		},
+		{
+			name:       "corrupt checkpoint",
+			checkpoint: func(string) any { return "not a checkpoint" },
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRedactor(Config{Engine: "native", Resume: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			dir := t.TempDir()
+			input, output := filepath.Join(dir, "app.log"), filepath.Join(dir, "out.log")
+			if err := os.WriteFile(input, []byte(first+second), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.checkpoint != nil {
+				data, err := json.Marshal(tt.checkpoint(input))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(output+CheckpointSuffix, data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(output, []byte(kept+"mail [RED"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := r.ProcessFile(context.Background(), input, output)
+			if tt.wantErr {
+				if err == nil || result.Success {
+					t.Fatalf("ProcessFile resumed from a bad checkpoint: %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if result.LinesProcessed != tt.wantLines || result.Detections["email"] != tt.wantEmails {
+				t.Errorf("result = %d lines, %d emails; want %d lines, %d emails",
+					result.LinesProcessed, result.Detections["email"], tt.wantLines, tt.wantEmails)
+			}
+			if _, err := os.Stat(output + CheckpointSuffix); !os.IsNotExist(err) {
+				t.Errorf("checkpoint left behind after a complete run: %v", err)
+			}
+		})
+	}
+}
+
+func TestResumePlaceholderTemplate(t *testing.T) {
+	r, err := NewRedactor(Config{Engine: "native", Resume: true, PlaceholderTemplate: "[{{.Rule}}_{{.Index}}]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	dir := t.TempDir()
+	input := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(input, []byte("mail alice@example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := r.ProcessFile(context.Background(), input, filepath.Join(dir, "out.log"))
+	var perr *ProcessError
+	if !errors.As(err, &perr) || perr.Code != CodeConfig || result.Success {
+		t.Fatalf("ProcessFile error = %v, want a CodeConfig ProcessError", err)
+	}
+}
@@ -0,0 +1,195 @@
+package logveil
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dsbzip2 "github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Output compression codecs accepted by Config.CompressOutput
+const (
+	CompressGzip  = "gzip"
+	CompressZstd  = "zstd"
+	CompressBzip2 = "bzip2"
+)
+
+// compressedSizeFactor estimates how much larger compressed input is once
+// decompressed, for timeout scaling
+const compressedSizeFactor = 10
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
+
+// compressionExtensions maps codecs to the file extension they conventionally
+// use
+var compressionExtensions = map[string]string{
+	CompressGzip:  ".gz",
+	CompressZstd:  ".zst",
+	CompressBzip2: ".bz2",
+}
+
+// validateCompression rejects unknown output codecs
+func validateCompression(codec string) error {
+	if _, ok := compressionExtensions[codec]; codec != "" && !ok {
+		return fmt.Errorf("unknown output compression %q (expected gzip, zstd or bzip2)", codec)
+	}
+	return nil
+}
+
+// sniffCompression returns the codec whose magic number starts header
+func sniffCompression(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return CompressGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return CompressZstd
+	case bytes.HasPrefix(header, bzip2Magic):
+		return CompressBzip2
+	default:
+		return ""
+	}
+}
+
+// fileCompression reports the codec path is compressed with, if any
+func fileCompression(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return sniffCompression(header[:n]), nil
+}
+
+// decompress wraps r in a decompressor when it starts with a known magic
+// number and returns it unchanged otherwise
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, _ := buffered.Peek(len(zstdMagic))
+
+	switch sniffCompression(header) {
+	case CompressGzip:
+		return gzip.NewReader(buffered)
+	case CompressZstd:
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case CompressBzip2:
+		return io.NopCloser(bzip2.NewReader(buffered)), nil
+	default:
+		return io.NopCloser(buffered), nil
+	}
+}
+
+// compress wraps w in an encoder for codec; closing the result flushes the
+// encoder but leaves w open
+func compress(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case "":
+		return nopWriteCloser{w}, nil
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZstd:
+		return zstd.NewWriter(w)
+	case CompressBzip2:
+		return dsbzip2.NewWriter(w, nil)
+	default:
+		return nil, validateCompression(codec)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// fileReader is an opened, decompressing input file
+type fileReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (f *fileReader) Close() error {
+	err := f.ReadCloser.Close()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// openInput opens path for reading, decompressing it transparently
+func openInput(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileReader{ReadCloser: reader, file: file}, nil
+}
+
+// fileWriter is a created, compressing output file
+type fileWriter struct {
+	io.WriteCloser
+	file *os.File
+}
+
+func (f *fileWriter) Close() error {
+	err := f.WriteCloser.Close()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// createOutput creates path for writing, compressed with codec
+func createOutput(path, codec string) (io.WriteCloser, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := compress(file, codec)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileWriter{WriteCloser: writer, file: file}, nil
+}
+
+// compressedName swaps any compression extension on path for the one
+// matching codec, so batch outputs are named after what they contain
+func compressedName(path, codec string) string {
+	ext := filepath.Ext(path)
+	for _, known := range compressionExtensions {
+		if strings.EqualFold(ext, known) {
+			path = strings.TrimSuffix(path, ext)
+			break
+		}
+	}
+	return path + compressionExtensions[codec]
+}
@@ -0,0 +1,180 @@
+package logveil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// condition is a compiled Rule.When: an expr expression deciding, for each
+// match, whether it is redacted. See https://expr-lang.org for the
+// language; its operators and built-ins such as len, contains, startsWith
+// and matches are all available.
+type condition struct {
+	source  string
+	program *vm.Program
+}
+
+// conditionEnv is what a condition sees of a match
+type conditionEnv struct {
+	// Value is the matched text
+	Value string `expr:"value"`
+	// Line is the text the match was found in, which is one field's value
+	// under the structured formats
+	Line string `expr:"line"`
+	// Rule names the rule that matched
+	Rule string `expr:"rule"`
+	// Field returns the named field of the record, "" when it has none.
+	// Records are read as JSON objects, with dots reaching into nested
+	// ones, or else as logfmt key=value pairs.
+	Field func(name string) string `expr:"field"`
+}
+
+// compileCondition compiles source, which must yield a bool
+func compileCondition(source string) (*condition, error) {
+	program, err := expr.Compile(source, expr.Env(conditionEnv{}), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition: %v", err)
+	}
+	return &condition{source: source, program: program}, nil
+}
+
+// allows reports whether the match value of rule in line is to be
+// redacted. A condition that fails at run time redacts, so that an
+// unexpected record does not leak what the rule matched.
+func (c *condition) allows(rule, line, value string, scope *matchScope) bool {
+	if scope == nil {
+		scope = &matchScope{record: line}
+	}
+	out, err := vm.Run(c.program, conditionEnv{Value: value, Line: line, Rule: rule, Field: scope.field})
+	if err != nil {
+		return true
+	}
+	allowed, ok := out.(bool)
+	return !ok || allowed
+}
+
+// matchScope is where a line being redacted comes from: the record it
+// belongs to, with its fields parsed on first use, the path of the field
+// it is the value of, its source file and its tenant
+type matchScope struct {
+	record string
+	parsed bool
+	object map[string]any
+	pairs  map[string]string
+
+	path   string
+	source string
+	tenant string
+}
+
+type (
+	// recordKey is the context key of the scope of a record
+	recordKey struct{}
+	// sourceKey is the context key of the file being redacted
+	sourceKey struct{}
+	// tenantKey is the context key of the tenant redacting
+	tenantKey struct{}
+)
+
+// WithTenant returns ctx attributing the redaction done under it to
+// tenant, for redaction policies
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// withSource returns ctx noting that the lines redacted under it come from
+// path
+func withSource(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, path)
+}
+
+// withRecord returns ctx carrying the scope of record for the rules that
+// redact its parts, unless ctx already carries one
+func withRecord(ctx context.Context, record string) context.Context {
+	if _, ok := ctx.Value(recordKey{}).(*matchScope); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, recordKey{}, newMatchScope(ctx, record))
+}
+
+// newMatchScope returns the scope of record, redacted under ctx
+func newMatchScope(ctx context.Context, record string) *matchScope {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return &matchScope{record: record, source: source, tenant: tenant}
+}
+
+// engineScoped reports whether engine has rules with conditions or a
+// policy, for which formats pass on the record they split into fields
+func engineScoped(engine Engine) bool {
+	native, ok := engine.(*NativeEngine)
+	return ok && native.scoped
+}
+
+// scopeOf returns the scope ctx carries, or that of line as its own record
+func scopeOf(ctx context.Context, line string) *matchScope {
+	if scope, ok := ctx.Value(recordKey{}).(*matchScope); ok {
+		return scope
+	}
+	return newMatchScope(ctx, line)
+}
+
+// field returns the value of the named field of the record
+func (s *matchScope) field(name string) string {
+	if !s.parsed {
+		s.parse()
+	}
+	if s.object != nil {
+		var v any = s.object
+		for _, key := range strings.Split(name, ".") {
+			object, ok := v.(map[string]any)
+			if !ok {
+				return ""
+			}
+			if v, ok = object[key]; !ok {
+				return ""
+			}
+		}
+		switch v := v.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(v)
+		case nil:
+			return ""
+		default:
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+	}
+	return s.pairs[name]
+}
+
+// parse reads the record as a JSON object, or else as logfmt
+func (s *matchScope) parse() {
+	s.parsed = true
+	if trimmed := strings.TrimSpace(s.record); strings.HasPrefix(trimmed, "{") {
+		if json.Unmarshal([]byte(trimmed), &s.object) == nil {
+			return
+		}
+		s.object = nil
+	}
+	pairs, ok := parseLogfmt(s.record)
+	if !ok {
+		return
+	}
+	s.pairs = make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		if _, seen := s.pairs[p.key]; !p.bare && !seen {
+			s.pairs[p.key] = p.value
+		}
+	}
+}
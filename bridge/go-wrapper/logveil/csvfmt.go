@@ -0,0 +1,216 @@
+package logveil
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CSVOptions configures the csv format
+type CSVOptions struct {
+	// Columns are the columns whose values are replaced, by header name,
+	// matched without regard to case, or by 1-based index
+	Columns []string
+	// NoHeader treats the first record as data; columns must then be
+	// given by index
+	NoHeader bool
+}
+
+// csvFormat redacts RFC 4180 CSV. Each record, quoted line breaks
+// included, is one record. The header row is kept, values in the selected
+// columns are replaced with [REDACTED_<COLUMN>], and every other value is
+// left alone. Quoting is kept, and added where a replacement needs it. The
+// header is read from the start of each stream, so a followed file must be
+// redacted from its start, and lines redacted one at a time, outside any
+// stream, are only accepted with NoHeader.
+type csvFormat struct {
+	names    []string
+	indexes  []int
+	noHeader bool
+	replacer replacer
+}
+
+func newCSVFormat(opts CSVOptions, repl replacer) (*csvFormat, error) {
+	if len(opts.Columns) == 0 {
+		return nil, fmt.Errorf("csv format needs at least one column to redact")
+	}
+	f := &csvFormat{noHeader: opts.NoHeader, replacer: repl}
+	for _, column := range opts.Columns {
+		if index, err := strconv.Atoi(column); err == nil {
+			if index < 1 {
+				return nil, fmt.Errorf("csv column %d: indexes start at 1", index)
+			}
+			f.indexes = append(f.indexes, index-1)
+			continue
+		}
+		if opts.NoHeader {
+			return nil, fmt.Errorf("csv column %q: columns must be indexes without a header", column)
+		}
+		f.names = append(f.names, column)
+	}
+	return f, nil
+}
+
+// records keeps quoted line breaks inside their record
+func (*csvFormat) records() *recordSplit {
+	return &recordSplit{read: readCSVRecord}
+}
+
+func (f *csvFormat) redactLine(record string, redact redactFunc) (string, []Detection, error) {
+	if !f.noHeader {
+		return "", nil, newError(CodeConfig, StageSetup, "csv format with a header only redacts whole streams")
+	}
+	return f.newStream().redactLine(record, redact)
+}
+
+// newStream starts a stream, whose first record is the header unless
+// NoHeader is set
+func (f *csvFormat) newStream() lineFormat {
+	stream := &csvStream{csvFormat: f}
+	if f.noHeader {
+		stream.columns = make(map[int]string)
+		for _, index := range f.indexes {
+			stream.columns[index] = csvColumnName(index)
+		}
+	}
+	return stream
+}
+
+// csvColumnName names a column that has no header
+func csvColumnName(index int) string {
+	return "column_" + strconv.Itoa(index+1)
+}
+
+// csvStream is a csvFormat reading one stream
+type csvStream struct {
+	*csvFormat
+	// columns maps the index of each selected column to its name, once
+	// the header is known
+	columns map[int]string
+}
+
+func (s *csvStream) redactLine(record string, redact redactFunc) (string, []Detection, error) {
+	if s.columns == nil {
+		return record, nil, s.readHeader(record)
+	}
+
+	var edits []jsonEdit
+	var detections []Detection
+	for i, field := range splitCSV(record) {
+		name, ok := s.columns[i]
+		if !ok || field.value == "" {
+			continue
+		}
+		edits = append(edits, jsonEdit{start: field.start, end: field.end, text: csvValue(s.replacer.replace(name, field.value), field.quoted)})
+		detections = append(detections, Detection{Rule: "csv_column"})
+	}
+	return applyEdits(record, edits), detections, nil
+}
+
+// readHeader maps the selected columns to their header names
+func (s *csvStream) readHeader(record string) error {
+	s.columns = make(map[int]string)
+	fields := splitCSV(record)
+	for _, index := range s.indexes {
+		name := csvColumnName(index)
+		if index < len(fields) && fields[index].value != "" {
+			name = fields[index].value
+		}
+		s.columns[index] = name
+	}
+	for _, name := range s.names {
+		found := false
+		for i, field := range fields {
+			if strings.EqualFold(strings.TrimSpace(field.value), name) {
+				s.columns[i] = field.value
+				found = true
+			}
+		}
+		if !found {
+			return newError(CodeParse, StageRead, "csv column %q is not in the header", name)
+		}
+	}
+	return nil
+}
+
+// csvField is one field of a record; start and end span its source text,
+// quotes included
+type csvField struct {
+	value      string
+	start, end int
+	quoted     bool
+}
+
+// splitCSV splits record into fields. A quote that does not start a field
+// is taken literally, as encoding/csv does with LazyQuotes.
+func splitCSV(record string) []csvField {
+	var fields []csvField
+	pos := 0
+	for {
+		field := csvField{start: pos}
+		if pos < len(record) && record[pos] == '"' {
+			var value strings.Builder
+			i := pos + 1
+			for i < len(record) {
+				if record[i] == '"' {
+					if i+1 < len(record) && record[i+1] == '"' {
+						value.WriteByte('"')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				value.WriteByte(record[i])
+				i++
+			}
+			// Anything between the closing quote and the comma is kept
+			for i < len(record) && record[i] != ',' {
+				value.WriteByte(record[i])
+				i++
+			}
+			field.value, field.quoted, pos = value.String(), true, i
+		} else {
+			end := strings.IndexByte(record[pos:], ',')
+			if end < 0 {
+				end = len(record) - pos
+			}
+			field.value = record[pos : pos+end]
+			pos += end
+		}
+		field.end = pos
+		fields = append(fields, field)
+		if pos >= len(record) {
+			return fields
+		}
+		pos++ // ,
+	}
+}
+
+// csvValue encodes value, quoting it when it was quoted before or holds a
+// comma, quote or line break
+func csvValue(value string, quoted bool) string {
+	if quoted || strings.ContainsAny(value, ",\"\r\n") {
+		return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+	}
+	return value
+}
+
+// readCSVRecord reads lines until the quotes in the record balance, so a
+// quoted field may span lines
+func readCSVRecord(reader *bufio.Reader) (string, int, error) {
+	var record strings.Builder
+	lines, quotes := 0, 0
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			record.WriteString(line)
+			lines++
+			quotes += strings.Count(line, `"`)
+		}
+		if err != nil || quotes%2 == 0 {
+			return record.String(), lines, err
+		}
+	}
+}
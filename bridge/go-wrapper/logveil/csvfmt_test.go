@@ -0,0 +1,102 @@
+package logveil
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// processStream redacts input as one stream with a Redactor built from cfg
+func processStream(t *testing.T, cfg Config, input string) (string, error) {
+	t.Helper()
+	r, err := NewRedactor(cfg)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	var out strings.Builder
+	if _, err := r.ProcessStream(context.Background(), strings.NewReader(input), &out); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func TestCSVFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    CSVOptions
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "column by name",
+			opts:  CSVOptions{Columns: []string{"email"}},
+			input: "id,email,note\n1,alice@example.com,mail bob@example.com\n",
+			want:  "id,email,note\n1,[REDACTED_EMAIL],mail bob@example.com\n",
+		},
+		{
+			name:  "name without case and index",
+			opts:  CSVOptions{Columns: []string{"EMAIL", "3"}},
+			input: "id,email,note\n1,alice@example.com,hi\n",
+			want:  "id,email,note\n1,[REDACTED_EMAIL],[REDACTED_NOTE]\n",
+		},
+		{
+			name:  "quoted line break",
+			opts:  CSVOptions{Columns: []string{"email"}},
+			input: "id,email\n1,\"line one\nline two\"\n2,b\n",
+			want:  "id,email\n1,\"[REDACTED_EMAIL]\"\n2,[REDACTED_EMAIL]\n",
+		},
+		{
+			name:  "quoted comma in another column",
+			opts:  CSVOptions{Columns: []string{"email"}},
+			input: "note,email\n\"x, y\",a\n",
+			want:  "note,email\n\"x, y\",[REDACTED_EMAIL]\n",
+		},
+		{
+			name:  "empty value kept",
+			opts:  CSVOptions{Columns: []string{"email"}},
+			input: "id,email\n1,\n",
+			want:  "id,email\n1,\n",
+		},
+		{
+			name:  "no header",
+			opts:  CSVOptions{Columns: []string{"2"}, NoHeader: true},
+			input: "1,secret\n2,other\n",
+			want:  "1,[REDACTED_COLUMN_2]\n2,[REDACTED_COLUMN_2]\n",
+		},
+		{
+			name:    "column not in the header",
+			opts:    CSVOptions{Columns: []string{"phone"}},
+			input:   "id,email\n1,a\n",
+			wantErr: true,
+		},
+		{
+			name:    "name without a header",
+			opts:    CSVOptions{Columns: []string{"email"}, NoHeader: true},
+			wantErr: true,
+		},
+		{
+			name:    "index below 1",
+			opts:    CSVOptions{Columns: []string{"0"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := processStream(t, Config{Format: "csv", CSV: tt.opts}, tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("redacted %q to %q, want an error", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("redacted %q\n got %q\nwant %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
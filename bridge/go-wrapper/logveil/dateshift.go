@@ -0,0 +1,140 @@
+package logveil
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDateShiftMaxDays bounds the date shift when DateShiftOptions sets
+// no limit
+const DefaultDateShiftMaxDays = 365
+
+// DateShiftOptions moves every date in the output by the same number of
+// days, so the intervals between events and their times of day survive
+// but the actual dates do not
+type DateShiftOptions struct {
+	Enabled bool
+	// MaxDays is the largest shift either way; DefaultDateShiftMaxDays
+	// when zero
+	MaxDays int
+	// Seed, when set, derives the shift so runs sharing it shift alike;
+	// otherwise every engine picks a random shift
+	Seed string
+}
+
+// dateLayout is a timestamp format: the pattern's first group is parsed
+// and formatted with layout. A layout without a year, as in syslog, is
+// taken to be in the current year, or in a leap year for Feb 29.
+type dateLayout struct {
+	pattern *regexp.Regexp
+	layout  string
+	noYear  bool
+}
+
+// dateLayouts are tried on every line. Where matches overlap the earlier
+// layout wins, so the full ctime form goes before its syslog subset.
+var dateLayouts = []dateLayout{
+	{pattern: regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2})(?:T|\b)`), layout: "2006-01-02"},
+	{pattern: regexp.MustCompile(`\b(\d{4}/\d{2}/\d{2})\b`), layout: "2006/01/02"},
+	{pattern: regexp.MustCompile(`\b(\d{2}/(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)/\d{4})\b`), layout: "02/Jan/2006"},
+	{pattern: regexp.MustCompile(`\b((?:Mon|Tue|Wed|Thu|Fri|Sat|Sun), \d{2} (?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) \d{4})\b`), layout: "Mon, 02 Jan 2006"},
+	{pattern: regexp.MustCompile(`\b((?:Mon|Tue|Wed|Thu|Fri|Sat|Sun), \d (?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) \d{4})\b`), layout: "Mon, 2 Jan 2006"},
+	{pattern: regexp.MustCompile(`\b((?:Mon|Tue|Wed|Thu|Fri|Sat|Sun) (?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) [ \d]\d \d{2}:\d{2}:\d{2} \d{4})\b`), layout: time.ANSIC},
+	{pattern: regexp.MustCompile(`\b((?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) [ \d]\d \d{2}:\d{2}:\d{2})\b`), layout: time.Stamp, noYear: true},
+}
+
+// dateShiftDays returns the shift opts asks for, never zero
+func dateShiftDays(opts DateShiftOptions) (int, error) {
+	maxDays := opts.MaxDays
+	if maxDays == 0 {
+		maxDays = DefaultDateShiftMaxDays
+	}
+	if maxDays < 0 {
+		return 0, fmt.Errorf("date shift max days must not be negative: %d", maxDays)
+	}
+
+	var seed [8]byte
+	if opts.Seed != "" {
+		sum := sha256.Sum256([]byte("logveil date shift\x00" + opts.Seed))
+		copy(seed[:], sum[:])
+	} else if _, err := rand.Read(seed[:]); err != nil {
+		return 0, fmt.Errorf("pick date shift: %v", err)
+	}
+	// 1 to maxDays days, earlier or later
+	n := binary.BigEndian.Uint64(seed[:]) % uint64(2*maxDays)
+	days := int(n%uint64(maxDays)) + 1
+	if n >= uint64(maxDays) {
+		days = -days
+	}
+	return days, nil
+}
+
+// shiftDates moves every date found in line by days
+func shiftDates(line string, days int) string {
+	type dateMatch struct {
+		start, end int
+		layout     dateLayout
+	}
+	var matches []dateMatch
+	for _, layout := range dateLayouts {
+		for _, m := range layout.pattern.FindAllStringSubmatchIndex(line, -1) {
+			overlaps := false
+			for _, other := range matches {
+				if m[2] < other.end && other.start < m[3] {
+					overlaps = true
+					break
+				}
+			}
+			if !overlaps {
+				matches = append(matches, dateMatch{start: m[2], end: m[3], layout: layout})
+			}
+		}
+	}
+	if matches == nil {
+		return line
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		shifted, ok := m.layout.shift(line[m.start:m.end], days)
+		if !ok {
+			continue
+		}
+		out = append(out, line[last:m.start]...)
+		out = append(out, shifted...)
+		last = m.end
+	}
+	return string(append(out, line[last:]...))
+}
+
+// shift moves text, a date in l's layout, by days, reporting false when
+// text is not a valid date
+func (l dateLayout) shift(text string, days int) (string, bool) {
+	layout := l.layout
+	if l.noYear {
+		layout = "2006 " + layout
+		text = fmt.Sprintf("%d %s", time.Now().Year(), text)
+	}
+	t, err := time.Parse(layout, text)
+	if err != nil && l.noYear {
+		// Feb 29 only parses in a leap year, which the log's must have been
+		_, date, _ := strings.Cut(text, " ")
+		t, err = time.Parse(layout, "2024 "+date)
+	}
+	if err != nil {
+		return "", false
+	}
+	shifted := t.AddDate(0, 0, days).Format(layout)
+	if l.noYear {
+		_, shifted, _ = strings.Cut(shifted, " ")
+	}
+	return shifted, true
+}
@@ -0,0 +1,27 @@
+package logveil
+
+import "testing"
+
+func TestShiftDates(t *testing.T) {
+	tests := []struct {
+		line string
+		days int
+		want string
+	}{
+		{line: "at 2024-01-31T10:00:00Z", days: 1, want: "at 2024-02-01T10:00:00Z"},
+		{line: "[10/Oct/2023:13:55:36 +0000]", days: -10, want: "[30/Sep/2023:13:55:36 +0000]"},
+		{line: "Mon Jan  2 15:04:05 2006 boot", days: 7, want: "Mon Jan  9 15:04:05 2006 boot"},
+		{line: "Dec 31 23:59:59 host", days: 1, want: "Jan  1 23:59:59 host"},
+		{line: "Feb 29 10:00:00 host", days: 1, want: "Mar  1 10:00:00 host"},
+		{line: "Feb 29 10:00:00 host", days: -1, want: "Feb 28 10:00:00 host"},
+		{line: "Feb 30 10:00:00 host", days: 1, want: "Feb 30 10:00:00 host"},
+		{line: "on 2024-13-01", days: 1, want: "on 2024-13-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := shiftDates(tt.line, tt.days); got != tt.want {
+				t.Errorf("shiftDates(%q, %d) = %q, want %q", tt.line, tt.days, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package logveil
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Input encodings converted to UTF-8 before redaction, as reported in
+// ProcessResult.Encoding
+const (
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	// EncodingLatin1 is read as Windows-1252, the superset of ISO 8859-1
+	// that Windows writes
+	EncodingLatin1 = "latin-1"
+)
+
+// SkippedBinary is the ProcessResult.Skipped reason for binary input
+const SkippedBinary = "binary"
+
+// sniffLength is how much of the input decides its encoding
+const sniffLength = 8192
+
+// sniffText classifies sample, the start of an input: "" for UTF-8 (or
+// ASCII), one of the Encoding constants, or SkippedBinary
+func sniffText(sample []byte) string {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xff, 0xfe}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(sample, []byte{0xfe, 0xff}):
+		return EncodingUTF16BE
+	}
+
+	// UTF-16 without a byte order mark: mostly ASCII text has a NUL in
+	// every other byte
+	pairs := len(sample) / 2
+	var evenNULs, oddNULs int
+	for i := 0; i+1 < len(sample); i += 2 {
+		if sample[i] == 0 {
+			evenNULs++
+		}
+		if sample[i+1] == 0 {
+			oddNULs++
+		}
+	}
+	switch {
+	case pairs > 0 && oddNULs > pairs/2 && evenNULs < pairs/10:
+		return EncodingUTF16LE
+	case pairs > 0 && evenNULs > pairs/2 && oddNULs < pairs/10:
+		return EncodingUTF16BE
+	}
+
+	controls := 0
+	for _, b := range sample {
+		switch {
+		case b == 0:
+			return SkippedBinary
+		case b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\v' && b != 0x1b:
+			controls++
+		}
+	}
+	if controls > len(sample)/10 {
+		return SkippedBinary
+	}
+
+	if len(sample) == sniffLength {
+		// The sample may end part way through a character
+		i := len(sample) - 1
+		for i > 0 && len(sample)-i < utf8.UTFMax && !utf8.RuneStart(sample[i]) {
+			i--
+		}
+		if !utf8.FullRune(sample[i:]) {
+			sample = sample[:i]
+		}
+	}
+	if !utf8.Valid(sample) {
+		return EncodingLatin1
+	}
+	return ""
+}
+
+// textInput reads the start of r to classify it like sniffText and
+// returns r as UTF-8 text. Only what one read returns is examined, so a
+// pipe delivering a line at a time is not held up.
+func textInput(r io.Reader) (io.Reader, string, error) {
+	sample := make([]byte, sniffLength)
+	n, err := io.ReadAtLeast(r, sample, 1)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, "", err
+	}
+	sample = sample[:n]
+	encoding := sniffText(sample)
+	return decodeText(io.MultiReader(bytes.NewReader(sample), r), encoding), encoding, nil
+}
+
+// engineInput is textInput for input engine runs on. Formats that frame
+// binary data read it as it is, neither converted nor skipped.
+func engineInput(r io.Reader, engine Engine) (io.Reader, string, error) {
+	if readsBinary(engine) {
+		return r, "", nil
+	}
+	return textInput(r)
+}
+
+// decodeText converts r from encoding to UTF-8
+func decodeText(r io.Reader, encoding string) io.Reader {
+	switch encoding {
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder().Reader(r)
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder().Reader(r)
+	case EncodingLatin1:
+		return charmap.Windows1252.NewDecoder().Reader(r)
+	}
+	return r
+}
+
+// sniffFile classifies the start of the file at path like sniffText,
+// after decompressing it
+func sniffFile(path string) (string, error) {
+	in, err := openInput(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	_, encoding, err := textInput(in)
+	return encoding, err
+}
+
+// stageText writes the file at src, decompressed and converted to UTF-8,
+// to dst and returns the encoding converted from. Binary input is not
+// copied.
+func stageText(dst, src string) (string, error) {
+	in, err := openInput(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	text, encoding, err := textInput(in)
+	if err != nil || encoding == SkippedBinary {
+		return encoding, err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, text); err != nil {
+		out.Close()
+		return "", err
+	}
+	return encoding, out.Close()
+}
+
+// skippedResult reports an input left unredacted for reason. Nothing was
+// redacted, so it is not a success, though not an error either.
+func skippedResult(reason string) (*ProcessResult, error) {
+	return &ProcessResult{Skipped: reason, Duration: "0s"}, nil
+}
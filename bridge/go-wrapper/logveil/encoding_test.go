@@ -0,0 +1,161 @@
+package logveil
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// utf16Sample encodes ASCII text as UTF-16 without a byte order mark
+func utf16Sample(text string, bigEndian bool) []byte {
+	var b []byte
+	for i := 0; i < len(text); i++ {
+		if bigEndian {
+			b = append(b, 0, text[i])
+		} else {
+			b = append(b, text[i], 0)
+		}
+	}
+	return b
+}
+
+func TestSniffText(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample []byte
+		want   string
+	}{
+		{name: "empty", sample: nil, want: ""},
+		{name: "ascii", sample: []byte("plain log line\n"), want: ""},
+		{name: "utf-8", sample: []byte("café ☕ naïve\n"), want: ""},
+		{name: "ansi colours", sample: []byte("\x1b[31merror\x1b[0m\tdone\r\n\f\v"), want: ""},
+		{name: "utf-16le bom", sample: []byte{0xff, 0xfe, 'h', 0, 'i', 0}, want: EncodingUTF16LE},
+		{name: "utf-16be bom", sample: []byte{0xfe, 0xff, 0, 'h', 0, 'i'}, want: EncodingUTF16BE},
+		{name: "utf-16le", sample: utf16Sample("utf-16 log line\n", false), want: EncodingUTF16LE},
+		{name: "utf-16be", sample: utf16Sample("utf-16 log line\n", true), want: EncodingUTF16BE},
+		{name: "latin-1", sample: []byte("caf\xe9 na\xefve\n"), want: EncodingLatin1},
+		{name: "nul byte", sample: []byte("text\x00more text\n"), want: SkippedBinary},
+		{name: "many controls", sample: []byte("ab\x01\x02\x03cdefgh\x04\n"), want: SkippedBinary},
+		{name: "few controls", sample: []byte("a line with one \x07 bell in it\n"), want: ""},
+		{
+			name:   "character cut by the sample",
+			sample: append(bytes.Repeat([]byte("a"), sniffLength-1), 0xe2),
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffText(tt.sample); got != tt.want {
+				t.Errorf("sniffText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// journalEntry returns a journalctl -o export entry whose MESSAGE is
+// written in the binary form, as journald does for values holding control
+// characters
+func journalEntry(message string) []byte {
+	var b bytes.Buffer
+	b.WriteString("__CURSOR=s=1\nMESSAGE\n")
+	binary.Write(&b, binary.LittleEndian, uint64(len(message)))
+	b.WriteString(message)
+	b.WriteString("\n_PID=1\n\n")
+	return b.Bytes()
+}
+
+func TestEngineInput(t *testing.T) {
+	journal, err := newFormat(Config{Format: "journal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := journalEntry("mail alice@example.com\x00")
+	tests := []struct {
+		name   string
+		engine Engine
+		input  []byte
+		// want is the encoding reported, the input passing unchanged
+		// unless it is SkippedBinary
+		want string
+	}{
+		{name: "text", engine: &NativeEngine{}, input: entry, want: SkippedBinary},
+		{name: "json", engine: &formatEngine{format: newJSONFormat(JSONOptions{}, nil)}, input: entry, want: SkippedBinary},
+		{name: "journal", engine: &formatEngine{format: journal}, input: entry, want: ""},
+		{name: "journal utf-16le", engine: &formatEngine{format: journal}, input: []byte{0xff, 0xfe, 'h', 0}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, encoding, err := engineInput(bytes.NewReader(tt.input), tt.engine)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoding != tt.want {
+				t.Errorf("engineInput() encoding = %q, want %q", encoding, tt.want)
+			}
+			if encoding == SkippedBinary {
+				return
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.input) {
+				t.Errorf("engineInput() read %q, want %q", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestProcessFileBinaryInput(t *testing.T) {
+	entry := journalEntry("mail alice@example.com\x00")
+	tests := []struct {
+		format      string
+		wantSkipped string
+	}{
+		{format: "text", wantSkipped: SkippedBinary},
+		{format: "journal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			r, err := NewRedactor(Config{Engine: "native", Format: tt.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			dir := t.TempDir()
+			input, output := filepath.Join(dir, "in.export"), filepath.Join(dir, "out.export")
+			if err := os.WriteFile(input, entry, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := r.ProcessFile(context.Background(), input, output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Skipped != tt.wantSkipped {
+				t.Fatalf("Skipped = %q, want %q", result.Skipped, tt.wantSkipped)
+			}
+			if tt.wantSkipped != "" {
+				if result.Success {
+					t.Error("a skipped input reported success")
+				}
+				return
+			}
+			if !result.Success {
+				t.Fatalf("result = %+v, want success", result)
+			}
+			got, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(got, []byte("alice@example.com")) || !strings.Contains(string(got), "_PID=1") {
+				t.Errorf("output = %q, want the entry with MESSAGE redacted", got)
+			}
+		})
+	}
+}
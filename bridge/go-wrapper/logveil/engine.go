@@ -0,0 +1,39 @@
+package logveil
+
+import (
+	"context"
+	"fmt"
+)
+
+// Engine performs the actual redaction work behind a Redactor
+type Engine interface {
+	// Name returns the identifier used to select the engine
+	Name() string
+	// ProcessFile redacts inputPath into outputPath
+	ProcessFile(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error)
+	// RedactLine redacts a single line of text and reports what it found.
+	// Engines that cannot attribute matches return no detections.
+	RedactLine(ctx context.Context, line string) (string, []Detection, error)
+}
+
+// NewEngine returns the engine selected by cfg.Engine
+func NewEngine(cfg Config) (Engine, error) {
+	switch cfg.Engine {
+	case "native":
+		return NewNativeEngine(cfg.Native)
+	case "python":
+		return NewPythonEngine(cfg.Python)
+	default:
+		return nil, fmt.Errorf("unknown engine %q (expected native or python)", cfg.Engine)
+	}
+}
+
+// streamsLines reports whether engine redacts files line by line through
+// RedactLine, and can therefore read from and write to arbitrary streams
+// without staging whole files on disk
+func streamsLines(engine Engine) bool {
+	if python, ok := engine.(*PythonEngine); ok {
+		return python.worker != nil
+	}
+	return true
+}
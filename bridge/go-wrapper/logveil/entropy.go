@@ -0,0 +1,128 @@
+package logveil
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// highEntropyRule is the generic secret detector, off unless selected by
+// name, since it also flags harmless random identifiers
+const highEntropyRule = "high_entropy"
+
+const (
+	// DefaultEntropyThreshold is the Shannon entropy, in bits per
+	// character, above which a token counts as a secret
+	DefaultEntropyThreshold = 4.0
+	// DefaultEntropyMinLength is the shortest token the entropy detector
+	// considers
+	DefaultEntropyMinLength = 20
+)
+
+// entropyCharset is what the entropy detector treats as part of a token:
+// the base64, base64url and hex alphabets. Tokens may also end in base64
+// "=" padding.
+const entropyCharset = `A-Za-z0-9+/_-`
+
+// EntropyOptions tunes the high_entropy detector
+type EntropyOptions struct {
+	// Threshold is the minimum Shannon entropy in bits per character;
+	// DefaultEntropyThreshold when zero
+	Threshold float64
+	// MinLength is the minimum token length; DefaultEntropyMinLength when
+	// zero
+	MinLength int
+	// Allowlist holds RE2 patterns for known-benign tokens, such as build
+	// IDs, that must match a token entirely to exempt it
+	Allowlist []string
+}
+
+// entropyDetectors holds the high_entropy detector with default settings;
+// NewNativeEngine rebuilds it from NativeOptions.Entropy
+var entropyDetectors = []detector{mustEntropyDetector(EntropyOptions{})}
+
+func mustEntropyDetector(opts EntropyOptions) detector {
+	d, err := newEntropyDetector(opts)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// newEntropyDetector builds a detector that replaces runs of token
+// characters at least opts.MinLength long whose entropy reaches
+// opts.Threshold
+func newEntropyDetector(opts EntropyOptions) (detector, error) {
+	threshold, minLength := opts.Threshold, opts.MinLength
+	if threshold == 0 {
+		threshold = DefaultEntropyThreshold
+	}
+	if minLength == 0 {
+		minLength = DefaultEntropyMinLength
+	}
+	if threshold < 0 || minLength < 0 {
+		return detector{}, fmt.Errorf("entropy threshold and minimum length must not be negative")
+	}
+
+	var allowlist []*regexp.Regexp
+	for _, pattern := range opts.Allowlist {
+		re, err := regexp.Compile(`\A(?:` + pattern + `)\z`)
+		if err != nil {
+			return detector{}, fmt.Errorf("entropy allowlist pattern %q: %v", pattern, err)
+		}
+		allowlist = append(allowlist, re)
+	}
+
+	pattern := regexp.MustCompile(fmt.Sprintf(`(?:^|[^%[1]s])(?P<value>[%[1]s]{%[2]d,}={0,2})`, entropyCharset, minLength))
+	return detector{
+		name:    highEntropyRule,
+		pattern: pattern,
+		validate: func(token string) bool {
+			if shannonEntropy(token) < threshold {
+				return false
+			}
+			for _, re := range allowlist {
+				if re.MatchString(token) {
+					return false
+				}
+			}
+			return true
+		},
+	}, nil
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	entropy := 0.0
+	n := float64(len(s))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// configureEntropy rebuilds any high_entropy detector in detectors from
+// opts
+func configureEntropy(detectors []detector, opts EntropyOptions) error {
+	for i, d := range detectors {
+		if d.name != highEntropyRule {
+			continue
+		}
+		configured, err := newEntropyDetector(opts)
+		if err != nil {
+			return err
+		}
+		detectors[i] = configured
+	}
+	return nil
+}
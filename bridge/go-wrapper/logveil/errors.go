@@ -0,0 +1,120 @@
+package logveil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorCode classifies a ProcessError, so callers can react to a kind of
+// failure without matching messages. New codes may be added; treat unknown
+// ones like CodeInternal.
+type ErrorCode string
+
+const (
+	// CodeTimeout means processing ran past its timeout
+	CodeTimeout ErrorCode = "timeout"
+	// CodeCancelled means processing was stopped, such as by a signal
+	CodeCancelled ErrorCode = "cancelled"
+	// CodeIO means opening, reading, staging or writing data failed
+	CodeIO ErrorCode = "io"
+	// CodeParse means the input is malformed for the configured format,
+	// such as a truncated journal entry or a missing CSV column
+	CodeParse ErrorCode = "parse"
+	// CodeConfig means the options cannot be applied to this input
+	CodeConfig ErrorCode = "config"
+	// CodeRedact means the engine failed to redact a line
+	CodeRedact ErrorCode = "redact"
+	// CodeSubprocess means the Python agent failed, died or could not start
+	CodeSubprocess ErrorCode = "subprocess"
+	// CodeIntegrity means the agent script did not match its pinned checksum
+	CodeIntegrity ErrorCode = "integrity"
+	// CodeFallback is a warning rather than a failure: the native engine
+	// redacted in place of the unavailable python one
+	CodeFallback ErrorCode = "engine_fallback"
+	// CodeInternal is anything not classified above
+	CodeInternal ErrorCode = "internal"
+)
+
+// retryable reports whether a failure with code may not recur
+func (c ErrorCode) retryable() bool {
+	return c == CodeTimeout || c == CodeCancelled
+}
+
+// ErrorStage says where in processing a ProcessError arose
+type ErrorStage string
+
+const (
+	StageSetup  ErrorStage = "setup"
+	StageRead   ErrorStage = "read"
+	StageRedact ErrorStage = "redact"
+	StageWrite  ErrorStage = "write"
+	StageAgent  ErrorStage = "agent"
+)
+
+// ProcessError is one entry in ProcessResult.Errors, and the error returned
+// alongside it
+type ProcessError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// Stage is empty for errors about the run as a whole, such as a timeout
+	Stage ErrorStage `json:"stage,omitempty"`
+	// Retryable suggests the same input may succeed if processed again
+	Retryable bool `json:"retryable"`
+	// Detail carries supporting output, such as what the agent printed
+	Detail string `json:"detail,omitempty"`
+
+	cause error
+}
+
+// newError returns a ProcessError formatted like fmt.Sprintf. An error
+// among args becomes its cause, and one that is already a ProcessError,
+// such as a parse error found while reading, keeps its classification.
+func newError(code ErrorCode, stage ErrorStage, format string, args ...any) *ProcessError {
+	e := &ProcessError{Code: code, Stage: stage, Message: fmt.Sprintf(format, args...), Retryable: code.retryable()}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			e.cause = err
+			var inner *ProcessError
+			if errors.As(err, &inner) {
+				e.Code, e.Stage, e.Retryable = inner.Code, inner.Stage, inner.Retryable
+			}
+		}
+	}
+	return e
+}
+
+func (e *ProcessError) Error() string {
+	return e.Message
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.cause
+}
+
+// asProcessError classifies err, keeping the code of a ProcessError in its
+// chain
+func asProcessError(err error) ProcessError {
+	var e *ProcessError
+	if errors.As(err, &e) {
+		entry := *e
+		entry.Message = err.Error()
+		return entry
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ProcessError{Code: CodeTimeout, Message: err.Error(), Retryable: true}
+	case errors.Is(err, context.Canceled):
+		return ProcessError{Code: CodeCancelled, Message: err.Error(), Retryable: true}
+	}
+	return ProcessError{Code: CodeInternal, Message: err.Error()}
+}
+
+// cancelError reports processing stopped because ctx ended with cause
+func cancelError(stage ErrorStage, cause error) *ProcessError {
+	code := CodeCancelled
+	if errors.Is(cause, context.DeadlineExceeded) {
+		code = CodeTimeout
+	}
+	return newError(code, stage, "processing cancelled: %v", cause)
+}
@@ -0,0 +1,54 @@
+package logveil
+
+import (
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// euPersonalDetectors recognise identifiers that are personal data under
+// the GDPR: bank accounts, national identity and tax numbers of the larger
+// member states and the UK, passport numbers after a label, and phone
+// numbers in international form. Checksummed formats are validated.
+var euPersonalDetectors = []detector{
+	{name: "iban", pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`), validate: validIBAN},
+	{name: "eu_vat_number", pattern: regexp.MustCompile(`\b(?:ATU\d{8}|BE[01]\d{9}|DE\d{9}|DK\d{8}|ES[A-Z0-9]\d{7}[A-Z0-9]|FR[A-HJ-NP-Z0-9]{2}\d{9}|IT\d{11}|NL\d{9}B\d{2}|PL\d{10}|SE\d{12}|IE\d{7}[A-W][A-IW]?)\b`)},
+	{name: "uk_nino", pattern: regexp.MustCompile(`\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`)},
+	{name: "es_dni", pattern: regexp.MustCompile(`\b[XYZ0-9]\d{7}-?[A-HJ-NP-TV-Z]\b`), validate: validDNI},
+	{name: "it_codice_fiscale", pattern: regexp.MustCompile(`\b[A-Z]{6}\d{2}[A-EHLMPR-T]\d{2}[A-Z]\d{3}[A-Z]\b`)},
+	{name: "fr_insee", pattern: regexp.MustCompile(`\b[12] ?\d{2} ?(?:0[1-9]|1[0-2]|[2-9]\d) ?(?:\d{2}|2[AB]) ?\d{3} ?\d{3} ?\d{2}\b`)},
+	{name: "passport_number", pattern: regexp.MustCompile(`(?i)\bpassport(?:[ _](?:no\.?|number|#))?["']?\s*[#:=]?\s*["']?(?P<value>[A-Z0-9]{6,9})\b`)},
+	{name: "intl_phone", pattern: regexp.MustCompile(`(?:\+|\b00)[1-9]\d{0,2}[ .-]?(?:\(0?\d{1,4}\)|\d{1,4})(?:[ .-]?\d{2,4}){2,4}\b`)},
+}
+
+// validIBAN reports whether an IBAN passes its ISO 7064 mod 97 check
+func validIBAN(candidate string) bool {
+	iban := strings.ReplaceAll(candidate, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	var digits strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			digits.WriteString(big.NewInt(int64(c - 'A' + 10)).String())
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// validDNI reports whether a Spanish DNI or NIE ends in its check letter
+func validDNI(candidate string) bool {
+	id := strings.ReplaceAll(candidate, "-", "")
+	number := strings.NewReplacer("X", "0", "Y", "1", "Z", "2").Replace(id[:8])
+	n := 0
+	for _, c := range number {
+		n = n*10 + int(c-'0')
+	}
+	return "TRWAGMYFPDXBNJZSQVHLCKE"[n%23] == id[8]
+}
@@ -0,0 +1,46 @@
+package logveil
+
+import "testing"
+
+func TestValidIBAN(t *testing.T) {
+	tests := []struct {
+		candidate string
+		want      bool
+	}{
+		{candidate: "GB82WEST12345698765432", want: true},
+		{candidate: "GB82 WEST 1234 5698 7654 32", want: true},
+		{candidate: "DE89370400440532013000", want: true},
+		{candidate: "FR1420041010050500013M02606", want: true},
+		{candidate: "GB82WEST12345698765433", want: false},
+		{candidate: "GB28WEST12345698765432", want: false},
+		{candidate: "gb82west12345698765432", want: false},
+		{candidate: "GB82WEST1234", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.candidate, func(t *testing.T) {
+			if got := validIBAN(tt.candidate); got != tt.want {
+				t.Errorf("validIBAN(%q) = %v, want %v", tt.candidate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidDNI(t *testing.T) {
+	tests := []struct {
+		candidate string
+		want      bool
+	}{
+		{candidate: "12345678Z", want: true},
+		{candidate: "12345678-Z", want: true},
+		{candidate: "X1234567L", want: true},
+		{candidate: "12345678A", want: false},
+		{candidate: "Y1234567L", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.candidate, func(t *testing.T) {
+			if got := validDNI(tt.candidate); got != tt.want {
+				t.Errorf("validDNI(%q) = %v, want %v", tt.candidate, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package logveil
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/brianvoe/gofakeit/v6"
+)
+
+// defaultFakeSeed seeds fake data when none is configured. Fake values are
+// then the same for everyone, so anyone can confirm a guessed original;
+// set a secret seed where that matters.
+const defaultFakeSeed = "logveil"
+
+// documentationNetworks are the IPv4 ranges reserved for examples by
+// RFC 5737, so a fake address never belongs to a real host
+var documentationNetworks = [...][3]byte{{192, 0, 2}, {198, 51, 100}, {203, 0, 113}}
+
+// fakeReplacer substitutes plausible fake data chosen by rule: emails at
+// example domains, documentation-range IPs, fictional 555-01xx phone
+// numbers, Luhn-valid card numbers, advertising-range SSNs, UUIDs, and
+// names for any rule or field whose name contains "name". Other rules get
+// placeholders. Each fake is seeded from the value, so the same value always
+// gets the same fake.
+type fakeReplacer struct {
+	seed []byte
+}
+
+func newFakeReplacer(seed string) fakeReplacer {
+	if seed == "" {
+		seed = defaultFakeSeed
+	}
+	return fakeReplacer{seed: []byte(seed)}
+}
+
+func (f fakeReplacer) replace(rule, value string) string {
+	faker := gofakeit.NewUnlocked(f.valueSeed(rule, value))
+	name := strings.ToLower(rule)
+	switch {
+	case name == "email":
+		domain := faker.RandomString([]string{"example.com", "example.org", "example.net"})
+		return strings.ToLower(faker.FirstName()+"."+faker.LastName()) + "@" + domain
+	case name == "ip_address":
+		network := documentationNetworks[faker.Number(0, len(documentationNetworks)-1)]
+		return fmt.Sprintf("%d.%d.%d.%d", network[0], network[1], network[2], faker.Number(1, 254))
+	case name == "phone":
+		// The match may start with the separator before the number, which
+		// is kept so the fake does not run into the text before it
+		number := strings.TrimLeft(value, "-.\t\n\f\r ")
+		return value[:len(value)-len(number)] + fmt.Sprintf("%d-555-01%02d", faker.Number(201, 989), faker.Number(0, 99))
+	case name == "credit_card" || name == "pan":
+		return faker.CreditCardNumber(nil)
+	case name == "ssn":
+		return fmt.Sprintf("987-65-432%d", faker.Number(0, 9))
+	case name == "uuid":
+		return faker.UUID()
+	case strings.Contains(name, "name"):
+		return faker.Name()
+	default:
+		return placeholder(rule)
+	}
+}
+
+// valueSeed derives a non-zero seed from rule and value; gofakeit treats
+// zero as a request for a random seed
+func (f fakeReplacer) valueSeed(rule, value string) int64 {
+	mac := hmac.New(sha256.New, f.seed)
+	mac.Write([]byte(rule))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	seed := int64(binary.BigEndian.Uint64(mac.Sum(nil)))
+	if seed == 0 {
+		seed = 1
+	}
+	return seed
+}
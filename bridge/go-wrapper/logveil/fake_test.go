@@ -0,0 +1,42 @@
+package logveil
+
+import (
+	"context"
+	"regexp"
+	"testing"
+)
+
+func TestFakePhoneKeepsSeparator(t *testing.T) {
+	r, err := NewRedactor(Config{Engine: "native", FakeData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	fakePhone := `\d{3}-555-01\d{2}`
+	fakeIP := `(?:192\.0\.2|198\.51\.100|203\.0\.113)\.\d{1,3}`
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "10.0.0.1 555-123-4567", want: `^` + fakeIP + ` ` + fakePhone + `$`},
+		{line: "call 555-123-4567 now", want: `^call ` + fakePhone + ` now$`},
+		{line: "tel:555.123.4567", want: `^tel:` + fakePhone + `$`},
+		{line: "a\t555 123 4567", want: `^a\t` + fakePhone + `$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, _, err := r.RedactLine(context.Background(), tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !regexp.MustCompile(tt.want).MatchString(got) {
+				t.Errorf("RedactLine(%q) = %q, want a match for %s", tt.line, got, tt.want)
+			}
+			again, _, _ := r.RedactLine(context.Background(), tt.line)
+			if again != got {
+				t.Errorf("RedactLine(%q) gave %q, then %q", tt.line, got, again)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package logveil
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// followPollInterval is how often a followed file is checked for new data,
+// rotation and truncation once its end has been reached
+var followPollInterval = 250 * time.Millisecond
+
+// FollowOptions configures Follow
+type FollowOptions struct {
+	// FromStart redacts the existing contents before following; by default
+	// only lines written after Follow starts are redacted
+	FromStart bool
+}
+
+// Follow redacts lines appended to path into out until ctx is cancelled,
+// like `tail -F`. When path is rotated the remainder of the old file is
+// redacted before switching to the new one, and a truncated file is
+// reread from the start. A missing file is waited for. The timeout does
+// not apply.
+func (r *Redactor) Follow(ctx context.Context, path string, out io.Writer, opts FollowOptions) (*ProcessResult, error) {
+	r, release := r.acquire()
+	defer release()
+	if r.compression != "" {
+		return failedResult(newError(CodeConfig, StageSetup, "compressed output is not supported when following"))
+	}
+	ctx = withSource(ctx, path)
+	start := time.Now()
+
+	in := &followReader{ctx: ctx, path: path, seekEnd: !opts.FromStart}
+	defer in.close()
+	if err := in.open(); err != nil {
+		if !os.IsNotExist(err) {
+			return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+		}
+		// Everything in a file that appears later is new
+		in.seekEnd = false
+	}
+
+	// Cancellation ends the input rather than failing the stream, so a
+	// followed run that is stopped still reports success
+	var hashedOut *hashingWriter
+	if r.audit != nil {
+		hashedOut = newHashingWriter(out)
+		out = hashedOut
+	}
+	result, err := redactStream(context.WithoutCancel(ctx), r.engine, in, out)
+	if err == nil && in.err != nil {
+		result.Success = false
+		result.addError(in.err)
+		err = in.err
+	}
+	if r.audit != nil {
+		// The file keeps changing, so only what was written is hashed
+		entry := AuditEntry{Operation: "follow", Input: path, OutputSHA256: sumHex(hashedOut.hash)}
+		result, err = r.auditResult(context.WithoutCancel(ctx), entry, start, result, err)
+	}
+	return result, err
+}
+
+// followReader reads path indefinitely, returning io.EOF only once ctx is
+// done
+type followReader struct {
+	ctx     context.Context
+	path    string
+	seekEnd bool
+
+	file     *os.File
+	info     os.FileInfo
+	offset   int64
+	lastByte byte
+	// err records why Read stopped early
+	err error
+}
+
+func (f *followReader) Read(p []byte) (int, error) {
+	for {
+		if f.file != nil {
+			n, err := f.file.Read(p)
+			if n > 0 {
+				f.offset += int64(n)
+				f.lastByte = p[n-1]
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				f.err = newError(CodeIO, StageRead, "read input: %v", err)
+				return 0, io.EOF
+			}
+			if n, switched := f.reopenIfReplaced(p); switched {
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+		} else if err := f.open(); err == nil {
+			continue
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(followPollInterval):
+		}
+	}
+}
+
+// open opens path, seeking to its end the first time when seekEnd is set
+func (f *followReader) open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.offset = 0
+	if f.seekEnd {
+		if f.offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return err
+		}
+		f.seekEnd = false
+	}
+	f.file, f.info = file, info
+	return nil
+}
+
+// reopenIfReplaced handles rotation and truncation once the current file
+// is exhausted. A line cut off by rotation is terminated in p so it isn't
+// joined to the first line of the new file.
+func (f *followReader) reopenIfReplaced(p []byte) (int, bool) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		// Rotated away and not yet recreated; keep the old file in case the
+		// writer still appends to it
+		return 0, false
+	}
+
+	if !os.SameFile(info, f.info) {
+		// Drain anything written between the last read and the rotation
+		if n, _ := f.file.Read(p); n > 0 {
+			f.offset += int64(n)
+			f.lastByte = p[n-1]
+			return n, true
+		}
+		f.close()
+		f.open()
+		return f.terminateLine(p), true
+	}
+	if info.Size() < f.offset {
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return 0, false
+		}
+		f.offset = 0
+		return f.terminateLine(p), true
+	}
+	return 0, false
+}
+
+// terminateLine writes a newline to p if the last byte read didn't end a
+// line
+func (f *followReader) terminateLine(p []byte) int {
+	if f.lastByte == 0 || f.lastByte == '\n' || len(p) == 0 {
+		return 0
+	}
+	f.lastByte = '\n'
+	p[0] = '\n'
+	return 1
+}
+
+func (f *followReader) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
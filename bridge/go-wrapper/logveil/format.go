@@ -0,0 +1,149 @@
+package logveil
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// redactFunc redacts free text with the underlying engine. field is the
+// path or key the text is the value of, for redaction policies, or empty
+// for free text.
+type redactFunc func(field, text string) (string, []Detection, error)
+
+// lineFormat understands the framing of one structured log format. It
+// redacts the parts of a line that can carry sensitive data through redact
+// and leaves the framing intact.
+type lineFormat interface {
+	redactLine(line string, redact redactFunc) (string, []Detection, error)
+}
+
+// newFormat returns the lineFormat selected by cfg.Format, or nil for plain
+// text
+func newFormat(cfg Config) (lineFormat, error) {
+	switch cfg.Format {
+	case "", "text":
+		return nil, nil
+	case "json":
+		return newJSONFormat(cfg.JSON, newReplacer(cfg)), nil
+	case "docker":
+		return newDockerFormat(cfg.JSON, newReplacer(cfg)), nil
+	case "journal":
+		return newJournalExportFormat(cfg.Journal), nil
+	case "journal-json":
+		return newJournalJSONFormat(cfg.Journal, cfg.JSON, newReplacer(cfg)), nil
+	case "syslog":
+		return syslogFormat{}, nil
+	case "logfmt":
+		return newLogfmtFormat(cfg.Logfmt, newReplacer(cfg)), nil
+	case "access":
+		return newAccessLogFormat(cfg.AccessLog, newReplacer(cfg)), nil
+	case "cef", "leef":
+		return newCEFFormat(cfg.CEF, cfg.Format == "leef", newReplacer(cfg)), nil
+	case "har":
+		return newHARFormat(newReplacer(cfg)), nil
+	case "csv":
+		return newCSVFormat(cfg.CSV, newReplacer(cfg))
+	case "winevent":
+		return winEventFormat{replacer: newReplacer(cfg)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", cfg.Format)
+	}
+}
+
+// streamFormat is implemented by formats that carry state from one record
+// of a stream to the next, such as a CSV header
+type streamFormat interface {
+	lineFormat
+	// newStream returns the format with fresh state for one stream
+	newStream() lineFormat
+}
+
+// binaryFormat is implemented by formats whose records frame binary data,
+// such as journal export entries, so that input is never sniffed as text
+type binaryFormat interface {
+	lineFormat
+	framesBinary()
+}
+
+// readsBinary reports whether engine's format frames binary data
+func readsBinary(engine Engine) bool {
+	if f, ok := engine.(*formatEngine); ok {
+		_, ok := f.format.(binaryFormat)
+		return ok
+	}
+	return false
+}
+
+// forStream returns engine ready for one stream: a copy with fresh format
+// state when its format has any, or else engine itself
+func forStream(engine Engine) Engine {
+	if f, ok := engine.(*formatEngine); ok {
+		if s, ok := f.format.(streamFormat); ok {
+			stream := *f
+			stream.format = s.newStream()
+			return &stream
+		}
+	}
+	return engine
+}
+
+// textFormat passes lines straight to the engine. It stands in for a
+// format when only multi-line records are configured.
+type textFormat struct{}
+
+func (textFormat) redactLine(line string, redact redactFunc) (string, []Detection, error) {
+	return redact("", line)
+}
+
+// formatEngine runs a lineFormat on top of another engine. Files are always
+// processed line by line so every line goes through the format; with
+// records set, a "line" is a whole multi-line record.
+type formatEngine struct {
+	Engine
+	format  lineFormat
+	records *recordSplit
+	lines   LineOptions
+	// numeric, when set, transforms numeric fields before the format runs
+	numeric *numericTransforms
+}
+
+func (e *formatEngine) RedactLine(ctx context.Context, line string) (string, []Detection, error) {
+	var scope *matchScope
+	if engineScoped(e.Engine) {
+		// Conditions and policies see the whole record, not the field
+		ctx = withRecord(ctx, line)
+		scope = scopeOf(ctx, line)
+	}
+	placeholders := enginePlaceholders(e.Engine)
+	if placeholders != nil {
+		// The format's own replacements are numbered with the engine's
+		ctx = placeholders.withDocument(ctx)
+	}
+	var transformed []Detection
+	if e.numeric != nil {
+		line, transformed = e.numeric.apply(line)
+	}
+	redacted, detections, err := e.format.redactLine(line, func(field, text string) (string, []Detection, error) {
+		if scope != nil {
+			scope.path = field
+		}
+		return e.Engine.RedactLine(ctx, text)
+	})
+	if placeholders != nil {
+		redacted = placeholders.resolve(ctx, redacted)
+	}
+	return redacted, append(transformed, detections...), err
+}
+
+func (e *formatEngine) ProcessFile(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error) {
+	return processFileByLine(ctx, e, inputPath, outputPath)
+}
+
+// Close forwards to the wrapped engine
+func (e *formatEngine) Close() error {
+	if closer, ok := e.Engine.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
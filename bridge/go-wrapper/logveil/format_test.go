@@ -0,0 +1,21 @@
+package logveil
+
+import (
+	"context"
+	"testing"
+)
+
+// redactLine redacts line with a Redactor built from cfg
+func redactLine(t *testing.T, cfg Config, line string) (string, []Detection) {
+	t.Helper()
+	r, err := NewRedactor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	redacted, detections, err := r.Engine().RedactLine(context.Background(), line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redacted, detections
+}
@@ -0,0 +1,88 @@
+package logveil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// gcsChunkSize is the resumable upload chunk size, buffered per upload
+const gcsChunkSize = 16 << 20
+
+// gcsStore serves gs://bucket/object URIs. Credentials come from
+// Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS, gcloud,
+// or the metadata server, which serves workload identity on GKE).
+type gcsStore struct {
+	client *storage.Client
+}
+
+func newGCSStore(ctx context.Context) (objectStore, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsStore{client: client}, nil
+}
+
+// gcsObject returns the object a gs:// URI names
+func (s *gcsStore) gcsObject(u *url.URL) (*storage.ObjectHandle, error) {
+	bucket, object := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || object == "" {
+		return nil, fmt.Errorf("invalid GCS URI %q (expected gs://bucket/object)", u.String())
+	}
+	return s.client.Bucket(bucket).Object(object), nil
+}
+
+func (s *gcsStore) open(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	object, err := s.gcsObject(u)
+	if err != nil {
+		return nil, err
+	}
+	return object.NewReader(ctx)
+}
+
+func (s *gcsStore) size(ctx context.Context, u *url.URL) (int64, error) {
+	object, err := s.gcsObject(u)
+	if err != nil {
+		return 0, err
+	}
+	attrs, err := object.Attrs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+// create streams writes into a resumable upload, which only creates the
+// object once closed
+func (s *gcsStore) create(ctx context.Context, u *url.URL) (objectWriter, error) {
+	object, err := s.gcsObject(u)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	w := object.NewWriter(ctx)
+	w.ChunkSize = gcsChunkSize
+	return &gcsUpload{Writer: w, cancel: cancel}, nil
+}
+
+// gcsUpload is an object being uploaded to GCS. Cancelling its context
+// abandons the upload.
+type gcsUpload struct {
+	*storage.Writer
+	cancel context.CancelFunc
+}
+
+func (u *gcsUpload) Close() error {
+	defer u.cancel()
+	return u.Writer.Close()
+}
+
+func (u *gcsUpload) Abort(error) {
+	u.cancel()
+	u.Writer.Close()
+}
@@ -0,0 +1,110 @@
+package logveil
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hasGlobMeta reports whether pattern contains glob metacharacters
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// expandGlob returns the regular files matching pattern together with the
+// directory they are relative to. Besides the path.Match syntax, a "**"
+// segment matches any number of directories.
+func expandGlob(pattern string) (string, []string, error) {
+	segments := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+
+	static := 0
+	for static < len(segments) && !hasGlobMeta(segments[static]) {
+		static++
+	}
+	for _, segment := range segments[static:] {
+		if _, err := path.Match(segment, ""); err != nil {
+			return "", nil, fmt.Errorf("invalid glob %q: %v", pattern, err)
+		}
+	}
+
+	root := strings.Join(segments[:static], "/")
+	switch {
+	case static == 0:
+		root = "."
+	case root == "":
+		root = "/"
+	}
+	root = filepath.FromSlash(root)
+	dynamic := segments[static:]
+
+	var matches []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && !matchPrefix(dynamic, strings.Split(filepath.ToSlash(rel), "/")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if matchSegments(dynamic, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	return root, matches, nil
+}
+
+// matchSegments matches path segments against pattern segments
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
+
+// matchPrefix reports whether files below the directory dir could match
+// pattern, so walks skip subtrees that cannot
+func matchPrefix(pattern, dir []string) bool {
+	if len(dir) == 0 {
+		return len(pattern) > 0
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	if ok, _ := path.Match(pattern[0], dir[0]); !ok {
+		return false
+	}
+	return matchPrefix(pattern[1:], dir[1:])
+}
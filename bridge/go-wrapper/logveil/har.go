@@ -0,0 +1,381 @@
+package logveil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// harSecretHeaders are headers whose values are replaced outright
+var harSecretHeaders = map[string]bool{
+	"authorization":        true,
+	"proxy-authorization":  true,
+	"cookie":               true,
+	"set-cookie":           true,
+	"x-api-key":            true,
+	"x-auth-token":         true,
+	"x-csrf-token":         true,
+	"x-xsrf-token":         true,
+	"x-amz-security-token": true,
+}
+
+// harURLHeaders are headers holding a URL, redacted like request URLs
+var harURLHeaders = map[string]bool{
+	"referer":  true,
+	"location": true,
+	"origin":   true,
+}
+
+// harFormat redacts HTTP Archive files as exported by browsers; the whole
+// input is one record. Cookie values, query and form parameter values and
+// credential headers such as Authorization are replaced outright, and
+// URLs, other header values and request and response bodies, base64 ones
+// included, go through the engine, JSON bodies value by value. Members are
+// kept in order, and the file is only re-encoded, with its indentation,
+// when something was redacted. Input that is not a HAR document is
+// redacted like the json format.
+type harFormat struct {
+	replacer replacer
+	urls     *accessLogFormat
+	bodies   *jsonFormat
+}
+
+func newHARFormat(repl replacer) *harFormat {
+	return &harFormat{
+		replacer: repl,
+		urls:     &accessLogFormat{params: newKeyPatterns([]string{"*"}), replacer: repl},
+		bodies:   newJSONFormat(JSONOptions{}, repl),
+	}
+}
+
+// records reads the whole input as one document
+func (*harFormat) records() *recordSplit {
+	return &recordSplit{read: readAll}
+}
+
+// readAll reads reader to its end
+func readAll(reader *bufio.Reader) (string, int, error) {
+	data, err := io.ReadAll(reader)
+	if err == nil {
+		err = io.EOF
+	}
+	lines := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+	return string(data), lines, err
+}
+
+func (f *harFormat) redactLine(record string, redact redactFunc) (string, []Detection, error) {
+	root, err := parseJSONTree(record)
+	entries := root.get("log").get("entries")
+	if err != nil || entries == nil || entries.kind != '[' {
+		return f.bodies.redactLine(record, redact)
+	}
+
+	h := &harRedaction{format: f, redact: redact}
+	for _, entry := range entries.array {
+		h.message(entry.get("request"))
+		h.message(entry.get("response"))
+		h.text(entry.get("serverIPAddress"))
+	}
+	for _, page := range root.get("log").get("pages").elements() {
+		h.url(page.get("title"))
+	}
+	if h.err != nil {
+		return "", nil, h.err
+	}
+	if !h.changed {
+		return record, h.detections, nil
+	}
+	return encodeJSONTree(root, record), h.detections, nil
+}
+
+// harRedaction collects the detections and first error of one document
+type harRedaction struct {
+	format     *harFormat
+	redact     redactFunc
+	detections []Detection
+	changed    bool
+	err        error
+}
+
+// message redacts a request or response
+func (h *harRedaction) message(m *jsonValue) {
+	h.url(m.get("url"))
+	h.url(m.get("redirectURL"))
+	for _, header := range m.get("headers").elements() {
+		name := strings.ToLower(header.get("name").text())
+		switch {
+		case harSecretHeaders[name]:
+			h.replace(header.get("value"), name, "har_header")
+		case harURLHeaders[name]:
+			h.url(header.get("value"))
+		default:
+			h.keyed(header.get("value"), name)
+		}
+	}
+	for _, cookie := range m.get("cookies").elements() {
+		h.replace(cookie.get("value"), cookie.get("name").text(), "har_cookie")
+	}
+	for _, param := range m.get("queryString").elements() {
+		h.replace(param.get("value"), param.get("name").text(), "query_param")
+	}
+	for _, body := range []*jsonValue{m.get("postData"), m.get("content")} {
+		for _, param := range body.get("params").elements() {
+			h.replace(param.get("value"), param.get("name").text(), "form_param")
+		}
+		h.body(body)
+	}
+}
+
+// replace swaps v, a string, for the replacement of its value under rule
+func (h *harRedaction) replace(v *jsonValue, rule, detection string) {
+	if v == nil || v.kind != '"' || v.str == "" {
+		return
+	}
+	h.set(v, h.format.replacer.replace(rule, v.str))
+	h.detections = append(h.detections, Detection{Rule: detection})
+}
+
+// keyed redacts v together with its header name
+func (h *harRedaction) keyed(v *jsonValue, name string) {
+	if v == nil || v.kind != '"' || h.err != nil {
+		return
+	}
+	redacted, found, err := redactKeyedValue(name, v.str, h.redact)
+	h.apply(v, redacted, found, err)
+}
+
+// url redacts v as a URL: the path through the engine and every query value
+// outright
+func (h *harRedaction) url(v *jsonValue) {
+	if v == nil || v.kind != '"' || h.err != nil {
+		return
+	}
+	redacted, found, err := h.format.urls.redactURL(v.str, h.redact)
+	h.apply(v, redacted, found, err)
+}
+
+// text redacts v through the engine
+func (h *harRedaction) text(v *jsonValue) {
+	if v == nil || v.kind != '"' || h.err != nil {
+		return
+	}
+	redacted, found, err := h.redact("", v.str)
+	h.apply(v, redacted, found, err)
+}
+
+// body redacts the text of postData or content, decoding base64 text
+// first. JSON bodies are redacted value by value; binary ones are left
+// alone.
+func (h *harRedaction) body(body *jsonValue) {
+	v := body.get("text")
+	if v == nil || v.kind != '"' || v.str == "" || h.err != nil {
+		return
+	}
+	text := v.str
+	encoded := body.get("encoding").text() == "base64"
+	if encoded {
+		decoded, err := base64.StdEncoding.DecodeString(text)
+		if err != nil || !utf8.Valid(decoded) {
+			return
+		}
+		text = string(decoded)
+	}
+
+	var redacted string
+	var found []Detection
+	var err error
+	if strings.Contains(body.get("mimeType").text(), "json") {
+		redacted, found, err = h.format.bodies.redactLine(text, h.redact)
+	} else {
+		redacted, found, err = h.redact("", text)
+	}
+	if err == nil && encoded {
+		if redacted == text {
+			redacted = v.str
+		} else {
+			redacted = base64.StdEncoding.EncodeToString([]byte(redacted))
+		}
+	}
+	h.apply(v, redacted, found, err)
+}
+
+// apply records the outcome of redacting v
+func (h *harRedaction) apply(v *jsonValue, redacted string, found []Detection, err error) {
+	if err != nil {
+		h.err = err
+		return
+	}
+	h.detections = append(h.detections, found...)
+	if redacted != v.str {
+		h.set(v, redacted)
+	}
+}
+
+func (h *harRedaction) set(v *jsonValue, text string) {
+	v.str = text
+	h.changed = true
+}
+
+// jsonValue is a decoded JSON value that keeps object members in order
+type jsonValue struct {
+	// kind is '{', '[', '"' or 0 for any other literal
+	kind    byte
+	members []jsonMember
+	array   []*jsonValue
+	str     string
+	literal string
+}
+
+type jsonMember struct {
+	key   string
+	value *jsonValue
+}
+
+// get returns the member key of an object, or nil
+func (v *jsonValue) get(key string) *jsonValue {
+	if v == nil || v.kind != '{' {
+		return nil
+	}
+	for _, m := range v.members {
+		if m.key == key {
+			return m.value
+		}
+	}
+	return nil
+}
+
+// elements returns the elements of an array, or nil
+func (v *jsonValue) elements() []*jsonValue {
+	if v == nil || v.kind != '[' {
+		return nil
+	}
+	return v.array
+}
+
+// text returns a string's value, or ""
+func (v *jsonValue) text() string {
+	if v == nil || v.kind != '"' {
+		return ""
+	}
+	return v.str
+}
+
+// parseJSONTree decodes one JSON document
+func parseJSONTree(src string) (*jsonValue, error) {
+	decoder := json.NewDecoder(strings.NewReader(src))
+	decoder.UseNumber()
+	v, err := decodeJSONValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errJSONSyntax
+	}
+	return v, nil
+}
+
+func decodeJSONValue(decoder *json.Decoder) (*jsonValue, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			v := &jsonValue{kind: '{'}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				member, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				v.members = append(v.members, jsonMember{key: key.(string), value: member})
+			}
+			_, err := decoder.Token() // }
+			return v, err
+		}
+		if t == '[' {
+			v := &jsonValue{kind: '['}
+			for decoder.More() {
+				element, err := decodeJSONValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				v.array = append(v.array, element)
+			}
+			_, err := decoder.Token() // ]
+			return v, err
+		}
+		return nil, errJSONSyntax
+	case string:
+		return &jsonValue{kind: '"', str: t}, nil
+	case json.Number:
+		return &jsonValue{literal: t.String()}, nil
+	case bool:
+		if t {
+			return &jsonValue{literal: "true"}, nil
+		}
+		return &jsonValue{literal: "false"}, nil
+	default:
+		return &jsonValue{literal: "null"}, nil
+	}
+}
+
+// encodeJSONTree encodes v compactly or, when the source it came from spans
+// lines, indented like the source's second line
+func encodeJSONTree(v *jsonValue, src string) string {
+	var b bytes.Buffer
+	writeJSONValue(&b, v)
+	_, rest, multiline := strings.Cut(strings.TrimSpace(src), "\n")
+	if !multiline {
+		return b.String()
+	}
+	indent := rest[:len(rest)-len(strings.TrimLeft(rest, " \t"))]
+	if indent == "" {
+		indent = "  "
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, b.Bytes(), "", indent); err != nil {
+		return b.String()
+	}
+	return indented.String()
+}
+
+func writeJSONValue(b *bytes.Buffer, v *jsonValue) {
+	switch v.kind {
+	case '{':
+		b.WriteByte('{')
+		for i, m := range v.members {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(marshalJSONString(m.key))
+			b.WriteByte(':')
+			writeJSONValue(b, m.value)
+		}
+		b.WriteByte('}')
+	case '[':
+		b.WriteByte('[')
+		for i, element := range v.array {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSONValue(b, element)
+		}
+		b.WriteByte(']')
+	case '"':
+		b.WriteString(marshalJSONString(v.str))
+	default:
+		b.WriteString(v.literal)
+	}
+}
@@ -0,0 +1,68 @@
+package logveil
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestHARFormat(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("mail alice@example.com"))
+	redacted := base64.StdEncoding.EncodeToString([]byte("mail [REDACTED_EMAIL]"))
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "request",
+			input: `{"log":{"entries":[{"request":{"url":"https://x.test/a?token=abc","headers":[{"name":"Authorization","value":"Bearer xyz"},{"name":"Accept","value":"text/html"}],"cookies":[{"name":"sid","value":"s1"}],"queryString":[{"name":"token","value":"abc"}]},"serverIPAddress":"10.0.0.1"}]}}`,
+			want:  `{"log":{"entries":[{"request":{"url":"https://x.test/a?token=[REDACTED_TOKEN]","headers":[{"name":"Authorization","value":"[REDACTED_AUTHORIZATION]"},{"name":"Accept","value":"text/html"}],"cookies":[{"name":"sid","value":"[REDACTED_SID]"}],"queryString":[{"name":"token","value":"[REDACTED_TOKEN]"}]},"serverIPAddress":"[REDACTED_IP_ADDRESS]"}]}}`,
+		},
+		{
+			name:  "text body",
+			input: `{"log":{"entries":[{"response":{"content":{"mimeType":"text/plain","text":"mail alice@example.com"}}}]}}`,
+			want:  `{"log":{"entries":[{"response":{"content":{"mimeType":"text/plain","text":"mail [REDACTED_EMAIL]"}}}]}}`,
+		},
+		{
+			name:  "base64 body",
+			input: `{"log":{"entries":[{"response":{"content":{"mimeType":"text/plain","encoding":"base64","text":"` + encoded + `"}}}]}}`,
+			want:  `{"log":{"entries":[{"response":{"content":{"mimeType":"text/plain","encoding":"base64","text":"` + redacted + `"}}}]}}`,
+		},
+		{
+			name:  "json body",
+			input: `{"log":{"entries":[{"request":{"postData":{"mimeType":"application/json","text":"{\"to\":\"alice@example.com\",\"n\":1}"}}}]}}`,
+			want:  `{"log":{"entries":[{"request":{"postData":{"mimeType":"application/json","text":"{\"to\":\"[REDACTED_EMAIL]\",\"n\":1}"}}}]}}`,
+		},
+		{
+			name:  "form params",
+			input: `{"log":{"entries":[{"request":{"postData":{"mimeType":"application/x-www-form-urlencoded","params":[{"name":"pin","value":"1234"}]}}}]}}`,
+			want:  `{"log":{"entries":[{"request":{"postData":{"mimeType":"application/x-www-form-urlencoded","params":[{"name":"pin","value":"[REDACTED_PIN]"}]}}}]}}`,
+		},
+		{
+			name:  "unchanged document kept as is",
+			input: "{\n    \"log\": { \"entries\": [ {\"request\": {\"url\": \"https://x.test/\"}} ] }\n}\n",
+			want:  "{\n    \"log\": { \"entries\": [ {\"request\": {\"url\": \"https://x.test/\"}} ] }\n}\n",
+		},
+		{
+			name:  "indentation kept",
+			input: "{\n    \"log\": {\"entries\": [{\"serverIPAddress\": \"10.0.0.1\"}]}\n}",
+			want:  "{\n    \"log\": {\n        \"entries\": [\n            {\n                \"serverIPAddress\": \"[REDACTED_IP_ADDRESS]\"\n            }\n        ]\n    }\n}",
+		},
+		{
+			name:  "not har",
+			input: `{"msg":"mail alice@example.com"}`,
+			want:  `{"msg":"mail [REDACTED_EMAIL]"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := processStream(t, Config{Format: "har"}, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("redacted %s\n got %s\nwant %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package logveil
+
+import "regexp"
+
+// healthDetectors recognise the HIPAA identifiers that have a recognisable
+// shape. Record and plan numbers vary by provider, so they are only taken
+// after a label such as "MRN:" and only the "value" group is replaced.
+var healthDetectors = []detector{
+	{name: "medical_record_number", pattern: regexp.MustCompile(`(?i)\b(?:MRN|medical[ _]record(?:[ _](?:number|no\.?))?)["']?\s*[#:=]?\s*["']?(?P<value>[A-Z0-9][A-Z0-9-]{4,19})\b`)},
+	{name: "health_plan_id", pattern: regexp.MustCompile(`(?i)\b(?:member|subscriber|beneficiary|policy|health[ _]plan)[ _]?(?:id|number|no\.?)["']?\s*[#:=]?\s*["']?(?P<value>[A-Z0-9][A-Z0-9-]{5,19})\b`)},
+	{name: "medicare_mbi", pattern: regexp.MustCompile(`\b[1-9][AC-HJKMNP-RT-Y][AC-HJKMNP-RT-Y0-9]\d-?[AC-HJKMNP-RT-Y][AC-HJKMNP-RT-Y0-9]\d-?[AC-HJKMNP-RT-Y]{2}\d{2}\b`)},
+	{name: "npi", pattern: regexp.MustCompile(`(?i)\bNPI["']?\s*[#:=]?\s*["']?(?P<value>\d{10})\b`), validate: validNPI},
+	{name: "dea_number", pattern: regexp.MustCompile(`\b[ABCDEFGHJKLMPRSTUX][A-Z9]\d{7}\b`), validate: validDEA},
+	{name: "diagnosis_code", pattern: regexp.MustCompile(`(?i)\b(?:diagnosis|dx|icd-?10(?:-cm)?)(?:[ _]code)?["']?\s*[#:=]?\s*["']?(?P<value>[A-TV-Z]\d[0-9AB](?:\.[0-9A-Z]{1,4})?)\b`)},
+	{name: "date_of_birth", pattern: regexp.MustCompile(`(?i)\b(?:dob|date[ _]of[ _]birth|birth[ _]?date)["']?\s*[:=]?\s*["']?(?P<value>\d{1,4}[-/.]\d{1,2}[-/.]\d{1,4})\b`)},
+}
+
+// validNPI reports whether a National Provider Identifier passes its Luhn
+// check, which covers the 80840 prefix of the card issuer it is modelled on
+func validNPI(candidate string) bool {
+	return luhnValid([]byte("80840" + candidate))
+}
+
+// validDEA reports whether a DEA registration number's final digit matches
+// its checksum
+func validDEA(candidate string) bool {
+	d := func(i int) int { return int(candidate[i] - '0') }
+	sum := d(2) + d(4) + d(6) + 2*(d(3)+d(5)+d(7))
+	return sum%10 == d(8)
+}
@@ -0,0 +1,213 @@
+package logveil
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// InPlaceOptions controls RedactInPlace
+type InPlaceOptions struct {
+	// BackupSuffix, when set, keeps the original file next to the redacted
+	// one under its name plus this suffix, such as ".bak"
+	BackupSuffix string
+}
+
+// RedactInPlace replaces the file at path with its redacted copy. The copy
+// is written to a temporary file in the same directory, synced, given the
+// original's mode, owner and modification time, and renamed over it, so
+// readers see either the old file or the new one and a failure leaves the
+// original untouched. Compressed files and archives keep their compression
+// whatever CompressOutput says. A file that changes while it is redacted,
+// such as a log still being written, is left alone and reported as an
+// error. Hard links to the original keep pointing at the unredacted data.
+// The audit log records one in-place entry against the final path, with
+// the hashes of the original and of its replacement.
+func (r *Redactor) RedactInPlace(ctx context.Context, path string, opts InPlaceOptions) (*ProcessResult, error) {
+	r, release := r.acquire()
+	defer release()
+	if r.audit == nil {
+		return r.redactInPlace(ctx, path, opts)
+	}
+	start := time.Now()
+	target := path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		target = resolved
+	}
+	// The original is hashed before it is replaced
+	entry := fileAuditEntry(target, target)
+	entry.Operation = "in-place"
+	result, err := r.redactInPlace(ctx, path, opts)
+	entry.OutputSHA256 = ""
+	if err == nil && result.Success {
+		entry.OutputSHA256, _ = fileSHA256(target)
+	}
+	return r.auditResult(ctx, entry, start, result, err)
+}
+
+// redactInPlace is RedactInPlace short of the audit log
+func (r *Redactor) redactInPlace(ctx context.Context, path string, opts InPlaceOptions) (*ProcessResult, error) {
+	if IsRemote(path) {
+		return failedResult(newError(CodeConfig, StageSetup, "in-place redaction needs a local file"))
+	}
+	// Replace the target of a symlink rather than the link
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	}
+	before, err := os.Stat(target)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	}
+	if !before.Mode().IsRegular() {
+		return failedResult(newError(CodeConfig, StageSetup, "%s is not a regular file", path))
+	}
+
+	codec := ""
+	if archiveKind(target) == "" {
+		if codec, err = fileCompression(target); err != nil {
+			return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+		}
+	}
+	// The copy keeps no audit log, RedactInPlace recording the redaction
+	// against the final path rather than the temporary file
+	same := &Redactor{redactorState: r.redactorState}
+	same.compression = codec
+	same.resume = false
+
+	dir, name := filepath.Split(target)
+	tmp, err := os.CreateTemp(dir, "."+name+".logveil-*")
+	if err != nil {
+		return failedResult(newError(CodeIO, StageWrite, "create output: %v", err))
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	replaced := false
+	defer func() {
+		if !replaced {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	result, err := same.ProcessFile(ctx, target, tmpPath)
+	if err != nil || result.Skipped != "" {
+		return result, err
+	}
+
+	fail := func(err *ProcessError) (*ProcessResult, error) {
+		result.Success = false
+		result.addError(err)
+		return result, err
+	}
+	if err := syncFile(tmpPath); err != nil {
+		return fail(newError(CodeIO, StageWrite, "sync output: %v", err))
+	}
+	if err := preserveAttributes(tmpPath, before); err != nil {
+		return fail(newError(CodeIO, StageWrite, "preserve attributes: %v", err))
+	}
+	after, err := os.Stat(target)
+	if err != nil || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		changed := newError(CodeIO, StageRead, "%s changed while it was redacted; left as it was", path)
+		changed.Retryable = true
+		return fail(changed)
+	}
+
+	if opts.BackupSuffix != "" {
+		if err := backupFile(target, target+opts.BackupSuffix); err != nil {
+			return fail(newError(CodeIO, StageWrite, "back up original: %v", err))
+		}
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		return fail(newError(CodeIO, StageWrite, "replace original: %v", err))
+	}
+	replaced = true
+	if err := syncDir(dir); err != nil {
+		return fail(newError(CodeIO, StageWrite, "sync directory: %v", err))
+	}
+	return result, nil
+}
+
+// ProcessInPlace redacts each file in paths in place on a pool of workers
+// goroutines, collecting the results like ProcessBatch
+func (r *Redactor) ProcessInPlace(ctx context.Context, paths []string, workers int, opts InPlaceOptions) *BatchResult {
+	r, release := r.acquire()
+	defer release()
+	jobs := make([]FileJob, len(paths))
+	for i, path := range paths {
+		jobs[i] = FileJob{Input: path, Output: path}
+	}
+	return r.processBatch(ctx, jobs, workers, func(ctx context.Context, job FileJob) FileResult {
+		file := FileResult{FileJob: job}
+		result, err := r.RedactInPlace(ctx, job.Input, opts)
+		file.Result = result
+		if err != nil {
+			file.Error = err.Error()
+			r.quarantineFailed(ctx, &file)
+		}
+		return file
+	})
+}
+
+// preserveAttributes gives the file at path the mode, owner and
+// modification time described by info
+func preserveAttributes(path string, info os.FileInfo) error {
+	if err := os.Chmod(path, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := preserveOwner(path, info); err != nil {
+		return err
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// syncFile flushes the file at path to stable storage
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// backupFile makes backup a copy of path, hard linked when the filesystem
+// allows, replacing any backup already there
+func backupFile(path, backup string) error {
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(path, backup); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return preserveAttributes(backup, info)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package logveil
+
+import "os"
+
+// preserveOwner does nothing where files have no unix owner
+func preserveOwner(path string, info os.FileInfo) error {
+	return nil
+}
+
+// syncDir does nothing where directories cannot be synced
+func syncDir(dir string) error {
+	return nil
+}
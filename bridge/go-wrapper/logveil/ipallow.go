@@ -0,0 +1,79 @@
+package logveil
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// privateNetworks is what the "private" allowlist entry expands to: the
+// RFC 1918 ranges plus loopback, link-local and IPv6 unique local addresses
+var privateNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// parseIPAllowlist parses CIDR ranges, bare addresses and the keyword
+// "private"
+func parseIPAllowlist(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.EqualFold(entry, "private") {
+			for _, network := range privateNetworks {
+				prefixes = append(prefixes, netip.MustParsePrefix(network))
+			}
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP allowlist entry %q: %v", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP allowlist entry %q: %v", entry, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// allowIPs makes every ip_address detector in detectors skip addresses
+// inside prefixes
+func allowIPs(detectors []detector, prefixes []netip.Prefix) {
+	if len(prefixes) == 0 {
+		return
+	}
+	for i, d := range detectors {
+		if d.name != "ip_address" {
+			continue
+		}
+		validate := d.validate
+		detectors[i].validate = func(value string) bool {
+			if validate != nil && !validate(value) {
+				return false
+			}
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return true
+			}
+			addr = addr.Unmap()
+			for _, prefix := range prefixes {
+				if prefix.Contains(addr) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package logveil
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// maxJournalField caps a binary journal field so a corrupt length cannot
+// exhaust memory
+const maxJournalField = 64 * 1024 * 1024
+
+// JournalOptions configures the journal and journal-json formats
+type JournalOptions struct {
+	// Fields are journal fields, such as _CMDLINE, that go through the
+	// engine along with MESSAGE
+	Fields []string
+}
+
+// journalFields returns MESSAGE and the configured fields
+func (opts JournalOptions) journalFields() []string {
+	return append([]string{"MESSAGE"}, opts.Fields...)
+}
+
+// newJournalJSONFormat returns the journal-json format, for `journalctl -o
+// json` output: the json format with the engine limited to MESSAGE and the
+// selected fields. Binary values, which journalctl writes as byte arrays,
+// are left alone.
+func newJournalJSONFormat(opts JournalOptions, jsonOpts JSONOptions, repl replacer) *jsonFormat {
+	f := newJSONFormat(jsonOpts, repl)
+	for _, field := range opts.journalFields() {
+		f.scope = append(f.scope, []string{field})
+	}
+	return f
+}
+
+// journalExportFormat redacts `journalctl -o export` entries. Each record
+// is one entry, its fields one per line as KEY=VALUE or, for binary
+// values, KEY, a newline, a little-endian 64-bit length and the data.
+// MESSAGE and the selected fields are redacted, binary ones with their
+// length rewritten, and every other field is copied as is.
+type journalExportFormat struct {
+	fields map[string]bool
+}
+
+func newJournalExportFormat(opts JournalOptions) journalExportFormat {
+	f := journalExportFormat{fields: make(map[string]bool)}
+	for _, field := range opts.journalFields() {
+		f.fields[field] = true
+	}
+	return f
+}
+
+// records makes every export entry one record rather than one line
+func (journalExportFormat) records() *recordSplit {
+	return &recordSplit{read: readJournalEntry}
+}
+
+// framesBinary marks export entries, whose binary fields hold NULs and
+// control bytes, as binary data rather than text
+func (journalExportFormat) framesBinary() {}
+
+func (f journalExportFormat) redactLine(entry string, redact redactFunc) (string, []Detection, error) {
+	var b strings.Builder
+	var detections []Detection
+	pos := 0
+	for pos < len(entry) {
+		end := strings.IndexByte(entry[pos:], '\n')
+		if end < 0 {
+			end = len(entry)
+		} else {
+			end += pos
+		}
+		line := entry[pos:end]
+
+		if key, value, ok := strings.Cut(line, "="); ok {
+			if f.fields[key] {
+				redacted, found, err := redact(key, value)
+				if err != nil {
+					return "", nil, err
+				}
+				detections = append(detections, found...)
+				// A text field can't hold a newline; one introduced by
+				// redaction is written as a binary field instead
+				if strings.Contains(redacted, "\n") {
+					writeJournalBinary(&b, key, redacted)
+				} else {
+					b.WriteString(key + "=" + redacted)
+					if end < len(entry) {
+						b.WriteByte('\n')
+					}
+				}
+			} else {
+				b.WriteString(entry[pos:min(end+1, len(entry))])
+			}
+			pos = end + 1
+			continue
+		}
+
+		// KEY\n<length><data>\n
+		key := line
+		dataStart := end + 1 + 8
+		if dataStart > len(entry) {
+			return "", nil, newError(CodeParse, StageRead, "journal field %s: truncated length", key)
+		}
+		size := binary.LittleEndian.Uint64([]byte(entry[end+1 : dataStart]))
+		if size > uint64(len(entry)-dataStart) {
+			return "", nil, newError(CodeParse, StageRead, "journal field %s: truncated data", key)
+		}
+		value := entry[dataStart : dataStart+int(size)]
+		next := dataStart + int(size)
+		if next < len(entry) && entry[next] == '\n' {
+			next++
+		}
+
+		if f.fields[key] {
+			redacted, found, err := redact(key, value)
+			if err != nil {
+				return "", nil, err
+			}
+			detections = append(detections, found...)
+			writeJournalBinary(&b, key, redacted)
+		} else {
+			b.WriteString(entry[pos:next])
+		}
+		pos = next
+	}
+	return b.String(), detections, nil
+}
+
+// writeJournalBinary writes key and value as a binary export field,
+// newline included
+func writeJournalBinary(b *strings.Builder, key, value string) {
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	b.WriteString(key + "\n")
+	b.Write(size[:])
+	b.WriteString(value + "\n")
+}
+
+// readJournalEntry reads one export entry, up to and including the blank
+// line after it. It returns io.EOF, possibly with a final entry, once the
+// input is exhausted.
+func readJournalEntry(reader *bufio.Reader) (string, int, error) {
+	var entry strings.Builder
+	lines := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" {
+			return entry.String(), lines, err
+		}
+		entry.WriteString(line)
+		lines++
+		if line == "\n" {
+			return entry.String(), lines, nil
+		}
+
+		body, _ := splitLineEnding(line)
+		if err != nil || strings.Contains(body, "=") {
+			if err != nil {
+				return entry.String(), lines, err
+			}
+			continue
+		}
+
+		// A binary field: a length, the data and a newline follow its name
+		var size [8]byte
+		if _, err := io.ReadFull(reader, size[:]); err != nil {
+			return entry.String(), lines, unexpectedEOF(err)
+		}
+		n := binary.LittleEndian.Uint64(size[:])
+		if n > maxJournalField {
+			return entry.String(), lines, newError(CodeParse, StageRead, "journal field %s: %d bytes exceeds the %d byte limit", body, n, maxJournalField)
+		}
+		data := make([]byte, n+1)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return entry.String(), lines, unexpectedEOF(err)
+		}
+		entry.Write(size[:])
+		entry.Write(data)
+		lines += strings.Count(string(data), "\n")
+	}
+}
+
+// unexpectedEOF reports a clean EOF inside a journal field as truncation
+func unexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return newError(CodeParse, StageRead, "journal entry truncated")
+	}
+	return err
+}
@@ -0,0 +1,405 @@
+package logveil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// JSONOptions configures the json format
+type JSONOptions struct {
+	// Fields are dotted paths such as user.email whose values are replaced
+	// outright. A * segment matches any key, and array elements share the
+	// path of their array.
+	Fields []string
+	// Nested parses string values that hold a serialized JSON document,
+	// as API gateways log request bodies, and redacts the inner document
+	// like the outer one before re-escaping it. Inner values continue the
+	// path of their string, so Fields such as body.user.email reach them.
+	Nested bool
+}
+
+// maxJSONNesting bounds how many documents deep Nested looks
+const maxJSONNesting = 4
+
+// jsonFormat redacts one JSON document per line. Targeted fields are
+// replaced with [REDACTED_<KEY>], like key paths in core/structured.py,
+// and every other string value goes through the engine. Only the
+// redacted values are rewritten, so key order and formatting survive.
+// Lines that are not valid JSON are redacted as plain text.
+type jsonFormat struct {
+	selectors [][]string
+	replacer  replacer
+	// scope, when set, limits the engine to string values at these paths;
+	// documents with no value in scope are redacted in full
+	scope [][]string
+	// nested enables JSONOptions.Nested
+	nested bool
+}
+
+func newJSONFormat(opts JSONOptions, repl replacer) *jsonFormat {
+	f := &jsonFormat{replacer: repl, nested: opts.Nested}
+	for _, field := range opts.Fields {
+		f.selectors = append(f.selectors, strings.Split(field, "."))
+	}
+	return f
+}
+
+// jsonEdit replaces src[start:end] with text
+type jsonEdit struct {
+	start, end int
+	text       string
+}
+
+func (f *jsonFormat) redactLine(line string, redact redactFunc) (string, []Detection, error) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return redact("", line)
+	}
+
+	s := &jsonScanner{src: line, format: f, redact: redact}
+	if err := s.document(); err != nil {
+		if s.redactErr != nil {
+			return "", nil, s.redactErr
+		}
+		return redact("", line)
+	}
+	if f.scope != nil && !s.inScope {
+		full := &jsonFormat{selectors: f.selectors, replacer: f.replacer, nested: f.nested}
+		return full.redactLine(line, redact)
+	}
+	return applyEdits(line, s.edits), s.detections, nil
+}
+
+// newDockerFormat returns the docker format: Docker json-file log entries,
+// {"log":"...","stream":"stdout","time":"..."}, where only the log text goes
+// through the engine and the envelope is kept byte for byte. Targeted
+// fields, such as attrs.user, are still replaced. Other JSON lines are
+// redacted like the json format, and anything else as plain text.
+func newDockerFormat(opts JSONOptions, repl replacer) *jsonFormat {
+	f := newJSONFormat(opts, repl)
+	f.scope = [][]string{{"log"}}
+	return f
+}
+
+// matches reports whether path is targeted by a selector
+func (f *jsonFormat) matches(path []string) bool {
+	return matchPath(f.selectors, path)
+}
+
+// inScope reports whether the engine should see the string value at path
+func (f *jsonFormat) inScope(path []string) bool {
+	return f.scope == nil || matchPath(f.scope, path)
+}
+
+// matchPath reports whether path is matched by one of selectors
+func matchPath(selectors [][]string, path []string) bool {
+	for _, selector := range selectors {
+		if len(selector) != len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range selector {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEdits rewrites src with non-overlapping edits
+func applyEdits(src string, edits []jsonEdit) string {
+	if len(edits) == 0 {
+		return src
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	var b strings.Builder
+	last := 0
+	for _, edit := range edits {
+		b.WriteString(src[last:edit.start])
+		b.WriteString(edit.text)
+		last = edit.end
+	}
+	b.WriteString(src[last:])
+	return b.String()
+}
+
+// isJSONNumber reports whether text is a JSON number literal
+func isJSONNumber(text string) bool {
+	if text == "" || (text[0] != '-' && (text[0] < '0' || text[0] > '9')) {
+		return false
+	}
+	var n json.Number
+	return json.Unmarshal([]byte(text), &n) == nil
+}
+
+// marshalJSONString encodes s without HTML escaping so redacted values
+// stay readable
+func marshalJSONString(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// jsonScanner walks a JSON document recording the span and key path of
+// every value, and collects the edits needed to redact it
+type jsonScanner struct {
+	src    string
+	pos    int
+	format *jsonFormat
+	redact redactFunc
+
+	edits      []jsonEdit
+	detections []Detection
+	redactErr  error
+	// inScope records that a string in the format's scope was seen
+	inScope bool
+	// base is the path of the string holding this document, and depth
+	// how many documents enclose it
+	base  []string
+	depth int
+}
+
+var errJSONSyntax = fmt.Errorf("invalid JSON")
+
+func (s *jsonScanner) document() error {
+	if err := s.value(s.base); err != nil {
+		return err
+	}
+	s.skipSpace()
+	if s.pos != len(s.src) {
+		return errJSONSyntax
+	}
+	return nil
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.src) {
+		switch s.src[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *jsonScanner) value(path []string) error {
+	s.skipSpace()
+	if s.pos >= len(s.src) {
+		return errJSONSyntax
+	}
+
+	start := s.pos
+	edits, detections := len(s.edits), len(s.detections)
+	var text string
+	var err error
+	switch c := s.src[s.pos]; {
+	case c == '{':
+		err = s.object(path)
+	case c == '[':
+		err = s.array(path)
+	case c == '"':
+		if text, err = s.str(); err == nil && !s.format.matches(path) {
+			if !s.format.inScope(path) {
+				return nil
+			}
+			s.inScope = true
+			return s.redactString(start, text, path)
+		}
+	default:
+		err = s.literal()
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(path) > 0 && s.format.matches(path) {
+		// Strings are tokenized by their decoded text so "a\u0040b" and "a@b"
+		// share a pseudonym; other values by their JSON source
+		if s.src[start] != '"' {
+			text = s.src[start:s.pos]
+		}
+		key := path[len(path)-1]
+		replacement := s.format.replacer.replace(key, text)
+		// A number replaced by another number, as with format-preserving
+		// redaction, stays a number
+		if s.src[start] == '"' || !isJSONNumber(text) || !isJSONNumber(replacement) {
+			replacement = marshalJSONString(replacement)
+		}
+		// An object or array is replaced whole, so the edits made inside it
+		// are dropped
+		s.edits, s.detections = s.edits[:edits], s.detections[:detections]
+		s.edits = append(s.edits, jsonEdit{start: start, end: s.pos, text: replacement})
+		s.detections = append(s.detections, Detection{Rule: "json_field"})
+	}
+	return nil
+}
+
+// redactString runs a string value at path through the engine, or when it
+// holds a JSON document and nesting is enabled, redacts that document;
+// the value is edited if anything changed
+func (s *jsonScanner) redactString(start int, text string, path []string) error {
+	if redacted, detections, ok, err := s.redactNested(text, path); ok || err != nil {
+		if err != nil {
+			return err
+		}
+		s.detections = append(s.detections, detections...)
+		if redacted != text {
+			s.edits = append(s.edits, jsonEdit{start: start, end: s.pos, text: marshalJSONString(redacted)})
+		}
+		return nil
+	}
+
+	redacted, detections, err := s.redact(strings.Join(path, "."), text)
+	if err != nil {
+		s.redactErr = err
+		return err
+	}
+	s.detections = append(s.detections, detections...)
+	if redacted != text {
+		s.edits = append(s.edits, jsonEdit{start: start, end: s.pos, text: marshalJSONString(redacted)})
+	}
+	return nil
+}
+
+// redactNested redacts text as a JSON document whose values continue path,
+// reporting false when nesting is off or text is not a document. Outside
+// the format's scope is in scope here: the string holding the document was.
+func (s *jsonScanner) redactNested(text string, path []string) (string, []Detection, bool, error) {
+	trimmed := strings.TrimSpace(text)
+	if !s.format.nested || s.depth >= maxJSONNesting ||
+		(!strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[")) {
+		return "", nil, false, nil
+	}
+	format := *s.format
+	format.scope = nil
+	inner := &jsonScanner{src: text, format: &format, redact: s.redact, base: path, depth: s.depth + 1}
+	if err := inner.document(); err != nil {
+		if inner.redactErr != nil {
+			s.redactErr = inner.redactErr
+			return "", nil, false, inner.redactErr
+		}
+		return "", nil, false, nil
+	}
+	return applyEdits(text, inner.edits), inner.detections, true, nil
+}
+
+func (s *jsonScanner) object(path []string) error {
+	s.pos++ // {
+	s.skipSpace()
+	if s.pos < len(s.src) && s.src[s.pos] == '}' {
+		s.pos++
+		return nil
+	}
+
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.src) || s.src[s.pos] != '"' {
+			return errJSONSyntax
+		}
+		key, err := s.str()
+		if err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		if s.pos >= len(s.src) || s.src[s.pos] != ':' {
+			return errJSONSyntax
+		}
+		s.pos++
+
+		child := append(append([]string(nil), path...), key)
+		if err := s.value(child); err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		if s.pos >= len(s.src) {
+			return errJSONSyntax
+		}
+		switch s.src[s.pos] {
+		case ',':
+			s.pos++
+		case '}':
+			s.pos++
+			return nil
+		default:
+			return errJSONSyntax
+		}
+	}
+}
+
+func (s *jsonScanner) array(path []string) error {
+	s.pos++ // [
+	s.skipSpace()
+	if s.pos < len(s.src) && s.src[s.pos] == ']' {
+		s.pos++
+		return nil
+	}
+
+	for {
+		if err := s.value(path); err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		if s.pos >= len(s.src) {
+			return errJSONSyntax
+		}
+		switch s.src[s.pos] {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return nil
+		default:
+			return errJSONSyntax
+		}
+	}
+}
+
+// str consumes a string token and returns its decoded value
+func (s *jsonScanner) str() (string, error) {
+	start := s.pos
+	s.pos++ // opening quote
+	for s.pos < len(s.src) {
+		switch s.src[s.pos] {
+		case '\\':
+			s.pos += 2
+		case '"':
+			s.pos++
+			var text string
+			if err := json.Unmarshal([]byte(s.src[start:s.pos]), &text); err != nil {
+				return "", errJSONSyntax
+			}
+			return text, nil
+		default:
+			s.pos++
+		}
+	}
+	return "", errJSONSyntax
+}
+
+// literal consumes a number, true, false or null
+func (s *jsonScanner) literal() error {
+	start := s.pos
+	for s.pos < len(s.src) && strings.IndexByte(",}] \t\r\n", s.src[s.pos]) < 0 {
+		s.pos++
+	}
+	token := s.src[start:s.pos]
+	if token == "" || !json.Valid([]byte(token)) {
+		return errJSONSyntax
+	}
+	return nil
+}
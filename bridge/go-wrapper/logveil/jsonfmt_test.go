@@ -0,0 +1,82 @@
+package logveil
+
+import "testing"
+
+func TestJSONFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		line   string
+		want   string
+	}{
+		{
+			name: "strings through the engine",
+			line: `{"msg":"mail alice@example.com","n":1}`,
+			want: `{"msg":"mail [REDACTED_EMAIL]","n":1}`,
+		},
+		{
+			name:   "nested path",
+			fields: []string{"user.name"},
+			line:   `{"user":{"name":"Alice","id":7},"name":"kept"}`,
+			want:   `{"user":{"name":"[REDACTED_NAME]","id":7},"name":"kept"}`,
+		},
+		{
+			name:   "wildcard segment",
+			fields: []string{"*.token"},
+			line:   `{"a":{"token":"x"},"b":{"token":"y"},"token":"z"}`,
+			want:   `{"a":{"token":"[REDACTED_TOKEN]"},"b":{"token":"[REDACTED_TOKEN]"},"token":"z"}`,
+		},
+		{
+			name:   "number and literal values",
+			fields: []string{"id", "ok"},
+			line:   `{"id":12345,"ok":true,"other":3}`,
+			want:   `{"id":"[REDACTED_ID]","ok":"[REDACTED_OK]","other":3}`,
+		},
+		{
+			name:   "object selected whole",
+			fields: []string{"user"},
+			line:   `{"user":{"email":"a@b.com"},"x":1}`,
+			want:   `{"user":"[REDACTED_USER]","x":1}`,
+		},
+		{
+			name:   "array selected whole",
+			fields: []string{"user"},
+			line:   `{"user":["a@b.com"]}`,
+			want:   `{"user":"[REDACTED_USER]"}`,
+		},
+		{
+			name:   "array elements share the array's path",
+			fields: []string{"items.sku"},
+			line:   `{"items":[{"sku":"a"},{"sku":"b"}]}`,
+			want:   `{"items":[{"sku":"[REDACTED_SKU]"},{"sku":"[REDACTED_SKU]"}]}`,
+		},
+		{
+			name: "formatting and key order kept",
+			line: `{ "z" : "10.0.0.1",  "a" : [ "x" ] }`,
+			want: `{ "z" : "[REDACTED_IP_ADDRESS]",  "a" : [ "x" ] }`,
+		},
+		{
+			name: "escapes decoded before redaction",
+			line: `{"msg":"alice\u0040example.com"}`,
+			want: `{"msg":"[REDACTED_EMAIL]"}`,
+		},
+		{
+			name: "invalid JSON redacted as text",
+			line: `{"msg": alice@example.com`,
+			want: `{"msg": [REDACTED_EMAIL]`,
+		},
+		{
+			name: "not JSON",
+			line: `plain alice@example.com`,
+			want: `plain [REDACTED_EMAIL]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := redactLine(t, Config{Format: "json", JSON: JSONOptions{Fields: tt.fields}}, tt.line)
+			if got != tt.want {
+				t.Errorf("redacted %s\n got %s\nwant %s", tt.line, got, tt.want)
+			}
+		})
+	}
+}
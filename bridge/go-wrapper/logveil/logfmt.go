@@ -0,0 +1,209 @@
+package logveil
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// LogfmtOptions configures the logfmt format
+type LogfmtOptions struct {
+	// Keys are keys whose values are replaced outright, matched without
+	// regard to case. A * matches any run of characters, so *token covers
+	// token and auth_token.
+	Keys []string
+}
+
+// logfmtFormat redacts key=value lines. Targeted keys have their values
+// replaced with [REDACTED_<KEY>]; every other value goes through the
+// engine together with its key, so detectors that look for password= and
+// the like still fire. Keys, spacing and quoting are kept, values are
+// quoted when their replacement needs it, and lines without any key=value
+// pair are redacted as plain text.
+type logfmtFormat struct {
+	keys     keyPatterns
+	replacer replacer
+}
+
+func newLogfmtFormat(opts LogfmtOptions, repl replacer) *logfmtFormat {
+	return &logfmtFormat{keys: newKeyPatterns(opts.Keys), replacer: repl}
+}
+
+// logfmtPair is one key=value (or bare key) token of a line
+type logfmtPair struct {
+	key string
+	// value is the decoded value; start and end span its source text,
+	// quotes included, and are zero for a bare key
+	value      string
+	start, end int
+	quoted     bool
+	bare       bool
+}
+
+func (f *logfmtFormat) redactLine(line string, redact redactFunc) (string, []Detection, error) {
+	pairs, ok := parseLogfmt(line)
+	if !ok {
+		return redact("", line)
+	}
+
+	var edits []jsonEdit
+	var detections []Detection
+	for i := 0; i < len(pairs); i++ {
+		p := pairs[i]
+		if p.bare {
+			// A run of bare words is free text, redacted as one span
+			end := p.end
+			for i+1 < len(pairs) && pairs[i+1].bare {
+				i++
+				end = pairs[i].end
+			}
+			text := line[p.start:end]
+			redacted, found, err := redact("", text)
+			if err != nil {
+				return "", nil, err
+			}
+			detections = append(detections, found...)
+			if redacted != text {
+				edits = append(edits, jsonEdit{start: p.start, end: end, text: redacted})
+			}
+			continue
+		}
+
+		if f.keys.matches(p.key) {
+			edits = append(edits, jsonEdit{start: p.start, end: p.end, text: logfmtValue(f.replacer.replace(p.key, p.value), p.quoted)})
+			detections = append(detections, Detection{Rule: "logfmt_field"})
+			continue
+		}
+
+		value, found, err := redactKeyedValue(p.key, p.value, redact)
+		if err != nil {
+			return "", nil, err
+		}
+		detections = append(detections, found...)
+		if value != p.value {
+			edits = append(edits, jsonEdit{start: p.start, end: p.end, text: logfmtValue(value, p.quoted)})
+		}
+	}
+	return applyEdits(line, edits), detections, nil
+}
+
+// redactKeyedValue redacts value as key=value so key-anchored detectors
+// see the key. When a match swallowed the key, the value is
+// redacted alone, or if that finds nothing, replaced by whatever the match
+// became, so password=hunter2 turns into password=[REDACTED_PASSWORD].
+func redactKeyedValue(key, value string, redact redactFunc) (string, []Detection, error) {
+	prefix := key + "="
+	redacted, detections, err := redact(key, prefix+value)
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.HasPrefix(redacted, prefix) {
+		return redacted[len(prefix):], detections, nil
+	}
+	alone, found, err := redact(key, value)
+	if err != nil || alone != value {
+		return alone, found, err
+	}
+	return redacted, detections, nil
+}
+
+// keyPatterns matches keys without regard to case against patterns in
+// which * stands for any run of characters
+type keyPatterns []string
+
+func newKeyPatterns(patterns []string) keyPatterns {
+	k := make(keyPatterns, 0, len(patterns))
+	for _, pattern := range patterns {
+		k = append(k, strings.ToLower(pattern))
+	}
+	return k
+}
+
+// matches reports whether key is targeted
+func (k keyPatterns) matches(key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range k {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// logfmtValue encodes value, quoting it when it was quoted before or
+// would not otherwise read back as one value
+func logfmtValue(value string, quoted bool) string {
+	if quoted || value == "" || strings.ContainsAny(value, " =\"\\") || strings.IndexFunc(value, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// parseLogfmt splits line into pairs. ok is false when the line is not
+// logfmt: it has no key=value pair or a quote is unbalanced.
+func parseLogfmt(line string) ([]logfmtPair, bool) {
+	var pairs []logfmtPair
+	hasValue := false
+	pos := 0
+	for {
+		for pos < len(line) && (line[pos] == ' ' || line[pos] == '\t') {
+			pos++
+		}
+		if pos >= len(line) {
+			break
+		}
+
+		keyStart := pos
+		for pos < len(line) && line[pos] != ' ' && line[pos] != '\t' && line[pos] != '=' && line[pos] != '"' {
+			pos++
+		}
+		key := line[keyStart:pos]
+		if key == "" {
+			return nil, false
+		}
+		if pos >= len(line) || line[pos] != '=' {
+			if pos < len(line) && line[pos] == '"' {
+				return nil, false
+			}
+			pairs = append(pairs, logfmtPair{key: key, start: keyStart, end: pos, bare: true})
+			continue
+		}
+		pos++ // =
+
+		p := logfmtPair{key: key, start: pos}
+		if pos < len(line) && line[pos] == '"' {
+			end := quotedEnd(line, pos)
+			if end < 0 {
+				return nil, false
+			}
+			value, err := strconv.Unquote(line[pos:end])
+			if err != nil {
+				return nil, false
+			}
+			p.value, p.quoted, pos = value, true, end
+		} else {
+			for pos < len(line) && line[pos] != ' ' && line[pos] != '\t' {
+				pos++
+			}
+			p.value = line[p.start:pos]
+		}
+		p.end = pos
+		pairs = append(pairs, p)
+		hasValue = true
+	}
+	return pairs, hasValue
+}
+
+// quotedEnd returns the offset just past the quoted string starting at
+// start, or -1 when it is unterminated
+func quotedEnd(line string, start int) int {
+	for i := start + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,63 @@
+package logveil
+
+import "testing"
+
+func TestLogfmtFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		line string
+		want string
+	}{
+		{
+			name: "values through the engine",
+			line: `level=info msg="mail alice@example.com" ip=10.0.0.1`,
+			want: `level=info msg="mail [REDACTED_EMAIL]" ip=[REDACTED_IP_ADDRESS]`,
+		},
+		{
+			name: "targeted key",
+			keys: []string{"user"},
+			line: `user=alice n=1`,
+			want: `user=[REDACTED_USER] n=1`,
+		},
+		{
+			name: "wildcard and case",
+			keys: []string{"*token"},
+			line: `Auth_Token=abc token="x y" tokens=3`,
+			want: `Auth_Token=[REDACTED_AUTH_TOKEN] token="[REDACTED_TOKEN]" tokens=3`,
+		},
+		{
+			name: "key-anchored detector",
+			line: `password=hunter2 ok=1`,
+			want: `password=[REDACTED_PASSWORD] ok=1`,
+		},
+		{
+			name: "spacing and bare words kept",
+			line: "at=start  \tlogin from 10.0.0.2 done",
+			want: "at=start  \tlogin from [REDACTED_IP_ADDRESS] done",
+		},
+		{
+			name: "escaped quote",
+			line: `msg="say \"hi\" to alice@example.com"`,
+			want: `msg="say \"hi\" to [REDACTED_EMAIL]"`,
+		},
+		{
+			name: "unbalanced quote is text",
+			line: `msg="mail alice@example.com`,
+			want: `msg="mail [REDACTED_EMAIL]`,
+		},
+		{
+			name: "no pairs is text",
+			line: `plain 10.0.0.1`,
+			want: `plain [REDACTED_IP_ADDRESS]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Format: "logfmt", Logfmt: LogfmtOptions{Keys: tt.keys}}
+			if got, _ := redactLine(t, cfg, tt.line); got != tt.want {
+				t.Errorf("redacted %s\n got %s\nwant %s", tt.line, got, tt.want)
+			}
+		})
+	}
+}
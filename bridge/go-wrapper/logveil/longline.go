@@ -0,0 +1,114 @@
+package logveil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Long line policies for LineOptions.Policy
+const (
+	// LongLineSplit redacts a long line in pieces of at most MaxBytes and
+	// writes them back as one line. Pieces are cut after a space or
+	// punctuation where possible, but a value straddling a cut can be
+	// missed.
+	LongLineSplit = "split"
+	// LongLineTruncate redacts the first MaxBytes of a long line and
+	// replaces the rest with TruncatedLineMarker
+	LongLineTruncate = "truncate"
+)
+
+// TruncatedLineMarker ends a line cut short under LongLineTruncate
+const TruncatedLineMarker = "[LOGVEIL: rest of line dropped]"
+
+// cutWindow is how far back from MaxBytes a piece may end to cut at a
+// separator rather than inside a value
+const cutWindow = 256
+
+// LineOptions bounds how much of one line is held and redacted at once.
+// Lines of any length are read whole when MaxBytes is zero.
+type LineOptions struct {
+	// MaxBytes is the longest line, without its ending, redacted in one go
+	MaxBytes int
+	// Policy is LongLineSplit (the default) or LongLineTruncate
+	Policy string
+}
+
+// validate checks opts
+func (opts LineOptions) validate() error {
+	if opts.MaxBytes < 0 {
+		return fmt.Errorf("max line size must not be negative: %d", opts.MaxBytes)
+	}
+	switch opts.Policy {
+	case "", LongLineSplit, LongLineTruncate:
+		return nil
+	}
+	return fmt.Errorf("unknown long line policy %q (expected split or truncate)", opts.Policy)
+}
+
+// readLine returns the next line with its ending and 1 line, or for a line
+// longer than maxLine, its next piece without an ending and 0 lines; the
+// last piece of a long line is returned as a line of its own
+func (rr *recordReader) readLine() (string, int, error) {
+	if rr.maxLine <= 0 {
+		line, err := rr.reader.ReadString('\n')
+		if line == "" {
+			return "", 0, err
+		}
+		return line, 1, err
+	}
+
+	line, err := rr.pending, rr.pendingErr
+	rr.pending, rr.pendingErr = nil, nil
+	for err == nil && bytes.IndexByte(line, '\n') < 0 && len(line) <= rr.maxLine {
+		var chunk []byte
+		chunk, err = rr.reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			err = nil
+		}
+	}
+
+	end := bytes.IndexByte(line, '\n') + 1
+	if end == 0 {
+		end = len(line)
+	}
+	if body, _ := splitLineEnding(string(line[:end])); len(body) <= rr.maxLine {
+		if end < len(line) {
+			rr.pending, rr.pendingErr = line[end:], err
+			err = nil
+		}
+		if end == 0 {
+			return "", 0, err
+		}
+		return string(line[:end]), 1, err
+	}
+
+	cut := cutPoint(line, rr.maxLine)
+	rr.pending, rr.pendingErr = line[cut:], err
+	return string(line[:cut]), 0, nil
+}
+
+// cutPoint returns where to end a piece of at most max bytes taken from the
+// start of line: after the last separator near max, or else at a character
+// boundary
+func cutPoint(line []byte, max int) int {
+	cut := max
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	from := cut - cutWindow
+	if from < 0 {
+		from = 0
+	}
+	if i := bytes.LastIndexAny(line[from:cut], " \t,;&|"); i >= 0 {
+		return from + i + 1
+	}
+	if cut == 0 {
+		// A single character longer than max
+		_, size := utf8.DecodeRune(line)
+		return size
+	}
+	return cut
+}
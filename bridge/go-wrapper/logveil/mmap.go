@@ -0,0 +1,67 @@
+package logveil
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// mappedInput is a local file mapped into memory and read in place, which
+// spares the copies and buffers of reading it
+type mappedInput struct {
+	*bytes.Reader
+	data []byte
+}
+
+func (m *mappedInput) Close() error {
+	return unmapFile(m.data)
+}
+
+// mappedDecompressor decompresses a mappedInput, unmapping it on Close
+type mappedDecompressor struct {
+	io.ReadCloser
+	mapped *mappedInput
+}
+
+func (m *mappedDecompressor) Close() error {
+	err := m.ReadCloser.Close()
+	if unmapErr := m.mapped.Close(); err == nil {
+		err = unmapErr
+	}
+	return err
+}
+
+// openMapped opens path like openInput but maps a regular file into memory
+// rather than reading it. Pipes, devices, empty files, file systems that
+// cannot be mapped and platforms without mmap are read like openInput
+// reads them. A mapped file must not shrink while it is read, as under
+// copytruncate log rotation: touching the pages cut off crashes the
+// process.
+func openMapped(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := mapFile(file)
+	if err != nil || data == nil {
+		reader, err := decompress(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &fileReader{ReadCloser: reader, file: file}, nil
+	}
+	// The mapping outlives the descriptor
+	file.Close()
+
+	mapped := &mappedInput{Reader: bytes.NewReader(data), data: data}
+	if sniffCompression(data) == "" {
+		return mapped, nil
+	}
+	reader, err := decompress(mapped)
+	if err != nil {
+		mapped.Close()
+		return nil, err
+	}
+	return &mappedDecompressor{ReadCloser: reader, mapped: mapped}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package logveil
+
+import "os"
+
+// mapFile maps nothing where mmap is unavailable, so files are read instead
+func mapFile(f *os.File) ([]byte, error) {
+	return nil, nil
+}
+
+// unmapFile does nothing where mmap is unavailable
+func unmapFile(data []byte) error {
+	return nil
+}
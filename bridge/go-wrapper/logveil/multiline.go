@@ -0,0 +1,170 @@
+package logveil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// DefaultMultilineMaxLines caps a multi-line record so a start pattern that
+// stops matching cannot buffer a whole file
+const DefaultMultilineMaxLines = 1000
+
+// MultilineOptions groups consecutive lines into records, such as a log
+// line followed by its stack trace, so detectors see the whole record
+type MultilineOptions struct {
+	// Start is an RE2 pattern matching the first line of each record; every
+	// line that doesn't match continues the record before it. Empty treats
+	// each line as a record.
+	Start string
+	// MaxLines ends a record after this many lines; DefaultMultilineMaxLines
+	// when zero
+	MaxLines int
+}
+
+// recordSplit is a compiled MultilineOptions, or the framing of a format
+// whose records span lines
+type recordSplit struct {
+	start    *regexp.Regexp
+	maxLines int
+	// read, when set, reads a record in a format's own framing instead
+	read func(*bufio.Reader) (string, int, error)
+}
+
+// recordFormat is implemented by formats whose records always span lines
+type recordFormat interface {
+	records() *recordSplit
+}
+
+func newRecordSplit(opts MultilineOptions) (*recordSplit, error) {
+	if opts.Start == "" {
+		return nil, nil
+	}
+	start, err := regexp.Compile(opts.Start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multi-line start pattern: %v", err)
+	}
+	if opts.MaxLines < 0 {
+		return nil, fmt.Errorf("multi-line max lines must not be negative")
+	}
+	if opts.MaxLines == 0 {
+		opts.MaxLines = DefaultMultilineMaxLines
+	}
+	return &recordSplit{start: start, maxLines: opts.MaxLines}, nil
+}
+
+// recordReader reads records from a stream: single lines, or under a
+// recordSplit, a start line and the continuation lines after it. A record
+// is only complete once the next one starts, so in follow mode records are
+// written a record late. Under LineOptions, lines longer than the limit
+// come in pieces, each a record of 0 lines apart from the last, and never
+// join a multi-line record.
+type recordReader struct {
+	reader *bufio.Reader
+	split  *recordSplit
+	// held is the first line of the next record, already read, and
+	// heldErr the error that came with it; heldPiece marks it as the
+	// start of a long line
+	held      string
+	heldErr   error
+	heldPiece bool
+	// maxLine is the LineOptions.MaxBytes in force and truncate its policy
+	maxLine  int
+	truncate bool
+	// pending is the rest of a long line already read past a cut, and
+	// pendingErr the error that came with it
+	pending    []byte
+	pendingErr error
+	// partial is set while the pieces of a long line are being returned
+	partial bool
+}
+
+// newRecordReader reads r using the record split and line limit engine is
+// configured with, if any
+func newRecordReader(r io.Reader, engine Engine) *recordReader {
+	rr := &recordReader{reader: bufio.NewReader(r)}
+	if f, ok := engine.(*formatEngine); ok {
+		rr.split = f.records
+		rr.maxLine = f.lines.MaxBytes
+		rr.truncate = f.lines.Policy == LongLineTruncate
+	}
+	return rr
+}
+
+// Buffered returns the number of bytes read ahead and not yet returned
+func (rr *recordReader) Buffered() int {
+	return rr.reader.Buffered() + len(rr.held) + len(rr.pending)
+}
+
+// read returns the next record, line endings included, and the number of
+// lines in it. Like bufio.Reader.ReadString it returns io.EOF, possibly
+// with a final record, once the input is exhausted.
+func (rr *recordReader) read() (string, int, error) {
+	if rr.split != nil && rr.split.read != nil {
+		return rr.split.read(rr.reader)
+	}
+	if rr.split == nil || rr.partial {
+		line, lines, err := rr.readLine()
+		rr.partial = line != "" && lines == 0
+		return line, lines, err
+	}
+
+	var record strings.Builder
+	lines := 0
+	if rr.held != "" {
+		held, err := rr.held, rr.heldErr
+		rr.held, rr.heldErr = "", nil
+		if rr.heldPiece {
+			rr.heldPiece, rr.partial = false, true
+			return held, 0, nil
+		}
+		record.WriteString(held)
+		lines++
+		if err != nil {
+			return held, lines, err
+		}
+	}
+	for {
+		line, n, err := rr.readLine()
+		if line != "" && n == 0 {
+			if lines > 0 {
+				rr.held, rr.heldPiece = line, true
+				return record.String(), lines, nil
+			}
+			rr.partial = true
+			return line, 0, nil
+		}
+		if line != "" {
+			body, _ := splitLineEnding(line)
+			if lines > 0 && (lines >= rr.split.maxLines || rr.split.start.MatchString(body)) {
+				rr.held, rr.heldErr = line, err
+				return record.String(), lines, nil
+			}
+			record.WriteString(line)
+			lines++
+		}
+		if err != nil {
+			return record.String(), lines, err
+		}
+	}
+}
+
+// position converts a 1-based rune column within record to a line offset
+// from the record's first line and a 1-based column within that line
+func position(record string, col int) (int, int) {
+	line, lineStart := 0, 1
+	n := 1
+	for _, r := range record {
+		if n == col {
+			break
+		}
+		n++
+		if r == '\n' {
+			line++
+			lineStart = n
+		}
+	}
+	return line, col - lineStart + 1
+}
@@ -0,0 +1,524 @@
+package logveil
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// detector is a named pattern for one class of sensitive data. When the
+// pattern has a group named "value", only that group is replaced and the
+// rest of the match, typically a key such as "AccountKey=", is kept.
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	// template replaces each whole match using regexp expansion syntax;
+	// empty means the value is passed to the engine's replacer
+	template string
+	// severity overrides the built-in rating of name when set
+	severity Severity
+	// replacer overrides the engine's replacer for this detector
+	replacer replacer
+	// validate, when set, rejects matches whose replaced text fails it
+	validate func(string) bool
+	// plugin, when set, finds the matches instead of pattern
+	plugin *wasmDetector
+	// when, when set, decides which matches are redacted
+	when *condition
+	// policy, when set, decides whether and how matches are redacted
+	policy *redactionPolicy
+}
+
+// lineEdit records one replacement: line[start:end] became size bytes
+type lineEdit struct {
+	start, end, size int
+}
+
+// detectorMatch is one match of a detector that passed validation:
+// line[start:end] is the text it replaces
+type detectorMatch struct {
+	start, end int
+	// submatch holds the pattern's submatch indexes, for templates
+	submatch []int
+	// replacer, when set, is the replacement a policy chose
+	replacer replacer
+}
+
+// find returns the matches of d in line, narrowed to the "value" group
+// unless d has a template, skipping those validate or the condition on
+// scope, the record line belongs to, rejects
+func (d detector) find(line string, scope *matchScope) []detectorMatch {
+	if d.plugin != nil {
+		return d.findPlugin(line, scope)
+	}
+	indexes := d.pattern.FindAllStringSubmatchIndex(line, -1)
+	if indexes == nil {
+		return nil
+	}
+	valueGroup := d.pattern.SubexpIndex("value")
+
+	decide := d.decider(scope)
+	matches := make([]detectorMatch, 0, len(indexes))
+	for _, m := range indexes {
+		start, end := m[0], m[1]
+		if d.template == "" && valueGroup > 0 && m[2*valueGroup] >= 0 {
+			start, end = m[2*valueGroup], m[2*valueGroup+1]
+		}
+		if d.validate != nil && !d.validate(line[start:end]) {
+			continue
+		}
+		if d.when != nil && !d.when.allows(d.name, line, line[start:end], scope) {
+			continue
+		}
+		decision := decide()
+		if !decision.redact {
+			continue
+		}
+		matches = append(matches, detectorMatch{start: start, end: end, submatch: m, replacer: decision.replacer})
+	}
+	return matches
+}
+
+// findPlugin returns the matches of d's plugin in line. A plugin that
+// fails fails closed, with the whole line as its match.
+func (d detector) findPlugin(line string, scope *matchScope) []detectorMatch {
+	spans, err := d.plugin.spans(line)
+	if err != nil {
+		spans = [][2]int{{0, len(line)}}
+	}
+	decide := d.decider(scope)
+	matches := make([]detectorMatch, 0, len(spans))
+	for _, s := range spans {
+		if d.validate != nil && !d.validate(line[s[0]:s[1]]) {
+			continue
+		}
+		if d.when != nil && !d.when.allows(d.name, line, line[s[0]:s[1]], scope) {
+			continue
+		}
+		decision := decide()
+		if !decision.redact {
+			continue
+		}
+		matches = append(matches, detectorMatch{start: s[0], end: s[1], replacer: decision.replacer})
+	}
+	return matches
+}
+
+// decider returns the policy decision for d's matches in scope, asking
+// the policy on the first call only, since every match shares its input
+func (d detector) decider(scope *matchScope) func() policyDecision {
+	decided := d.policy == nil
+	decision := policyDecision{redact: true}
+	return func() policyDecision {
+		if !decided {
+			decision, decided = d.policy.decide(d, scope), true
+		}
+		return decision
+	}
+}
+
+// replacement returns the text that replaces m, a match of d in line
+func (d detector) replacement(line string, m detectorMatch, repl replacer) string {
+	if m.replacer != nil {
+		return m.replacer.replace(d.name, line[m.start:m.end])
+	}
+	if d.template != "" {
+		return string(d.pattern.ExpandString(nil, d.template, line, m.submatch))
+	}
+	if d.replacer != nil {
+		repl = d.replacer
+	}
+	return repl.replace(d.name, line[m.start:m.end])
+}
+
+// redact replaces every match of d in line and reports each replacement
+func (d detector) redact(line string, repl replacer, scope *matchScope) (string, []lineEdit) {
+	matches := d.find(line, scope)
+	if matches == nil {
+		return line, nil
+	}
+
+	var out []byte
+	edits := make([]lineEdit, 0, len(matches))
+	last := 0
+	for _, m := range matches {
+		out = append(out, line[last:m.start]...)
+		replacement := d.replacement(line, m, repl)
+		out = append(out, replacement...)
+		edits = append(edits, lineEdit{start: m.start, end: m.end, size: len(replacement)})
+		last = m.end
+	}
+	out = append(out, line[last:]...)
+	return string(out), edits
+}
+
+// defaultDetectors mirrors PatternRegistry.DEFAULT_PATTERNS in
+// core/redactor.py, in the same order. The Python RedactionEngine also runs
+// its entropy detection by default, replacing high-entropy tokens with
+// [REDACTED_SECRET]; the native engine does so only when the high_entropy
+// rule is selected, with its own thresholds, so the engines' output differs
+// on such tokens.
+var defaultDetectors = []detector{
+	{name: "ip_address", pattern: regexp.MustCompile(`(?i)\b(?:[0-9]{1,3}\.){3}[0-9]{1,3}\b`)},
+	{name: "email", pattern: regexp.MustCompile(`(?i)\b[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}\b`)},
+	{name: "uuid", pattern: regexp.MustCompile(`(?i)\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[1-5][0-9a-fA-F]{3}-[89abAB][0-9a-fA-F]{3}-[0-9a-fA-F]{12}\b`)},
+	{name: "sha256", pattern: regexp.MustCompile(`(?i)\b[a-fA-F0-9]{64}\b`)},
+	{name: "sha1", pattern: regexp.MustCompile(`(?i)\b[a-fA-F0-9]{40}\b`)},
+	{name: "md5", pattern: regexp.MustCompile(`(?i)\b[a-fA-F0-9]{32}\b`)},
+	{name: "jwt", pattern: regexp.MustCompile(`(?i)\beyJ[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]+\b`)},
+	{name: "aws_access_key", pattern: regexp.MustCompile(`(?i)\bAKIA[0-9A-Z]{16}\b`)},
+	{name: "aws_secret_key", pattern: regexp.MustCompile(`(?i)\b[0-9a-zA-Z/+]{40}\b`)},
+	{name: "credit_card", pattern: regexp.MustCompile(`(?i)\b(?:4[0-9]{12}(?:[0-9]{3})?|5[1-5][0-9]{14}|3[47][0-9]{13}|3[0-9]{13}|6(?:011|5[0-9]{2})[0-9]{12})\b`)},
+	{name: "ssn", pattern: regexp.MustCompile(`(?i)\b\d{3}-\d{2}-\d{4}\b`)},
+	{name: "phone", pattern: regexp.MustCompile(`(?i)\b\+?1?[-.\s]?\(?[0-9]{3}\)?[-.\s]?[0-9]{3}[-.\s]?[0-9]{4}\b`)},
+	{name: "api_key", pattern: regexp.MustCompile(`(?i)\b[a-zA-Z0-9]{32,}\b`)},
+	{name: "bearer_token", pattern: regexp.MustCompile(`(?i)\bBearer\s+[a-zA-Z0-9_-]+\b`)},
+	{name: "password", pattern: regexp.MustCompile(`(?i)(password|passwd|pwd)[\s=:]+[^\s]+`)},
+	{name: "private_key", pattern: regexp.MustCompile(`(?i)-----BEGIN\s+(?:RSA\s+)?PRIVATE\s+KEY-----`)},
+}
+
+// NativeEngine redacts in-process with Go regexes, no Python runtime required
+type NativeEngine struct {
+	detectors []detector
+	replacer  replacer
+	// payloads enables redactPayloads
+	payloads bool
+	// overlap is the policy for text several detectors match
+	overlap string
+	// placeholders, when set, numbers the values redacted by replacer
+	placeholders *placeholderTemplate
+	// shiftDays moves every date by that many days when not zero
+	shiftDays int
+	// prefilter, when set, skips detectors whose literals a line lacks
+	prefilter *prefilter
+	// plugins back the detectors loaded from WebAssembly modules
+	plugins []*wasmDetector
+	// scoped is set when any detector has a condition or the engine a
+	// policy, which need to know where a line comes from
+	scoped bool
+}
+
+// NativeOptions configures the native engine
+type NativeOptions struct {
+	// Rules selects built-in detectors or rule packs such as
+	// "cloud-secrets" by name; the default pack when empty
+	Rules []string
+	// CustomRules run after the built-ins. A custom rule sharing a built-in's
+	// name replaces it in place, or removes it when disabled.
+	CustomRules []Rule
+	// Tokenizer, when set, replaces matches with stable pseudonyms instead
+	// of placeholders. Rules with an explicit Replacement or Strategy keep
+	// it.
+	Tokenizer *Tokenizer
+	// PreserveFormat replaces matches with substitutes of the same length
+	// and character classes instead of placeholders
+	PreserveFormat bool
+	// FakeData replaces matches with plausible fake values seeded from
+	// FakeSeed; FakeSeed also seeds rules using the fake strategy
+	FakeData bool
+	FakeSeed string
+	// PlaceholderTemplate, when set, is a text/template rendering each
+	// placeholder from PlaceholderData, such as "[{{.Rule}}_{{.Index}}]"
+	// for [EMAIL_1], [EMAIL_2] and so on within each document
+	PlaceholderTemplate string
+	// Entropy tunes the high_entropy detector when it is selected
+	Entropy EntropyOptions
+	// IPAllowlist holds CIDR ranges and addresses the ip_address detector
+	// leaves visible; "private" stands for the RFC 1918, loopback and
+	// link-local ranges
+	IPAllowlist []string
+	// Allow exempts known-benign values from every detector, or from
+	// those an entry names
+	Allow []AllowEntry
+	// DecodePayloads also decodes base64 and percent-encoded substrings
+	// and redacts each one whose decoded text a detector matches
+	DecodePayloads bool
+	// DateShift moves every date in the output by one random number of
+	// days per engine
+	DateShift DateShiftOptions
+	// Overlap decides which rule redacts text several rules match:
+	// OverlapPriority (the default), OverlapLongest or OverlapMerge
+	Overlap string
+	// Plugins are WebAssembly detectors run after every rule
+	Plugins []Plugin
+	// Policy, when it has paths, decides whether and how each match is
+	// redacted
+	Policy PolicyOptions
+}
+
+// NewNativeEngine returns a NativeEngine configured by opts
+func NewNativeEngine(opts NativeOptions) (*NativeEngine, error) {
+	if err := validateOverlap(opts.Overlap); err != nil {
+		return nil, err
+	}
+	detectors := defaultDetectors
+	if len(opts.Rules) > 0 {
+		selected, err := selectDetectors(opts.Rules)
+		if err != nil {
+			return nil, err
+		}
+		if err := configureEntropy(selected, opts.Entropy); err != nil {
+			return nil, err
+		}
+		detectors = selected
+	}
+
+	detectors, err := applyCustomRules(detectors, opts.CustomRules)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := parseIPAllowlist(opts.IPAllowlist)
+	if err != nil {
+		return nil, err
+	}
+	allowIPs(detectors, allowed)
+	exempt, err := compileAllowlist(opts.Allow)
+	if err != nil {
+		return nil, err
+	}
+	var shiftDays int
+	if opts.DateShift.Enabled {
+		if shiftDays, err = dateShiftDays(opts.DateShift); err != nil {
+			return nil, err
+		}
+	}
+	var placeholders *placeholderTemplate
+	if opts.PlaceholderTemplate != "" {
+		if placeholders, err = parsePlaceholderTemplate(opts.PlaceholderTemplate); err != nil {
+			return nil, err
+		}
+	}
+	policy, err := loadPolicy(opts.Policy, opts.FakeSeed)
+	if err != nil {
+		return nil, err
+	}
+	// Loaded last, so that nothing after can fail and leave them open
+	detectors, plugins, err := pluginDetectors(detectors, opts.Plugins)
+	if err != nil {
+		return nil, err
+	}
+	allowValues(detectors, exempt)
+	e := &NativeEngine{detectors: detectors, replacer: placeholderReplacer{}, payloads: opts.DecodePayloads, overlap: opts.Overlap, shiftDays: shiftDays, prefilter: newPrefilter(detectors), plugins: plugins}
+	switch {
+	case opts.Tokenizer != nil:
+		e.replacer = opts.Tokenizer
+	case opts.PreserveFormat:
+		e.replacer = shapeReplacer{}
+	case opts.FakeData:
+		e.replacer = newFakeReplacer(opts.FakeSeed)
+	case placeholders != nil:
+		e.placeholders = placeholders
+		e.replacer = indexedReplacer{}
+	}
+	for i, d := range e.detectors {
+		if _, ok := d.replacer.(fakeReplacer); ok {
+			e.detectors[i].replacer = newFakeReplacer(opts.FakeSeed)
+		}
+		e.detectors[i].policy = policy
+		if d.when != nil || policy != nil {
+			e.scoped = true
+		}
+	}
+	return e, nil
+}
+
+// Close releases the engine's plugins
+func (e *NativeEngine) Close() error {
+	var first error
+	for _, w := range e.plugins {
+		if err := w.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// applyCustomRules merges rules into detectors without modifying it
+func applyCustomRules(detectors []detector, rules []Rule) ([]detector, error) {
+	merged := append([]detector(nil), detectors...)
+
+	for _, rule := range rules {
+		index := -1
+		for i, d := range merged {
+			if d.name == rule.Name {
+				index = i
+				break
+			}
+		}
+
+		if !rule.IsEnabled() {
+			if index >= 0 {
+				merged = append(merged[:index], merged[index+1:]...)
+			}
+			continue
+		}
+
+		if rule.Pattern == "" {
+			// Adjusts a built-in, which may not be selected
+			if !isBuiltinDetector(rule.Name) {
+				return nil, fmt.Errorf("rule %q has no pattern", rule.Name)
+			}
+			if index >= 0 {
+				d, err := rule.apply(merged[index])
+				if err != nil {
+					return nil, err
+				}
+				merged[index] = d
+			}
+			continue
+		}
+
+		d, err := compileRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		if index >= 0 {
+			merged[index] = d
+		} else {
+			merged = append(merged, d)
+		}
+	}
+	return merged, nil
+}
+
+func (e *NativeEngine) Name() string {
+	return "native"
+}
+
+// redactLine applies every detector to line, in order or with overlaps
+// resolved by the engine's policy, and then shifts the dates left in it.
+// scope is the record line belongs to, for rule conditions.
+func (e *NativeEngine) redactLine(line string, scope *matchScope) (string, []Detection) {
+	var detections []Detection
+	if e.resolvesOverlaps() {
+		var rules []string
+		line, _, rules = e.redactResolved(line, e.replacer, scope)
+		for _, rule := range rules {
+			detections = append(detections, Detection{Rule: rule})
+		}
+	} else {
+		run := e.candidates(line)
+		for i, d := range e.detectors {
+			if run != nil && !run[i] {
+				continue
+			}
+			var edits []lineEdit
+			line, edits = d.redact(line, e.replacer, scope)
+			for range edits {
+				detections = append(detections, Detection{Rule: d.name})
+			}
+			if len(edits) > 0 {
+				// Replacements may hold literals later detectors need
+				run = e.candidates(line)
+			}
+		}
+	}
+	if e.payloads {
+		var rules []string
+		line, _, rules = e.redactPayloads(line, e.replacer, scope)
+		for _, rule := range rules {
+			detections = append(detections, Detection{Rule: rule})
+		}
+	}
+	if e.shiftDays != 0 {
+		line = shiftDates(line, e.shiftDays)
+	}
+	return line, detections
+}
+
+// candidates reports which detectors could match line, or nil when every
+// detector must run
+func (e *NativeEngine) candidates(line string) []bool {
+	if e.prefilter == nil {
+		return nil
+	}
+	return e.prefilter.candidates(line)
+}
+
+// locate redacts line like redactLine, with placeholders, and reports where
+// in the original line each detection was found. Under OverlapPriority
+// detectors run on the output of the ones before them, so every edit is
+// mapped back through those that preceded it.
+func (e *NativeEngine) locate(line string) (string, []Finding) {
+	original := line
+	scope := &matchScope{record: line}
+	// origin[i] is the offset in original of byte i of line
+	origin := make([]int, len(line)+1)
+	for i := range origin {
+		origin[i] = i
+	}
+
+	var findings []Finding
+	// record notes the edits made to produce line, the ith under rule(i)
+	record := func(edits []lineEdit, rule func(i int) string) {
+		if len(edits) == 0 {
+			return
+		}
+		next := make([]int, 0, len(line)+1)
+		last := 0
+		for i, edit := range edits {
+			findings = append(findings, Finding{
+				Rule:      rule(i),
+				Column:    column(original, origin[edit.start]),
+				EndColumn: column(original, origin[edit.end]),
+			})
+			next = append(next, origin[last:edit.start]...)
+			for j := 0; j < edit.size; j++ {
+				next = append(next, origin[edit.start])
+			}
+			last = edit.end
+		}
+		origin = append(next, origin[last:]...)
+	}
+	if e.resolvesOverlaps() {
+		var edits []lineEdit
+		var rules []string
+		line, edits, rules = e.redactResolved(line, placeholderReplacer{}, scope)
+		record(edits, func(i int) string { return rules[i] })
+	} else {
+		run := e.candidates(line)
+		for i, d := range e.detectors {
+			if run != nil && !run[i] {
+				continue
+			}
+			var edits []lineEdit
+			line, edits = d.redact(line, placeholderReplacer{}, scope)
+			record(edits, func(int) string { return d.name })
+			if len(edits) > 0 {
+				run = e.candidates(line)
+			}
+		}
+	}
+	if e.payloads {
+		var edits []lineEdit
+		var rules []string
+		line, edits, rules = e.redactPayloads(line, placeholderReplacer{}, scope)
+		record(edits, func(i int) string { return rules[i] })
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Column < findings[j].Column })
+	return line, findings
+}
+
+// column converts a byte offset in line to a 1-based character column
+func column(line string, offset int) int {
+	return utf8.RuneCountInString(line[:offset]) + 1
+}
+
+func (e *NativeEngine) RedactLine(ctx context.Context, line string) (string, []Detection, error) {
+	var scope *matchScope
+	if e.scoped {
+		scope = scopeOf(ctx, line)
+	}
+	redacted, detections := e.redactLine(line, scope)
+	if e.placeholders != nil {
+		redacted = e.placeholders.resolve(ctx, redacted)
+	}
+	return redacted, detections, nil
+}
+
+func (e *NativeEngine) ProcessFile(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error) {
+	return processFileByLine(ctx, e, inputPath, outputPath)
+}
@@ -0,0 +1,192 @@
+package logveil
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Numeric transforms for NumericRule.Transform. They generalize
+// quasi-identifiers, values that single people out in combination such as
+// ages or home coordinates, rather than removing them.
+const (
+	// NumericBucket replaces a value with the lower bound of its bucket of
+	// width Amount, so 37 in buckets of 10 becomes 30
+	NumericBucket = "bucket"
+	// NumericRange replaces a value with its bucket as a label, "30-39"
+	NumericRange = "range"
+	// NumericRound rounds a value to Amount decimal places; a negative
+	// Amount rounds to tens, hundreds and so on
+	NumericRound = "round"
+	// NumericNoise moves a value by up to Amount times itself, so 0.1 is
+	// within 10%
+	NumericNoise = "noise"
+	// NumericJitter moves a value by up to Amount either way
+	NumericJitter = "jitter"
+)
+
+// NumericRule transforms the numeric values of a field wherever it
+// appears as key=value, key: value or "key": value, in JSON, logfmt or
+// plain text alike. Noise and jitter are drawn from a per-run key, so a
+// value repeated within a run is perturbed alike and cannot be averaged
+// back out.
+type NumericRule struct {
+	// Field is the key, optionally with * wildcards, matched ignoring case
+	Field     string
+	Transform string
+	Amount    float64
+}
+
+// ParseNumericRule parses field=transform:amount, such as age=bucket:10
+// or lat=round:2
+func ParseNumericRule(spec string) (NumericRule, error) {
+	field, transform, ok := strings.Cut(spec, "=")
+	if !ok || field == "" {
+		return NumericRule{}, fmt.Errorf("numeric transform %q: expected field=transform:amount", spec)
+	}
+	name, amount, ok := strings.Cut(transform, ":")
+	if !ok {
+		return NumericRule{}, fmt.Errorf("numeric transform %q: expected field=transform:amount", spec)
+	}
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return NumericRule{}, fmt.Errorf("numeric transform %q: invalid amount: %v", spec, err)
+	}
+	rule := NumericRule{Field: field, Transform: name, Amount: value}
+	return rule, rule.validate()
+}
+
+// validate checks r
+func (r NumericRule) validate() error {
+	switch r.Transform {
+	case NumericBucket, NumericRange:
+		if r.Amount <= 0 {
+			return fmt.Errorf("numeric transform for %s: bucket width must be positive", r.Field)
+		}
+	case NumericRound:
+		if r.Amount != math.Trunc(r.Amount) {
+			return fmt.Errorf("numeric transform for %s: decimal places must be whole", r.Field)
+		}
+	case NumericNoise, NumericJitter:
+		if r.Amount < 0 {
+			return fmt.Errorf("numeric transform for %s: noise must not be negative", r.Field)
+		}
+	default:
+		return fmt.Errorf("numeric transform for %s: unknown transform %q (expected bucket, range, round, noise or jitter)", r.Field, r.Transform)
+	}
+	return nil
+}
+
+// numericField matches a key and a number assigned to it, the key and the
+// number each optionally quoted
+var numericField = regexp.MustCompile(`(["']?)([A-Za-z_][\w.-]*)(["']?)(\s*[:=]\s*)(["']?)(-?\d+(?:\.\d+)?)(["']?)`)
+
+// numericTransforms applies NumericRules to lines
+type numericTransforms struct {
+	rules []NumericRule
+	keys  []keyPatterns
+}
+
+// newNumericTransforms validates rules; it returns nil for no rules
+func newNumericTransforms(rules []NumericRule) (*numericTransforms, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	t := &numericTransforms{rules: rules}
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		t.keys = append(t.keys, newKeyPatterns([]string{rule.Field}))
+	}
+	return t, nil
+}
+
+// apply transforms the numeric fields in line, reporting one numeric_field
+// detection for each
+func (t *numericTransforms) apply(line string) (string, []Detection) {
+	var detections []Detection
+	line = numericField.ReplaceAllStringFunc(line, func(match string) string {
+		m := numericField.FindStringSubmatch(match)
+		keyOpen, key, keyClose, sep, valueOpen, number, valueClose := m[1], m[2], m[3], m[4], m[5], m[6], m[7]
+		if keyOpen != keyClose || valueOpen != valueClose {
+			return match
+		}
+		for i, rule := range t.rules {
+			if !t.keys[i].matches(key) {
+				continue
+			}
+			value := rule.transform(key, number)
+			if rule.Transform == NumericRange && valueOpen == "" && keyOpen == `"` {
+				// A JSON number becomes a string
+				valueOpen, valueClose = `"`, `"`
+			}
+			detections = append(detections, Detection{Rule: "numeric_field"})
+			return keyOpen + key + keyClose + sep + valueOpen + value + valueClose
+		}
+		return match
+	})
+	return line, detections
+}
+
+// transform applies r to number, the value of key
+func (r NumericRule) transform(key, number string) string {
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return number
+	}
+	places := 0
+	if dot := strings.IndexByte(number, '.'); dot >= 0 {
+		places = len(number) - dot - 1
+	}
+
+	switch r.Transform {
+	case NumericBucket:
+		return formatNumber(math.Floor(value/r.Amount)*r.Amount, places)
+	case NumericRange:
+		low := math.Floor(value/r.Amount) * r.Amount
+		high := low + r.Amount
+		if places == 0 && r.Amount == math.Trunc(r.Amount) {
+			// Whole buckets read 30-39 rather than 30-40
+			return formatNumber(low, 0) + "-" + formatNumber(high-1, 0)
+		}
+		return formatNumber(low, places) + "-" + formatNumber(high, places)
+	case NumericRound:
+		scale := math.Pow(10, r.Amount)
+		rounded := math.Round(value*scale) / scale
+		if r.Amount < float64(places) {
+			places = max(int(r.Amount), 0)
+		}
+		return formatNumber(rounded, places)
+	case NumericNoise:
+		return formatNumber(value+value*r.Amount*noiseFactor(key, number), places)
+	case NumericJitter:
+		return formatNumber(value+r.Amount*noiseFactor(key, number), places)
+	}
+	return number
+}
+
+// noiseFactor returns a number in [-1, 1) derived from key and number with
+// the per-run runKey, so equal values are perturbed alike within a run
+func noiseFactor(key, number string) float64 {
+	mac := hmac.New(sha256.New, runKey())
+	mac.Write([]byte(strings.ToLower(key)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(number))
+	n := binary.BigEndian.Uint64(mac.Sum(nil))
+	return float64(n>>11)/float64(1<<53)*2 - 1
+}
+
+// formatNumber formats value with places decimals
+func formatNumber(value float64, places int) string {
+	s := strconv.FormatFloat(value, 'f', places, 64)
+	if s == "-0" || strings.HasPrefix(s, "-0.") && strings.Trim(s[3:], "0") == "" {
+		s = s[1:]
+	}
+	return s
+}
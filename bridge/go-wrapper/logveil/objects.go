@@ -0,0 +1,293 @@
+package logveil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// objectStore reads and writes objects addressed by URI, such as
+// s3://bucket/key, gs://bucket/object, azblob://container/blob or, for
+// reading only, sftp://host/path
+type objectStore interface {
+	open(ctx context.Context, uri *url.URL) (io.ReadCloser, error)
+	create(ctx context.Context, uri *url.URL) (objectWriter, error)
+	size(ctx context.Context, uri *url.URL) (int64, error)
+}
+
+// objectWriter is an object being written. Close completes it and Abort
+// discards it, so failed redactions don't leave partial objects behind.
+type objectWriter interface {
+	io.Writer
+	Close() error
+	Abort(err error)
+}
+
+// objectStoreFactories builds the store for each supported URI scheme
+var objectStoreFactories = map[string]func(ctx context.Context) (objectStore, error){
+	"s3":     newS3Store,
+	"gs":     newGCSStore,
+	"azblob": newAzblobStore,
+	"sftp":   newSFTPStore,
+}
+
+var (
+	objectStoresMu sync.Mutex
+	objectStores   = make(map[string]objectStore)
+)
+
+// IsRemote reports whether path is an object storage URI rather than a
+// local file
+func IsRemote(path string) bool {
+	scheme, _, ok := strings.Cut(path, "://")
+	if !ok {
+		return false
+	}
+	_, known := objectStoreFactories[strings.ToLower(scheme)]
+	return known
+}
+
+// lookupObject parses uri and returns the store that serves it, creating
+// the store on first use
+func lookupObject(ctx context.Context, uri string) (objectStore, *url.URL, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	scheme := strings.ToLower(u.Scheme)
+	factory, ok := objectStoreFactories[scheme]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported URI scheme %q", u.Scheme)
+	}
+
+	objectStoresMu.Lock()
+	defer objectStoresMu.Unlock()
+	store, ok := objectStores[scheme]
+	if !ok {
+		if store, err = factory(ctx); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", scheme, err)
+		}
+		objectStores[scheme] = store
+	}
+	return store, u, nil
+}
+
+// remoteSize returns the size of the object at uri
+func remoteSize(ctx context.Context, uri string) (int64, error) {
+	store, u, err := lookupObject(ctx, uri)
+	if err != nil {
+		return 0, err
+	}
+	return store.size(ctx, u)
+}
+
+// openLocation opens a local path or object URI for reading, decompressing
+// it transparently
+func openLocation(ctx context.Context, path string) (io.ReadCloser, error) {
+	if !IsRemote(path) {
+		return openInput(path)
+	}
+	store, u, err := lookupObject(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	body, err := store.open(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := decompress(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return &objectReader{ReadCloser: reader, body: body}, nil
+}
+
+// objectReader is an opened, decompressing object
+type objectReader struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (o *objectReader) Close() error {
+	err := o.ReadCloser.Close()
+	if closeErr := o.body.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// createLocation creates a local path or object URI for writing,
+// compressed with codec
+func createLocation(ctx context.Context, path, codec string) (objectWriter, error) {
+	var dst objectWriter
+	if IsRemote(path) {
+		store, u, err := lookupObject(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if dst, err = store.create(ctx, u); err != nil {
+			return nil, err
+		}
+	} else {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		dst = localObject{file}
+	}
+
+	encoder, err := compress(dst, codec)
+	if err != nil {
+		dst.Abort(err)
+		return nil, err
+	}
+	return &encodedObject{WriteCloser: encoder, dst: dst}, nil
+}
+
+// localObject adapts a file to objectWriter. Aborting leaves what was
+// written, as a failed local ProcessFile does.
+type localObject struct {
+	*os.File
+}
+
+func (l localObject) Abort(error) {
+	l.File.Close()
+}
+
+// encodedObject compresses into an objectWriter
+type encodedObject struct {
+	io.WriteCloser
+	dst objectWriter
+}
+
+func (e *encodedObject) Close() error {
+	if err := e.WriteCloser.Close(); err != nil {
+		e.dst.Abort(err)
+		return err
+	}
+	return e.dst.Close()
+}
+
+func (e *encodedObject) Abort(err error) {
+	e.dst.Abort(err)
+}
+
+// processRemote redacts between local files and object URIs, streaming so
+// large objects are never held in memory or on disk. Engines that only
+// work on whole files get staged local copies instead.
+func (r *Redactor) processRemote(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error) {
+	if !streamsLines(r.engine) {
+		return r.processRemoteStaged(ctx, inputPath, outputPath)
+	}
+
+	in, err := openLocation(ctx, inputPath)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	}
+	defer in.Close()
+
+	out, err := createLocation(ctx, outputPath, r.compression)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageWrite, "create output: %v", err))
+	}
+
+	result, err := redactStream(ctx, r.engine, in, out)
+	if err != nil {
+		out.Abort(err)
+		return result, err
+	}
+	if result.Skipped != "" {
+		out.Abort(errors.New("input skipped"))
+		if !IsRemote(outputPath) {
+			os.Remove(outputPath)
+		}
+		return result, nil
+	}
+	if err := out.Close(); err != nil {
+		result.Success = false
+		result.addError(newError(CodeIO, StageWrite, "write output: %v", err))
+		return result, err
+	}
+	return result, nil
+}
+
+// processRemoteStaged downloads a remote input, runs processFile on local
+// copies and uploads the output
+func (r *Redactor) processRemoteStaged(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error) {
+	dir, err := os.MkdirTemp("", "logveil-stage-")
+	if err != nil {
+		return failedResult(newError(CodeIO, StageSetup, "create staging dir: %v", err))
+	}
+	defer os.RemoveAll(dir)
+
+	localInput, localOutput := inputPath, outputPath
+	if IsRemote(inputPath) {
+		localInput = filepath.Join(dir, "input.log")
+		if err := transfer(ctx, localInput, inputPath); err != nil {
+			return failedResult(newError(CodeIO, StageRead, "download input: %v", err))
+		}
+	}
+	if IsRemote(outputPath) {
+		localOutput = filepath.Join(dir, "output.log")
+	}
+
+	result, err := r.processFile(ctx, localInput, localOutput)
+	if err != nil || localOutput == outputPath || result.Skipped != "" {
+		return result, err
+	}
+	if err := transfer(ctx, outputPath, localOutput); err != nil {
+		result.Success = false
+		result.addError(newError(CodeIO, StageWrite, "write output: %v", err))
+		return result, err
+	}
+	return result, nil
+}
+
+// transfer copies src to dst byte for byte; either may be an object URI
+func transfer(ctx context.Context, dst, src string) error {
+	var in io.ReadCloser
+	var err error
+	if IsRemote(src) {
+		var store objectStore
+		var u *url.URL
+		if store, u, err = lookupObject(ctx, src); err == nil {
+			in, err = store.open(ctx, u)
+		}
+	} else {
+		in, err = os.Open(src)
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var out objectWriter
+	if IsRemote(dst) {
+		var store objectStore
+		var u *url.URL
+		if store, u, err = lookupObject(ctx, dst); err == nil {
+			out, err = store.create(ctx, u)
+		}
+	} else {
+		var file *os.File
+		if file, err = os.Create(dst); err == nil {
+			out = localObject{file}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Abort(err)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,150 @@
+package logveil
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Overlap policies for NativeOptions.Overlap, deciding which rule redacts
+// text that several rules match, such as an email address inside a URL
+const (
+	// OverlapPriority runs the rules in order, each on the output of the
+	// ones before it, so the first rule to match text redacts it. This is
+	// the default and matches the Python engine.
+	OverlapPriority = "priority"
+	// OverlapLongest matches every rule against the original line and
+	// redacts the longest of overlapping matches, the earlier rule on a tie
+	OverlapLongest = "longest"
+	// OverlapMerge matches every rule against the original line and
+	// redacts overlapping matches as one span covering all of them, under
+	// the rule of the longest
+	OverlapMerge = "merge"
+)
+
+// validateOverlap checks an overlap policy name
+func validateOverlap(policy string) error {
+	switch policy {
+	case "", OverlapPriority, OverlapLongest, OverlapMerge:
+		return nil
+	}
+	return fmt.Errorf("unknown overlap policy %q (expected priority, longest or merge)", policy)
+}
+
+// resolvesOverlaps reports whether e matches every detector against the
+// original line rather than running them in turn
+func (e *NativeEngine) resolvesOverlaps() bool {
+	return e.overlap == OverlapLongest || e.overlap == OverlapMerge
+}
+
+// ruleMatch is a match of the detector at index rule in a NativeEngine
+type ruleMatch struct {
+	detectorMatch
+	rule int
+}
+
+// redactResolved redacts line under the longest or merge policy: every
+// detector is matched against the original line, overlaps are resolved
+// and the surviving spans are replaced in one pass
+func (e *NativeEngine) redactResolved(line string, repl replacer, scope *matchScope) (string, []lineEdit, []string) {
+	var matches []ruleMatch
+	run := e.candidates(line)
+	for i, d := range e.detectors {
+		if run != nil && !run[i] {
+			continue
+		}
+		for _, m := range d.find(line, scope) {
+			if m.end > m.start {
+				matches = append(matches, ruleMatch{detectorMatch: m, rule: i})
+			}
+		}
+	}
+	if matches == nil {
+		return line, nil, nil
+	}
+
+	var spans []ruleMatch
+	if e.overlap == OverlapMerge {
+		spans = mergeMatches(matches)
+	} else {
+		spans = longestMatches(matches)
+	}
+
+	var out []byte
+	edits := make([]lineEdit, 0, len(spans))
+	rules := make([]string, 0, len(spans))
+	last := 0
+	for _, m := range spans {
+		d := e.detectors[m.rule]
+		if m.submatch == nil {
+			// A merged span no longer lines up with d's template
+			d.template = ""
+		}
+		replacement := d.replacement(line, m.detectorMatch, repl)
+		out = append(out, line[last:m.start]...)
+		out = append(out, replacement...)
+		edits = append(edits, lineEdit{start: m.start, end: m.end, size: len(replacement)})
+		rules = append(rules, d.name)
+		last = m.end
+	}
+	return string(append(out, line[last:]...)), edits, rules
+}
+
+// longestMatches keeps the longest of each set of overlapping matches,
+// preferring the earlier rule and then the earlier match on a tie, and
+// returns them in line order
+func longestMatches(matches []ruleMatch) []ruleMatch {
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if la, lb := a.end-a.start, b.end-b.start; la != lb {
+			return la > lb
+		}
+		if a.rule != b.rule {
+			return a.rule < b.rule
+		}
+		return a.start < b.start
+	})
+	var kept []ruleMatch
+	for _, m := range matches {
+		overlaps := false
+		for _, k := range kept {
+			if m.start < k.end && k.start < m.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, m)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].start < kept[j].start })
+	return kept
+}
+
+// mergeMatches joins overlapping matches into single spans, each under
+// the rule of its longest match, the earlier rule on a tie, and returns
+// them in line order. A span left with a single match keeps its
+// submatches, so templates still apply.
+func mergeMatches(matches []ruleMatch) []ruleMatch {
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	var merged []ruleMatch
+	// longest is the length of the match whose rule each span has
+	var longest []int
+	for _, m := range matches {
+		n := len(merged) - 1
+		if n < 0 || m.start >= merged[n].end {
+			merged = append(merged, m)
+			longest = append(longest, m.end-m.start)
+			continue
+		}
+		span := &merged[n]
+		size := m.end - m.start
+		if size > longest[n] || size == longest[n] && m.rule < span.rule {
+			span.rule, longest[n] = m.rule, size
+		}
+		span.submatch = nil
+		if m.end > span.end {
+			span.end = m.end
+		}
+	}
+	return merged
+}
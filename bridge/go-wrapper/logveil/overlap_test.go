@@ -0,0 +1,87 @@
+package logveil
+
+import (
+	"fmt"
+	"testing"
+)
+
+// match returns a match of the detector at index rule over [start, end)
+func match(rule, start, end int) ruleMatch {
+	return ruleMatch{detectorMatch: detectorMatch{start: start, end: end, submatch: []int{start, end}}, rule: rule}
+}
+
+func TestOverlapPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		matches []ruleMatch
+		// longest and merge are the spans kept, as rule:start-end; a merged
+		// span only keeps its submatches when it is a single match
+		longest []string
+		merge   []string
+	}{
+		{
+			name:    "disjoint",
+			matches: []ruleMatch{match(1, 10, 15), match(0, 0, 5)},
+			longest: []string{"0:0-5", "1:10-15"},
+			merge:   []string{"0:0-5", "1:10-15"},
+		},
+		{
+			name:    "nested",
+			matches: []ruleMatch{match(0, 4, 8), match(1, 0, 20)},
+			longest: []string{"1:0-20"},
+			merge:   []string{"1:0-20"},
+		},
+		{
+			name:    "tie goes to the earlier rule",
+			matches: []ruleMatch{match(1, 0, 10), match(0, 5, 15)},
+			longest: []string{"0:5-15"},
+			merge:   []string{"0:0-15"},
+		},
+		{
+			name:    "chain",
+			matches: []ruleMatch{match(0, 0, 6), match(1, 4, 14), match(2, 12, 18)},
+			longest: []string{"1:4-14"},
+			merge:   []string{"1:0-18"},
+		},
+		{
+			name:    "touching spans stay apart",
+			matches: []ruleMatch{match(0, 0, 5), match(1, 5, 9)},
+			longest: []string{"0:0-5", "1:5-9"},
+			merge:   []string{"0:0-5", "1:5-9"},
+		},
+	}
+	spans := func(matches []ruleMatch) []string {
+		var out []string
+		for _, m := range matches {
+			out = append(out, fmt.Sprintf("%d:%d-%d", m.rule, m.start, m.end))
+		}
+		return out
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spans(longestMatches(append([]ruleMatch(nil), tt.matches...)))
+			if fmt.Sprint(got) != fmt.Sprint(tt.longest) {
+				t.Errorf("longestMatches = %v, want %v", got, tt.longest)
+			}
+			merged := mergeMatches(append([]ruleMatch(nil), tt.matches...))
+			if got := spans(merged); fmt.Sprint(got) != fmt.Sprint(tt.merge) {
+				t.Errorf("mergeMatches = %v, want %v", got, tt.merge)
+			}
+			for _, m := range merged {
+				overlapping := 0
+				for _, in := range tt.matches {
+					if in.start < m.end && m.start < in.end {
+						overlapping++
+					}
+				}
+				if (overlapping == 1) != (m.submatch != nil) {
+					t.Errorf("merged span %d-%d of %d matches has submatches %v", m.start, m.end, overlapping, m.submatch)
+				}
+			}
+		})
+	}
+
+	if _, err := NewNativeEngine(NativeOptions{Overlap: "widest"}); err == nil {
+		t.Error("NewNativeEngine accepted an unknown overlap policy")
+	}
+}
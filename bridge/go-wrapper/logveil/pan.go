@@ -0,0 +1,101 @@
+package logveil
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// panDetectors recognise payment card numbers (PANs). Candidates of 13 to
+// 19 digits, optionally grouped by spaces or dashes, only count when they
+// pass the Luhn check and fall in an issuer's IIN range, so order numbers
+// and timestamps of the same length are left alone.
+var panDetectors = []detector{
+	{name: "pan", pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), validate: validPAN},
+}
+
+// cardDataDetectors recognise the rest of the cardholder and sensitive
+// authentication data PCI DSS forbids storing: magnetic stripe track data,
+// which holds the PAN and so runs before panDetectors, and verification
+// codes and expiry dates, which are only taken after a label
+var cardDataDetectors = []detector{
+	{name: "card_track_data", pattern: regexp.MustCompile(`%?B\d{13,19}\^[^^\n]{2,26}\^\d{4}\d*\??|;?\d{13,19}=\d{4}\d*\?`)},
+	{name: "card_verification_code", pattern: regexp.MustCompile(`(?i)\b(?:cvv2?|cvc2?|cvn|cid|card[ _]?verification(?:[ _](?:code|value))?|security[ _]code)["']?\s*[:=]\s*["']?(?P<value>\d{3,4})\b`)},
+	{name: "card_expiry", pattern: regexp.MustCompile(`(?i)\b(?:exp(?:iry|iration)?(?:[ _]?date)?|valid[ _]thru)["']?\s*[:=]\s*["']?(?P<value>(?:0[1-9]|1[0-2]) ?/ ?(?:\d{4}|\d{2}))\b`)},
+}
+
+// iinRange is a span of issuer identification number prefixes, compared
+// on their first digits, and the card lengths the issuer uses
+type iinRange struct {
+	low, high  int
+	minLength  int
+	maxLength  int
+	prefixSize int
+}
+
+// iinRanges covers the major card networks
+var iinRanges = []iinRange{
+	{low: 4, high: 4, minLength: 13, maxLength: 19, prefixSize: 1},       // Visa
+	{low: 51, high: 55, minLength: 16, maxLength: 16, prefixSize: 2},     // Mastercard
+	{low: 2221, high: 2720, minLength: 16, maxLength: 16, prefixSize: 4}, // Mastercard 2-series
+	{low: 34, high: 34, minLength: 15, maxLength: 15, prefixSize: 2},     // American Express
+	{low: 37, high: 37, minLength: 15, maxLength: 15, prefixSize: 2},     // American Express
+	{low: 6011, high: 6011, minLength: 16, maxLength: 19, prefixSize: 4}, // Discover
+	{low: 644, high: 649, minLength: 16, maxLength: 19, prefixSize: 3},   // Discover
+	{low: 65, high: 65, minLength: 16, maxLength: 19, prefixSize: 2},     // Discover
+	{low: 300, high: 305, minLength: 14, maxLength: 19, prefixSize: 3},   // Diners Club
+	{low: 36, high: 36, minLength: 14, maxLength: 19, prefixSize: 2},     // Diners Club
+	{low: 38, high: 39, minLength: 16, maxLength: 19, prefixSize: 2},     // Diners Club
+	{low: 3528, high: 3589, minLength: 16, maxLength: 19, prefixSize: 4}, // JCB
+	{low: 62, high: 62, minLength: 16, maxLength: 19, prefixSize: 2},     // UnionPay
+	{low: 2200, high: 2204, minLength: 16, maxLength: 19, prefixSize: 4}, // Mir
+	{low: 6759, high: 6759, minLength: 13, maxLength: 19, prefixSize: 4}, // Maestro UK
+	{low: 5018, high: 5018, minLength: 13, maxLength: 19, prefixSize: 4}, // Maestro
+	{low: 5020, high: 5020, minLength: 13, maxLength: 19, prefixSize: 4}, // Maestro
+	{low: 5038, high: 5038, minLength: 13, maxLength: 19, prefixSize: 4}, // Maestro
+	{low: 6304, high: 6304, minLength: 13, maxLength: 19, prefixSize: 4}, // Maestro
+}
+
+// validPAN reports whether candidate, ignoring separators, is a Luhn-valid
+// number in a known IIN range
+func validPAN(candidate string) bool {
+	digits := make([]byte, 0, len(candidate))
+	for i := 0; i < len(candidate); i++ {
+		if c := candidate[i]; c >= '0' && c <= '9' {
+			digits = append(digits, c)
+		}
+	}
+	return knownIIN(digits) && luhnValid(digits)
+}
+
+// knownIIN reports whether digits start with an issuer prefix and have a
+// length that issuer uses
+func knownIIN(digits []byte) bool {
+	for _, r := range iinRanges {
+		if len(digits) < r.minLength || len(digits) > r.maxLength {
+			continue
+		}
+		prefix, err := strconv.Atoi(string(digits[:r.prefixSize]))
+		if err == nil && prefix >= r.low && prefix <= r.high {
+			return true
+		}
+	}
+	return false
+}
+
+// luhnValid reports whether digits pass the Luhn checksum
+func luhnValid(digits []byte) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,33 @@
+package logveil
+
+import "testing"
+
+func TestValidPAN(t *testing.T) {
+	tests := []struct {
+		candidate string
+		want      bool
+	}{
+		{candidate: "4111111111111111", want: true},
+		{candidate: "4111 1111 1111 1111", want: true},
+		{candidate: "4111-1111-1111-1111", want: true},
+		{candidate: "5555555555554444", want: true},
+		{candidate: "2221000000000009", want: true},
+		{candidate: "378282246310005", want: true},
+		{candidate: "6011111111111117", want: true},
+		{candidate: "3530111333300000", want: true},
+		// fails the Luhn check
+		{candidate: "4111111111111112", want: false},
+		// passes the Luhn check outside every IIN range
+		{candidate: "1234567812345670", want: false},
+		// a Mastercard prefix at an American Express length
+		{candidate: "555555555555440", want: false},
+		{candidate: "411111111111", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.candidate, func(t *testing.T) {
+			if got := validPAN(tt.candidate); got != tt.want {
+				t.Errorf("validPAN(%q) = %v, want %v", tt.candidate, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package logveil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+// parallelChunkSize is the size of the chunks redactParallel splits input
+// into; each chunk runs on to the end of the line it would cut
+const parallelChunkSize = 8 << 20
+
+// chunkable reports whether engine redacts every line independently of the
+// lines before it, so a file can be redacted in chunks. Multi-line records
+// may straddle chunks, formats such as csv carry state from line to line
+// and numbered placeholders are numbered in order of appearance, so those
+// are redacted sequentially; so are python workers, which serialise lines.
+func chunkable(engine Engine) bool {
+	if f, ok := engine.(*formatEngine); ok {
+		if f.records != nil {
+			return false
+		}
+		if _, ok := f.format.(streamFormat); ok {
+			return false
+		}
+		engine = f.Engine
+	}
+	native, ok := engine.(*NativeEngine)
+	return ok && native.placeholders == nil
+}
+
+// textChunk is a line-aligned piece of the input and what became of it
+type textChunk struct {
+	input []byte
+	// lines is the number of lines in input
+	lines  int
+	output bytes.Buffer
+	result *ProcessResult
+	err    error
+	done   chan struct{}
+}
+
+// redactParallel is redactStream spreading line-aligned chunks of r over
+// workers goroutines. The chunks are written in order, so the output is
+// the same as redactStream's, and at most about twice workers chunks are
+// held in memory at once. Output is only written a chunk at a time, which
+// suits files rather than interactive pipelines. A mapped file is split
+// without copying it.
+func redactParallel(ctx context.Context, engine Engine, r io.Reader, w io.Writer, workers int) (*ProcessResult, error) {
+	if mapped, ok := r.(*mappedInput); ok && sniffText(mapped.data[:min(len(mapped.data), sniffLength)]) == "" {
+		// UTF-8 is split in place
+		return redactChunks(ctx, engine, mappedChunks(mapped.data), w, workers)
+	}
+	text, encoding, err := engineInput(r, engine)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "read input: %v", err))
+	}
+	if encoding == SkippedBinary {
+		return skippedResult(SkippedBinary)
+	}
+	result, err := redactChunks(ctx, engine, readerChunks(text), w, workers)
+	result.Encoding = encoding
+	return result, err
+}
+
+// readerChunks returns a function reading the next chunk of r, which
+// returns io.EOF with the last
+func readerChunks(r io.Reader) func() ([]byte, error) {
+	reader := bufio.NewReader(r)
+	return func() ([]byte, error) {
+		buf := make([]byte, parallelChunkSize)
+		n, err := io.ReadFull(reader, buf)
+		buf = buf[:n]
+		switch err {
+		case nil:
+			rest, err := reader.ReadBytes('\n')
+			return append(buf, rest...), err
+		case io.ErrUnexpectedEOF:
+			return buf, io.EOF
+		}
+		return buf, err
+	}
+}
+
+// mappedChunks is readerChunks for a mapped file, whose chunks are slices
+// of data rather than copies
+func mappedChunks(data []byte) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		end := len(data)
+		if end > parallelChunkSize {
+			if i := bytes.IndexByte(data[parallelChunkSize:], '\n'); i >= 0 {
+				end = parallelChunkSize + i + 1
+			}
+		}
+		chunk := data[:end]
+		if data = data[end:]; len(data) == 0 {
+			return chunk, io.EOF
+		}
+		return chunk, nil
+	}
+}
+
+// redactChunks redacts the chunks next returns into w on workers
+// goroutines and merges their results as if the input had been redacted
+// in one piece
+func redactChunks(ctx context.Context, engine Engine, next func() ([]byte, error), w io.Writer, workers int) (*ProcessResult, error) {
+	startTime := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// pending holds the chunks in input order for the writer, and bounds
+	// how far reading runs ahead of writing
+	pending := make(chan *textChunk, workers)
+	jobs := make(chan *textChunk)
+	// readErr is why reading stopped early, and complete is set once
+	// everything was read
+	var readErr error
+	complete := false
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for ctx.Err() == nil {
+			buf, err := next()
+			if err != nil && err != io.EOF {
+				readErr = err
+				return
+			}
+			if len(buf) > 0 {
+				c := &textChunk{input: buf, lines: bytes.Count(buf, []byte("\n")), done: make(chan struct{})}
+				if buf[len(buf)-1] != '\n' {
+					c.lines++
+				}
+				pending <- c
+				jobs <- c
+			}
+			if err == io.EOF {
+				complete = true
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for c := range jobs {
+				c.result, c.err = redactCheckpointed(ctx, engine, bytes.NewReader(c.input), &c.output, nil)
+				c.input = nil
+				close(c.done)
+			}
+		}()
+	}
+
+	result := &ProcessResult{}
+	var stopErr error
+	line := 0
+	for c := range pending {
+		<-c.done
+		if stopErr != nil {
+			// Drained so reading and the workers wind down
+			continue
+		}
+		for _, failed := range c.result.FailedLines {
+			failed.Number += line
+			result.FailedLines = append(result.FailedLines, failed)
+		}
+		result.BytesRead += c.result.BytesRead
+		result.LinesProcessed += c.result.LinesProcessed
+		result.LongLines += c.result.LongLines
+		for rule, n := range c.result.Detections {
+			if result.Detections == nil {
+				result.Detections = make(map[string]int)
+			}
+			result.Detections[rule] += n
+		}
+		n, err := c.output.WriteTo(w)
+		result.BytesWritten += n
+		line += c.lines
+		switch {
+		case err != nil:
+			stopErr = newError(CodeIO, StageWrite, "write output: %v", err)
+		case c.result.Truncated:
+			// The chunk ends in TruncationMarker
+			result.Truncated = true
+			stopErr = c.err
+		case len(result.FailedLines) > maxFailedLines:
+			failed := result.FailedLines[maxFailedLines]
+			stopErr = newError(CodeRedact, StageRedact, "line %d: %s", failed.Number, failed.Errors[0])
+		}
+		if stopErr != nil {
+			cancel()
+		}
+	}
+	if stopErr == nil && readErr != nil {
+		stopErr = newError(CodeIO, StageRead, "read input: %v", readErr)
+	}
+	if stopErr == nil && !complete {
+		// Cancelled between chunks
+		if n, err := io.WriteString(w, TruncationMarker+"\n"); err == nil {
+			result.BytesWritten += int64(n)
+			result.Truncated = true
+		}
+		stopErr = cancelError(StageRedact, ctx.Err())
+	}
+	if stopErr == nil && len(result.FailedLines) > 0 {
+		stopErr = newError(CodeRedact, StageRedact, "%d of %d lines could not be redacted, first line %d", len(result.FailedLines), line, result.FailedLines[0].Number)
+	}
+	if stopErr != nil {
+		result.addError(stopErr)
+		result.finish(startTime)
+		return result, stopErr
+	}
+	result.Success = true
+	result.finish(startTime)
+	return result, nil
+}
@@ -0,0 +1,88 @@
+package logveil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkEngine upper-cases lines, is slow on lines holding "slow" and fails
+// on lines holding "fail"
+type chunkEngine struct{}
+
+func (chunkEngine) Name() string { return "chunk" }
+
+func (chunkEngine) ProcessFile(context.Context, string, string) (*ProcessResult, error) {
+	return nil, errors.New("chunkEngine only redacts lines")
+}
+
+func (chunkEngine) RedactLine(_ context.Context, line string) (string, []Detection, error) {
+	if strings.Contains(line, "slow") {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if strings.Contains(line, "fail") {
+		return "", nil, errors.New("bad line")
+	}
+	return strings.ToUpper(line), []Detection{{Rule: "line"}}, nil
+}
+
+func TestRedactChunksOrder(t *testing.T) {
+	// Enough lines for several chunks, the first held up so the later
+	// ones finish before it
+	var b strings.Builder
+	lines := 3*parallelChunkSize/13 + 1000
+	for i := 1; i <= lines; i++ {
+		switch i {
+		case 2:
+			b.WriteString("slow line\n")
+		case parallelChunkSize / 13 * 2, lines - 10:
+			b.WriteString("fail line\n")
+		default:
+			fmt.Fprintf(&b, "line %07d\n", i)
+		}
+	}
+	input := b.String()
+
+	var want strings.Builder
+	wantResult, wantErr := redactStream(context.Background(), chunkEngine{}, strings.NewReader(input), &want)
+	tests := []struct {
+		name    string
+		chunks  func() func() ([]byte, error)
+		workers int
+	}{
+		{name: "reader, 1 worker", chunks: func() func() ([]byte, error) { return readerChunks(strings.NewReader(input)) }, workers: 1},
+		{name: "reader, 4 workers", chunks: func() func() ([]byte, error) { return readerChunks(strings.NewReader(input)) }, workers: 4},
+		{name: "mapped, 4 workers", chunks: func() func() ([]byte, error) { return mappedChunks([]byte(input)) }, workers: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got strings.Builder
+			result, err := redactChunks(context.Background(), chunkEngine{}, tt.chunks(), &got, tt.workers)
+			if got.String() != want.String() {
+				t.Error("output differs from redactStream's")
+			}
+			if (err == nil) != (wantErr == nil) {
+				t.Errorf("error = %v, want %v", err, wantErr)
+			}
+			if result.LinesProcessed != wantResult.LinesProcessed || result.Detections["line"] != wantResult.Detections["line"] {
+				t.Errorf("result = %d lines, %d detections; want %d, %d", result.LinesProcessed, result.Detections["line"],
+					wantResult.LinesProcessed, wantResult.Detections["line"])
+			}
+			if fmt.Sprint(failedNumbers(result)) != fmt.Sprint(failedNumbers(wantResult)) {
+				t.Errorf("failed lines = %v, want %v", failedNumbers(result), failedNumbers(wantResult))
+			}
+		})
+	}
+}
+
+// failedNumbers returns the numbers of result's failed lines
+func failedNumbers(result *ProcessResult) []int {
+	var numbers []int
+	for _, failed := range result.FailedLines {
+		numbers = append(numbers, failed.Number)
+	}
+	return numbers
+}
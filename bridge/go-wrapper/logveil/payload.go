@@ -0,0 +1,130 @@
+package logveil
+
+import (
+	"encoding/base64"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// base64Span matches a run of standard or URL-safe base64 long enough
+	// to hide a value worth redacting
+	base64Span = regexp.MustCompile(`[A-Za-z0-9+/_-]{12,}={0,2}`)
+	// percentSpan matches a run of URL characters with at least one
+	// percent escape, stopping at query separators
+	percentSpan = regexp.MustCompile(`[A-Za-z0-9._~+-]*(?:%[0-9A-Fa-f]{2}[A-Za-z0-9._~+-]*)+`)
+	// base64Encodings are tried in turn on each base64 span
+	base64Encodings = []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding}
+)
+
+// payloadDepth is how many layers of encoding are looked through, such
+// as base64 inside a query parameter
+const payloadDepth = 2
+
+// redactPayloads decodes the base64 and percent-encoded spans left in line
+// after the detectors ran and replaces each span whose decoded text a
+// detector matches. The span is replaced whole, under the rule of the
+// first match inside it.
+func (e *NativeEngine) redactPayloads(line string, repl replacer, scope *matchScope) (string, []lineEdit, []string) {
+	// Percent-encoded spans take precedence over the base64 runs inside them
+	spans := percentSpan.FindAllStringIndex(line, -1)
+	for _, m := range base64Span.FindAllStringIndex(line, -1) {
+		overlaps := false
+		for _, p := range spans {
+			if m[0] < p[1] && p[0] < m[1] {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			spans = append(spans, m)
+		}
+	}
+	if spans == nil {
+		return line, nil, nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	var out []byte
+	var edits []lineEdit
+	var rules []string
+	last := 0
+	for _, m := range spans {
+		rule := e.payloadRule(line[m[0]:m[1]], payloadDepth, scope)
+		if rule == "" {
+			continue
+		}
+		out = append(out, line[last:m[0]]...)
+		replacement := repl.replace(rule, line[m[0]:m[1]])
+		out = append(out, replacement...)
+		edits = append(edits, lineEdit{start: m[0], end: m[1], size: len(replacement)})
+		rules = append(rules, rule)
+		last = m[1]
+	}
+	if edits == nil {
+		return line, nil, nil
+	}
+	return string(append(out, line[last:]...)), edits, rules
+}
+
+// payloadRule returns the first rule matching the decoded text of span,
+// looking through up to depth layers of encoding, or "" when span does not
+// decode to text or nothing in it matches
+func (e *NativeEngine) payloadRule(span string, depth int, scope *matchScope) string {
+	decoded, ok := decodePayload(span)
+	if !ok {
+		return ""
+	}
+	run := e.candidates(decoded)
+	for i, d := range e.detectors {
+		if run != nil && !run[i] {
+			continue
+		}
+		if _, edits := d.redact(decoded, placeholderReplacer{}, scope); len(edits) > 0 {
+			return d.name
+		}
+	}
+	if depth > 1 {
+		for _, span := range []*regexp.Regexp{percentSpan, base64Span} {
+			for _, inner := range span.FindAllString(decoded, -1) {
+				if rule := e.payloadRule(inner, depth-1, scope); rule != "" {
+					return rule
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// decodePayload decodes a percent-encoded or base64 span, reporting
+// whether the result is printable text
+func decodePayload(span string) (string, bool) {
+	if strings.Contains(span, "%") {
+		decoded, err := url.QueryUnescape(span)
+		return decoded, err == nil && printable(decoded)
+	}
+	for _, encoding := range base64Encodings {
+		if decoded, err := encoding.DecodeString(span); err == nil {
+			return string(decoded), printable(string(decoded))
+		}
+	}
+	return "", false
+}
+
+// printable reports whether s is UTF-8 text without control characters
+// other than whitespace, as a decoded payload worth scanning is
+func printable(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
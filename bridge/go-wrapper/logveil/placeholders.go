@@ -0,0 +1,164 @@
+package logveil
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// PlaceholderData is what a placeholder template is executed with
+type PlaceholderData struct {
+	// Rule is the rule name in upper case, as in [REDACTED_EMAIL]
+	Rule string
+	// Index numbers the distinct values of Rule within a document from 1,
+	// so the same value always gets the same index
+	Index int
+}
+
+// placeholderTemplate renders placeholders such as [EMAIL_1] that tell
+// redacted values apart within a document without revealing them
+type placeholderTemplate struct {
+	tmpl *template.Template
+}
+
+// parsePlaceholderTemplate parses text, such as "[{{.Rule}}_{{.Index}}]"
+func parsePlaceholderTemplate(text string) (*placeholderTemplate, error) {
+	tmpl, err := template.New("placeholder").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid placeholder template: %v", err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, PlaceholderData{Rule: "EMAIL", Index: 1}); err != nil {
+		return nil, fmt.Errorf("invalid placeholder template: %v", err)
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("invalid placeholder template: %q renders nothing", text)
+	}
+	return &placeholderTemplate{tmpl: tmpl}, nil
+}
+
+// Values are indexed per document, but replacers see neither the document
+// nor the context, and formats quote or escape what they return. So
+// indexedReplacer emits a marker naming the rule and a keyed hash of the
+// value, and the engine swaps markers for rendered placeholders once the
+// line is redacted. Markers are spelled in Braille patterns, which no
+// built-in detector matches and every format passes through unchanged.
+const (
+	markerOpen  = "⦃"
+	markerSplit = "⦙"
+	markerClose = "⦄"
+	// markerBase is the rune encoding byte 0 in a marker
+	markerBase = 0x2800
+)
+
+// markerPattern matches a marker produced by indexedReplacer
+var markerPattern = regexp.MustCompile(markerOpen + `([\x{2800}-\x{28FF}]+)` + markerSplit + `([\x{2800}-\x{28FF}]{8})` + markerClose)
+
+// runKey keys the value hashes in markers and the numeric noise drawn
+// for values. It is random per process, so neither can be linked across
+// runs.
+var runKey = sync.OnceValue(func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+})
+
+// indexedReplacer marks values for a placeholderTemplate to number
+type indexedReplacer struct{}
+
+func (indexedReplacer) replace(rule, value string) string {
+	mac := hmac.New(sha256.New, runKey())
+	mac.Write([]byte(rule))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return markerOpen + markerRunes([]byte(rule)) + markerSplit + markerRunes(mac.Sum(nil)[:8]) + markerClose
+}
+
+// markerRunes spells b one rune per byte
+func markerRunes(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		s.WriteRune(markerBase + rune(c))
+	}
+	return s.String()
+}
+
+// markerBytes reverses markerRunes
+func markerBytes(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteByte(byte(r - markerBase))
+	}
+	return b.String()
+}
+
+// placeholderDocument numbers the values redacted in one document
+type placeholderDocument struct {
+	template *placeholderTemplate
+	mu       sync.Mutex
+	// indexes maps a rule to the index of each value hash seen for it
+	indexes map[string]map[string]int
+}
+
+// documentKey is the context key of the current placeholderDocument
+type documentKey struct{}
+
+// withDocument returns ctx carrying a new document to number placeholders
+// in, or ctx itself when it already carries one
+func (t *placeholderTemplate) withDocument(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(documentKey{}).(*placeholderDocument); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, documentKey{}, &placeholderDocument{template: t, indexes: make(map[string]map[string]int)})
+}
+
+// resolve replaces the markers in text with placeholders numbered within
+// the document ctx carries, or within text alone when it carries none
+func (t *placeholderTemplate) resolve(ctx context.Context, text string) string {
+	if !strings.Contains(text, markerOpen) {
+		return text
+	}
+	doc, _ := t.withDocument(ctx).Value(documentKey{}).(*placeholderDocument)
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	return markerPattern.ReplaceAllStringFunc(text, func(marker string) string {
+		m := markerPattern.FindStringSubmatch(marker)
+		rule, hash := markerBytes(m[1]), m[2]
+		values := doc.indexes[rule]
+		if values == nil {
+			values = make(map[string]int)
+			doc.indexes[rule] = values
+		}
+		index, ok := values[hash]
+		if !ok {
+			index = len(values) + 1
+			values[hash] = index
+		}
+		var out strings.Builder
+		if err := doc.template.tmpl.Execute(&out, PlaceholderData{Rule: strings.ToUpper(rule), Index: index}); err != nil {
+			// The template executed when it was parsed, so this cannot
+			// happen; never let the marker through regardless
+			return placeholder(rule)
+		}
+		return out.String()
+	})
+}
+
+// enginePlaceholders returns the placeholder template of the native engine
+// behind engine, or nil when it has none
+func enginePlaceholders(engine Engine) *placeholderTemplate {
+	if f, ok := engine.(*formatEngine); ok {
+		engine = f.Engine
+	}
+	if native, ok := engine.(*NativeEngine); ok {
+		return native.placeholders
+	}
+	return nil
+}
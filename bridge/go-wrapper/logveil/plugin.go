@@ -0,0 +1,259 @@
+package logveil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// A detector plugin is a WebAssembly module exporting its memory and
+//
+//	logveil_alloc(size i32) i32
+//	logveil_detect(ptr i32, len i32) i64
+//
+// For each line the engine writes the line's bytes to memory the module
+// allocates with logveil_alloc, then calls logveil_detect on them. The
+// result packs the address of the spans found in its high 32 bits and
+// their count in its low 32: each span is a pair of little-endian u32
+// byte offsets, start and end, into the line, so a result of 0 means
+// nothing was found. The span array need only stay valid until the next
+// call. A module may also export logveil_free(ptr i32, size i32), which is
+// called with each line's allocation once detection is done. WASI reactor
+// modules are supported, with _initialize run once per instance and no
+// access to files, the network or the clock's real time.
+const (
+	pluginAlloc  = "logveil_alloc"
+	pluginDetect = "logveil_detect"
+	pluginFree   = "logveil_free"
+)
+
+const (
+	// pluginMemoryPages caps an instance's memory at 64 MiB
+	pluginMemoryPages = 1024
+	// pluginCallTimeout bounds detection on a single line
+	pluginCallTimeout = 5 * time.Second
+)
+
+// Plugin is a WebAssembly detector named like a rule in results
+type Plugin struct {
+	Name string
+	Path string
+}
+
+// ParsePlugin parses name=path, such as customer_id=plugins/customer.wasm
+func ParsePlugin(spec string) (Plugin, error) {
+	name, path, ok := strings.Cut(spec, "=")
+	if !ok || name == "" || path == "" {
+		return Plugin{}, fmt.Errorf("plugin %q: expected name=path", spec)
+	}
+	return Plugin{Name: name, Path: path}, nil
+}
+
+// wasmDetector runs one plugin. Instances are not safe for concurrent
+// use, so each call takes one from a pool, instantiating another when all
+// are busy.
+type wasmDetector struct {
+	name     string
+	digest   string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+
+	mu        sync.Mutex
+	instances []api.Module
+	closed    bool
+}
+
+// loadPlugin compiles the module of p and checks that it exports the
+// detector contract
+func loadPlugin(ctx context.Context, p Plugin) (*wasmDetector, error) {
+	code, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %v", p.Name, err)
+	}
+	sum := sha256.Sum256(code)
+
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true).WithMemoryLimitPages(pluginMemoryPages)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("plugin %s: %v", p.Name, err)
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("plugin %s: %v", p.Name, err)
+	}
+	exports := compiled.ExportedFunctions()
+	for _, name := range []string{pluginAlloc, pluginDetect} {
+		if _, ok := exports[name]; !ok {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("plugin %s: %s does not export %s", p.Name, p.Path, name)
+		}
+	}
+	if len(compiled.ExportedMemories()) == 0 {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("plugin %s: %s does not export its memory", p.Name, p.Path)
+	}
+
+	w := &wasmDetector{name: p.Name, digest: hex.EncodeToString(sum[:]), runtime: runtime, compiled: compiled}
+	// Instantiating one now reports a module that traps on start
+	instance, err := w.instantiate(ctx)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("plugin %s: %v", p.Name, err)
+	}
+	w.instances = append(w.instances, instance)
+	return w, nil
+}
+
+func (w *wasmDetector) instantiate(ctx context.Context) (api.Module, error) {
+	config := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	return w.runtime.InstantiateModule(ctx, w.compiled, config)
+}
+
+// get takes an idle instance, or instantiates another
+func (w *wasmDetector) get(ctx context.Context) (api.Module, error) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil, errors.New("plugin closed")
+	}
+	if n := len(w.instances); n > 0 {
+		instance := w.instances[n-1]
+		w.instances = w.instances[:n-1]
+		w.mu.Unlock()
+		return instance, nil
+	}
+	w.mu.Unlock()
+	return w.instantiate(ctx)
+}
+
+// put returns an instance to the pool
+func (w *wasmDetector) put(instance api.Module) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		instance.Close(context.Background())
+		return
+	}
+	w.instances = append(w.instances, instance)
+}
+
+// spans returns the byte ranges of line the plugin detects, sorted and
+// without overlaps
+func (w *wasmDetector) spans(line string) ([][2]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginCallTimeout)
+	defer cancel()
+	instance, err := w.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	spans, err := w.call(ctx, instance, line)
+	if err != nil {
+		// A trap or timeout may leave the instance broken
+		instance.Close(context.Background())
+		return nil, err
+	}
+	w.put(instance)
+	return spans, nil
+}
+
+func (w *wasmDetector) call(ctx context.Context, instance api.Module, line string) ([][2]int, error) {
+	memory := instance.Memory()
+	results, err := instance.ExportedFunction(pluginAlloc).Call(ctx, uint64(len(line)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(results[0])
+	if !memory.Write(ptr, []byte(line)) {
+		return nil, fmt.Errorf("%s returned an address outside memory", pluginAlloc)
+	}
+	results, err = instance.ExportedFunction(pluginDetect).Call(ctx, uint64(ptr), uint64(len(line)))
+	if err != nil {
+		return nil, err
+	}
+	at, count := uint32(results[0]>>32), uint32(results[0])
+	var spans [][2]int
+	if count > 0 {
+		raw, ok := memory.Read(at, count*8)
+		if !ok {
+			return nil, fmt.Errorf("%s returned spans outside memory", pluginDetect)
+		}
+		spans = make([][2]int, 0, count)
+		for i := uint32(0); i < count; i++ {
+			start := int(binary.LittleEndian.Uint32(raw[i*8:]))
+			end := int(binary.LittleEndian.Uint32(raw[i*8+4:]))
+			if start > end || end > len(line) {
+				return nil, fmt.Errorf("%s returned span [%d, %d) outside a %d byte line", pluginDetect, start, end, len(line))
+			}
+			if start < end {
+				spans = append(spans, [2]int{start, end})
+			}
+		}
+	}
+	if free := instance.ExportedFunction(pluginFree); free != nil {
+		if _, err := free.Call(ctx, uint64(ptr), uint64(len(line))); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	kept := spans[:0]
+	for _, s := range spans {
+		if n := len(kept); n > 0 && s[0] < kept[n-1][1] {
+			if s[1] > kept[n-1][1] {
+				kept[n-1][1] = s[1]
+			}
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept, nil
+}
+
+// Close releases the plugin's instances and compiled code
+func (w *wasmDetector) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.instances = nil
+	w.mu.Unlock()
+	return w.runtime.Close(context.Background())
+}
+
+// pluginDetectors loads plugins as detectors, which must not share the
+// name of another in detectors
+func pluginDetectors(detectors []detector, plugins []Plugin) ([]detector, []*wasmDetector, error) {
+	var loaded []*wasmDetector
+	fail := func(err error) ([]detector, []*wasmDetector, error) {
+		for _, w := range loaded {
+			w.Close()
+		}
+		return nil, nil, err
+	}
+	for _, p := range plugins {
+		for _, d := range detectors {
+			if d.name == p.Name {
+				return fail(fmt.Errorf("plugin %s: a rule of that name is already selected", p.Name))
+			}
+		}
+		w, err := loadPlugin(context.Background(), p)
+		if err != nil {
+			return fail(err)
+		}
+		loaded = append(loaded, w)
+		detectors = append(detectors, detector{name: p.Name, plugin: w})
+	}
+	return detectors, loaded, nil
+}
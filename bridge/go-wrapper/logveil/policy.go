@@ -0,0 +1,174 @@
+package logveil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// DefaultPolicyQuery is the decision a policy is asked for by default
+const DefaultPolicyQuery = "data.logveil.decision"
+
+// maxPolicyDecisions bounds the decisions remembered before they are
+// forgotten and asked for again
+const maxPolicyDecisions = 10000
+
+// PolicyOptions delegates whether, and how, each match is redacted to an
+// OPA policy, so that compliance can own that policy apart from the rules.
+// The query is evaluated with the input
+//
+//	{"rule": "email", "severity": "high", "field": "user.email",
+//	 "source": "/var/log/app.log", "tenant": "acme"}
+//
+// where field is the path of the structured field the match is in, empty
+// for free text, and source and tenant are empty when unknown. It may
+// yield a bool, false keeping the match, or an object such as
+// {"redact": true, "strategy": "mask"} choosing its replacement from the
+// rule strategies; an undefined decision redacts as the rule would. The
+// matched text is not part of the input, so decisions are remembered per
+// input and the policy runs once for each.
+type PolicyOptions struct {
+	// Paths are Rego files, and JSON or YAML data files, or directories
+	// of them
+	Paths []string
+	// Query is the decision evaluated; DefaultPolicyQuery when empty
+	Query string
+}
+
+// policyInput is the input a policy decides on
+type policyInput struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Field    string `json:"field"`
+	Source   string `json:"source"`
+	Tenant   string `json:"tenant"`
+}
+
+// policyDecision is what a policy decided for one input. A nil replacer
+// redacts as the rule would.
+type policyDecision struct {
+	redact   bool
+	replacer replacer
+}
+
+// redactionPolicy is a prepared policy query and the decisions it made
+type redactionPolicy struct {
+	query    rego.PreparedEvalQuery
+	fakeSeed string
+	// digest hashes the policy files, for the result cache
+	digest string
+
+	mu        sync.Mutex
+	decisions map[policyInput]policyDecision
+}
+
+// loadPolicy compiles the policy opts configures
+func loadPolicy(opts PolicyOptions, fakeSeed string) (*redactionPolicy, error) {
+	if len(opts.Paths) == 0 {
+		return nil, nil
+	}
+	query := opts.Query
+	if query == "" {
+		query = DefaultPolicyQuery
+	}
+	prepared, err := rego.New(rego.Query(query), rego.Load(opts.Paths, nil)).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("policy: %v", err)
+	}
+	digest, err := policyDigest(opts.Paths)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %v", err)
+	}
+	return &redactionPolicy{query: prepared, fakeSeed: fakeSeed, digest: digest, decisions: make(map[policyInput]policyDecision)}, nil
+}
+
+// policyDigest hashes the names and contents of the files in paths
+func policyDigest(paths []string) (string, error) {
+	h := sha256.New()
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00", path, len(data))
+			h.Write(data)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// decide returns the decision for a match of d in scope
+func (p *redactionPolicy) decide(d detector, scope *matchScope) policyDecision {
+	severity := d.severity
+	if severity == 0 {
+		severity = builtinSeverity(d.name)
+	}
+	input := policyInput{Rule: d.name, Severity: severity.String()}
+	if scope != nil {
+		input.Field, input.Source, input.Tenant = scope.path, scope.source, scope.tenant
+	}
+
+	p.mu.Lock()
+	decision, ok := p.decisions[input]
+	p.mu.Unlock()
+	if ok {
+		return decision
+	}
+	decision = p.evaluate(input)
+	p.mu.Lock()
+	if len(p.decisions) >= maxPolicyDecisions {
+		clear(p.decisions)
+	}
+	p.decisions[input] = decision
+	p.mu.Unlock()
+	return decision
+}
+
+// evaluate asks the policy about input. A policy that fails, or decides
+// something other than a bool or decision object, redacts as the rule
+// would, so that a broken policy does not leak what the rules matched.
+func (p *redactionPolicy) evaluate(input policyInput) policyDecision {
+	fallback := policyDecision{redact: true}
+	results, err := p.query.Eval(context.Background(), rego.EvalInput(input))
+	if err != nil || len(results) == 0 || len(results[0].Expressions) == 0 {
+		return fallback
+	}
+	switch value := results[0].Expressions[0].Value.(type) {
+	case bool:
+		return policyDecision{redact: value}
+	case map[string]any:
+		decision := fallback
+		if redact, ok := value["redact"].(bool); ok {
+			decision.redact = redact
+		} else if _, set := value["redact"]; set {
+			return fallback
+		}
+		if name, ok := value["strategy"].(string); ok && name != "" {
+			repl, err := newStrategy(name)
+			if err != nil {
+				return fallback
+			}
+			if _, ok := repl.(fakeReplacer); ok {
+				repl = newFakeReplacer(p.fakeSeed)
+			}
+			decision.replacer = repl
+		}
+		return decision
+	}
+	return fallback
+}
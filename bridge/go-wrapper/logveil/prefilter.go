@@ -0,0 +1,411 @@
+package logveil
+
+import (
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on the literal sets requiredLiterals derives
+const (
+	// maxClassExpansion is the largest character class expanded into
+	// alternative literals, as in A[KS]IA
+	maxClassExpansion = 10
+	// maxLiteralSet is the most alternative literals kept for one pattern
+	maxLiteralSet = 64
+	// minClassRun is the shortest run of a character class worth checking
+	minClassRun = 2
+)
+
+// prefilter finds, in one pass over a line, which detectors could match
+// it. A detector whose pattern requires one of a set of literals, such as
+// "@" for email or "akia" for aws_access_key, only runs on lines holding
+// one of them, matched ignoring ASCII case with an Aho-Corasick automaton.
+// A detector whose pattern requires a run of characters, such as the 32
+// alphanumerics of api_key, only runs on lines holding such a run.
+// Detectors requiring neither always run.
+type prefilter struct {
+	detectors int
+	// always marks the detectors without required literals
+	always []bool
+	// next is the automaton's transition table, by state and input byte
+	next [][256]int32
+	// out lists the detectors a literal ending in each state belongs to
+	out  [][]int
+	runs []classRun
+}
+
+// classRun requires at least min consecutive bytes of class in a line
+// before detector runs
+type classRun struct {
+	detector int
+	class    *[256]bool
+	min      int
+}
+
+// newPrefilter builds a prefilter for detectors, or returns nil when no
+// detector has any requirement to check
+func newPrefilter(detectors []detector) *prefilter {
+	p := &prefilter{detectors: len(detectors), always: make([]bool, len(detectors))}
+	// The trie, before failure links complete it into the automaton
+	p.next = append(p.next, [256]int32{})
+	p.out = append(p.out, nil)
+	useful := false
+	for i, d := range detectors {
+		if d.pattern == nil {
+			// Plugins have no pattern to require anything of lines
+			p.always[i] = true
+			continue
+		}
+		re, err := syntax.Parse(d.pattern.String(), syntax.Perl)
+		if err != nil {
+			p.always[i] = true
+			continue
+		}
+		if class, n := requiredRun(re); class != nil && n >= minClassRun {
+			p.runs = append(p.runs, classRun{detector: i, class: class, min: n})
+			useful = true
+		}
+		literals := requiredLiterals(re)
+		if literals == nil {
+			p.always[i] = true
+			continue
+		}
+		useful = true
+		for _, literal := range literals {
+			state := int32(0)
+			for j := 0; j < len(literal); j++ {
+				c := literal[j]
+				if p.next[state][c] == 0 {
+					p.next = append(p.next, [256]int32{})
+					p.out = append(p.out, nil)
+					p.next[state][c] = int32(len(p.next) - 1)
+				}
+				state = p.next[state][c]
+			}
+			p.out[state] = append(p.out[state], i)
+		}
+	}
+	if !useful {
+		return nil
+	}
+
+	// Breadth first, point missing transitions where the failure link's go
+	// and inherit the failure state's outputs
+	fail := make([]int32, len(p.next))
+	var queue []int32
+	for c := 0; c < 256; c++ {
+		if s := p.next[0][c]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		p.out[state] = append(p.out[state], p.out[fail[state]]...)
+		for c := 0; c < 256; c++ {
+			if s := p.next[state][c]; s != 0 {
+				fail[s] = p.next[fail[state]][c]
+				queue = append(queue, s)
+			} else {
+				p.next[state][c] = p.next[fail[state]][c]
+			}
+		}
+	}
+	return p
+}
+
+// candidates reports which detectors could match line
+func (p *prefilter) candidates(line string) []bool {
+	run := make([]bool, p.detectors)
+	copy(run, p.always)
+	state := int32(0)
+	folds := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		folds = folds || c == 0xC5 || c == 0xE2
+		state = p.next[state][c]
+		for _, d := range p.out[state] {
+			run[d] = true
+		}
+	}
+	if folds && strings.ContainsAny(line, "\u017F\u212A") {
+		// Case-insensitive s and k also match ſ and the Kelvin sign, which
+		// the ASCII scan does not fold
+		for i := range run {
+			run[i] = true
+		}
+	}
+	for _, r := range p.runs {
+		if run[r.detector] && !r.in(line) {
+			run[r.detector] = false
+		}
+	}
+	return run
+}
+
+// in reports whether line holds the run r requires
+func (r classRun) in(line string) bool {
+	n := 0
+	for i := 0; i < len(line); i++ {
+		if !r.class[line[i]] {
+			n = 0
+			continue
+		}
+		if n++; n >= r.min {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredLiterals returns literals, lower-cased, one of which every
+// match of re contains, or nil when it cannot tell
+func requiredLiterals(re *syntax.Regexp) []string {
+	if exact := exactLiterals(re); exact != nil {
+		return exact
+	}
+	switch re.Op {
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpAlternate:
+		var union []string
+		for _, sub := range re.Sub {
+			literals := requiredLiterals(sub)
+			if literals == nil {
+				return nil
+			}
+			union = append(union, literals...)
+		}
+		if len(union) > maxLiteralSet {
+			return nil
+		}
+		return union
+	case syntax.OpConcat:
+		// Runs of exactly known parts multiply out into longer literals;
+		// the best of those and of the other parts is kept
+		var best []string
+		consider := func(literals []string) {
+			if betterLiterals(literals, best) {
+				best = literals
+			}
+		}
+		run := []string{""}
+		flush := func() {
+			if run[0] != "" {
+				consider(run)
+			}
+			run = []string{""}
+		}
+		for _, sub := range re.Sub {
+			exact := exactLiterals(sub)
+			if exact == nil || len(run)*len(exact) > maxLiteralSet {
+				flush()
+				if exact == nil {
+					consider(requiredLiterals(sub))
+					continue
+				}
+			}
+			run = product(run, exact)
+		}
+		flush()
+		return best
+	}
+	return nil
+}
+
+// exactLiterals returns every string re matches, lower-cased, when those
+// are few and known, as for a literal, a small character class or an
+// alternation of them; otherwise nil
+func exactLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		literal := make([]byte, 0, len(re.Rune))
+		for _, r := range re.Rune {
+			if r >= utf8.RuneSelf && re.Flags&syntax.FoldCase != 0 {
+				// Unicode case folding is beyond the ASCII-only scan
+				return nil
+			}
+			literal = utf8.AppendRune(literal, lowerASCII(r))
+		}
+		return []string{string(literal)}
+	case syntax.OpCharClass:
+		seen := make(map[rune]bool)
+		var literals []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			low, high := re.Rune[i], re.Rune[i+1]
+			if high >= utf8.RuneSelf || high-low >= maxClassExpansion {
+				return nil
+			}
+			for r := low; r <= high; r++ {
+				if r := lowerASCII(r); !seen[r] {
+					seen[r] = true
+					literals = append(literals, string(r))
+				}
+			}
+		}
+		if len(literals) == 0 || len(literals) > maxClassExpansion {
+			return nil
+		}
+		return literals
+	case syntax.OpCapture:
+		return exactLiterals(re.Sub[0])
+	case syntax.OpAlternate:
+		var union []string
+		for _, sub := range re.Sub {
+			exact := exactLiterals(sub)
+			if exact == nil || len(union)+len(exact) > maxLiteralSet {
+				return nil
+			}
+			union = append(union, exact...)
+		}
+		return union
+	case syntax.OpConcat:
+		literals := []string{""}
+		for _, sub := range re.Sub {
+			exact := exactLiterals(sub)
+			if exact == nil || len(literals)*len(exact) > maxLiteralSet {
+				return nil
+			}
+			literals = product(literals, exact)
+		}
+		return literals
+	}
+	return nil
+}
+
+// product returns every prefix followed by every suffix
+func product(prefixes, suffixes []string) []string {
+	out := make([]string, 0, len(prefixes)*len(suffixes))
+	for _, prefix := range prefixes {
+		for _, suffix := range suffixes {
+			out = append(out, prefix+suffix)
+		}
+	}
+	return out
+}
+
+// requiredRun returns a class of bytes and a length such that every match
+// of re holds that many consecutive bytes of the class, or a nil class when
+// it cannot tell
+func requiredRun(re *syntax.Regexp) (*[256]bool, int) {
+	switch re.Op {
+	case syntax.OpLiteral, syntax.OpCharClass:
+		if class := classBytes(re); class != nil {
+			return class, 1
+		}
+	case syntax.OpCapture:
+		return requiredRun(re.Sub[0])
+	case syntax.OpPlus, syntax.OpRepeat:
+		if re.Op == syntax.OpRepeat && re.Min < 1 {
+			return nil, 0
+		}
+		min := 1
+		if re.Op == syntax.OpRepeat {
+			min = re.Min
+		}
+		if class := classBytes(re.Sub[0]); class != nil {
+			return class, min
+		}
+		return requiredRun(re.Sub[0])
+	case syntax.OpAlternate:
+		// Any branch's run is a run of the union of their classes
+		var union [256]bool
+		shortest := 0
+		for i, sub := range re.Sub {
+			class, n := requiredRun(sub)
+			if class == nil {
+				return nil, 0
+			}
+			for c, in := range class {
+				union[c] = union[c] || in
+			}
+			if i == 0 || n < shortest {
+				shortest = n
+			}
+		}
+		return &union, shortest
+	case syntax.OpConcat:
+		var best *[256]bool
+		longest := 0
+		for _, sub := range re.Sub {
+			if class, n := requiredRun(sub); class != nil && n > longest {
+				best, longest = class, n
+			}
+		}
+		return best, longest
+	}
+	return nil, 0
+}
+
+// classBytes returns the bytes a single-character node may start with,
+// counting every non-ASCII byte in when it may match a non-ASCII rune, or
+// nil when re is not a single character
+func classBytes(re *syntax.Regexp) *[256]bool {
+	var class [256]bool
+	add := func(low, high rune) {
+		for r := low; r <= high && r < utf8.RuneSelf; r++ {
+			class[r] = true
+		}
+		if high >= utf8.RuneSelf {
+			for c := utf8.RuneSelf; c < 256; c++ {
+				class[c] = true
+			}
+		}
+	}
+	switch {
+	case re.Op == syntax.OpLiteral && len(re.Rune) == 1:
+		r := re.Rune[0]
+		add(r, r)
+		if re.Flags&syntax.FoldCase != 0 {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				add(f, f)
+			}
+		}
+	case re.Op == syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			add(re.Rune[i], re.Rune[i+1])
+		}
+	default:
+		return nil
+	}
+	return &class
+}
+
+// betterLiterals reports whether a filters lines better than b: its
+// shortest literal is longer, or as long with fewer alternatives
+func betterLiterals(a, b []string) bool {
+	if a == nil {
+		return false
+	}
+	if b == nil {
+		return true
+	}
+	shortest := func(literals []string) int {
+		n := len(literals[0])
+		for _, l := range literals[1:] {
+			n = min(n, len(l))
+		}
+		return n
+	}
+	if sa, sb := shortest(a), shortest(b); sa != sb {
+		return sa > sb
+	}
+	return len(a) < len(b)
+}
+
+// lowerASCII lower-cases an ASCII letter
+func lowerASCII(r rune) rune {
+	if 'A' <= r && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
@@ -0,0 +1,28 @@
+//go:build !unix
+
+package logveil
+
+import (
+	"os"
+	"os/exec"
+)
+
+// startInGroup leaves cmd as is where process groups aren't available
+func startInGroup(cmd *exec.Cmd) {}
+
+// interruptGroup kills cmd's process, since it cannot be interrupted here
+func interruptGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killGroup kills cmd's process
+func killGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// killedOutright reports false, since how a process ended isn't known here
+func killedOutright(state *os.ProcessState) bool {
+	return false
+}
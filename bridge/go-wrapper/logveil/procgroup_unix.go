@@ -0,0 +1,35 @@
+//go:build unix
+
+package logveil
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startInGroup makes cmd the leader of its own process group, so anything
+// the agent starts is stopped with it and a terminal's Ctrl-C reaches only
+// this process, which then decides how the agent is stopped
+func startInGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interruptGroup sends SIGINT to cmd's process group, which the agent
+// handles by closing its output and exiting
+func interruptGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
+
+// killGroup kills every process left in cmd's process group
+func killGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// killedOutright reports whether the process was ended by SIGKILL
+func killedOutright(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGKILL
+}
@@ -0,0 +1,142 @@
+package logveil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile is a curated selection of built-in detectors covering the data
+// a compliance regime protects. Categories document which rules cover
+// which kind of data, and are reported by Scan.
+type Profile struct {
+	Name        string
+	Description string
+	// Rules are the detectors and rule packs the profile selects
+	Rules      []string
+	Categories []ProfileCategory
+}
+
+// ProfileCategory is one kind of protected data and the rules covering it
+type ProfileCategory struct {
+	Name  string
+	Rules []string
+}
+
+// profiles are selectable by name with Config.Profile. Each keeps the
+// default pack, since credentials and contact details matter under all of
+// them.
+var profiles = map[string]Profile{
+	"gdpr": {
+		Name:        "gdpr",
+		Description: "Personal data of EU data subjects (GDPR Art. 4 and 9)",
+		Rules:       []string{"default", "eu-personal", "pan"},
+		Categories: []ProfileCategory{
+			{Name: "Contact details", Rules: []string{"email", "phone", "intl_phone"}},
+			{Name: "Online identifiers", Rules: []string{"ip_address", "uuid"}},
+			{Name: "National identity numbers", Rules: []string{"uk_nino", "es_dni", "it_codice_fiscale", "fr_insee", "ssn", "passport_number"}},
+			{Name: "Financial data", Rules: []string{"iban", "eu_vat_number", "pan", "credit_card"}},
+			{Name: "Credentials", Rules: []string{"password", "api_key", "bearer_token", "jwt", "private_key", "aws_access_key", "aws_secret_key"}},
+		},
+	},
+	"hipaa": {
+		Name:        "hipaa",
+		Description: "Protected health information under the HIPAA Safe Harbor identifiers (45 CFR 164.514(b)(2))",
+		Rules:       []string{"default", "health"},
+		Categories: []ProfileCategory{
+			{Name: "Contact details", Rules: []string{"email", "phone"}},
+			{Name: "Dates", Rules: []string{"date_of_birth"}},
+			{Name: "Social security numbers", Rules: []string{"ssn"}},
+			{Name: "Medical record numbers", Rules: []string{"medical_record_number"}},
+			{Name: "Health plan beneficiary numbers", Rules: []string{"health_plan_id", "medicare_mbi"}},
+			{Name: "Provider identifiers", Rules: []string{"npi", "dea_number"}},
+			{Name: "Diagnoses", Rules: []string{"diagnosis_code"}},
+			{Name: "Account numbers", Rules: []string{"credit_card"}},
+			{Name: "Device and network identifiers", Rules: []string{"ip_address", "uuid"}},
+			{Name: "Credentials", Rules: []string{"password", "api_key", "bearer_token", "jwt", "private_key", "aws_access_key", "aws_secret_key"}},
+		},
+	},
+	"pci": {
+		Name:        "pci",
+		Description: "Cardholder and sensitive authentication data under PCI DSS v4 requirement 3",
+		Rules:       []string{"default", "pci", "card-data"},
+		Categories: []ProfileCategory{
+			{Name: "Primary account numbers", Rules: []string{"pan", "credit_card"}},
+			{Name: "Magnetic stripe data", Rules: []string{"card_track_data"}},
+			{Name: "Card verification codes", Rules: []string{"card_verification_code"}},
+			{Name: "Expiration dates", Rules: []string{"card_expiry"}},
+			{Name: "Cardholder contact details", Rules: []string{"email", "phone"}},
+			{Name: "Credentials", Rules: []string{"password", "api_key", "bearer_token", "jwt", "private_key", "aws_access_key", "aws_secret_key"}},
+		},
+	},
+}
+
+// LookupProfile returns the named compliance profile
+func LookupProfile(name string) (Profile, error) {
+	profile, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %q (expected %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// ProfileNames returns the names of the compliance profiles, sorted
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileCoverage reports what a scan under a profile looked for
+type ProfileCoverage struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Categories  []CategoryCoverage `json:"categories"`
+}
+
+// CategoryCoverage lists the rules active for one profile category and
+// what they found. A category without active rules, because a rules file
+// disabled them, is not covered.
+type CategoryCoverage struct {
+	Name     string   `json:"name"`
+	Rules    []string `json:"rules"`
+	Covered  bool     `json:"covered"`
+	Findings int      `json:"findings"`
+}
+
+// coverage reports p's categories limited to the rules active is true for,
+// with the findings counted in detections
+func (p Profile) coverage(active func(rule string) bool, detections map[string]int) *ProfileCoverage {
+	report := &ProfileCoverage{Name: p.Name, Description: p.Description}
+	for _, category := range p.Categories {
+		c := CategoryCoverage{Name: category.Name, Rules: []string{}}
+		for _, rule := range category.Rules {
+			if active(rule) {
+				c.Rules = append(c.Rules, rule)
+				c.Findings += detections[rule]
+			}
+		}
+		c.Covered = len(c.Rules) > 0
+		report.Categories = append(report.Categories, c)
+	}
+	return report
+}
+
+// hasRule reports whether the native engine behind r runs the named rule
+func (r *Redactor) hasRule(rule string) bool {
+	engine := r.engine
+	if f, ok := engine.(*formatEngine); ok {
+		engine = f.Engine
+	}
+	if native, ok := engine.(*NativeEngine); ok {
+		for _, d := range native.detectors {
+			if d.name == rule {
+				return true
+			}
+		}
+	}
+	return false
+}
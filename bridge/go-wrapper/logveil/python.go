@@ -0,0 +1,436 @@
+package logveil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultPython is the interpreter used to run the agent
+	defaultPython = "python3"
+	// defaultAgent is the agent script relative to bridge/go-wrapper
+	defaultAgent = "../cli/logveil_agent.py"
+	// agentInterruptGrace is how long an interrupted agent may take to close
+	// its output and exit before it is killed
+	agentInterruptGrace = 5 * time.Second
+)
+
+// agentBootstrap runs the agent source it is handed on fd 3 as the
+// interpreter would run the script at its path, the first argument: with
+// the same sys.argv, __file__ and sys.path[0]
+const agentBootstrap = `import os, sys
+with os.fdopen(3, "rb") as f:
+    source = f.read()
+sys.argv = sys.argv[1:]
+sys.path[0] = os.path.dirname(os.path.abspath(sys.argv[0]))
+__file__ = sys.argv[0]
+exec(compile(source, __file__, "exec"))
+`
+
+// agentSearchPaths are where the bundled agent is looked for, relative to
+// the directory of the executable and then the working directory: next to
+// bridge/go-wrapper, from a source checkout, and from an installed package
+// that keeps the bridge inside it
+var agentSearchPaths = []string{
+	defaultAgent,
+	"../../logveil/cli/logveil_agent.py",
+	"../../cli/logveil_agent.py",
+}
+
+// PythonOptions controls how the Python agent is launched
+type PythonOptions struct {
+	// Interpreter is the Python executable, python3 from PATH if empty. A
+	// relative path is resolved like Agent.
+	Interpreter string
+	// Agent is the path to logveil_agent.py, the bundled agent if empty. A
+	// relative path that does not exist from the working directory is
+	// resolved against the directory of the executable.
+	Agent string
+	// Persistent starts the agent once in --worker mode and reuses it for
+	// every file and line instead of spawning it per file
+	Persistent bool
+	// AgentSHA256, when set, is the hex SHA-256 the agent script must have.
+	// The script is read and checked every time the agent starts, refused
+	// on a mismatch, and the bytes checked are what the interpreter runs;
+	// the modules it imports are not covered.
+	AgentSHA256 string
+	// Sandbox limits the resources and system calls of each agent process
+	Sandbox SandboxOptions
+	// Retry repeats an agent run that failed transiently, such as by the
+	// OOM killer; per-file runs only
+	Retry RetryOptions
+	// NoFallback makes a missing interpreter or agent an error instead of
+	// falling back to the native engine
+	NoFallback bool
+}
+
+// agentChecksum returns the pinned checksum in opts, lower-cased and
+// without an optional sha256: prefix, after checking it is well formed
+func (opts PythonOptions) agentChecksum() (string, error) {
+	sum := strings.ToLower(strings.TrimSpace(opts.AgentSHA256))
+	sum = strings.TrimPrefix(sum, "sha256:")
+	if sum == "" {
+		return "", nil
+	}
+	if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid agent checksum %q (expected %d hex digits)", opts.AgentSHA256, 2*sha256.Size)
+	}
+	return sum, nil
+}
+
+// PythonEngine delegates redaction to the Python agent in a subprocess
+type PythonEngine struct {
+	interpreter string
+	agent       string
+	// worker, when set, serves every line from one long-lived agent
+	// process instead of spawning the agent once per file
+	worker *pythonWorker
+	// checksum, when set, is the hex SHA-256 the agent must have
+	checksum string
+	sandbox  SandboxOptions
+	retry    RetryOptions
+	// failures counts agent runs that failed other than by cancellation
+	failures atomic.Int64
+}
+
+// NewPythonEngine returns a PythonEngine configured by opts
+func NewPythonEngine(opts PythonOptions) (*PythonEngine, error) {
+	checksum, err := opts.agentChecksum()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSandbox(opts.Sandbox); err != nil {
+		return nil, err
+	}
+	e := &PythonEngine{
+		interpreter: opts.Interpreter,
+		agent:       resolvePath(opts.Agent),
+		checksum:    checksum,
+		sandbox:     opts.Sandbox,
+		retry:       opts.Retry,
+	}
+	switch {
+	case e.interpreter == "":
+		e.interpreter = defaultPython
+	case filepath.Base(e.interpreter) != e.interpreter:
+		// A bare name is looked up in PATH instead
+		e.interpreter = resolvePath(e.interpreter)
+	}
+	if e.agent == "" {
+		e.agent = findAgent()
+	}
+	if opts.Persistent {
+		e.worker = newPythonWorker(func() (*exec.Cmd, func(), error) {
+			source, err := e.agentSource()
+			if err != nil {
+				return nil, nil, err
+			}
+			return e.agentCommand(context.Background(), source, "--worker", "--quiet")
+		})
+		e.worker.sandbox = opts.Sandbox
+	}
+	return e, nil
+}
+
+// PythonAgent is the interpreter and agent script a PythonEngine runs
+type PythonAgent struct {
+	Interpreter string `json:"interpreter"`
+	Agent       string `json:"agent"`
+	// SHA256 is the hex digest of the agent script
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// LocatePythonAgent returns the interpreter and agent opts resolve to, as
+// NewPythonEngine finds them, and why they cannot run, if they cannot
+func LocatePythonAgent(opts PythonOptions) (PythonAgent, error) {
+	opts.Persistent = false
+	e, err := NewPythonEngine(opts)
+	if err != nil {
+		return PythonAgent{}, err
+	}
+	agent := PythonAgent{Interpreter: e.interpreter, Agent: e.agent}
+	if err := e.available(); err != nil {
+		return agent, err
+	}
+	if agent.SHA256, err = fileSHA256(e.agent); err != nil {
+		return agent, err
+	}
+	return agent, e.verifyAgent()
+}
+
+// available reports why the agent cannot run, or nil if its interpreter
+// and script are both present
+func (e *PythonEngine) available() error {
+	if _, err := exec.LookPath(e.interpreter); err != nil {
+		return fmt.Errorf("interpreter %s not found", e.interpreter)
+	}
+	if info, err := os.Stat(e.agent); err != nil || info.IsDir() {
+		return fmt.Errorf("agent %s not found", e.agent)
+	}
+	return nil
+}
+
+// agentSource reads the agent script and refuses it if its SHA-256 differs
+// from the pinned checksum, so a tampered agent cannot see unredacted
+// input. The bytes it returns are the ones agentCommand runs, so the file
+// cannot be swapped after the check. It returns nil when no checksum is
+// pinned, to run the agent from its path.
+func (e *PythonEngine) agentSource() ([]byte, error) {
+	if e.checksum == "" {
+		return nil, nil
+	}
+	source, err := os.ReadFile(e.agent)
+	if err != nil {
+		return nil, newError(CodeIO, StageSetup, "verify agent: %v", err)
+	}
+	sum := sha256.Sum256(source)
+	if got := hex.EncodeToString(sum[:]); got != e.checksum {
+		return nil, newError(CodeIntegrity, StageSetup, "agent %s does not match the pinned checksum: sha256 is %s, expected %s", e.agent, got, e.checksum)
+	}
+	return source, nil
+}
+
+// verifyAgent reports whether the agent script on disk still matches the
+// pinned checksum; it is a no-op when none is pinned
+func (e *PythonEngine) verifyAgent() error {
+	_, err := e.agentSource()
+	return err
+}
+
+// agentCommand returns the command running the agent with args: from its
+// path, or when source is set, from source handed to agentBootstrap in an
+// unlinked temporary file. The returned func closes that file once the
+// command has started.
+func (e *PythonEngine) agentCommand(ctx context.Context, source []byte, args ...string) (*exec.Cmd, func(), error) {
+	if source == nil {
+		return exec.CommandContext(ctx, e.interpreter, append([]string{e.agent}, args...)...), func() {}, nil
+	}
+	f, err := os.CreateTemp("", "logveil-agent-")
+	if err != nil {
+		return nil, nil, newError(CodeIO, StageSetup, "stage agent: %v", err)
+	}
+	os.Remove(f.Name())
+	if _, err := f.Write(source); err != nil {
+		f.Close()
+		return nil, nil, newError(CodeIO, StageSetup, "stage agent: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, newError(CodeIO, StageSetup, "stage agent: %v", err)
+	}
+	cmd := exec.CommandContext(ctx, e.interpreter, append([]string{"-c", agentBootstrap, e.agent}, args...)...)
+	cmd.ExtraFiles = []*os.File{f}
+	return cmd, func() { f.Close() }, nil
+}
+
+// executableDir returns the directory of the running executable, with
+// symlinks resolved, or "" if it cannot be determined
+func executableDir() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return filepath.Dir(exe)
+}
+
+// resolvePath returns path as is unless it is relative and missing from the
+// working directory but present next to the executable
+func resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	if dir := executableDir(); dir != "" {
+		candidate := filepath.Join(dir, path)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return path
+}
+
+// findAgent returns the first of agentSearchPaths that exists relative to
+// the executable, so the binary works from any working directory, or
+// failing that relative to the working directory, which covers `go run`.
+// When none exists the default is returned for the interpreter to report.
+func findAgent() string {
+	var dirs []string
+	if dir := executableDir(); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, ".")
+	for _, dir := range dirs {
+		for _, rel := range agentSearchPaths {
+			candidate := filepath.Join(dir, rel)
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate
+			}
+		}
+	}
+	return defaultAgent
+}
+
+func (e *PythonEngine) Name() string {
+	return "python"
+}
+
+// RedactLine sends line to the persistent worker, or otherwise stages it
+// through temporary files since the agent only accepts file paths
+func (e *PythonEngine) RedactLine(ctx context.Context, line string) (string, []Detection, error) {
+	if e.worker != nil {
+		redacted, err := e.worker.redact(ctx, line)
+		return redacted, nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "logveil-line-")
+	if err != nil {
+		return "", nil, newError(CodeIO, StageSetup, "create staging dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inputPath := filepath.Join(dir, "input.log")
+	outputPath := filepath.Join(dir, "output.log")
+	if err := os.WriteFile(inputPath, []byte(line+"\n"), 0o600); err != nil {
+		return "", nil, newError(CodeIO, StageRead, "stage line: %v", err)
+	}
+
+	if _, err := e.ProcessFile(ctx, inputPath, outputPath); err != nil {
+		return "", nil, err
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		return "", nil, newError(CodeIO, StageWrite, "read redacted line: %v", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil, nil
+}
+
+func (e *PythonEngine) ProcessFile(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error) {
+	if e.worker != nil {
+		return processFileByLine(ctx, e, inputPath, outputPath)
+	}
+
+	startTime := time.Now()
+	source, err := e.agentSource()
+	if err != nil {
+		return failedResult(err)
+	}
+
+	// Run the agent, again after a transient failure if retries allow
+	var output []byte
+	var attempts []Attempt
+	var cpu time.Duration
+	for attempt := 1; ; attempt++ {
+		attemptStart := time.Now()
+		var used time.Duration
+		output, used, err = e.runAgent(ctx, source, inputPath, outputPath)
+		cpu += used
+		if e.retry.Attempts > 1 {
+			record := Attempt{Attempt: attempt, Duration: time.Since(attemptStart).String()}
+			if err != nil {
+				record.Error = err.Error()
+			}
+			attempts = append(attempts, record)
+		}
+		if err == nil || attempt >= e.retry.Attempts || ctx.Err() != nil || !transientFailure(err) {
+			break
+		}
+		e.failures.Add(1)
+		if !sleepContext(ctx, e.retry.delay(attempt)) {
+			break
+		}
+	}
+
+	result := &ProcessResult{Success: err == nil, Attempts: attempts, SubprocessCPUSeconds: cpu.Seconds()}
+	if err == nil {
+		// The agent reports nothing back, so measure what it read and wrote
+		result.LinesProcessed, result.BytesRead, _ = countLines(inputPath)
+		_, result.BytesWritten, _ = countLines(outputPath)
+	} else {
+		// Whatever the agent wrote before it stopped or failed is kept,
+		// marked as incomplete, so a rerun can pick up after it
+		result.LinesProcessed, result.BytesWritten, _ = countLines(outputPath)
+		if written, markErr := appendTruncationMarker(outputPath); markErr == nil {
+			result.BytesWritten += written
+			result.Truncated = true
+		}
+	}
+	result.finish(startTime)
+
+	if err != nil {
+		var failure *ProcessError
+		if ctx.Err() != nil {
+			failure = cancelError(StageAgent, ctx.Err())
+		} else {
+			e.failures.Add(1)
+			failure = newError(CodeSubprocess, StageAgent, "command failed: %v", err)
+			failure.Retryable = transientFailure(err)
+		}
+		failure.Detail = string(output)
+		result.Errors = append(result.Errors, *failure)
+		if failure.Code == CodeSubprocess {
+			// The agent writes in order, so the first line missing from its
+			// output is where it failed
+			result.FailedLines = []RedactedLine{{Number: result.LinesProcessed + 1, Errors: []string{failure.Message}}}
+		}
+		return result, failure
+	}
+
+	return result, nil
+}
+
+// runAgent runs the agent, from source when set, once over inputPath,
+// returning what it printed and the CPU time it used. Cancelling ctx
+// interrupts the agent and everything it started, and kills them if they
+// outlast the grace period.
+func (e *PythonEngine) runAgent(ctx context.Context, source []byte, inputPath, outputPath string) ([]byte, time.Duration, error) {
+	cmd, release, err := e.agentCommand(ctx, source, inputPath, "-o", outputPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+	cmd = sandboxed(cmd, e.sandbox)
+	startInGroup(cmd)
+	cmd.Cancel = func() error { return interruptGroup(cmd) }
+	cmd.WaitDelay = agentInterruptGrace
+
+	// Capture both stdout and stderr
+	output, err := cmd.CombinedOutput()
+	killGroup(cmd)
+	var cpu time.Duration
+	if cmd.ProcessState != nil {
+		cpu = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+	}
+	return output, cpu, err
+}
+
+// SubprocessFailures returns how many agent processes have failed or, with
+// a persistent worker, died or failed to start
+func (e *PythonEngine) SubprocessFailures() int64 {
+	if e.worker != nil {
+		return e.failures.Load() + e.worker.failures.Load()
+	}
+	return e.failures.Load()
+}
+
+// Close stops the persistent worker, if one is running
+func (e *PythonEngine) Close() error {
+	if e.worker == nil {
+		return nil
+	}
+	return e.worker.Close()
+}
@@ -0,0 +1,97 @@
+package logveil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// QuarantineReportSuffix names the report written next to a quarantined
+// input
+const QuarantineReportSuffix = ".error.json"
+
+// QuarantineOptions sets aside the inputs a batch fails to redact, so they
+// are not left unprocessed unnoticed
+type QuarantineOptions struct {
+	// Dir receives each failed input at its absolute path below Dir, with
+	// a QuarantineReportSuffix report beside it; empty disables quarantine
+	Dir string
+	// Move moves failed inputs into Dir rather than copying them
+	Move bool
+}
+
+// quarantineReport explains why an input was quarantined
+type quarantineReport struct {
+	Input  string         `json:"input"`
+	Output string         `json:"output"`
+	Time   time.Time      `json:"time"`
+	Error  string         `json:"error"`
+	Moved  bool           `json:"moved"`
+	Result *ProcessResult `json:"result,omitempty"`
+}
+
+// quarantine sets the input of file aside and reports why, returning where
+// it went
+func (q QuarantineOptions) quarantine(file FileResult) (string, error) {
+	abs, err := filepath.Abs(file.Input)
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(q.Dir, abs[len(filepath.VolumeName(abs)):])
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+
+	if q.Move {
+		if err := os.Rename(abs, dest); err != nil {
+			// Across file systems, copy and remove instead
+			if err := copyQuarantined(abs, dest); err != nil {
+				return "", err
+			}
+			if err := os.Remove(abs); err != nil {
+				return "", err
+			}
+		}
+	} else if err := copyQuarantined(abs, dest); err != nil {
+		return "", err
+	}
+
+	report := quarantineReport{Input: abs, Output: file.Output, Time: time.Now().UTC(), Error: file.Error, Moved: q.Move, Result: file.Result}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(dest+QuarantineReportSuffix, append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("write report: %v", err)
+	}
+	return dest, nil
+}
+
+// copyQuarantined copies path to dest with its mode and times. It is never
+// a hard link, which appends to a live log would reach.
+func copyQuarantined(path, dest string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,718 @@
+// Package logveil exposes the LogVeil Go bridge as an embeddable library
+//
+//	r, err := logveil.NewRedactor(logveil.Config{Engine: "native"})
+//	if err != nil {
+//		return err
+//	}
+//	result, err := r.ProcessFile(ctx, "app.log", "app.redacted.log")
+package logveil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Config selects how a Redactor processes input
+type Config struct {
+	// Engine is "native" (default) or "python"
+	Engine string
+	// Timeout bounds each ProcessFile and ProcessStream call. Zero means
+	// unlimited; TimeoutAuto scales it with the input file size.
+	Timeout time.Duration
+	// Native configures the native engine
+	Native NativeOptions
+	// Profile names a compliance profile, "gdpr", "hipaa" or "pci", whose
+	// rules are selected in addition to Native.Rules and whose coverage is
+	// documented in scan reports. It requires the native engine.
+	Profile string
+	// Format is "text" (default), "json", "docker" (Docker json-file logs),
+	// "journal" (journalctl -o export), "journal-json", "syslog", "logfmt",
+	// "access" (Apache and Nginx common and combined logs), "winevent"
+	// (Windows event logs exported as XML), "cef" (ArcSight), "leef"
+	// (QRadar), "csv" or "har" (HTTP Archive files) and selects how each
+	// line or record is parsed before redaction
+	Format string
+	// JSON configures the json and docker formats
+	JSON JSONOptions
+	// Logfmt configures the logfmt format
+	Logfmt LogfmtOptions
+	// AccessLog configures the access format
+	AccessLog AccessLogOptions
+	// Journal configures the journal and journal-json formats
+	Journal JournalOptions
+	// CEF configures the cef and leef formats
+	CEF CEFOptions
+	// CSV configures the csv format
+	CSV CSVOptions
+	// Multiline groups continuation lines, such as stack traces, with the
+	// line that starts their record so each record is redacted as one. It
+	// requires the native engine.
+	Multiline MultilineOptions
+	// Lines bounds the memory a very long line, such as a minified JSON
+	// blob, takes by redacting it in pieces or truncating it. It requires
+	// an engine that streams lines: native or a persistent python worker.
+	Lines LineOptions
+	// Numeric generalizes or perturbs numeric fields, such as ages and
+	// coordinates, before redaction. It requires the native engine.
+	Numeric []NumericRule
+	// Tokenizer, when set, replaces sensitive values with stable pseudonyms
+	// instead of placeholders. It requires the native engine.
+	Tokenizer *Tokenizer
+	// PreserveFormat replaces sensitive values with substitutes of the same
+	// length and character classes instead of placeholders. It requires the
+	// native engine and excludes Tokenizer.
+	PreserveFormat bool
+	// FakeData replaces sensitive values with plausible fake ones, such as
+	// emails at example.com, seeded from FakeSeed so each value always gets
+	// the same fake. It requires the native engine and excludes Tokenizer
+	// and PreserveFormat.
+	FakeData bool
+	FakeSeed string
+	// PlaceholderTemplate renders placeholders from PlaceholderData, such
+	// as "[{{.Rule}}_{{.Index}}]", numbering the distinct values of each
+	// rule per file or stream. It requires the native engine and excludes
+	// Tokenizer, PreserveFormat and FakeData.
+	PlaceholderTemplate string
+	// CompressOutput is empty for plain output or one of CompressGzip,
+	// CompressZstd and CompressBzip2. Compressed input is always detected
+	// and decompressed transparently.
+	CompressOutput string
+	// Parallel redacts each local file in line-aligned chunks on that many
+	// goroutines, written back in order; zero or one redact sequentially.
+	// Files are redacted sequentially regardless under Resume, by the python
+	// engine, or when multi-line records, a format carrying state from line
+	// to line such as csv, or a PlaceholderTemplate is configured.
+	Parallel int
+	// Mmap reads local input files through memory mappings rather than
+	// buffered reads, sparing copies and garbage on very large files;
+	// pipes and remote inputs are read as usual. A mapped file must not be
+	// truncated while it is read, as under copytruncate log rotation.
+	Mmap bool
+	// CacheFile names a manifest recording every file ProcessBatch redacts
+	// successfully. A file is skipped, its recorded result reported, when
+	// its content hash and the ruleset it was redacted with match the
+	// manifest and its output is unchanged since. Remote inputs are never
+	// cached.
+	CacheFile string
+	// Quarantine sets aside the local inputs ProcessBatch and
+	// ProcessInPlace fail to redact, with a report of why
+	Quarantine QuarantineOptions
+	// Audit records every file and stream redacted in a hash-chained log
+	Audit AuditOptions
+	// Resume makes ProcessFile checkpoint its progress next to the output
+	// and carry on from a checkpoint left by an interrupted run. It needs
+	// local, uncompressed files and an engine that streams lines, and
+	// excludes PlaceholderTemplate, whose numbering a resumed run could not
+	// continue.
+	Resume bool
+	// Python configures the python engine. Call Close when Persistent is
+	// set so the worker process is stopped.
+	Python PythonOptions
+}
+
+// Redactor redacts files and lines using the configured engine. Reload
+// replaces its configuration while it is in use.
+type Redactor struct {
+	mu sync.RWMutex
+	redactorState
+	// uses counts the operations under way on each engine, the current one
+	// and those reloads replaced; it is nil in the copies operations work
+	// on, whose engine the operation holds
+	uses map[Engine]int
+	// retired are the engines reloads replaced that work begun before a
+	// reload is still using; each is closed once that work ends
+	retired []Engine
+	// retiredFailures counts the agent failures of retired engines that
+	// have been closed
+	retiredFailures int64
+	// audit, when set, records every file and stream redacted; reloads
+	// keep it, so that its entries stay one chain
+	audit *auditLog
+}
+
+// redactorState is the configuration of a Redactor. Each operation works
+// on a snapshot of it, which a reload midway leaves as it was.
+type redactorState struct {
+	engine      Engine
+	timeout     time.Duration
+	compression string
+	resume      bool
+	// parallel is the number of goroutines redacting chunks of a file
+	parallel int
+	// mmap maps local input files into memory
+	mmap bool
+	// cache, when set, holds the results of earlier batches, valid for
+	// the configuration fingerprinted by ruleset, which audit entries
+	// also record
+	cache   *resultCache
+	ruleset string
+	// quarantine receives the inputs of failed batch entries
+	quarantine QuarantineOptions
+	// profile is the compliance profile selected, if any
+	profile *Profile
+	// fallback explains why the native engine stands in for the python
+	// one; it is recorded in every result
+	fallback string
+}
+
+// NewRedactor builds a Redactor from cfg
+func NewRedactor(cfg Config) (*Redactor, error) {
+	if cfg.Engine == "" {
+		cfg.Engine = "native"
+	}
+	if err := validateCompression(cfg.CompressOutput); err != nil {
+		return nil, err
+	}
+	if cfg.Tokenizer != nil && cfg.Engine != "native" {
+		return nil, fmt.Errorf("tokenization requires the native engine")
+	}
+	if cfg.PreserveFormat && cfg.Engine != "native" {
+		return nil, fmt.Errorf("format-preserving redaction requires the native engine")
+	}
+	if cfg.FakeData && cfg.Engine != "native" {
+		return nil, fmt.Errorf("fake data requires the native engine")
+	}
+	if len(cfg.Native.Plugins) > 0 && cfg.Engine != "native" {
+		return nil, fmt.Errorf("detector plugins require the native engine")
+	}
+	if len(cfg.Native.Policy.Paths) > 0 && cfg.Engine != "native" {
+		return nil, fmt.Errorf("redaction policies require the native engine")
+	}
+	if cfg.Multiline.Start != "" && cfg.Engine != "native" {
+		return nil, fmt.Errorf("multi-line records require the native engine")
+	}
+	numeric, err := newNumericTransforms(cfg.Numeric)
+	if err != nil {
+		return nil, err
+	}
+	if numeric != nil && cfg.Engine != "native" {
+		return nil, fmt.Errorf("numeric transforms require the native engine")
+	}
+	if cfg.Parallel < 0 {
+		return nil, fmt.Errorf("parallel chunk workers must not be negative: %d", cfg.Parallel)
+	}
+	if err := cfg.Lines.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Lines.MaxBytes > 0 && cfg.Engine == "python" && !cfg.Python.Persistent {
+		return nil, fmt.Errorf("a max line size requires the native engine or a persistent python worker")
+	}
+	if cfg.Python.Sandbox.enabled() && cfg.Engine != "python" {
+		return nil, fmt.Errorf("agent sandboxing applies to the python engine")
+	}
+	if cfg.PlaceholderTemplate != "" && cfg.Engine != "native" {
+		return nil, fmt.Errorf("placeholder templates require the native engine")
+	}
+	if modes := countTrue(cfg.Tokenizer != nil, cfg.PreserveFormat, cfg.FakeData, cfg.PlaceholderTemplate != ""); modes > 1 {
+		return nil, fmt.Errorf("tokenization, format-preserving redaction, fake data and placeholder templates are mutually exclusive")
+	}
+	var profile *Profile
+	if cfg.Profile != "" {
+		if cfg.Engine != "native" {
+			return nil, fmt.Errorf("compliance profiles require the native engine")
+		}
+		selected, err := LookupProfile(cfg.Profile)
+		if err != nil {
+			return nil, err
+		}
+		profile = &selected
+		cfg.Native.Rules = append(append([]string{}, selected.Rules...), cfg.Native.Rules...)
+	}
+	cfg.Native.Tokenizer = cfg.Tokenizer
+	cfg.Native.PreserveFormat = cfg.PreserveFormat
+	cfg.Native.FakeData = cfg.FakeData
+	cfg.Native.FakeSeed = cfg.FakeSeed
+	cfg.Native.PlaceholderTemplate = cfg.PlaceholderTemplate
+
+	engine, err := NewEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Without Python, best-effort redaction beats none at all
+	var fallback string
+	if python, ok := engine.(*PythonEngine); ok && !cfg.Python.NoFallback {
+		if missing := python.available(); missing != nil {
+			if engine, err = NewNativeEngine(cfg.Native); err != nil {
+				return nil, err
+			}
+			fallback = fmt.Sprintf("python engine unavailable: %v; redacted with the native engine instead", missing)
+		}
+	}
+
+	format, err := newFormat(cfg)
+	if err != nil {
+		return nil, err
+	}
+	records, err := newRecordSplit(cfg.Multiline)
+	if err != nil {
+		return nil, err
+	}
+	if rf, ok := format.(recordFormat); ok {
+		if records != nil {
+			return nil, fmt.Errorf("multi-line records cannot be combined with the %s format", cfg.Format)
+		}
+		records = rf.records()
+	}
+	if (records != nil || cfg.Lines.MaxBytes > 0 || numeric != nil) && format == nil {
+		format = textFormat{}
+	}
+	if format != nil {
+		engine = &formatEngine{Engine: engine, format: format, records: records, lines: cfg.Lines, numeric: numeric}
+	}
+
+	r := &Redactor{redactorState: redactorState{engine: engine, timeout: cfg.Timeout, compression: cfg.CompressOutput, resume: cfg.Resume, parallel: cfg.Parallel, mmap: cfg.Mmap, quarantine: cfg.Quarantine, profile: profile, fallback: fallback}, uses: make(map[Engine]int)}
+	if cfg.CacheFile != "" {
+		if r.cache, err = loadResultCache(cfg.CacheFile); err != nil {
+			return nil, err
+		}
+	}
+	if r.audit, err = openAuditLog(cfg.Audit); err != nil {
+		return nil, err
+	}
+	r.ruleset = rulesetFingerprint(cfg, engine)
+	return r, nil
+}
+
+// countTrue returns how many of conditions hold
+func countTrue(conditions ...bool) int {
+	n := 0
+	for _, c := range conditions {
+		if c {
+			n++
+		}
+	}
+	return n
+}
+
+// Engine returns the engine backing r, for inspecting it. A reload closes
+// the engine it replaces once the operations on it end, so lines are
+// redacted with RedactLine rather than through the engine.
+func (r *Redactor) Engine() Engine {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.engine
+}
+
+// Fallback returns why r redacts with the native engine although the
+// python engine was configured, or "" if it does not
+func (r *Redactor) Fallback() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.fallback
+}
+
+// Ruleset returns the fingerprint of the rules and settings r redacts
+// with, as audit log entries record it, or "" if they cannot be
+// fingerprinted
+func (r *Redactor) Ruleset() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ruleset
+}
+
+// Check reports why r cannot redact at the moment, such as a Python agent
+// that has gone missing or no longer matches its pinned checksum, or nil
+// when it can
+func (r *Redactor) Check(context.Context) error {
+	engine := r.Engine()
+	if f, ok := engine.(*formatEngine); ok {
+		engine = f.Engine
+	}
+	if python, ok := engine.(*PythonEngine); ok {
+		if err := python.available(); err != nil {
+			return err
+		}
+		return python.verifyAgent()
+	}
+	return nil
+}
+
+// WritesLines reports whether ProcessFile writes the redaction of
+// inputPath as plain lines, which can be read as they are written, rather
+// than compressed or as an archive
+func (r *Redactor) WritesLines(inputPath string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.compression == "" && archiveKind(inputPath) == ""
+}
+
+// noteFallback records the engine fallback, if any, in result
+func (r *Redactor) noteFallback(result *ProcessResult) *ProcessResult {
+	if result != nil && r.fallback != "" {
+		result.Errors = append(result.Errors, ProcessError{Code: CodeFallback, Stage: StageSetup, Message: r.fallback})
+	}
+	return result
+}
+
+// snapshot returns a Redactor with r's current configuration, which
+// reloads leave alone, for reading it; work on the engine uses acquire
+func (r *Redactor) snapshot() *Redactor {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return &Redactor{redactorState: r.redactorState, audit: r.audit}
+}
+
+// acquire is snapshot for an operation on the engine, which holds it open
+// across reloads until the returned func is called. A copy's engine is
+// already held by whoever made it.
+func (r *Redactor) acquire() (*Redactor, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	own := &Redactor{redactorState: r.redactorState, audit: r.audit}
+	if r.uses == nil {
+		return own, func() {}
+	}
+	engine := r.engine
+	r.uses[engine]++
+	var once sync.Once
+	return own, func() { once.Do(func() { r.release(engine) }) }
+}
+
+// release ends an operation on engine, closing it if it was the last on an
+// engine a reload replaced
+func (r *Redactor) release(engine Engine) {
+	r.mu.Lock()
+	r.uses[engine]--
+	if r.uses[engine] > 0 {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.uses, engine)
+	drained := r.retire(engine)
+	r.mu.Unlock()
+	if drained {
+		closeEngine(engine)
+	}
+}
+
+// retire drops engine from the retired ones, reporting whether it was one,
+// and keeps count of its agent failures. r.mu must be held.
+func (r *Redactor) retire(engine Engine) bool {
+	for i, retired := range r.retired {
+		if retired == engine {
+			r.retired = append(r.retired[:i], r.retired[i+1:]...)
+			r.retiredFailures += subprocessFailures(engine)
+			return true
+		}
+	}
+	return false
+}
+
+// Reload makes r redact as next does from now on, for rule and
+// configuration changes without a restart. Work already under way carries
+// on as it began. next must not be used afterwards. The engine r replaces
+// is closed once the work still using it ends, or at once when there is
+// none.
+func (r *Redactor) Reload(next *Redactor) {
+	r.mu.Lock()
+	replaced := r.engine
+	r.redactorState = next.redactorState
+	r.retired = append(r.retired, replaced)
+	idle := r.uses != nil && r.uses[replaced] == 0 && r.retire(replaced)
+	r.mu.Unlock()
+	if idle {
+		closeEngine(replaced)
+	}
+}
+
+// SubprocessFailures returns how many Python agent processes have failed,
+// counting engines replaced by reloads; it is always zero for the native
+// engine
+func (r *Redactor) SubprocessFailures() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n := r.retiredFailures
+	for _, engine := range append([]Engine{r.engine}, r.retired...) {
+		n += subprocessFailures(engine)
+	}
+	return n
+}
+
+// subprocessFailures returns how many agent processes of engine have failed
+func subprocessFailures(engine Engine) int64 {
+	if f, ok := engine.(*formatEngine); ok {
+		engine = f.Engine
+	}
+	if python, ok := engine.(*PythonEngine); ok {
+		return python.SubprocessFailures()
+	}
+	return 0
+}
+
+// closeEngine closes engine if it holds resources
+func closeEngine(engine Engine) error {
+	if closer, ok := engine.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Close releases resources held by the engine, and by those reloads
+// replaced, such as a persistent Python worker
+func (r *Redactor) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var first error
+	for _, engine := range append([]Engine{r.engine}, r.retired...) {
+		if err := closeEngine(engine); err != nil && first == nil {
+			first = err
+		}
+	}
+	r.retired = nil
+	return first
+}
+
+// ProcessFile redacts inputPath into outputPath. Either may be an object
+// storage URI such as s3://bucket/key, gs://bucket/object or
+// azblob://container/blob, which is streamed rather than downloaded first,
+// and inputPath may be an sftp://host/path read over SSH. A local .tar,
+// .tar.gz, .tgz or .zip input is written as an archive of the same kind
+// with every member redacted.
+func (r *Redactor) ProcessFile(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error) {
+	r, release := r.acquire()
+	defer release()
+	ctx = withSource(ctx, inputPath)
+	start := time.Now()
+	ctx, span := startSpan(ctx, "logveil.ProcessFile",
+		attribute.String("logveil.input", inputPath), attribute.String("logveil.output", outputPath))
+	timeout := r.fileTimeout(ctx, inputPath)
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := r.processFile(ctx, inputPath, outputPath)
+	result = r.noteFallback(r.noteTimeout(ctx, timeout, result))
+	if r.audit != nil {
+		result, err = r.auditResult(ctx, fileAuditEntry(inputPath, outputPath), start, result, err)
+	}
+	endFileSpan(ctx, span, start, result, err)
+	return result, err
+}
+
+// fileAuditEntry describes redacting inputPath into outputPath, with the
+// absolute paths and hashes of those that are local files
+func fileAuditEntry(inputPath, outputPath string) AuditEntry {
+	entry := AuditEntry{Operation: "file", Input: inputPath, Output: outputPath}
+	if !IsRemote(inputPath) {
+		entry.InputSHA256, _ = fileSHA256(inputPath)
+		if abs, err := filepath.Abs(inputPath); err == nil {
+			entry.Input = abs
+		}
+	}
+	if !IsRemote(outputPath) {
+		entry.OutputSHA256, _ = fileSHA256(outputPath)
+		if abs, err := filepath.Abs(outputPath); err == nil {
+			entry.Output = abs
+		}
+	}
+	return entry
+}
+
+// processFile hands plain files to the engine directly and streams
+// compressed ones through it, decompressing on the fly. Engines that only
+// work on whole files get decompressed copies in a temporary directory.
+// Tar and zip archives are redacted member by member.
+func (r *Redactor) processFile(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error) {
+	if r.resume {
+		return r.processResumable(ctx, inputPath, outputPath)
+	}
+	if IsRemote(inputPath) || IsRemote(outputPath) {
+		return r.processRemote(ctx, inputPath, outputPath)
+	}
+
+	if kind := archiveKind(inputPath); kind != "" {
+		return r.processArchive(ctx, kind, inputPath, outputPath)
+	}
+
+	codec, err := fileCompression(inputPath)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	}
+	parallel := r.parallel > 1 && chunkable(r.engine)
+	// Chunked and mapped input is read here rather than by the engine
+	readsInput := parallel || r.mmap && streamsLines(r.engine)
+	if codec == "" && r.compression == "" && streamsLines(r.engine) && !readsInput {
+		return r.engine.ProcessFile(ctx, inputPath, outputPath)
+	}
+	if codec == "" && r.compression == "" && !readsInput {
+		// The agent reads the file itself, so only UTF-8 goes to it directly
+		encoding, err := sniffFile(inputPath)
+		if err != nil {
+			return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+		}
+		switch encoding {
+		case SkippedBinary:
+			return skippedResult(SkippedBinary)
+		case "":
+			return r.engine.ProcessFile(ctx, inputPath, outputPath)
+		}
+	}
+	if !streamsLines(r.engine) {
+		return r.processStaged(ctx, inputPath, outputPath)
+	}
+
+	open := openInput
+	if r.mmap {
+		open = openMapped
+	}
+	in, err := open(inputPath)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	}
+	defer in.Close()
+
+	out, err := createOutput(outputPath, r.compression)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageWrite, "create output: %v", err))
+	}
+
+	var result *ProcessResult
+	if parallel {
+		result, err = redactParallel(ctx, r.engine, in, out, r.parallel)
+	} else {
+		result, err = redactStream(ctx, r.engine, in, out)
+	}
+	if closeErr := out.Close(); closeErr != nil && err == nil {
+		result.Success = false
+		result.addError(newError(CodeIO, StageWrite, "write output: %v", closeErr))
+		err = closeErr
+	}
+	if result.Skipped != "" {
+		os.Remove(outputPath)
+	}
+	return result, err
+}
+
+// processStaged decompresses inputPath into a temporary file, converting
+// it to UTF-8, runs the engine on it and compresses the result into
+// outputPath
+func (r *Redactor) processStaged(ctx context.Context, inputPath, outputPath string) (*ProcessResult, error) {
+	dir, err := os.MkdirTemp("", "logveil-stage-")
+	if err != nil {
+		return failedResult(newError(CodeIO, StageSetup, "create staging dir: %v", err))
+	}
+	defer os.RemoveAll(dir)
+
+	stagedInput := filepath.Join(dir, "input.log")
+	stagedOutput := filepath.Join(dir, "output.log")
+	encoding, err := stageText(stagedInput, inputPath)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "stage input: %v", err))
+	}
+	if encoding == SkippedBinary {
+		return skippedResult(SkippedBinary)
+	}
+
+	result, err := r.engine.ProcessFile(ctx, stagedInput, stagedOutput)
+	result.Encoding = encoding
+	if err != nil {
+		return result, err
+	}
+
+	if err := copyFile(outputPath, r.compression, stagedOutput); err != nil {
+		result.Success = false
+		result.addError(newError(CodeIO, StageWrite, "write output: %v", err))
+		return result, err
+	}
+	return result, nil
+}
+
+// copyFile decompresses src into dst, compressing it with codec
+func copyFile(dst, codec, src string) error {
+	in, err := openInput(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createOutput(dst, codec)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ProcessStream redacts in line by line into out until in is exhausted or ctx
+// is cancelled. Streams have no known size, so TimeoutAuto leaves them
+// unbounded.
+func (r *Redactor) ProcessStream(ctx context.Context, in io.Reader, out io.Writer) (*ProcessResult, error) {
+	r, release := r.acquire()
+	defer release()
+	start := time.Now()
+	ctx, span := startSpan(ctx, "logveil.ProcessStream")
+	timeout := r.timeout
+	if timeout == TimeoutAuto {
+		timeout = 0
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	var hashedIn *hashingReader
+	var hashedOut *hashingWriter
+	if r.audit != nil {
+		hashedIn, hashedOut = newHashingReader(in), newHashingWriter(out)
+		in, out = hashedIn, hashedOut
+	}
+	result, err := r.processStream(ctx, in, out)
+	result = r.noteFallback(r.noteTimeout(ctx, timeout, result))
+	if r.audit != nil {
+		entry := AuditEntry{Operation: "stream", InputSHA256: sumHex(hashedIn.hash), OutputSHA256: sumHex(hashedOut.hash)}
+		result, err = r.auditResult(ctx, entry, start, result, err)
+	}
+	endFileSpan(ctx, span, start, result, err)
+	return result, err
+}
+
+func (r *Redactor) processStream(ctx context.Context, in io.Reader, out io.Writer) (*ProcessResult, error) {
+	input, err := decompress(in)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "read input: %v", err))
+	}
+	output, err := compress(out, r.compression)
+	if err != nil {
+		return failedResult(newError(CodeConfig, StageSetup, "%v", err))
+	}
+
+	result, err := redactStream(ctx, r.engine, input, output)
+	if closeErr := output.Close(); closeErr != nil && err == nil {
+		result.Success = false
+		result.addError(newError(CodeIO, StageWrite, "write output: %v", closeErr))
+		err = closeErr
+	}
+	return result, err
+}
+
+// noteTimeout records a timeout in result when ctx expired during processing
+func (r *Redactor) noteTimeout(ctx context.Context, timeout time.Duration, result *ProcessResult) *ProcessResult {
+	if result != nil && ctx.Err() == context.DeadlineExceeded {
+		result.Errors = append(result.Errors, ProcessError{Code: CodeTimeout, Message: fmt.Sprintf("Process timed out after %s", timeout), Retryable: true})
+	}
+	return result
+}
+
+// RedactLine redacts a single line with r's current engine, which a reload
+// leaves open until the line is done
+func (r *Redactor) RedactLine(ctx context.Context, line string) (string, []Detection, error) {
+	r, release := r.acquire()
+	defer release()
+	return r.engine.RedactLine(ctx, line)
+}
+
+// ProcessLine redacts a single line
+func (r *Redactor) ProcessLine(line string) (RedactedLine, error) {
+	redacted, detections, err := r.RedactLine(context.Background(), line)
+	if err != nil {
+		return RedactedLine{Errors: []string{err.Error()}}, err
+	}
+	return RedactedLine{Line: redacted, Detections: detections}, nil
+}
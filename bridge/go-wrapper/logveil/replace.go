@@ -0,0 +1,165 @@
+package logveil
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// replacer produces the text substituted for a sensitive value
+type replacer interface {
+	replace(rule, value string) string
+}
+
+// placeholderReplacer emits the [REDACTED_<RULE>] placeholders used by the
+// Python engine
+type placeholderReplacer struct{}
+
+func (placeholderReplacer) replace(rule, value string) string {
+	return placeholder(rule)
+}
+
+// placeholder returns the replacement text the Python engine uses for rule
+func placeholder(rule string) string {
+	return "[REDACTED_" + strings.ToUpper(rule) + "]"
+}
+
+// newReplacer returns the replacer selected by cfg
+func newReplacer(cfg Config) replacer {
+	switch {
+	case cfg.Tokenizer != nil:
+		return cfg.Tokenizer
+	case cfg.PreserveFormat:
+		return shapeReplacer{}
+	case cfg.FakeData:
+		return newFakeReplacer(cfg.FakeSeed)
+	case cfg.PlaceholderTemplate != "":
+		return indexedReplacer{}
+	}
+	return placeholderReplacer{}
+}
+
+// newStrategy returns the replacer for a rule's strategy: placeholder,
+// mask, keep-last-4, keep-domain, hash, preserve or fake. A fake strategy
+// is seeded by the engine.
+func newStrategy(name string) (replacer, error) {
+	switch name {
+	case "fake":
+		return fakeReplacer{}, nil
+	case "preserve":
+		return shapeReplacer{}, nil
+	case "placeholder":
+		return placeholderReplacer{}, nil
+	case "mask":
+		return maskReplacer{}, nil
+	case "keep-last-4":
+		return maskReplacer{keep: 4}, nil
+	case "keep-domain":
+		return domainReplacer{}, nil
+	case "hash":
+		return hashReplacer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q (expected placeholder, mask, keep-last-4, keep-domain, hash, preserve or fake)", name)
+	}
+}
+
+// maskReplacer replaces every character with *. With keep set, only
+// letters and digits are masked and the last keep of them survive, so
+// 4111-1111-1111-1234 becomes ****-****-****-1234.
+type maskReplacer struct {
+	keep int
+}
+
+func (m maskReplacer) replace(rule, value string) string {
+	if m.keep == 0 {
+		return strings.Repeat("*", len([]rune(value)))
+	}
+
+	runes := []rune(value)
+	kept := 0
+	for i := len(runes) - 1; i >= 0; i-- {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			continue
+		}
+		if kept < m.keep {
+			kept++
+			continue
+		}
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
+// domainReplacer masks the local part of an email address and keeps its
+// domain. Values without an @ are masked entirely.
+type domainReplacer struct{}
+
+func (domainReplacer) replace(rule, value string) string {
+	at := strings.LastIndex(value, "@")
+	if at < 0 {
+		return maskReplacer{}.replace(rule, value)
+	}
+	return maskReplacer{}.replace(rule, value[:at]) + value[at:]
+}
+
+// hashReplacer substitutes the first 16 hex digits of the value's SHA-256.
+// Unlike a Tokenizer it is unkeyed, so the same value hashes alike
+// everywhere, but guessable values can be confirmed by anyone.
+type hashReplacer struct{}
+
+func (hashReplacer) replace(rule, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// shapeKey seeds shapeReplacer. It is random per process, so a value is
+// substituted consistently within a run but not linkably across runs.
+var shapeKey = sync.OnceValue(func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+})
+
+// shapeReplacer substitutes a value of the same shape: each digit becomes
+// a digit and each letter a letter of the same case, and everything else,
+// such as separators, is kept. Fixed-width fields and format checks keep
+// working downstream.
+type shapeReplacer struct{}
+
+func (shapeReplacer) replace(rule, value string) string {
+	mac := hmac.New(sha256.New, shapeKey())
+	mac.Write([]byte(rule))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	seed := mac.Sum(nil)
+
+	// Expand the seed into as many pseudorandom bytes as the value needs
+	stream := make([]byte, 0, len(value))
+	for block := uint32(0); len(stream) < len(value); block++ {
+		expand := hmac.New(sha256.New, seed)
+		binary.Write(expand, binary.BigEndian, block)
+		stream = expand.Sum(stream)
+	}
+
+	runes := []rune(value)
+	for i, r := range runes {
+		b := stream[i%len(stream)]
+		switch {
+		case r >= '0' && r <= '9':
+			runes[i] = '0' + rune(b%10)
+		case r >= 'a' && r <= 'z':
+			runes[i] = 'a' + rune(b%26)
+		case r >= 'A' && r <= 'Z':
+			runes[i] = 'A' + rune(b%26)
+		case unicode.IsLetter(r):
+			runes[i] = 'x'
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,213 @@
+package logveil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// previewLength caps the redacted line shown with each finding
+const previewLength = 160
+
+// Finding locates one detection. Columns are 1-based character positions in
+// the original line, EndColumn being one past the match; both are zero when
+// the engine cannot attribute a detection to a position. EndLine is set
+// when a match in a multi-line record ends on a later line.
+type Finding struct {
+	File      string   `json:"file,omitempty"`
+	Line      int      `json:"line"`
+	Rule      string   `json:"rule"`
+	Severity  Severity `json:"severity"`
+	Column    int      `json:"column,omitempty"`
+	EndLine   int      `json:"end_line,omitempty"`
+	EndColumn int      `json:"end_column,omitempty"`
+	// Preview is the line as it would be redacted, so it never carries the
+	// values it points at
+	Preview string `json:"preview"`
+}
+
+// ScanReport lists what a dry run found
+type ScanReport struct {
+	Success      bool           `json:"success"`
+	FilesScanned int            `json:"files_scanned"`
+	LinesScanned int            `json:"lines_scanned"`
+	Detections   map[string]int `json:"detections,omitempty"`
+	Findings     []Finding      `json:"findings"`
+	Errors       []string       `json:"errors,omitempty"`
+	// Skipped notes the files left unscanned, such as binary ones
+	Skipped []string `json:"skipped,omitempty"`
+	// Profile documents the coverage of the compliance profile in use
+	Profile  *ProfileCoverage `json:"profile,omitempty"`
+	Duration string           `json:"duration"`
+}
+
+// errBinary reports a binary file, which has no lines to scan
+var errBinary = errors.New("binary file")
+
+// locator is implemented by engines that can report where in a line each
+// detection was found
+type locator interface {
+	locate(line string) (string, []Finding)
+}
+
+// Scan reads each of paths, local files or object URIs, and reports every
+// detection without writing any redacted output. Files that cannot be read
+// are noted in the report and the rest are still scanned. UTF-16 and
+// Latin-1 files are scanned as UTF-8; binary files are skipped.
+func (r *Redactor) Scan(ctx context.Context, paths []string) (*ScanReport, error) {
+	r, release := r.acquire()
+	defer release()
+	startTime := time.Now()
+	report := &ScanReport{Findings: []Finding{}}
+	engine := r.engine
+	if f, ok := engine.(*formatEngine); ok {
+		engine = f.Engine
+	}
+	if _, ok := engine.(*PythonEngine); ok {
+		return report, fmt.Errorf("dry run needs an engine that reports detections, such as native")
+	}
+
+	for _, path := range paths {
+		if err := r.scanFile(ctx, path, report); errors.Is(err, errBinary) {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: %v", path, err))
+			continue
+		} else if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", path, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		report.FilesScanned++
+	}
+
+	if r.profile != nil {
+		report.Profile = r.profile.coverage(r.hasRule, report.Detections)
+	}
+	report.Success = len(report.Errors) == 0
+	report.Duration = time.Since(startTime).String()
+	return report, nil
+}
+
+func (r *Redactor) scanFile(ctx context.Context, path string, report *ScanReport) error {
+	ctx, cancel := withTimeout(ctx, r.fileTimeout(ctx, path))
+	defer cancel()
+
+	in, err := openLocation(ctx, path)
+	if err != nil {
+		return fmt.Errorf("open input: %v", err)
+	}
+	defer in.Close()
+	text, encoding, err := engineInput(in, r.engine)
+	if err != nil {
+		return fmt.Errorf("read input: %v", err)
+	}
+	if encoding == SkippedBinary {
+		return errBinary
+	}
+
+	engine := forStream(r.engine)
+	reader := newRecordReader(text, engine)
+	// column is where the current piece starts in a long line, which is
+	// scanned in pieces
+	column := 0
+	for lineNumber := 1; ; {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("scan cancelled: %v", err)
+		}
+		record, lines, readErr := reader.read()
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("read input: %v", readErr)
+		}
+		if record != "" {
+			body, _ := splitLineEnding(record)
+			var findings []Finding
+			// Only the start of a truncated line is scanned
+			if column == 0 || !reader.truncate {
+				if findings, err = r.scanLine(ctx, engine, body); err != nil {
+					return fmt.Errorf("line %d: %v", lineNumber, err)
+				}
+			}
+			for _, finding := range findings {
+				finding.File = path
+				finding.Line = lineNumber
+				if column > 0 && finding.Column > 0 {
+					finding.Column += column
+					finding.EndColumn += column
+				}
+				if lines > 1 && finding.Column > 0 {
+					startLine, startColumn := position(body, finding.Column)
+					endLine, endColumn := position(body, finding.EndColumn)
+					finding.Line += startLine
+					finding.Column, finding.EndColumn = startColumn, endColumn
+					if endLine != startLine {
+						finding.EndLine = lineNumber + endLine
+					}
+				}
+				finding.Severity = r.Severity(finding.Rule)
+				report.Findings = append(report.Findings, finding)
+				if report.Detections == nil {
+					report.Detections = make(map[string]int)
+				}
+				report.Detections[finding.Rule]++
+			}
+			report.LinesScanned += lines
+			lineNumber += lines
+		}
+		if lines == 0 {
+			column += utf8.RuneCountInString(record)
+		} else {
+			column = 0
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// scanLine returns the findings engine reports in line, located when the
+// engine supports it
+func (r *Redactor) scanLine(ctx context.Context, engine Engine, line string) ([]Finding, error) {
+	var redacted string
+	var findings []Finding
+	inner := engine
+	if f, ok := engine.(*formatEngine); ok {
+		if _, plain := f.format.(textFormat); plain {
+			inner = f.Engine
+		}
+	}
+	if l, ok := inner.(locator); ok {
+		redacted, findings = l.locate(line)
+	} else {
+		var detections []Detection
+		var err error
+		if redacted, detections, err = engine.RedactLine(ctx, line); err != nil {
+			return nil, err
+		}
+		for _, d := range detections {
+			findings = append(findings, Finding{Rule: d.Rule})
+		}
+	}
+
+	preview := truncatePreview(redacted)
+	for i := range findings {
+		findings[i].Preview = preview
+	}
+	return findings, nil
+}
+
+// truncatePreview shortens line to previewLength bytes on a character
+// boundary
+func truncatePreview(line string) string {
+	if len(line) <= previewLength {
+		return line
+	}
+	end := previewLength
+	for end > 0 && !utf8.RuneStart(line[end]) {
+		end--
+	}
+	return line[:end] + "…"
+}
@@ -0,0 +1,209 @@
+package logveil
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// sarifSchema is the SARIF version WriteSARIF produces
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           sarifRegion   `json:"region"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+// WriteSARIF writes the findings as a SARIF 2.1.0 log with one rule per
+// detector that matched, for code-scanning dashboards
+func (r *ScanReport) WriteSARIF(w io.Writer) error {
+	rules := r.rules()
+	index := make(map[string]int, len(rules))
+	run := sarifRun{
+		Tool:       sarifTool{Driver: sarifDriver{Name: "logveil", Rules: []sarifRule{}}},
+		ColumnKind: "unicodeCodePoints",
+		Results:    []sarifResult{},
+	}
+	for i, rule := range rules {
+		index[rule] = i
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               rule,
+			ShortDescription: sarifMessage{Text: "Sensitive data: " + rule},
+		})
+	}
+	for _, f := range r.Findings {
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    f.Rule,
+			RuleIndex: index[f.Rule],
+			Level:     sarifLevel(f.Severity),
+			Message:   sarifMessage{Text: f.Rule + " found; redacted line: " + f.Preview},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifact{URI: filepath.ToSlash(f.File)},
+				Region:           sarifRegion{StartLine: f.Line, StartColumn: f.Column, EndLine: f.EndLine, EndColumn: f.EndColumn},
+			}}},
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(sarifLog{Schema: sarifSchema, Version: "2.1.0", Runs: []sarifRun{run}})
+}
+
+// sarifLevel maps a severity onto SARIF's error, warning and note levels
+func sarifLevel(severity Severity) string {
+	switch {
+	case severity >= SeverityHigh:
+		return "error"
+	case severity == SeverityMedium:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// WriteCSV writes one row per finding under a header row
+func (r *ScanReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"file", "line", "rule", "severity", "column", "end_column", "preview"})
+	for _, f := range r.Findings {
+		writer.Write([]string{
+			f.File,
+			strconv.Itoa(f.Line),
+			f.Rule,
+			f.Severity.String(),
+			strconv.Itoa(f.Column),
+			strconv.Itoa(f.EndColumn),
+			f.Preview,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>logveil findings</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; }
+td.num { text-align: right; }
+code { white-space: pre-wrap; word-break: break-all; }
+.errors { color: #a00; }
+</style>
+</head>
+<body>
+<h1>logveil findings</h1>
+<p>{{.FilesScanned}} files and {{.LinesScanned}} lines scanned in {{.Duration}}; {{len .Findings}} findings.</p>
+{{if .Errors}}<h2>Errors</h2>
+<ul class="errors">{{range .Errors}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{if .Skipped}}<h2>Skipped</h2>
+<ul>{{range .Skipped}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{with .Profile}}<h2>Profile coverage: {{.Name}}</h2>
+<p>{{.Description}}</p>
+<table>
+<tr><th>Category</th><th>Rules</th><th>Findings</th></tr>
+{{range .Categories}}<tr><td>{{.Name}}</td><td>{{if .Covered}}{{range $i, $rule := .Rules}}{{if $i}}, {{end}}{{$rule}}{{end}}{{else}}<span class="errors">not covered</span>{{end}}</td><td class="num">{{.Findings}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Rules}}<h2>By rule</h2>
+<table>
+<tr><th>Rule</th><th>Findings</th></tr>
+{{range .Rules}}<tr><td>{{.Name}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Findings}}<h2>Findings</h2>
+<table>
+<tr><th>File</th><th>Line</th><th>Columns</th><th>Rule</th><th>Severity</th><th>Redacted line</th></tr>
+{{range .Findings}}<tr><td>{{.File}}</td><td class="num">{{.Line}}</td><td class="num">{{if .Column}}{{.Column}}&ndash;{{.EndColumn}}{{end}}</td><td>{{.Rule}}</td><td>{{.Severity}}</td><td><code>{{.Preview}}</code></td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes a self-contained HTML summary of the findings
+func (r *ScanReport) WriteHTML(w io.Writer) error {
+	type ruleCount struct {
+		Name  string
+		Count int
+	}
+	var rules []ruleCount
+	for _, rule := range r.rules() {
+		rules = append(rules, ruleCount{rule, r.Detections[rule]})
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Count > rules[j].Count })
+
+	return htmlReport.Execute(w, struct {
+		*ScanReport
+		Rules []ruleCount
+	}{r, rules})
+}
+
+// rules returns the names of the rules with findings, sorted
+func (r *ScanReport) rules() []string {
+	rules := make([]string, 0, len(r.Detections))
+	for rule := range r.Detections {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
@@ -0,0 +1,55 @@
+package logveil
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// defaultRetryBackoff is the wait before the first retry when none is set
+const defaultRetryBackoff = time.Second
+
+// RetryOptions controls how often an agent run that failed transiently is
+// repeated
+type RetryOptions struct {
+	// Attempts is the most times the agent is run for one file; 0 and 1
+	// both mean once
+	Attempts int
+	// Backoff is the wait before the first retry, doubled for each one
+	// after it; defaultRetryBackoff if zero
+	Backoff time.Duration
+}
+
+// delay returns the wait after the given failed attempt, counted from 1
+func (opts RetryOptions) delay(attempt int) time.Duration {
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	return backoff << (attempt - 1)
+}
+
+// transientFailure reports whether an agent run failed in a way that may
+// not recur: killed outright, which is how the OOM killer ends it, or
+// unable to start because the interpreter was still being written
+func transientFailure(err error) bool {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return killedOutright(exitErr.ProcessState)
+	}
+	return errors.Is(err, syscall.ETXTBSY)
+}
+
+// sleepContext waits for d, or reports false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
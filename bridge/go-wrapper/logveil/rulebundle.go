@@ -0,0 +1,375 @@
+package logveil
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// rulesBundleTimeout bounds a single bundle download
+	rulesBundleTimeout = 30 * time.Second
+	// maxRulesBundle is the largest bundle accepted
+	maxRulesBundle = 16 << 20
+	// maxBundleSignature is the largest signature file accepted
+	maxBundleSignature = 64 << 10
+	// bundleSignatureSuffix names a bundle's signature after it, both at
+	// its URL and in the cache
+	bundleSignatureSuffix = ".sig"
+)
+
+var errBundleSignature = errors.New("rules bundle signature does not verify against any trusted key")
+
+// RulesBundle is a rules file published at an HTTPS URL, so that a central
+// team can update the detectors of every instance at once. Downloads are
+// kept in CacheDir and revalidated with their ETag, so an unchanged bundle
+// is not downloaded again, and a server that cannot be reached falls back
+// to the last copy fetched. With PublicKeys set, a bundle is only used once
+// its signature verifies, so that whoever controls its distribution cannot
+// also weaken redaction.
+type RulesBundle struct {
+	URL      string
+	CacheDir string
+	// PublicKeys are trusted to sign bundles, any one of them sufficing.
+	// When empty, bundles are not verified.
+	PublicKeys []crypto.PublicKey
+	// SignatureURL is where the detached signature is published; empty
+	// means URL with .sig appended
+	SignatureURL string
+	// Client makes the requests; nil uses http.DefaultClient
+	Client *http.Client
+}
+
+// RulesBundleResult is the outcome of RulesBundle.Fetch
+type RulesBundleResult struct {
+	Rules []Rule
+	// ETag identifies the copy the rules came from
+	ETag string
+	// Changed reports that a new copy was downloaded rather than the
+	// cached one confirmed or used
+	Changed bool
+	// Stale is why the cached copy was used without the server confirming
+	// it is current, or nil
+	Stale error
+}
+
+// RulesBundleVersion identifies the cached copy of a bundle
+type RulesBundleVersion struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag,omitempty"`
+	// SHA256 is the hex digest of the cached bundle
+	SHA256  string    `json:"sha256"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// rulesBundleMeta is the cache entry of a bundle, beside its content
+type rulesBundleMeta struct {
+	URL     string    `json:"url"`
+	ETag    string    `json:"etag,omitempty"`
+	File    string    `json:"file"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// Fetch returns the rules of the bundle, downloading it when the cached
+// copy is missing or the server's differs. A new copy replaces the cached
+// one only once every rule in it is valid.
+func (b RulesBundle) Fetch(ctx context.Context) (RulesBundleResult, error) {
+	u, err := url.Parse(b.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return RulesBundleResult{}, fmt.Errorf("invalid rules URL %q (expected an https URL)", b.URL)
+	}
+	if err := os.MkdirAll(b.CacheDir, 0o700); err != nil {
+		return RulesBundleResult{}, err
+	}
+
+	key := b.cacheKey()
+	metaPath := filepath.Join(b.CacheDir, key+".json")
+	meta, cached := b.cached(metaPath)
+
+	data, etag, ext, err := b.download(ctx, u, meta.ETag, cached)
+	if err != nil {
+		if !cached {
+			return RulesBundleResult{}, fmt.Errorf("%s: %v", b.URL, err)
+		}
+		result, loadErr := b.loadCached(meta)
+		result.Stale = err
+		return result, loadErr
+	}
+	if data == nil {
+		// Not modified
+		return b.loadCached(meta)
+	}
+
+	var signature []byte
+	if len(b.PublicKeys) > 0 {
+		if signature, err = b.signature(ctx); err == nil {
+			err = verifyBundle(b.PublicKeys, data, signature)
+		}
+	}
+	var file rulesFile
+	if err == nil {
+		file, err = decodeRulesFile(b.URL, ext, data)
+	}
+	if err == nil {
+		file.Rules, err = checkRules(b.URL, file)
+	}
+	if err != nil {
+		if !cached {
+			return RulesBundleResult{}, err
+		}
+		// A broken or unsigned release does not displace the last good one
+		result, loadErr := b.loadCached(meta)
+		result.Stale = err
+		return result, loadErr
+	}
+
+	next := rulesBundleMeta{URL: b.URL, ETag: etag, File: key + ".rules" + ext, Fetched: time.Now().UTC()}
+	if signature != nil {
+		// Written first, so the cached bundle is never left without it
+		if err := writeFileAtomic(filepath.Join(b.CacheDir, next.File+bundleSignatureSuffix), signature, 0o600); err != nil {
+			return RulesBundleResult{}, err
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(b.CacheDir, next.File), data, 0o600); err != nil {
+		return RulesBundleResult{}, err
+	}
+	encoded, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return RulesBundleResult{}, err
+	}
+	if err := writeFileAtomic(metaPath, encoded, 0o600); err != nil {
+		return RulesBundleResult{}, err
+	}
+	if meta.File != "" && meta.File != next.File {
+		os.Remove(filepath.Join(b.CacheDir, meta.File))
+		os.Remove(filepath.Join(b.CacheDir, meta.File+bundleSignatureSuffix))
+	}
+	return RulesBundleResult{Rules: file.Rules, ETag: etag, Changed: true}, nil
+}
+
+// Version identifies the copy of the bundle Fetch last cached, which it
+// returns rules from, without contacting the server. It returns an error
+// matching os.ErrNotExist when no copy is cached.
+func (b RulesBundle) Version() (RulesBundleVersion, error) {
+	meta, cached := b.cached(filepath.Join(b.CacheDir, b.cacheKey()+".json"))
+	if !cached {
+		return RulesBundleVersion{}, fmt.Errorf("%s: no cached copy: %w", b.URL, os.ErrNotExist)
+	}
+	digest, err := fileSHA256(filepath.Join(b.CacheDir, meta.File))
+	if err != nil {
+		return RulesBundleVersion{}, err
+	}
+	return RulesBundleVersion{URL: meta.URL, ETag: meta.ETag, SHA256: digest, Fetched: meta.Fetched}, nil
+}
+
+// cacheKey names the cache entry of the bundle
+func (b RulesBundle) cacheKey() string {
+	sum := sha256.Sum256([]byte(b.URL))
+	return hex.EncodeToString(sum[:8])
+}
+
+// cached returns the cache entry at metaPath and whether its content, and
+// signature when one is needed, are still there to fall back on
+func (b RulesBundle) cached(metaPath string) (rulesBundleMeta, bool) {
+	var meta rulesBundleMeta
+	data, err := os.ReadFile(metaPath)
+	if err != nil || json.Unmarshal(data, &meta) != nil || meta.URL != b.URL || meta.File == "" {
+		return rulesBundleMeta{}, false
+	}
+	if _, err := os.Stat(filepath.Join(b.CacheDir, meta.File)); err != nil {
+		return rulesBundleMeta{}, false
+	}
+	if len(b.PublicKeys) > 0 {
+		// A copy cached before signing was required is fetched afresh
+		if _, err := os.Stat(filepath.Join(b.CacheDir, meta.File+bundleSignatureSuffix)); err != nil {
+			return rulesBundleMeta{}, false
+		}
+	}
+	return meta, true
+}
+
+// download fetches the bundle, conditionally on etag when there is a
+// cached copy. It returns nil data when the cached copy is current, and
+// the extension naming the format of new data.
+func (b RulesBundle) download(ctx context.Context, u *url.URL, etag string, cached bool) ([]byte, string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, rulesBundleTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("Accept", "application/yaml, application/json;q=0.9, */*;q=0.1")
+	if cached && etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		return nil, etag, "", nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", "", fmt.Errorf("server returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRulesBundle+1))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if len(data) > maxRulesBundle {
+		return nil, "", "", errors.New("bundle exceeds 16 MiB")
+	}
+	return data, resp.Header.Get("ETag"), bundleExtension(u, resp.Header.Get("Content-Type")), nil
+}
+
+// bundleExtension names the format of a bundle by the extension of its URL
+// path, or else by its content type, taking YAML as the default
+func bundleExtension(u *url.URL, contentType string) string {
+	switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+	case ".yaml", ".yml", ".json":
+		return ext
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return ".json"
+	}
+	return ".yaml"
+}
+
+// signature downloads the detached signature of the bundle
+func (b RulesBundle) signature(ctx context.Context) ([]byte, error) {
+	sigURL := b.SignatureURL
+	if sigURL == "" {
+		sigURL = b.URL + bundleSignatureSuffix
+	}
+	ctx, cancel := context.WithTimeout(ctx, rulesBundleTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sigURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rules bundle signature: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rules bundle signature: server returned %s", resp.Status)
+	}
+	signature, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleSignature))
+	if err != nil {
+		return nil, fmt.Errorf("rules bundle signature: %v", err)
+	}
+	return signature, nil
+}
+
+// loadCached reads the rules of the cached copy meta describes, verifying
+// it again when bundles must be signed
+func (b RulesBundle) loadCached(meta rulesBundleMeta) (RulesBundleResult, error) {
+	path := filepath.Join(b.CacheDir, meta.File)
+	data, err := os.ReadFile(path)
+	if err == nil && len(b.PublicKeys) > 0 {
+		var signature []byte
+		if signature, err = os.ReadFile(path + bundleSignatureSuffix); err == nil {
+			err = verifyBundle(b.PublicKeys, data, signature)
+		}
+	}
+	var file rulesFile
+	if err == nil {
+		file, err = decodeRulesFile(path, filepath.Ext(path), data)
+	}
+	if err == nil {
+		file.Rules, err = checkRules(path, file)
+	}
+	if err != nil {
+		return RulesBundleResult{}, fmt.Errorf("cached rules bundle: %v", err)
+	}
+	return RulesBundleResult{Rules: file.Rules, ETag: meta.ETag}, nil
+}
+
+// LoadBundleKeys reads the PEM-encoded public keys in path trusted to sign
+// rules bundles: Ed25519 keys, or ECDSA keys such as cosign generates
+func LoadBundleKeys(path string) ([]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("%s: unsupported %T key (expected Ed25519 or ECDSA)", path, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no PEM public keys", path)
+	}
+	return keys, nil
+}
+
+// verifyBundle checks signature, raw or base64-encoded as cosign writes
+// it, over data against keys. ECDSA signatures are ASN.1 over the SHA-256
+// of data; Ed25519 ones are over data itself.
+func verifyBundle(keys []crypto.PublicKey, data, signature []byte) error {
+	candidates := [][]byte{signature}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err == nil {
+		candidates = append(candidates, decoded)
+	}
+	digest := sha256.Sum256(data)
+	for _, key := range keys {
+		for _, sig := range candidates {
+			switch key := key.(type) {
+			case ed25519.PublicKey:
+				if len(sig) == ed25519.SignatureSize && ed25519.Verify(key, data, sig) {
+					return nil
+				}
+			case *ecdsa.PublicKey:
+				if ecdsa.VerifyASN1(key, digest[:], sig) {
+					return nil
+				}
+			}
+		}
+	}
+	return errBundleSignature
+}
@@ -0,0 +1,214 @@
+package logveil
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+)
+
+// Lint issue levels
+const (
+	// LintError marks a rule LoadRules rejects or one that cannot work
+	LintError = "error"
+	// LintWarning marks a rule that loads but is likely a mistake
+	LintWarning = "warning"
+)
+
+// LintIssue is one problem LintRules found
+type LintIssue struct {
+	File    string `json:"file"`
+	Rule    string `json:"rule,omitempty"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// LintReport lists the issues found in a set of rules files
+type LintReport struct {
+	Files    int         `json:"files"`
+	Rules    int         `json:"rules"`
+	Errors   int         `json:"errors"`
+	Warnings int         `json:"warnings"`
+	Issues   []LintIssue `json:"issues"`
+}
+
+// lintedRule is a rule already seen, for the checks spanning rules
+type lintedRule struct {
+	file string
+	rule Rule
+}
+
+// LintRules checks the rules files at paths, in the order they would be
+// applied, for everything LoadRules rejects and for likely mistakes:
+// patterns that match the empty string or nest unbounded quantifiers,
+// which backtracking regex engines such as the Python agent's can take
+// exponential time over, replacements naming groups the pattern lacks, and
+// rules whose name or pattern repeats an earlier rule's, where only one of
+// them takes effect. Unlike LoadRules it reports every issue rather than
+// stopping at the first.
+func LintRules(paths []string) *LintReport {
+	report := &LintReport{Issues: []LintIssue{}}
+	byName := make(map[string]lintedRule)
+	byPattern := make(map[string]lintedRule)
+
+	for _, path := range paths {
+		report.Files++
+		add := func(rule, level, format string, args ...any) {
+			report.Issues = append(report.Issues, LintIssue{File: path, Rule: rule, Level: level, Message: fmt.Sprintf(format, args...)})
+			if level == LintError {
+				report.Errors++
+			} else {
+				report.Warnings++
+			}
+		}
+
+		file, err := readRulesFile(path)
+		if err != nil {
+			add("", LintError, "%v", err)
+			continue
+		}
+		inFile := make(map[string]bool, len(file.Rules))
+		for i, rule := range file.Rules {
+			report.Rules++
+			if rule.Name == "" {
+				add("", LintError, "rule %d has no name", i+1)
+				continue
+			}
+			if inFile[rule.Name] {
+				add(rule.Name, LintError, "duplicate rule name")
+				continue
+			}
+			inFile[rule.Name] = true
+			if earlier, ok := byName[rule.Name]; ok {
+				if conflictingReplacements(earlier.rule, rule) {
+					add(rule.Name, LintWarning, "overrides the rule of the same name in %s with a different replacement", earlier.file)
+				} else {
+					add(rule.Name, LintWarning, "overrides the rule of the same name in %s", earlier.file)
+				}
+			}
+			byName[rule.Name] = lintedRule{file: path, rule: rule}
+
+			if !rule.IsEnabled() {
+				continue
+			}
+			if _, err := rule.apply(detector{name: rule.Name}); err != nil {
+				add(rule.Name, LintError, "%v", err)
+			}
+			if rule.Pattern == "" {
+				switch {
+				case !isBuiltinDetector(rule.Name):
+					add(rule.Name, LintError, "no pattern, and no built-in detector of this name to adjust")
+				case rule.Replacement == "" && rule.Strategy == "" && rule.Severity == "":
+					add(rule.Name, LintWarning, "no pattern, replacement, strategy or severity, so the rule changes nothing")
+				}
+				continue
+			}
+
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				add(rule.Name, LintError, "invalid pattern: %v", err)
+				continue
+			}
+			if pattern.MatchString("") {
+				add(rule.Name, LintError, "pattern matches the empty string")
+			}
+			if parsed, err := syntax.Parse(rule.Pattern, syntax.Perl); err == nil {
+				if nested := nestedQuantifier(parsed, false); nested != "" {
+					add(rule.Name, LintWarning, "nested unbounded quantifiers in %s can backtrack catastrophically outside RE2, e.g. in the Python engine", nested)
+				}
+			}
+			for _, group := range unknownGroups(rule.Replacement, pattern) {
+				add(rule.Name, LintError, "replacement refers to group %s, which the pattern does not have", group)
+			}
+			if earlier, ok := byPattern[rule.Pattern]; ok && earlier.rule.Name != rule.Name {
+				add(rule.Name, LintWarning, "same pattern as rule %q in %s, which redacts the matches first", earlier.rule.Name, earlier.file)
+			} else if !ok {
+				byPattern[rule.Pattern] = lintedRule{file: path, rule: rule}
+			}
+		}
+	}
+	return report
+}
+
+// conflictingReplacements reports whether two rules of the same name
+// replace matches differently
+func conflictingReplacements(a, b Rule) bool {
+	set := func(r Rule) bool { return r.Replacement != "" || r.Strategy != "" }
+	return set(a) && set(b) && (a.Replacement != b.Replacement || a.Strategy != b.Strategy)
+}
+
+// unbounded reports whether re repeats without an upper bound
+func unbounded(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus:
+		return true
+	case syntax.OpRepeat:
+		return re.Max == -1
+	}
+	return false
+}
+
+// nestedQuantifier returns the first unbounded repetition in re that
+// contains another, such as (a+)+, or "" when there is none. inside is set
+// below an unbounded repetition.
+func nestedQuantifier(re *syntax.Regexp, inside bool) string {
+	if unbounded(re) {
+		for _, sub := range re.Sub {
+			if found := nestedQuantifier(sub, true); found != "" {
+				if inside {
+					return found
+				}
+				return re.String()
+			}
+		}
+		if inside {
+			return re.String()
+		}
+		return ""
+	}
+	for _, sub := range re.Sub {
+		if found := nestedQuantifier(sub, inside); found != "" {
+			return found
+		}
+	}
+	return ""
+}
+
+// templateGroup matches a group reference in a replacement template
+var templateGroup = regexp.MustCompile(`\$(?:\{([^}]*)\}|([A-Za-z0-9_]+))`)
+
+// unknownGroups returns the groups replacement refers to that pattern does
+// not define, as regexp.Expand would name them
+func unknownGroups(replacement string, pattern *regexp.Regexp) []string {
+	var unknown []string
+	names := make(map[string]bool)
+	for _, name := range pattern.SubexpNames() {
+		if name != "" {
+			names[name] = true
+		}
+	}
+	for i := 0; i < len(replacement); i++ {
+		if replacement[i] != '$' {
+			continue
+		}
+		if i+1 < len(replacement) && replacement[i+1] == '$' {
+			// $$ is a literal dollar sign
+			i++
+			continue
+		}
+		m := templateGroup.FindStringSubmatch(replacement[i:])
+		if m == nil {
+			continue
+		}
+		group := m[1] + m[2]
+		if n, err := strconv.Atoi(group); err == nil {
+			if n > pattern.NumSubexp() {
+				unknown = append(unknown, "$"+group)
+			}
+		} else if !names[group] {
+			unknown = append(unknown, "$"+group)
+		}
+		i += len(m[0]) - 1
+	}
+	return unknown
+}
@@ -0,0 +1,173 @@
+package logveil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is a user-defined detector loaded from a rules file
+type Rule struct {
+	// Name identifies the rule in results; a rule named after a built-in
+	// detector replaces it
+	Name string `json:"name" yaml:"name"`
+	// Pattern is an RE2 regular expression. It may be omitted to adjust a
+	// built-in detector's replacement or severity.
+	Pattern string `json:"pattern" yaml:"pattern"`
+	// Replacement is expanded per match, so $1 or ${group} refer to capture
+	// groups. When empty the match, or only its "value" group if the
+	// pattern has one, is replaced according to Strategy.
+	Replacement string `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+	// Enabled defaults to true; false also disables a built-in of the same
+	// name
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Severity is low, medium, high or critical; empty keeps the built-in
+	// rating of a same-named detector, or medium
+	Severity string `json:"severity,omitempty" yaml:"severity,omitempty"`
+	// Strategy chooses how matches are replaced when there is no
+	// Replacement: placeholder, mask, keep-last-4, keep-domain, hash,
+	// preserve or fake.
+	// Empty uses the engine's placeholders or tokens.
+	Strategy string `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	// When is an expr expression deciding whether each match is redacted,
+	// such as field("env") != "prod" or len(value) > 20. Empty redacts
+	// every match.
+	When string `json:"when,omitempty" yaml:"when,omitempty"`
+}
+
+// IsEnabled reports whether the rule should run
+func (r Rule) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// rulesFile is the on-disk layout of a rules file
+type rulesFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// LoadRules reads a YAML or JSON rules file and validates every rule
+func LoadRules(path string) ([]Rule, error) {
+	file, err := readRulesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return checkRules(path, file)
+}
+
+// checkRules validates every rule in file, read from path
+func checkRules(path string, file rulesFile) ([]Rule, error) {
+	seen := make(map[string]bool, len(file.Rules))
+	for i, rule := range file.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("%s: rule %d has no name", path, i+1)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("%s: duplicate rule %q", path, rule.Name)
+		}
+		seen[rule.Name] = true
+
+		if !rule.IsEnabled() {
+			continue
+		}
+		if rule.Pattern == "" {
+			// A pattern-less rule adjusts the built-in of the same name
+			if !isBuiltinDetector(rule.Name) {
+				return nil, fmt.Errorf("%s: rule %q has no pattern", path, rule.Name)
+			}
+			if _, err := rule.apply(detector{name: rule.Name}); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		if _, err := compileRule(rule); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return file.Rules, nil
+}
+
+// readRulesFile decodes the YAML or JSON rules file at path without
+// validating the rules
+func readRulesFile(path string) (rulesFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rulesFile{}, err
+	}
+	return decodeRulesFile(path, filepath.Ext(path), data)
+}
+
+// decodeRulesFile decodes data, read from path, as a rules file in the
+// format ext names
+func decodeRulesFile(path, ext string, data []byte) (rulesFile, error) {
+	var file rulesFile
+	var err error
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	default:
+		return file, fmt.Errorf("%s: unsupported rules format %q (expected .yaml, .yml or .json)", path, ext)
+	}
+	if err != nil {
+		return file, fmt.Errorf("%s: %v", path, err)
+	}
+	return file, nil
+}
+
+// compileRule turns a Rule into a detector
+func compileRule(rule Rule) (detector, error) {
+	pattern, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return detector{}, fmt.Errorf("rule %q: invalid pattern: %v", rule.Name, err)
+	}
+	return rule.apply(detector{name: rule.Name, pattern: pattern})
+}
+
+// apply sets the rule's replacement, strategy and severity on d
+func (r Rule) apply(d detector) (detector, error) {
+	var err error
+	if r.Replacement != "" && r.Strategy != "" {
+		return detector{}, fmt.Errorf("rule %q: replacement and strategy are mutually exclusive", r.Name)
+	}
+	if r.Replacement != "" {
+		d.template, d.replacer = r.Replacement, nil
+	}
+	if r.Strategy != "" {
+		if d.replacer, err = newStrategy(r.Strategy); err != nil {
+			return detector{}, fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+		d.template = ""
+	}
+	if r.When != "" {
+		if d.when, err = compileCondition(r.When); err != nil {
+			return detector{}, fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+	}
+	if r.Severity != "" {
+		if d.severity, err = ParseSeverity(r.Severity); err != nil {
+			return detector{}, fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+	}
+	return d, nil
+}
+
+// isBuiltinDetector reports whether name is a built-in detector
+func isBuiltinDetector(name string) bool {
+	for _, d := range builtinDetectors {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package logveil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleCase is a regression test for rules: Input must redact to Expected
+type RuleCase struct {
+	// Name identifies the case in results; the file and index when empty
+	Name     string `json:"name" yaml:"name"`
+	Input    string `json:"input" yaml:"input"`
+	Expected string `json:"expected" yaml:"expected"`
+	// File is the fixture file the case was loaded from
+	File string `json:"file,omitempty" yaml:"-"`
+}
+
+// ruleCaseFile is the on-disk layout of a fixture file
+type ruleCaseFile struct {
+	Cases []RuleCase `json:"cases" yaml:"cases"`
+}
+
+// RuleTestResult is the outcome of one RuleCase
+type RuleTestResult struct {
+	File   string `json:"file"`
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	// Expected and Got are only set for a case that failed
+	Expected   string   `json:"expected,omitempty"`
+	Got        string   `json:"got,omitempty"`
+	Detections []string `json:"detections,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// RuleTestReport summarizes a TestRules run
+type RuleTestReport struct {
+	Passed  int              `json:"passed"`
+	Failed  int              `json:"failed"`
+	Results []RuleTestResult `json:"results"`
+}
+
+// LoadRuleCases reads every .yaml, .yml and .json fixture file below dir,
+// or dir itself when it is a file, in lexical order. Each file holds a
+// list of cases:
+//
+//	cases:
+//	  - name: employee id
+//	    input: "login by EMP-123456"
+//	    expected: "login by [EMPLOYEE_ID]"
+func LoadRuleCases(dir string) ([]RuleCase, error) {
+	var cases []RuleCase
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		loaded, err := loadRuleCaseFile(path)
+		if err != nil {
+			return err
+		}
+		cases = append(cases, loaded...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("%s: no test cases found", dir)
+	}
+	return cases, nil
+}
+
+// loadRuleCaseFile reads the cases in one fixture file
+func loadRuleCaseFile(path string) ([]RuleCase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file ruleCaseFile
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for i := range file.Cases {
+		file.Cases[i].File = path
+		if file.Cases[i].Name == "" {
+			file.Cases[i].Name = fmt.Sprintf("case %d", i+1)
+		}
+	}
+	return file.Cases, nil
+}
+
+// TestRules redacts each case's input and compares it with the expected
+// output
+func (r *Redactor) TestRules(ctx context.Context, cases []RuleCase) *RuleTestReport {
+	r, release := r.acquire()
+	defer release()
+	report := &RuleTestReport{Results: make([]RuleTestResult, 0, len(cases))}
+	for _, c := range cases {
+		result := RuleTestResult{File: c.File, Name: c.Name}
+		got, detections, err := r.engine.RedactLine(ctx, c.Input)
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+		case got == c.Expected:
+			result.Passed = true
+		default:
+			result.Expected, result.Got = c.Expected, got
+			for _, d := range detections {
+				result.Detections = append(result.Detections, d.Rule)
+			}
+		}
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
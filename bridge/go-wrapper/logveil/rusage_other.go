@@ -0,0 +1,8 @@
+//go:build !unix
+
+package logveil
+
+// peakRSS returns zero where resident memory is not reported
+func peakRSS() int64 {
+	return 0
+}
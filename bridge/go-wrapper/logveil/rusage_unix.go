@@ -0,0 +1,22 @@
+//go:build unix
+
+package logveil
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakRSS returns the most memory this process has held resident, in
+// bytes, or zero when it cannot tell
+func peakRSS() int64 {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return int64(usage.Maxrss)
+	}
+	// Kilobytes elsewhere
+	return int64(usage.Maxrss) * 1024
+}
@@ -0,0 +1,114 @@
+package logveil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3PartSize is the multipart upload part size. Uploads smaller than one
+// part are sent with a single PutObject.
+const s3PartSize = 16 << 20
+
+// s3Store serves s3://bucket/key URIs. Credentials, region and endpoint
+// come from the standard AWS configuration chain (environment, shared
+// config files, instance roles).
+type s3Store struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+}
+
+func newS3Store(ctx context.Context) (objectStore, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg)
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = s3PartSize
+	})
+	return &s3Store{client: client, uploader: uploader}, nil
+}
+
+// s3Location splits an s3:// URI into bucket and key
+func s3Location(u *url.URL) (string, string, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 URI %q (expected s3://bucket/key)", u.String())
+	}
+	return bucket, key, nil
+}
+
+func (s *s3Store) open(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	bucket, key, err := s3Location(u)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Store) size(ctx context.Context, u *url.URL) (int64, error) {
+	bucket, key, err := s3Location(u)
+	if err != nil {
+		return 0, err
+	}
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return 0, err
+	}
+	return aws.ToInt64(out.ContentLength), nil
+}
+
+// create streams writes into a multipart upload through a pipe
+func (s *s3Store) create(ctx context.Context, u *url.URL) (objectWriter, error) {
+	bucket, key, err := s3Location(u)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	upload := &pipeUpload{pipe: writer, done: make(chan error, 1)}
+	go func() {
+		_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   reader,
+		})
+		// Unblock writers if the upload fails first
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+	return upload, nil
+}
+
+// pipeUpload is an object being uploaded from the read end of a pipe. The
+// uploader abandons the upload when the pipe is closed with an error.
+type pipeUpload struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (u *pipeUpload) Write(p []byte) (int, error) {
+	return u.pipe.Write(p)
+}
+
+func (u *pipeUpload) Close() error {
+	u.pipe.Close()
+	return <-u.done
+}
+
+func (u *pipeUpload) Abort(err error) {
+	u.pipe.CloseWithError(err)
+	<-u.done
+}
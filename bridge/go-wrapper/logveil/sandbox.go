@@ -0,0 +1,64 @@
+package logveil
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// sandboxEnv carries the limits for an agent launched through the sandbox.
+// The executable re-runs itself with it set, applies the limits and then
+// execs the agent, so they are in place before the agent's first
+// instruction.
+const sandboxEnv = "LOGVEIL_AGENT_SANDBOX"
+
+// SandboxOptions confines each Python agent process so that a pathological
+// input cannot exhaust the host. Zero values leave a limit unset. Sandboxing
+// is only available on Linux.
+type SandboxOptions struct {
+	// CPUTime caps the CPU time of one agent process, after which the kernel
+	// kills it. A persistent worker is restarted when it is killed.
+	CPUTime time.Duration `json:"cpu_time,omitempty"`
+	// Memory caps the address space of one agent process, in bytes
+	Memory int64 `json:"memory,omitempty"`
+	// OpenFiles caps the file descriptors one agent process may hold
+	OpenFiles int `json:"open_files,omitempty"`
+	// NoNewPrivileges stops the agent gaining privileges through setuid
+	// binaries or file capabilities
+	NoNewPrivileges bool `json:"no_new_privileges,omitempty"`
+	// Seccomp denies the agent network sockets and the system calls for
+	// tracing other processes, mounting, namespaces, kernel modules and
+	// keyrings. It implies NoNewPrivileges.
+	Seccomp bool `json:"seccomp,omitempty"`
+}
+
+func (o SandboxOptions) enabled() bool {
+	return o.CPUTime > 0 || o.Memory > 0 || o.OpenFiles > 0 || o.NoNewPrivileges || o.Seccomp
+}
+
+// sandboxed rewrites cmd to start through the sandbox described by opts,
+// unless opts sets no limits
+func sandboxed(cmd *exec.Cmd, opts SandboxOptions) *exec.Cmd {
+	if !opts.enabled() {
+		return cmd
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		cmd.Err = err
+		return cmd
+	}
+	spec, err := json.Marshal(opts)
+	if err != nil {
+		cmd.Err = err
+		return cmd
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, sandboxEnv+"="+string(spec))
+	cmd.Args = append([]string{exe}, cmd.Args...)
+	cmd.Path = exe
+	return cmd
+}
@@ -0,0 +1,85 @@
+package logveil
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// A process started with sandboxEnv set is an agent launch: it applies the
+// limits and becomes the agent without running the rest of the program
+func init() {
+	spec, ok := os.LookupEnv(sandboxEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(sandboxEnv)
+	if err := enterSandbox(spec, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "logveil: sandbox: %v\n", err)
+		os.Exit(126)
+	}
+}
+
+// validateSandbox reports whether opts can be applied on this platform
+func validateSandbox(opts SandboxOptions) error {
+	if opts.Seccomp && seccompArch == 0 {
+		return fmt.Errorf("seccomp sandboxing is not supported on %s", runtime.GOARCH)
+	}
+	return nil
+}
+
+// enterSandbox applies the limits in spec to this process and replaces it
+// with command. The privilege and seccomp settings are per thread, so the
+// thread that sets them is the one that execs.
+func enterSandbox(spec string, command []string) error {
+	var opts SandboxOptions
+	if err := json.Unmarshal([]byte(spec), &opts); err != nil {
+		return fmt.Errorf("invalid limits: %v", err)
+	}
+	if len(command) == 0 {
+		return fmt.Errorf("no command")
+	}
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return err
+	}
+
+	runtime.LockOSThread()
+	if opts.CPUTime > 0 {
+		seconds := uint64((opts.CPUTime.Seconds()) + 0.5)
+		if seconds == 0 {
+			seconds = 1
+		}
+		// SIGXCPU at the soft limit, SIGKILL a second later
+		if err := unix.Setrlimit(unix.RLIMIT_CPU, &unix.Rlimit{Cur: seconds, Max: seconds + 1}); err != nil {
+			return fmt.Errorf("limit cpu time: %v", err)
+		}
+	}
+	if opts.Memory > 0 {
+		limit := uint64(opts.Memory)
+		if err := unix.Setrlimit(unix.RLIMIT_AS, &unix.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return fmt.Errorf("limit memory: %v", err)
+		}
+	}
+	if opts.OpenFiles > 0 {
+		limit := uint64(opts.OpenFiles)
+		if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &unix.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return fmt.Errorf("limit open files: %v", err)
+		}
+	}
+	if opts.NoNewPrivileges || opts.Seccomp {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("set no_new_privs: %v", err)
+		}
+	}
+	if opts.Seccomp {
+		if err := installSeccomp(); err != nil {
+			return fmt.Errorf("install seccomp filter: %v", err)
+		}
+	}
+	return unix.Exec(path, command, os.Environ())
+}
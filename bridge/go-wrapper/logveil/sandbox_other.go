@@ -0,0 +1,16 @@
+//go:build !linux
+
+package logveil
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// validateSandbox reports whether opts can be applied on this platform
+func validateSandbox(opts SandboxOptions) error {
+	if opts.enabled() {
+		return fmt.Errorf("sandboxing the agent is not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package logveil
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// sealVersion identifies the sealed mapping file layout
+const sealVersion = 1
+
+// scrypt cost parameters for deriving the sealing key from a passphrase
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	sealSaltSize  = 16
+	sealKeyLength = 32
+)
+
+// sealedToken matches pseudonyms produced by a Tokenizer
+var sealedToken = regexp.MustCompile(`\[[^\[\]\s]+_[0-9a-f]{32}\]`)
+
+// ErrSealKey is returned when a sealed mapping file cannot be opened with
+// the given key
+var ErrSealKey = errors.New("wrong key or corrupted mapping file")
+
+// sealedFile is the on-disk envelope. Ciphertext is the AES-256-GCM
+// encrypted JSON object of pseudonyms to original values.
+type sealedFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Sealer keeps the original of every value a Tokenizer replaces so the
+// redaction can be reversed by whoever holds the sealing key
+type Sealer struct {
+	mu     sync.Mutex
+	values SealedMap
+}
+
+// NewSealer returns an empty Sealer
+func NewSealer() *Sealer {
+	return &Sealer{values: make(SealedMap)}
+}
+
+// SealTo makes t record the original of every value it tokenizes in s
+func (t *Tokenizer) SealTo(s *Sealer) {
+	t.sealer = s
+}
+
+func (s *Sealer) record(token, value string) {
+	s.mu.Lock()
+	s.values[token] = value
+	s.mu.Unlock()
+}
+
+// WriteMap encrypts the recorded values with a key derived from passphrase
+// and writes them to path. An existing mapping file at path is merged, so
+// it must have been sealed with the same passphrase.
+func (s *Sealer) WriteMap(path string, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return fmt.Errorf("seal %s: empty key", path)
+	}
+
+	s.mu.Lock()
+	values := make(SealedMap, len(s.values))
+	for token, value := range s.values {
+		values[token] = value
+	}
+	s.mu.Unlock()
+
+	existing, err := LoadSealedMap(path, passphrase)
+	switch {
+	case err == nil:
+		for token, value := range existing {
+			if _, ok := values[token]; !ok {
+				values[token] = value
+			}
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("seal %s: %v", path, err)
+	}
+	file := sealedFile{Version: sealVersion, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP}
+	file.Salt = make([]byte, sealSaltSize)
+	if _, err := rand.Read(file.Salt); err != nil {
+		return fmt.Errorf("seal %s: %v", path, err)
+	}
+	aead, err := file.cipher(passphrase)
+	if err != nil {
+		return fmt.Errorf("seal %s: %v", path, err)
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return fmt.Errorf("seal %s: %v", path, err)
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, plaintext, file.header())
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("seal %s: %v", path, err)
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0o600)
+}
+
+// SealedMap maps pseudonyms to the original values they replaced
+type SealedMap map[string]string
+
+// LoadSealedMap decrypts the mapping file at path. It returns ErrSealKey
+// when passphrase does not open it.
+func LoadSealedMap(path string, passphrase []byte) (SealedMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open mapping file: %w", err)
+	}
+
+	var file sealedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("mapping file %s: %v", path, err)
+	}
+	if file.Version != sealVersion || file.KDF != "scrypt" {
+		return nil, fmt.Errorf("mapping file %s: unsupported version %d (%s)", path, file.Version, file.KDF)
+	}
+	// The header is only authenticated once the key it derives opens the
+	// file, so costs beyond what WriteMap uses are refused unread rather
+	// than let a crafted file exhaust memory and CPU
+	if file.N < 2 || file.N > scryptN || file.R < 1 || file.R > scryptR || file.P < 1 || file.P > scryptP {
+		return nil, fmt.Errorf("mapping file %s: scrypt parameters N=%d r=%d p=%d are outside N<=%d r<=%d p<=%d", path, file.N, file.R, file.P, scryptN, scryptR, scryptP)
+	}
+	aead, err := file.cipher(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("mapping file %s: %v", path, err)
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("mapping file %s: %w", path, ErrSealKey)
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, file.header())
+	if err != nil {
+		return nil, fmt.Errorf("mapping file %s: %w", path, ErrSealKey)
+	}
+
+	values := make(SealedMap)
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("mapping file %s: %v", path, err)
+	}
+	return values, nil
+}
+
+// cipher derives the AES-GCM cipher for f from passphrase
+func (f *sealedFile) cipher(passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, f.Salt, f.N, f.R, f.P, sealKeyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// header is authenticated alongside the ciphertext so the KDF parameters
+// cannot be altered
+func (f *sealedFile) header() []byte {
+	return []byte(fmt.Sprintf("logveil-seal v%d %s N=%d r=%d p=%d", f.Version, f.KDF, f.N, f.R, f.P))
+}
+
+// Unveil replaces every pseudonym in line that m knows with its original
+// value and reports how many it restored
+func (m SealedMap) Unveil(line string) (string, int) {
+	restored := 0
+	line = sealedToken.ReplaceAllStringFunc(line, func(token string) string {
+		if value, ok := m[token]; ok {
+			restored++
+			return value
+		}
+		return token
+	})
+	return line, restored
+}
+
+// UnveilResult summarizes an UnveilStream run
+type UnveilResult struct {
+	Success        bool `json:"success"`
+	LinesProcessed int  `json:"lines_processed"`
+	Restored       int  `json:"restored"`
+}
+
+// UnveilStream copies r to w line by line, restoring pseudonyms known to m
+func (m SealedMap) UnveilStream(r io.Reader, w io.Writer) (*UnveilResult, error) {
+	result := &UnveilResult{}
+	reader := bufio.NewReader(r)
+	writer := bufio.NewWriter(w)
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			writer.Flush()
+			return result, fmt.Errorf("read input: %v", readErr)
+		}
+		if line != "" {
+			text, ending := splitLineEnding(line)
+			text, restored := m.Unveil(text)
+			if _, err := writer.WriteString(text + ending); err != nil {
+				return result, fmt.Errorf("write output: %v", err)
+			}
+			result.LinesProcessed++
+			result.Restored += restored
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return result, fmt.Errorf("write output: %v", err)
+	}
+	result.Success = true
+	return result, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,130 @@
+package logveil
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sealTestMap writes a mapping file of the values tokenizer redacts
+func sealTestMap(t *testing.T, passphrase string, values ...string) (string, *Tokenizer) {
+	t.Helper()
+	tokenizer, err := NewTokenizer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealer := NewSealer()
+	tokenizer.SealTo(sealer)
+	for _, value := range values {
+		tokenizer.Token("email", value)
+	}
+	path := filepath.Join(t.TempDir(), "map.seal")
+	if err := sealer.WriteMap(path, []byte(passphrase)); err != nil {
+		t.Fatal(err)
+	}
+	return path, tokenizer
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	path, tokenizer := sealTestMap(t, "passphrase", "alice@example.com", "bob@example.com")
+	alice := tokenizer.Token("email", "alice@example.com")
+	bob := tokenizer.Token("email", "bob@example.com")
+
+	m, err := LoadSealedMap(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line     string
+		want     string
+		restored int
+	}{
+		{"from " + alice + " to " + bob, "from alice@example.com to bob@example.com", 2},
+		{"no pseudonyms", "no pseudonyms", 0},
+		{"unknown [EMAIL_0123456789abcdef0123456789abcdef]", "unknown [EMAIL_0123456789abcdef0123456789abcdef]", 0},
+	}
+	for _, tt := range tests {
+		got, restored := m.Unveil(tt.line)
+		if got != tt.want || restored != tt.restored {
+			t.Errorf("Unveil(%q) = %q, %d; want %q, %d", tt.line, got, restored, tt.want, tt.restored)
+		}
+	}
+
+	// A second write merges into the file rather than replacing it
+	sealer := NewSealer()
+	tokenizer.SealTo(sealer)
+	carol := tokenizer.Token("email", "carol@example.com")
+	if err := sealer.WriteMap(path, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = LoadSealedMap(path, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	if m[alice] != "alice@example.com" || m[carol] != "carol@example.com" {
+		t.Errorf("merged map = %v, want alice and carol", m)
+	}
+}
+
+func TestSealTamper(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+		tamper     func(f *sealedFile)
+		// wantKeyErr is set when the damage must read as ErrSealKey
+		wantKeyErr bool
+		wantErr    string
+	}{
+		{name: "wrong key", passphrase: "other", wantKeyErr: true},
+		{name: "ciphertext", tamper: func(f *sealedFile) { f.Ciphertext[0] ^= 1 }, wantKeyErr: true},
+		{name: "tag", tamper: func(f *sealedFile) { f.Ciphertext[len(f.Ciphertext)-1] ^= 1 }, wantKeyErr: true},
+		{name: "nonce", tamper: func(f *sealedFile) { f.Nonce[0] ^= 1 }, wantKeyErr: true},
+		{name: "short nonce", tamper: func(f *sealedFile) { f.Nonce = f.Nonce[1:] }, wantKeyErr: true},
+		{name: "salt", tamper: func(f *sealedFile) { f.Salt[0] ^= 1 }, wantKeyErr: true},
+		{name: "lowered cost", tamper: func(f *sealedFile) { f.N = scryptN / 2 }, wantKeyErr: true},
+		{name: "raised N", tamper: func(f *sealedFile) { f.N = 1 << 30 }, wantErr: "scrypt parameters"},
+		{name: "raised r", tamper: func(f *sealedFile) { f.R = 1 << 20 }, wantErr: "scrypt parameters"},
+		{name: "raised p", tamper: func(f *sealedFile) { f.P = 1 << 20 }, wantErr: "scrypt parameters"},
+		{name: "zero N", tamper: func(f *sealedFile) { f.N = 0 }, wantErr: "scrypt parameters"},
+		{name: "version", tamper: func(f *sealedFile) { f.Version = sealVersion + 1 }, wantErr: "unsupported version"},
+		{name: "kdf", tamper: func(f *sealedFile) { f.KDF = "pbkdf2" }, wantErr: "unsupported version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, _ := sealTestMap(t, "passphrase", "alice@example.com")
+			if tt.tamper != nil {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var file sealedFile
+				if err := json.Unmarshal(data, &file); err != nil {
+					t.Fatal(err)
+				}
+				tt.tamper(&file)
+				if data, err = json.Marshal(file); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			passphrase := tt.passphrase
+			if passphrase == "" {
+				passphrase = "passphrase"
+			}
+
+			m, err := LoadSealedMap(path, []byte(passphrase))
+			switch {
+			case err == nil:
+				t.Fatalf("LoadSealedMap opened a damaged map: %v", m)
+			case tt.wantKeyErr && !errors.Is(err, ErrSealKey):
+				t.Errorf("LoadSealedMap error = %v, want ErrSealKey", err)
+			case !tt.wantKeyErr && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("LoadSealedMap error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
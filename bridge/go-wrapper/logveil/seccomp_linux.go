@@ -0,0 +1,82 @@
+//go:build linux && (amd64 || arm64)
+
+package logveil
+
+import (
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompArch is the audit architecture the filter accepts; calls made
+// under any other ABI kill the process
+var seccompArch uint32 = map[string]uint32{
+	"amd64": unix.AUDIT_ARCH_X86_64,
+	"arm64": unix.AUDIT_ARCH_AARCH64,
+}[runtime.GOARCH]
+
+// seccompDenied are the system calls the agent never needs: tracing and
+// reading other processes, mounts and namespaces, kernel modules, BPF,
+// performance counters, keyrings, io_uring, which bypasses the filter, and
+// rebooting or swapping
+var seccompDenied = []uint32{
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_UNSHARE, unix.SYS_SETNS,
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY,
+	unix.SYS_IO_URING_SETUP, unix.SYS_IO_URING_ENTER, unix.SYS_IO_URING_REGISTER,
+	unix.SYS_REBOOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF,
+}
+
+// Offsets into struct seccomp_data
+const (
+	seccompNR        = 0
+	seccompArg0      = 16
+	seccompAuditArch = 4
+)
+
+// installSeccomp confines the calling thread, and the program it execs, to
+// the filter: the denied calls fail with EPERM and sockets other than Unix
+// ones, so any network access, with EACCES
+func installSeccomp() error {
+	errno := func(e unix.Errno) uint32 { return unix.SECCOMP_RET_ERRNO | uint32(e) }
+	load := func(offset uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offset}
+	}
+	jeq := func(k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: k, Jt: jt, Jf: jf}
+	}
+	ret := func(k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: k}
+	}
+
+	filter := []unix.SockFilter{
+		load(seccompAuditArch),
+		jeq(seccompArch, 1, 0),
+		ret(unix.SECCOMP_RET_KILL_PROCESS),
+		load(seccompNR),
+	}
+	if runtime.GOARCH == "amd64" {
+		// x32 calls share the arch but set bit 30 of the number
+		filter = append(filter,
+			unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, K: 0x40000000, Jt: 0, Jf: 1},
+			ret(unix.SECCOMP_RET_KILL_PROCESS))
+	}
+	for _, nr := range seccompDenied {
+		filter = append(filter, jeq(nr, 0, 1), ret(errno(unix.EPERM)))
+	}
+	filter = append(filter,
+		jeq(unix.SYS_SOCKET, 0, 3),
+		load(seccompArg0),
+		jeq(unix.AF_UNIX, 1, 0),
+		ret(errno(unix.EACCES)),
+		ret(unix.SECCOMP_RET_ALLOW),
+	)
+
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0)
+}
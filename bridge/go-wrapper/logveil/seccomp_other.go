@@ -0,0 +1,12 @@
+//go:build linux && !amd64 && !arm64
+
+package logveil
+
+import "fmt"
+
+// seccompArch is zero where no filter is available
+var seccompArch uint32
+
+func installSeccomp() error {
+	return fmt.Errorf("not supported on this architecture")
+}
@@ -0,0 +1,128 @@
+package logveil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity ranks how damaging a leak of a rule's matches would be
+type Severity int
+
+const (
+	SeverityLow Severity = iota + 1
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityLow:      "low",
+	SeverityMedium:   "medium",
+	SeverityHigh:     "high",
+	SeverityCritical: "critical",
+}
+
+// ParseSeverity parses low, medium, high or critical
+func ParseSeverity(name string) (Severity, error) {
+	for severity, n := range severityNames {
+		if strings.EqualFold(name, n) {
+			return severity, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q (expected low, medium, high or critical)", name)
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// builtinSeverities rates the built-in detectors and rule packs. Hashes and
+// identifiers are rarely secret on their own; credentials are.
+var builtinSeverities = map[string]Severity{
+	"uuid":          SeverityLow,
+	"sha256":        SeverityLow,
+	"sha1":          SeverityLow,
+	"md5":           SeverityLow,
+	"eu_vat_number": SeverityLow,
+	"numeric_field": SeverityLow,
+
+	"ip_address":     SeverityMedium,
+	"email":          SeverityMedium,
+	"phone":          SeverityMedium,
+	"intl_phone":     SeverityMedium,
+	"npi":            SeverityMedium,
+	"dea_number":     SeverityMedium,
+	"diagnosis_code": SeverityMedium,
+	"card_expiry":    SeverityMedium,
+
+	"high_entropy":          SeverityHigh,
+	"jwt":                   SeverityHigh,
+	"aws_access_key":        SeverityHigh,
+	"aws_access_key_id":     SeverityHigh,
+	"api_key":               SeverityHigh,
+	"bearer_token":          SeverityHigh,
+	"gcp_private_key_id":    SeverityHigh,
+	"gcp_api_key":           SeverityHigh,
+	"azure_sas_token":       SeverityHigh,
+	"github_token":          SeverityHigh,
+	"gitlab_token":          SeverityHigh,
+	"slack_token":           SeverityHigh,
+	"slack_webhook":         SeverityHigh,
+	"medical_record_number": SeverityHigh,
+	"health_plan_id":        SeverityHigh,
+	"medicare_mbi":          SeverityHigh,
+	"date_of_birth":         SeverityHigh,
+	"iban":                  SeverityHigh,
+
+	"aws_secret_key":          SeverityCritical,
+	"aws_secret_access_key":   SeverityCritical,
+	"credit_card":             SeverityCritical,
+	"pan":                     SeverityCritical,
+	"card_track_data":         SeverityCritical,
+	"card_verification_code":  SeverityCritical,
+	"ssn":                     SeverityCritical,
+	"uk_nino":                 SeverityCritical,
+	"es_dni":                  SeverityCritical,
+	"it_codice_fiscale":       SeverityCritical,
+	"fr_insee":                SeverityCritical,
+	"passport_number":         SeverityCritical,
+	"password":                SeverityCritical,
+	"private_key":             SeverityCritical,
+	"gcp_service_account_key": SeverityCritical,
+	"azure_storage_key":       SeverityCritical,
+	"stripe_secret_key":       SeverityCritical,
+}
+
+// builtinSeverity returns the severity of a built-in rule, medium for any
+// other name
+func builtinSeverity(rule string) Severity {
+	if severity, ok := builtinSeverities[rule]; ok {
+		return severity
+	}
+	return SeverityMedium
+}
+
+// Severity returns the severity of the named rule: the one its rules file
+// declares, or the built-in rating
+func (r *Redactor) Severity(rule string) Severity {
+	r = r.snapshot()
+	engine := r.engine
+	if f, ok := engine.(*formatEngine); ok {
+		engine = f.Engine
+	}
+	if native, ok := engine.(*NativeEngine); ok {
+		for _, d := range native.detectors {
+			if d.name == rule && d.severity != 0 {
+				return d.severity
+			}
+		}
+	}
+	return builtinSeverity(rule)
+}
@@ -0,0 +1,270 @@
+package logveil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpDialTimeout bounds connecting to a host and the SSH handshake
+const sftpDialTimeout = 30 * time.Second
+
+// SFTPOptions configures how sftp:// inputs authenticate
+type SFTPOptions struct {
+	// KeyFile is the private key to authenticate with. Empty offers the keys
+	// of a running ssh-agent and ~/.ssh/id_ed25519, id_ecdsa and id_rsa.
+	KeyFile string
+	// KnownHosts is the known_hosts file host keys are verified against;
+	// empty is ~/.ssh/known_hosts. Unknown hosts are refused.
+	KnownHosts string
+}
+
+var (
+	sftpOptionsMu sync.Mutex
+	sftpOptions   SFTPOptions
+)
+
+// ConfigureSFTP sets how sftp:// inputs authenticate from now on, for every
+// Redactor. Connections already open are kept.
+func ConfigureSFTP(opts SFTPOptions) {
+	sftpOptionsMu.Lock()
+	defer sftpOptionsMu.Unlock()
+	sftpOptions = opts
+}
+
+func currentSFTPOptions() SFTPOptions {
+	sftpOptionsMu.Lock()
+	defer sftpOptionsMu.Unlock()
+	return sftpOptions
+}
+
+// sftpStore reads sftp://[user@]host[:port]/path URIs over SSH with
+// public key authentication, as the current user unless the URI names one.
+// One connection per user and host is kept open and shared.
+type sftpStore struct {
+	mu      sync.Mutex
+	clients map[string]*sftp.Client
+}
+
+func newSFTPStore(context.Context) (objectStore, error) {
+	return &sftpStore{clients: make(map[string]*sftp.Client)}, nil
+}
+
+// client returns the connection for the user and host of u, dialing it on
+// first use, and the path u names
+func (s *sftpStore) client(ctx context.Context, u *url.URL) (*sftp.Client, string, error) {
+	if u.Hostname() == "" || u.Path == "" || u.Path == "/" {
+		return nil, "", fmt.Errorf("invalid SFTP URI %q (expected sftp://[user@]host[:port]/path)", u.Redacted())
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, "", fmt.Errorf("SFTP URIs cannot carry a password; authenticate with a key")
+	}
+	name := u.User.Username()
+	if name == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, "", err
+		}
+		name = current.Username
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	key := name + "@" + addr
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if client, ok := s.clients[key]; ok {
+		return client, u.Path, nil
+	}
+	conn, err := dialSSH(ctx, name, addr, currentSFTPOptions())
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("start SFTP on %s: %v", addr, err)
+	}
+	s.clients[key] = client
+	// Forget the connection once it drops, so the next open dials again
+	go func() {
+		client.Wait()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.clients[key] == client {
+			delete(s.clients, key)
+		}
+	}()
+	return client, u.Path, nil
+}
+
+func (s *sftpStore) open(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	client, path, err := s.client(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	file, err := client.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s on %s: %v", path, u.Host, err)
+	}
+	return file, nil
+}
+
+func (s *sftpStore) size(ctx context.Context, u *url.URL) (int64, error) {
+	client, path, err := s.client(ctx, u)
+	if err != nil {
+		return 0, err
+	}
+	info, err := client.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("stat %s on %s: %v", path, u.Host, err)
+	}
+	return info.Size(), nil
+}
+
+func (s *sftpStore) create(context.Context, *url.URL) (objectWriter, error) {
+	return nil, fmt.Errorf("sftp:// URIs are inputs only")
+}
+
+// dialSSH connects and authenticates to addr as name. Host keys are
+// verified against known_hosts; a host known only by another key type is
+// dialed again offering just the types on record.
+func dialSSH(ctx context.Context, name, addr string, opts SFTPOptions) (*ssh.Client, error) {
+	knownHosts := opts.KnownHosts
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts: %v", err)
+	}
+	signers, closeAgent, err := sshSigners(opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+
+	config := &ssh.ClientConfig{
+		User:            name,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeys,
+		Timeout:         sftpDialTimeout,
+	}
+	client, err := handshakeSSH(ctx, addr, config)
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) && len(keyErr.Want) > 0 && config.HostKeyAlgorithms == nil {
+		for _, known := range keyErr.Want {
+			config.HostKeyAlgorithms = append(config.HostKeyAlgorithms, hostKeyAlgorithms(known.Key.Type())...)
+		}
+		client, err = handshakeSSH(ctx, addr, config)
+	}
+	if errors.As(err, &keyErr) {
+		if len(keyErr.Want) == 0 {
+			return nil, fmt.Errorf("%s is not in %s; add its host key, e.g. with ssh-keyscan", addr, knownHosts)
+		}
+		return nil, fmt.Errorf("host key of %s does not match %s", addr, knownHosts)
+	}
+	return client, err
+}
+
+// handshakeSSH dials addr and runs the SSH handshake within the dial timeout
+func handshakeSSH(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: sftpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(sftpDialTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// hostKeyAlgorithms lists the signature algorithms a host key of keyType
+// verifies with
+func hostKeyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// sshSigners returns the keys to offer: keyFile alone when set, otherwise
+// those of ssh-agent and the default identity files. Passphrase-protected
+// default keys are skipped, being usable through the agent. The returned
+// func closes the agent connection once authenticated.
+func sshSigners(keyFile string) ([]ssh.Signer, func(), error) {
+	if keyFile != "" {
+		signer, err := loadSSHKey(keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []ssh.Signer{signer}, func() {}, nil
+	}
+
+	var signers []ssh.Signer
+	closeAgent := func() {}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+				closeAgent = func() { conn.Close() }
+			} else {
+				conn.Close()
+			}
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			if signer, err := loadSSHKey(filepath.Join(home, ".ssh", name)); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) == 0 {
+		closeAgent()
+		return nil, nil, fmt.Errorf("no SSH key to authenticate with: set a key file or add one to ssh-agent")
+	}
+	return signers, closeAgent, nil
+}
+
+// loadSSHKey reads an unencrypted private key
+func loadSSHKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("%s is passphrase-protected; add it to ssh-agent instead", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read SSH key %s: %v", path, err)
+	}
+	return signer, nil
+}
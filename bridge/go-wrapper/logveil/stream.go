@@ -0,0 +1,256 @@
+package logveil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// TruncationMarker ends the output of a run that was interrupted, so a
+// partial file cannot be mistaken for a complete one
+const TruncationMarker = "[LOGVEIL: output truncated, processing was interrupted]"
+
+// FailedLineMarker stands in for a line the engine could not redact, so
+// the output keeps the input's line numbering
+const FailedLineMarker = "[LOGVEIL: line could not be redacted]"
+
+// maxFailedLines is how many lines may fail before a stream is abandoned,
+// since an engine failing that often is unlikely to recover
+const maxFailedLines = 1000
+
+// redactStream feeds r through engine line by line, or record by record
+// when multi-line records are configured, and writes the result to w.
+// Output is flushed whenever no more input is buffered, so interactive
+// pipelines such as `tail -f` see each line as soon as it is redacted.
+// Cancelling ctx stops at a record boundary: the records already redacted
+// are written, followed by TruncationMarker, and the result counts them.
+// A record the engine fails on is replaced by FailedLineMarker and listed
+// in the result's FailedLines, and the stream carries on. Lines over the
+// engine's LineOptions limit are split or truncated and counted in
+// LongLines. UTF-16 and Latin-1 input is converted to UTF-8 first; binary
+// input is skipped and nothing is written, unless the format frames binary
+// data.
+func redactStream(ctx context.Context, engine Engine, r io.Reader, w io.Writer) (*ProcessResult, error) {
+	text, encoding, err := engineInput(r, engine)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "read input: %v", err))
+	}
+	if encoding == SkippedBinary {
+		return skippedResult(SkippedBinary)
+	}
+	result, err := redactCheckpointed(ctx, engine, text, w, nil)
+	result.Encoding = encoding
+	return result, err
+}
+
+// redactCheckpointed is redactStream reporting progress to cp, if set,
+// whenever the output is flushed. A resumed cp seeds the result with the
+// counts of the runs before it.
+func redactCheckpointed(ctx context.Context, engine Engine, r io.Reader, w io.Writer, cp *checkpointer) (*ProcessResult, error) {
+	startTime := time.Now()
+	result := cp.resumedResult()
+
+	fail := func(err error) (*ProcessResult, error) {
+		result.addError(err)
+		result.finish(startTime)
+		return result, err
+	}
+
+	engine = forStream(engine)
+	if placeholders := enginePlaceholders(engine); placeholders != nil {
+		// The stream is one document for numbering placeholders
+		ctx = placeholders.withDocument(ctx)
+	}
+	reader := newRecordReader(r, engine)
+	writer := bufio.NewWriter(w)
+
+	interrupted := func(cause error) (*ProcessResult, error) {
+		if err := writer.Flush(); err == nil {
+			cp.save(result)
+		}
+		if _, err := writer.WriteString(TruncationMarker + "\n"); err == nil {
+			if err := writer.Flush(); err == nil {
+				result.BytesWritten += int64(len(TruncationMarker) + 1)
+				result.Truncated = true
+			}
+		}
+		return fail(cancelError(StageRedact, cause))
+	}
+
+	// line is the number of input lines read so far, and continued is set
+	// when the last record was a piece of a long line
+	line := cp.resumedLines()
+	continued := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return interrupted(err)
+		}
+
+		record, lines, readErr := reader.read()
+		if readErr != nil && readErr != io.EOF {
+			if err := ctx.Err(); err != nil {
+				// The input was closed to unblock a pending read
+				return interrupted(err)
+			}
+			if err := writer.Flush(); err == nil {
+				cp.save(result)
+			}
+			return fail(newError(CodeIO, StageRead, "read input: %v", readErr))
+		}
+
+		if record != "" {
+			result.BytesRead += int64(len(record))
+			body, ending := splitLineEnding(record)
+			var redacted string
+			var detections []Detection
+			var err error
+			// Only the start of a truncated line is kept
+			if !continued || !reader.truncate {
+				redacted, detections, err = engine.RedactLine(ctx, body)
+			}
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return interrupted(ctxErr)
+				}
+				if n := len(result.FailedLines); n == 0 || result.FailedLines[n-1].Number != line+1 {
+					result.FailedLines = append(result.FailedLines, RedactedLine{Number: line + 1, Errors: []string{err.Error()}})
+				}
+				if len(result.FailedLines) > maxFailedLines {
+					writer.Flush()
+					return fail(newError(CodeRedact, StageRedact, "line %d: %v", line+1, err))
+				}
+				redacted, detections = FailedLineMarker, nil
+			} else {
+				result.LinesProcessed += lines
+			}
+			if lines == 0 && !continued {
+				result.LongLines++
+				if reader.truncate {
+					redacted += TruncatedLineMarker
+				}
+			}
+			if _, err := writer.WriteString(redacted + ending); err != nil {
+				return fail(newError(CodeIO, StageWrite, "write output: %v", err))
+			}
+			result.BytesWritten += int64(len(redacted) + len(ending))
+			result.addDetections(detections)
+			line += lines
+			continued = lines == 0
+			cp.consumed(record, lines)
+		}
+
+		if readErr == io.EOF || reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return fail(newError(CodeIO, StageWrite, "write output: %v", err))
+			}
+			cp.flushed(result)
+		}
+		if readErr == io.EOF {
+			cp.complete()
+			break
+		}
+	}
+
+	if failed := len(result.FailedLines); failed > 0 {
+		return fail(newError(CodeRedact, StageRedact, "%d of %d lines could not be redacted, first line %d", failed, line, result.FailedLines[0].Number))
+	}
+	result.Success = true
+	result.finish(startTime)
+	return result, nil
+}
+
+// processFileByLine redacts inputPath into outputPath one line at a time
+// through engine.RedactLine
+func processFileByLine(ctx context.Context, engine Engine, inputPath, outputPath string) (*ProcessResult, error) {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageRead, "open input: %v", err))
+	}
+	defer in.Close()
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return failedResult(newError(CodeIO, StageWrite, "create output: %v", err))
+	}
+	defer out.Close()
+
+	result, err := redactStream(ctx, engine, in, out)
+	if result.Skipped != "" {
+		out.Close()
+		os.Remove(outputPath)
+	}
+	return result, err
+}
+
+// appendTruncationMarker ends the file at path with TruncationMarker on a
+// line of its own and returns the bytes added
+func appendTruncationMarker(path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	marker := TruncationMarker + "\n"
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			marker = "\n" + marker
+		}
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return 0, err
+	}
+	if _, err := f.WriteString(marker); err != nil {
+		return 0, err
+	}
+	return int64(len(marker)), f.Close()
+}
+
+// countLines returns the number of lines in the file at path and its size.
+// A final line without a newline is counted.
+func countLines(path string) (int, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var lines int
+	var size int64
+	var last byte
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			lines += bytes.Count(buf[:n], []byte{'\n'})
+			size += int64(n)
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return lines, size, err
+		}
+	}
+	if size > 0 && last != '\n' {
+		lines++
+	}
+	return lines, size, nil
+}
+
+// splitLineEnding separates a trailing "\n" or "\r\n" from line
+func splitLineEnding(line string) (string, string) {
+	if strings.HasSuffix(line, "\r\n") {
+		return line[:len(line)-2], "\r\n"
+	}
+	if strings.HasSuffix(line, "\n") {
+		return line[:len(line)-1], "\n"
+	}
+	return line, ""
+}
@@ -0,0 +1,173 @@
+package logveil
+
+import (
+	"regexp"
+	"strings"
+)
+
+// syslogFormat redacts RFC 5424 and RFC 3164 syslog lines. PRI, version,
+// timestamp, hostname, app name, procid, msgid and the structured-data
+// framing are kept; SD-PARAM values and the message are redacted. Lines
+// that match neither layout are redacted as plain text.
+type syslogFormat struct{}
+
+// rfc5424Header matches PRI VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+var rfc5424Header = regexp.MustCompile(`^<\d{1,3}>[1-9]\d{0,2} \S+ \S+ \S+ \S+ \S+ `)
+
+// rfc3164Header matches an optional PRI, a BSD or RFC 3339 timestamp, the
+// hostname and an optional TAG[pid]: prefix
+var rfc3164Header = regexp.MustCompile(`^(?:<\d{1,3}>)?(?:[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}|\d{4}-\d{2}-\d{2}T\S+) \S+ (?:[^\s:\[]+(?:\[[^\]]*\])?: ?)?`)
+
+// utf8BOM may prefix an RFC 5424 MSG
+const utf8BOM = "\xef\xbb\xbf"
+
+func (syslogFormat) redactLine(line string, redact redactFunc) (string, []Detection, error) {
+	if header := rfc5424Header.FindString(line); header != "" {
+		if redacted, detections, ok, err := redactRFC5424(line, len(header), redact); ok || err != nil {
+			return redacted, detections, err
+		}
+	}
+
+	if header := rfc3164Header.FindString(line); header != "" {
+		msg, detections, err := redact("", line[len(header):])
+		if err != nil {
+			return "", nil, err
+		}
+		return header + msg, detections, nil
+	}
+
+	return redact("", line)
+}
+
+// redactRFC5424 redacts the structured data starting at sdStart and the
+// message after it. ok is false when the structured data is malformed.
+func redactRFC5424(line string, sdStart int, redact redactFunc) (out string, detections []Detection, ok bool, err error) {
+	var b strings.Builder
+	b.WriteString(line[:sdStart])
+	pos := sdStart
+
+	if strings.HasPrefix(line[pos:], "-") {
+		b.WriteByte('-')
+		pos++
+	} else {
+		if pos >= len(line) || line[pos] != '[' {
+			return "", nil, false, nil
+		}
+		for pos < len(line) && line[pos] == '[' {
+			end, elementDetections, err := redactSDElement(line, pos, &b, redact)
+			if err != nil {
+				return "", nil, false, err
+			}
+			if end < 0 {
+				return "", nil, false, nil
+			}
+			detections = append(detections, elementDetections...)
+			pos = end
+		}
+	}
+
+	if pos < len(line) {
+		if line[pos] != ' ' {
+			return "", nil, false, nil
+		}
+		b.WriteByte(' ')
+		pos++
+
+		msg := line[pos:]
+		if strings.HasPrefix(msg, utf8BOM) {
+			b.WriteString(utf8BOM)
+			msg = msg[len(utf8BOM):]
+		}
+		redactedMsg, msgDetections, err := redact("", msg)
+		if err != nil {
+			return "", nil, false, err
+		}
+		b.WriteString(redactedMsg)
+		detections = append(detections, msgDetections...)
+	}
+
+	return b.String(), detections, true, nil
+}
+
+// redactSDElement copies one [SD-ID PARAM="VALUE"...] element starting at
+// pos into b with redacted values, returning the offset after it or -1 if
+// the element is malformed
+func redactSDElement(line string, pos int, b *strings.Builder, redact redactFunc) (int, []Detection, error) {
+	var detections []Detection
+
+	// SD-ID and PARAM-NAME run up to a space, '=' or ']'
+	name := func() string {
+		start := pos
+		for pos < len(line) && strings.IndexByte(" =]\"", line[pos]) < 0 {
+			pos++
+		}
+		return line[start:pos]
+	}
+
+	pos++ // [
+	id := name()
+	if id == "" {
+		return -1, nil, nil
+	}
+	b.WriteByte('[')
+	b.WriteString(id)
+
+	for {
+		if pos >= len(line) {
+			return -1, nil, nil
+		}
+		if line[pos] == ']' {
+			b.WriteByte(']')
+			return pos + 1, detections, nil
+		}
+		if line[pos] != ' ' {
+			return -1, nil, nil
+		}
+		pos++
+
+		param := name()
+		if param == "" || !strings.HasPrefix(line[pos:], `="`) {
+			return -1, nil, nil
+		}
+		pos += 2
+
+		var value strings.Builder
+		closed := false
+		for pos < len(line) && !closed {
+			switch c := line[pos]; c {
+			case '\\':
+				if pos+1 < len(line) && strings.IndexByte(`"\]`, line[pos+1]) >= 0 {
+					value.WriteByte(line[pos+1])
+					pos += 2
+					continue
+				}
+				value.WriteByte(c)
+			case '"':
+				closed = true
+			default:
+				value.WriteByte(c)
+			}
+			pos++
+		}
+		if !closed {
+			return -1, nil, nil
+		}
+
+		redacted, valueDetections, err := redact(id+"."+param, value.String())
+		if err != nil {
+			return -1, nil, err
+		}
+		detections = append(detections, valueDetections...)
+
+		b.WriteByte(' ')
+		b.WriteString(param)
+		b.WriteString(`="`)
+		b.WriteString(escapeSDValue(redacted))
+		b.WriteByte('"')
+	}
+}
+
+// escapeSDValue escapes the characters RFC 5424 reserves in PARAM-VALUE
+func escapeSDValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
@@ -0,0 +1,65 @@
+package logveil
+
+import "testing"
+
+func TestSyslogFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{
+			name: "rfc 5424 message",
+			line: `<34>1 2024-01-02T03:04:05Z host app 42 ID7 - mail alice@example.com`,
+			want: `<34>1 2024-01-02T03:04:05Z host app 42 ID7 - mail [REDACTED_EMAIL]`,
+		},
+		{
+			// ] is escaped in the redacted values, as RFC 5424 requires
+			name: "rfc 5424 structured data",
+			line: `<34>1 2024-01-02T03:04:05Z host app - - [meta user="alice@example.com" n="1"][x@1 ip="10.0.0.1"] done`,
+			want: `<34>1 2024-01-02T03:04:05Z host app - - [meta user="[REDACTED_EMAIL\]" n="1"][x@1 ip="[REDACTED_IP_ADDRESS\]"] done`,
+		},
+		{
+			name: "rfc 5424 escaped value",
+			line: `<34>1 2024-01-02T03:04:05Z host app - - [meta q="say \"hi\" \] alice@example.com"]`,
+			want: `<34>1 2024-01-02T03:04:05Z host app - - [meta q="say \"hi\" \] [REDACTED_EMAIL\]"]`,
+		},
+		{
+			name: "rfc 5424 header kept",
+			line: `<34>1 2024-01-02T03:04:05Z 10.0.0.1 app - - - ok`,
+			want: `<34>1 2024-01-02T03:04:05Z 10.0.0.1 app - - - ok`,
+		},
+		{
+			name: "rfc 5424 byte order mark",
+			line: "<34>1 2024-01-02T03:04:05Z host app - - - \xef\xbb\xbfalice@example.com",
+			want: "<34>1 2024-01-02T03:04:05Z host app - - - \xef\xbb\xbf[REDACTED_EMAIL]",
+		},
+		{
+			name: "rfc 3164",
+			line: `<13>Jan  2 03:04:05 10.0.0.1 sshd[99]: login from 10.0.0.2`,
+			want: `<13>Jan  2 03:04:05 10.0.0.1 sshd[99]: login from [REDACTED_IP_ADDRESS]`,
+		},
+		{
+			name: "rfc 3164 without pri or tag",
+			line: `Jan 12 03:04:05 host user alice@example.com`,
+			want: `Jan 12 03:04:05 host user [REDACTED_EMAIL]`,
+		},
+		{
+			name: "malformed structured data",
+			line: `<34>1 2024-01-02T03:04:05Z host app - - [meta user="alice@example.com" done`,
+			want: `<34>1 2024-01-02T03:04:05Z host app - - [meta user="[REDACTED_EMAIL]" done`,
+		},
+		{
+			name: "not syslog",
+			line: `plain 10.0.0.1`,
+			want: `plain [REDACTED_IP_ADDRESS]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := redactLine(t, Config{Format: "syslog"}, tt.line); got != tt.want {
+				t.Errorf("redacted %s\n got %s\nwant %s", tt.line, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package logveil
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer and meter redaction reports through.
+// Both do nothing until the program installs OpenTelemetry providers.
+const instrumentation = "github.com/logveil/logveil/bridge/go-wrapper/logveil"
+
+var tracer = otel.Tracer(instrumentation)
+
+var (
+	fileCounter      metric.Int64Counter
+	lineCounter      metric.Int64Counter
+	detectionCounter metric.Int64Counter
+	fileDuration     metric.Float64Histogram
+)
+
+func init() {
+	// The global meter forwards to whichever provider is installed later
+	meter := otel.Meter(instrumentation)
+	fileCounter, _ = meter.Int64Counter("logveil.files",
+		metric.WithDescription("Files and streams redacted, by outcome"))
+	lineCounter, _ = meter.Int64Counter("logveil.lines",
+		metric.WithDescription("Lines redacted in files and streams"))
+	detectionCounter, _ = meter.Int64Counter("logveil.detections",
+		metric.WithDescription("Sensitive values redacted in files and streams, by rule"))
+	fileDuration, _ = meter.Float64Histogram("logveil.file.duration",
+		metric.WithDescription("Time taken to redact one file or stream"), metric.WithUnit("s"))
+}
+
+// startSpan starts a span named name under any span in ctx
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endFileSpan records the outcome of redacting one file or stream on span
+// and in the metrics, then ends span
+func endFileSpan(ctx context.Context, span trace.Span, start time.Time, result *ProcessResult, err error) {
+	defer span.End()
+
+	outcome := "success"
+	if err != nil || result == nil || !result.Success {
+		outcome = "failure"
+	}
+	fileCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("outcome", outcome)))
+	fileDuration.Record(ctx, time.Since(start).Seconds())
+
+	if result != nil {
+		span.SetAttributes(
+			attribute.Int("logveil.lines_processed", result.LinesProcessed),
+			attribute.Int64("logveil.bytes_read", result.BytesRead),
+			attribute.Int64("logveil.bytes_written", result.BytesWritten),
+			attribute.Int("logveil.detections", totalDetections(result.Detections)),
+		)
+		lineCounter.Add(ctx, int64(result.LinesProcessed))
+		rules := make([]string, 0, len(result.Detections))
+		for rule, n := range result.Detections {
+			rules = append(rules, rule)
+			detectionCounter.Add(ctx, int64(n), metric.WithAttributes(attribute.String("rule", rule)))
+		}
+		sort.Strings(rules)
+		span.SetAttributes(attribute.StringSlice("logveil.rules", rules))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if result != nil && !result.Success {
+		span.SetStatus(codes.Error, "redaction failed")
+	}
+}
+
+func totalDetections(detections map[string]int) int {
+	total := 0
+	for _, n := range detections {
+		total += n
+	}
+	return total
+}
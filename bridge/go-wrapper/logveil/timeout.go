@@ -0,0 +1,57 @@
+package logveil
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// TimeoutAuto scales the processing timeout with the size of the input file
+const TimeoutAuto time.Duration = -1
+
+const (
+	// baseTimeout is the minimum budget given to any single file
+	baseTimeout = 30 * time.Second
+	// timeoutPerMiB is added for every MiB of input, sized for the Python
+	// agent which is the slowest engine
+	timeoutPerMiB = time.Second
+)
+
+// scaledTimeout returns the automatic timeout for a file of size bytes
+func scaledTimeout(size int64) time.Duration {
+	return baseTimeout + time.Duration(size>>20)*timeoutPerMiB
+}
+
+// fileTimeout resolves the configured timeout for inputPath; zero means
+// unlimited
+func (r *Redactor) fileTimeout(ctx context.Context, inputPath string) time.Duration {
+	if r.timeout != TimeoutAuto {
+		return r.timeout
+	}
+	if IsRemote(inputPath) {
+		// Remote objects aren't sniffed for compression, so assume the
+		// worst case
+		size, err := remoteSize(ctx, inputPath)
+		if err != nil {
+			return baseTimeout
+		}
+		return scaledTimeout(size * compressedSizeFactor)
+	}
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return baseTimeout
+	}
+	size := info.Size()
+	if codec, err := fileCompression(inputPath); err == nil && codec != "" {
+		size *= compressedSizeFactor
+	}
+	return scaledTimeout(size)
+}
+
+// withTimeout bounds ctx by timeout unless it is zero
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
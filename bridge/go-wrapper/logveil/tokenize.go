@@ -0,0 +1,68 @@
+package logveil
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// tokenKeySize is the length of generated tokenization keys
+const tokenKeySize = 32
+
+// tokenHexLength is how many hex digits of the HMAC appear in a token: 128
+// bits, so two values only share a pseudonym, and a sealed map reveals the
+// wrong one, after some 2^64 distinct values
+const tokenHexLength = 32
+
+// Tokenizer replaces sensitive values with stable pseudonyms such as
+// [EMAIL_5f2c9a0b13de4e7a9c1d8b3f60a2e4d7]. The suffix is an HMAC of the
+// value alone, so a value keeps its pseudonym across rules and formats for
+// as long as the key is reused.
+type Tokenizer struct {
+	key []byte
+	// store, when set, records every pseudonym issued
+	store *TokenStore
+	// sealer, when set, keeps the value behind every pseudonym
+	sealer *Sealer
+}
+
+// NewTokenizer returns a Tokenizer keyed by key, or by a random key scoped
+// to this Tokenizer when key is empty
+func NewTokenizer(key []byte) (*Tokenizer, error) {
+	if len(key) == 0 {
+		var err error
+		if key, err = newTokenKey(); err != nil {
+			return nil, err
+		}
+	}
+	return &Tokenizer{key: key}, nil
+}
+
+// Token returns the pseudonym for value found by rule
+func (t *Tokenizer) Token(rule, value string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(value))
+	digest := hex.EncodeToString(mac.Sum(nil))
+	token := "[" + strings.ToUpper(rule) + "_" + digest[:tokenHexLength] + "]"
+	if t.store != nil {
+		t.store.record(rule, token)
+	}
+	if t.sealer != nil {
+		t.sealer.record(token, value)
+	}
+	return token
+}
+
+// Flush writes the pseudonyms issued so far to the token store, if t has
+// one
+func (t *Tokenizer) Flush() error {
+	if t == nil || t.store == nil {
+		return nil
+	}
+	return t.store.Flush()
+}
+
+func (t *Tokenizer) replace(rule, value string) string {
+	return t.Token(rule, value)
+}
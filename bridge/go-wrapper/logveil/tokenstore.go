@@ -0,0 +1,359 @@
+package logveil
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Token store layout: the meta bucket holds the tokenization key, the
+// tokens bucket holds one tokenRecord per pseudonym issued
+var (
+	metaBucket   = []byte("meta")
+	tokensBucket = []byte("tokens")
+	keyEntry     = []byte("key")
+	rotatedEntry = []byte("rotated_at")
+)
+
+// tokenStoreLockTimeout bounds how long OpenTokenStore waits for another
+// process holding the store
+const tokenStoreLockTimeout = 5 * time.Second
+
+// Buffered records are written once this many pseudonyms are pending, or
+// this long after the last write, so long-running modes neither hold the
+// ledger in memory nor lose it in a crash
+const (
+	tokenFlushRecords  = 10000
+	tokenFlushInterval = 30 * time.Second
+)
+
+// tokenRecord is what the store remembers about a pseudonym. Original
+// values are never written to disk.
+type tokenRecord struct {
+	Rule      string    `json:"rule"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Hits      int64     `json:"hits"`
+}
+
+// TokenStore persists the tokenization key and a ledger of issued
+// pseudonyms in a BoltDB file, so pseudonyms stay consistent across files
+// and runs without passing a key on every invocation. Only one process can
+// hold a store at a time.
+type TokenStore struct {
+	db  *bolt.DB
+	key []byte
+
+	mu      sync.Mutex
+	pending map[string]*tokenRecord
+	// flushErr is why the last background write failed, kept for Close
+	flushErr error
+
+	// full asks the background writer to write now; stop ends it and done
+	// is closed once it has
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenTokenStore opens the store at path, creating it with a random key if
+// it does not exist
+func OpenTokenStore(path string) (*TokenStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: tokenStoreLockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open token store %s: %v", path, err)
+	}
+
+	s := &TokenStore{db: db, pending: make(map[string]*tokenRecord), full: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(tokensBucket); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if key := meta.Get(keyEntry); key != nil {
+			s.key = append([]byte(nil), key...)
+			return nil
+		}
+		s.key, err = newTokenKey()
+		if err != nil {
+			return err
+		}
+		return meta.Put(keyEntry, s.key)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialize token store %s: %v", path, err)
+	}
+	go s.writeBehind()
+	return s, nil
+}
+
+// writeBehind writes buffered records in batches, whenever enough are
+// pending or the flush interval passes, until Close
+func (s *TokenStore) writeBehind() {
+	defer close(s.done)
+	ticker := time.NewTicker(tokenFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.full:
+		}
+		err := s.Flush()
+		s.mu.Lock()
+		s.flushErr = err
+		s.mu.Unlock()
+	}
+}
+
+// Tokenizer returns a Tokenizer keyed by the store that records every
+// pseudonym it issues
+func (s *TokenStore) Tokenizer() *Tokenizer {
+	return &Tokenizer{key: s.key, store: s}
+}
+
+// record notes that rule produced token. Records are buffered and written
+// in batches in the background so redaction doesn't wait on disk syncs.
+func (s *TokenStore) record(rule, token string) {
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.pending[token]
+	if !ok {
+		rec = &tokenRecord{Rule: rule, FirstSeen: now}
+		s.pending[token] = rec
+	}
+	rec.LastSeen = now
+	rec.Hits++
+	if len(s.pending) >= tokenFlushRecords {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush merges buffered records into the ledger in one transaction
+func (s *TokenStore) Flush() error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]*tokenRecord)
+	s.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		tokens := tx.Bucket(tokensBucket)
+		for token, rec := range pending {
+			merged := *rec
+			if data := tokens.Get([]byte(token)); data != nil {
+				var stored tokenRecord
+				if err := json.Unmarshal(data, &stored); err != nil {
+					return fmt.Errorf("token %s: %v", token, err)
+				}
+				merged.FirstSeen = stored.FirstSeen
+				merged.Hits += stored.Hits
+			}
+			data, err := json.Marshal(merged)
+			if err != nil {
+				return err
+			}
+			if err := tokens.Put([]byte(token), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Put the records back for the next write
+		s.mu.Lock()
+		for token, rec := range pending {
+			if newer, ok := s.pending[token]; ok {
+				rec.LastSeen = newer.LastSeen
+				rec.Hits += newer.Hits
+			}
+			s.pending[token] = rec
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Close writes buffered records and releases the store. It reports the
+// last background write that failed if the final one succeeds.
+func (s *TokenStore) Close() error {
+	close(s.stop)
+	<-s.done
+	err := s.Flush()
+	if err == nil {
+		err = s.flushErr
+	}
+	if closeErr := s.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// TokenStoreStats summarizes a compaction or rotation
+type TokenStoreStats struct {
+	Tokens    int   `json:"tokens"`
+	Removed   int   `json:"removed"`
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+// CompactTokenStore drops pseudonyms not seen within maxAge (zero keeps
+// all of them) and rewrites the store at path to reclaim free pages
+func CompactTokenStore(path string, maxAge time.Duration) (*TokenStoreStats, error) {
+	stats := &TokenStoreStats{}
+	cutoff := time.Now().UTC().Add(-maxAge)
+
+	err := updateTokenStore(path, func(tx *bolt.Tx) error {
+		tokens := tx.Bucket(tokensBucket)
+		if tokens == nil {
+			return fmt.Errorf("not a token store")
+		}
+
+		var expired [][]byte
+		err := tokens.ForEach(func(token, data []byte) error {
+			var rec tokenRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return fmt.Errorf("token %s: %v", token, err)
+			}
+			if maxAge > 0 && rec.LastSeen.Before(cutoff) {
+				expired = append(expired, append([]byte(nil), token...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, token := range expired {
+			if err := tokens.Delete(token); err != nil {
+				return err
+			}
+		}
+		stats.Removed = len(expired)
+		stats.Tokens = tokens.Stats().KeyN - len(expired)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.SizeBytes, err = rewriteTokenStore(path); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// RotateTokenStore replaces the key in the store at path and clears its
+// ledger, so pseudonyms issued afterwards cannot be linked to earlier ones
+func RotateTokenStore(path string) (*TokenStoreStats, error) {
+	stats := &TokenStoreStats{}
+	err := updateTokenStore(path, func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		tokens := tx.Bucket(tokensBucket)
+		if meta == nil || tokens == nil {
+			return fmt.Errorf("not a token store")
+		}
+		stats.Removed = tokens.Stats().KeyN
+
+		key, err := newTokenKey()
+		if err != nil {
+			return err
+		}
+		if err := meta.Put(keyEntry, key); err != nil {
+			return err
+		}
+		if err := meta.Put(rotatedEntry, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket(tokensBucket); err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket(tokensBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.SizeBytes, err = rewriteTokenStore(path); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// updateTokenStore runs fn in a write transaction on an existing store
+func updateTokenStore(path string, fn func(tx *bolt.Tx) error) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("token store %s: %v", path, err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: tokenStoreLockTimeout})
+	if err != nil {
+		return fmt.Errorf("open token store %s: %v", path, err)
+	}
+	defer db.Close()
+	if err := db.Update(fn); err != nil {
+		return fmt.Errorf("token store %s: %v", path, err)
+	}
+	return nil
+}
+
+// rewriteTokenStore copies the store at path into a fresh file and swaps it
+// in place, returning the new size. BoltDB never shrinks a file on its own.
+func rewriteTokenStore(path string) (int64, error) {
+	src, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: tokenStoreLockTimeout, ReadOnly: true})
+	if err != nil {
+		return 0, fmt.Errorf("open token store %s: %v", path, err)
+	}
+	defer src.Close()
+
+	tmpPath := path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bolt.Open(tmpPath, 0o600, nil)
+	if err != nil {
+		return 0, fmt.Errorf("compact token store %s: %v", path, err)
+	}
+	if err := bolt.Compact(dst, src, 0); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("compact token store %s: %v", path, err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("compact token store %s: %v", path, err)
+	}
+	src.Close()
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("compact token store %s: %v", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// newTokenKey returns a fresh random tokenization key
+func newTokenKey() ([]byte, error) {
+	key := make([]byte, tokenKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate tokenization key: %v", err)
+	}
+	return key, nil
+}
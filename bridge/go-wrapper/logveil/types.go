@@ -0,0 +1,104 @@
+package logveil
+
+import "time"
+
+// Detection records one rule match
+type Detection struct {
+	Rule string `json:"rule"`
+}
+
+// RedactedLine represents a processed log line
+type RedactedLine struct {
+	// Number is the 1-based input line, where lines are reported from a
+	// file or stream
+	Number     int         `json:"line_number,omitempty"`
+	Line       string      `json:"line"`
+	Timestamp  string      `json:"timestamp,omitempty"`
+	Errors     []string    `json:"errors,omitempty"`
+	Detections []Detection `json:"detections,omitempty"`
+}
+
+// ProcessResult represents the overall processing result. Byte counts are
+// of uncompressed log data; the rates cover the whole run.
+type ProcessResult struct {
+	Success        bool           `json:"success"`
+	LinesProcessed int            `json:"lines_processed"`
+	Errors         []ProcessError `json:"errors,omitempty"`
+	Duration       string         `json:"duration"`
+	Detections     map[string]int `json:"detections,omitempty"`
+	BytesRead      int64          `json:"bytes_read"`
+	BytesWritten   int64          `json:"bytes_written"`
+	LinesPerSecond float64        `json:"lines_per_second,omitempty"`
+	BytesPerSecond float64        `json:"bytes_per_second,omitempty"`
+	// PeakRSSBytes is the most memory the process had held resident when
+	// the input was done, as the operating system reports it; zero where
+	// unknown
+	PeakRSSBytes int64 `json:"peak_rss_bytes,omitempty"`
+	// SubprocessCPUSeconds is the user and system CPU time of the python
+	// agent processes run for the input, retries included. A persistent
+	// worker's time is not split per input and is not counted.
+	SubprocessCPUSeconds float64 `json:"subprocess_cpu_seconds,omitempty"`
+	// Truncated reports that processing was interrupted and the output,
+	// ending in TruncationMarker, covers only the lines counted
+	Truncated bool `json:"truncated,omitempty"`
+	// FailedLines lists, by number and error, the lines that could not be
+	// redacted; their text is never included
+	FailedLines []RedactedLine `json:"failed_lines,omitempty"`
+	// Attempts lists each run of the agent when retries are enabled
+	Attempts []Attempt `json:"attempts,omitempty"`
+	// LongLines counts the lines over the LineOptions limit, which were
+	// redacted in pieces or truncated
+	LongLines int `json:"long_lines,omitempty"`
+	// Encoding names the encoding the input was converted from, such as
+	// EncodingUTF16LE; it is empty for UTF-8
+	Encoding string `json:"encoding,omitempty"`
+	// Skipped gives why the input was left unredacted and no output
+	// written, such as SkippedBinary
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Attempt records one run of the agent for a file
+type Attempt struct {
+	Attempt  int    `json:"attempt"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// finish records the time since startTime, the throughput it implies and
+// the peak memory use
+func (r *ProcessResult) finish(startTime time.Time) {
+	elapsed := time.Since(startTime)
+	r.Duration = elapsed.String()
+	r.PeakRSSBytes = peakRSS()
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		r.LinesPerSecond = float64(r.LinesProcessed) / seconds
+		r.BytesPerSecond = float64(r.BytesRead) / seconds
+	}
+}
+
+// addError records err in r
+func (r *ProcessResult) addError(err error) {
+	r.Errors = append(r.Errors, asProcessError(err))
+}
+
+// addDetections counts detections per rule in r
+func (r *ProcessResult) addDetections(detections []Detection) {
+	if len(detections) == 0 {
+		return
+	}
+	if r.Detections == nil {
+		r.Detections = make(map[string]int)
+	}
+	for _, d := range detections {
+		r.Detections[d.Rule]++
+	}
+}
+
+// failedResult wraps err in a ProcessResult for errors raised before any
+// input was processed
+func failedResult(err error) (*ProcessResult, error) {
+	return &ProcessResult{
+		Errors:   []ProcessError{asProcessError(err)},
+		Duration: time.Duration(0).String(),
+	}, err
+}
@@ -0,0 +1,143 @@
+package logveil
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Symlink policies for WalkOptions.Symlinks
+const (
+	// SymlinkSkip ignores symlinks found in directories
+	SymlinkSkip = "skip"
+	// SymlinkFiles follows symlinks to files but not to directories
+	SymlinkFiles = "files"
+	// SymlinkFollow follows symlinks to files and directories, entering
+	// each directory once so link loops end
+	SymlinkFollow = "follow"
+)
+
+// WalkOptions selects the files ExpandInputs takes from directories and
+// glob matches. Files named directly are always taken.
+type WalkOptions struct {
+	// Include, when set, keeps only files matching one of these globs
+	Include []string
+	// Exclude drops files matching any of these globs, and directories
+	// matching them with everything below
+	Exclude []string
+	// MaxDepth is how many directory levels below an input directory are
+	// searched, 1 for only the files directly in it; 0 is unlimited
+	MaxDepth int
+	// Symlinks is SymlinkSkip (the default), SymlinkFiles or SymlinkFollow
+	Symlinks string
+}
+
+// validate checks the patterns and policy in opts
+func (opts WalkOptions) validate() error {
+	for _, pattern := range append(append([]string(nil), opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if opts.MaxDepth < 0 {
+		return fmt.Errorf("max depth must not be negative: %d", opts.MaxDepth)
+	}
+	switch opts.Symlinks {
+	case "", SymlinkSkip, SymlinkFiles, SymlinkFollow:
+		return nil
+	}
+	return fmt.Errorf("unknown symlink policy %q (expected skip, files or follow)", opts.Symlinks)
+}
+
+// matchAny reports whether the file or directory at rel, a slash-separated
+// path below the walk root, matches one of patterns. A pattern without a
+// slash matches the base name at any depth; one with a slash matches the
+// whole relative path, with ** for any number of directories.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+			continue
+		}
+		if matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(rel, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// selected reports whether the file at rel passes the filters, including
+// those excluding a directory above it
+func (opts WalkOptions) selected(rel string) bool {
+	if len(opts.Include) > 0 && !matchAny(opts.Include, rel) {
+		return false
+	}
+	for dir := rel; dir != "."; dir = path.Dir(dir) {
+		if matchAny(opts.Exclude, dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// walk calls visit for every regular file below root that opts select, in
+// lexical order
+func (opts WalkOptions) walk(root string, visit func(file string) error) error {
+	entered := make(map[string]bool)
+
+	var walkDir func(dir, rel string, depth int) error
+	walkDir = func(dir, rel string, depth int) error {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			if entered[real] {
+				return nil
+			}
+			entered[real] = true
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			file := filepath.Join(dir, entry.Name())
+			fileRel := path.Join(rel, entry.Name())
+			kind := entry.Type()
+			if kind&fs.ModeSymlink != 0 {
+				if opts.Symlinks == "" || opts.Symlinks == SymlinkSkip {
+					continue
+				}
+				info, err := os.Stat(file)
+				if err != nil {
+					// A dangling link has nothing to redact
+					continue
+				}
+				kind = info.Mode().Type()
+				if kind.IsDir() && opts.Symlinks != SymlinkFollow {
+					continue
+				}
+			}
+
+			switch {
+			case kind.IsDir():
+				if matchAny(opts.Exclude, fileRel) || (opts.MaxDepth > 0 && depth >= opts.MaxDepth) {
+					continue
+				}
+				if err := walkDir(file, fileRel, depth+1); err != nil {
+					return err
+				}
+			case kind.IsRegular():
+				if opts.selected(fileRel) {
+					if err := visit(file); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return walkDir(root, "", 1)
+}
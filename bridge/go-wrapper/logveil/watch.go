@@ -0,0 +1,277 @@
+package logveil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultWatchDebounce is how long a file must be quiet before it is
+// redacted
+const defaultWatchDebounce = 2 * time.Second
+
+// WatchOptions configures Watch
+type WatchOptions struct {
+	// Debounce is how long a file must go without writes before it is
+	// redacted; defaults to two seconds
+	Debounce time.Duration
+	// StateFile records the size and modification time of every file
+	// redacted, so a restarted watcher skips files it already handled.
+	// Defaults to .logveil-watch-state.json in the output directory.
+	StateFile string
+	// OnResult, when set, is called after each file is redacted
+	OnResult func(FileResult)
+}
+
+// watchState is the on-disk record of redacted files, keyed by path
+// relative to the watched directory
+type watchState struct {
+	path  string
+	Files map[string]watchedFile `json:"files"`
+}
+
+type watchedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Watch redacts every file under dir into the same relative path under
+// outputDir, then keeps watching for files that are created or modified
+// until ctx is cancelled. Changes are debounced, so a file being written
+// is redacted once writes pause, and a modified file is redacted again in
+// full. Dotfiles and anything under outputDir are ignored.
+func (r *Redactor) Watch(ctx context.Context, dir, outputDir string, opts WatchOptions) error {
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultWatchDebounce
+	}
+	if opts.StateFile == "" {
+		opts.StateFile = filepath.Join(outputDir, ".logveil-watch-state.json")
+	}
+
+	w := &watcher{
+		redactor: r,
+		opts:     opts,
+		timers:   make(map[string]*time.Timer),
+		ready:    make(chan string, 64),
+		done:     make(chan struct{}),
+	}
+	var err error
+	if w.dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+	if w.outputDir, err = filepath.Abs(outputDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(w.outputDir, 0o755); err != nil {
+		return fmt.Errorf("create output directory: %v", err)
+	}
+	if w.state, err = loadWatchState(opts.StateFile); err != nil {
+		return err
+	}
+
+	if w.notify, err = fsnotify.NewWatcher(); err != nil {
+		return err
+	}
+	defer w.notify.Close()
+	if err := w.addTree(w.dir); err != nil {
+		return err
+	}
+
+	return w.run(ctx)
+}
+
+type watcher struct {
+	redactor  *Redactor
+	opts      WatchOptions
+	dir       string
+	outputDir string
+	state     *watchState
+	notify    *fsnotify.Watcher
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	// ready receives files whose debounce has expired
+	ready chan string
+	// done is closed when run returns so pending timers don't block
+	done chan struct{}
+}
+
+// run dispatches events and redacts debounced files one at a time
+func (w *watcher) run(ctx context.Context) error {
+	defer close(w.done)
+	defer w.stopTimers()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.notify.Events:
+			if !ok {
+				return nil
+			}
+			w.handle(event)
+		case err, ok := <-w.notify.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("watch %s: %v", w.dir, err)
+		case path := <-w.ready:
+			w.process(ctx, path)
+		}
+	}
+}
+
+func (w *watcher) handle(event fsnotify.Event) {
+	if w.ignored(event.Name) {
+		return
+	}
+	switch {
+	case event.Has(fsnotify.Create):
+		info, err := os.Stat(event.Name)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			w.addTree(event.Name)
+			return
+		}
+		w.schedule(event.Name)
+	case event.Has(fsnotify.Write):
+		w.schedule(event.Name)
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		w.mu.Lock()
+		if timer, ok := w.timers[event.Name]; ok {
+			timer.Stop()
+			delete(w.timers, event.Name)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// addTree watches root and every directory below it, scheduling the files
+// already there
+func (w *watcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != root && w.ignored(p) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := w.notify.Add(p); err != nil {
+				return fmt.Errorf("watch %s: %v", p, err)
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			w.schedule(p)
+		}
+		return nil
+	})
+}
+
+// ignored reports whether path is a dotfile or lies inside the output
+// directory
+func (w *watcher) ignored(path string) bool {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return true
+	}
+	return path == w.outputDir || strings.HasPrefix(path, w.outputDir+string(filepath.Separator))
+}
+
+// schedule (re)starts path's debounce timer
+func (w *watcher) schedule(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if timer, ok := w.timers[path]; ok {
+		timer.Reset(w.opts.Debounce)
+		return
+	}
+	w.timers[path] = time.AfterFunc(w.opts.Debounce, func() {
+		w.mu.Lock()
+		delete(w.timers, path)
+		w.mu.Unlock()
+		select {
+		case w.ready <- path:
+		case <-w.done:
+		}
+	})
+}
+
+func (w *watcher) stopTimers() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path, timer := range w.timers {
+		timer.Stop()
+		delete(w.timers, path)
+	}
+}
+
+// process redacts path unless the state shows it unchanged since it was
+// last redacted
+func (w *watcher) process(ctx context.Context, path string) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	rel, err := filepath.Rel(w.dir, path)
+	if err != nil {
+		return
+	}
+	current := watchedFile{Size: info.Size(), ModTime: info.ModTime().UTC()}
+	if seen, ok := w.state.Files[rel]; ok && seen.Size == current.Size && seen.ModTime.Equal(current.ModTime) {
+		return
+	}
+
+	// Each file is redacted as configured when it is, reloads included
+	redactor, release := w.redactor.acquire()
+	defer release()
+	job := FileJob{Input: path, Output: compressedName(filepath.Join(w.outputDir, rel), redactor.compression)}
+	result := redactor.processJob(ctx, job)
+	if result.Error == "" {
+		w.state.Files[rel] = current
+		if err := w.state.save(); err != nil {
+			result.Error = fmt.Sprintf("save watch state: %v", err)
+		}
+	}
+	if w.opts.OnResult != nil {
+		w.opts.OnResult(result)
+	}
+}
+
+func loadWatchState(path string) (*watchState, error) {
+	state := &watchState{path: path, Files: make(map[string]watchedFile)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("watch state %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("watch state %s: %v", path, err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]watchedFile)
+	}
+	return state, nil
+}
+
+func (s *watchState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,192 @@
+package logveil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// winEventFormat redacts Windows event logs exported as XML, by Event
+// Viewer's "Save as XML" or `wevtutil qe /f:xml`. Each <Event> is one
+// record however the export is laid out. EventData values named like
+// users, domains, hosts, SIDs and addresses are replaced outright, other
+// EventData and UserData text goes through the engine, and System keeps
+// everything but Computer and the Security UserID. Markup outside the
+// redacted values is written back as found. Raw .evtx files must be
+// exported to XML first, e.g. with `wevtutil qe log.evtx /lf:true /f:xml`.
+type winEventFormat struct {
+	replacer replacer
+}
+
+// winEventEnd closes an event record
+const winEventEnd = "</Event>"
+
+// sidPattern matches Windows security identifiers
+var sidPattern = regexp.MustCompile(`\bS-1-\d+(?:-\d+)+\b`)
+
+// userIDAttr matches the UserID attribute of System/Security
+var userIDAttr = regexp.MustCompile(`\bUserID=(?:"([^"]*)"|'([^']*)')`)
+
+// records makes every <Event> one record
+func (winEventFormat) records() *recordSplit {
+	return &recordSplit{read: readWinEvent}
+}
+
+// winEventDataRule returns the rule an EventData value of the given Name is
+// replaced under, or "" when it goes through the engine
+func winEventDataRule(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, "sid"):
+		return "sid"
+	case strings.Contains(lower, "username") || strings.HasSuffix(lower, "user") || lower == "accountname":
+		return "username"
+	case strings.Contains(lower, "domainname"):
+		return "domain"
+	case strings.Contains(lower, "workstation") || strings.Contains(lower, "computer") || strings.Contains(lower, "hostname"):
+		return "hostname"
+	case strings.Contains(lower, "ipaddress") || lower == "sourceaddress" || lower == "destaddress" || lower == "clientaddress":
+		return "ip_address"
+	}
+	return ""
+}
+
+func (f winEventFormat) redactLine(record string, redact redactFunc) (string, []Detection, error) {
+	if !strings.Contains(record, "<Event") {
+		return redact("", record)
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(record))
+	decoder.Strict = false
+	var edits []jsonEdit
+	var detections []Detection
+	var stack []string
+	dataName := ""
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Not well-formed: fall back to redacting it as text
+			return redact("", record)
+		}
+		end := int(decoder.InputOffset())
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			if t.Name.Local == "Data" {
+				dataName = ""
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "Name" {
+						dataName = attr.Value
+					}
+				}
+			}
+			if t.Name.Local == "Security" && inWinEventSection(stack, "System") {
+				raw := record[offset:end]
+				if m := userIDAttr.FindStringSubmatchIndex(raw); m != nil {
+					valueStart, valueEnd := m[2], m[3]
+					if valueStart < 0 {
+						valueStart, valueEnd = m[4], m[5]
+					}
+					value := raw[valueStart:valueEnd]
+					edits = append(edits, jsonEdit{start: offset + valueStart, end: offset + valueEnd, text: xmlEscape(f.replacer.replace("sid", value))})
+					detections = append(detections, Detection{Rule: "sid"})
+				}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			text := string(t)
+			if strings.TrimSpace(text) == "" || strings.TrimSpace(text) == "-" || len(stack) == 0 {
+				continue
+			}
+			current := stack[len(stack)-1]
+			var redacted string
+			var found []Detection
+			switch {
+			case inWinEventSection(stack, "System"):
+				if current != "Computer" {
+					continue
+				}
+				redacted, found = f.replacer.replace("hostname", text), []Detection{{Rule: "hostname"}}
+			case inWinEventSection(stack, "EventData") || inWinEventSection(stack, "UserData"):
+				if rule := winEventDataRule(dataName); current == "Data" && rule != "" {
+					redacted, found = f.replacer.replace(rule, text), []Detection{{Rule: rule}}
+					break
+				}
+				if redacted, found, err = redact(dataName, text); err != nil {
+					return "", nil, err
+				}
+				redacted = sidPattern.ReplaceAllStringFunc(redacted, func(sid string) string {
+					found = append(found, Detection{Rule: "sid"})
+					return f.replacer.replace("sid", sid)
+				})
+			default:
+				continue
+			}
+			detections = append(detections, found...)
+			if redacted != text {
+				edits = append(edits, jsonEdit{start: offset, end: end, text: xmlEscape(redacted)})
+			}
+		}
+	}
+	return applyEdits(record, edits), detections, nil
+}
+
+// inWinEventSection reports whether the element stack is inside section
+func inWinEventSection(stack []string, section string) bool {
+	for _, name := range stack {
+		if name == section {
+			return true
+		}
+	}
+	return false
+}
+
+// xmlEscape escapes text for character data or a quoted attribute
+func xmlEscape(text string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+// readWinEvent reads up to and including the next </Event> and the line
+// break after it, so each event is one record even when an export has no
+// line breaks. Text after the last event, such as </Events>, is returned
+// as a final record. The count is of the line breaks read, so an event that
+// shares its line with the next one counts none.
+func readWinEvent(reader *bufio.Reader) (string, int, error) {
+	var record strings.Builder
+	for {
+		chunk, err := reader.ReadString('>')
+		record.WriteString(chunk)
+		if err != nil {
+			text := record.String()
+			lines := strings.Count(text, "\n")
+			if text != "" && !strings.HasSuffix(text, "\n") {
+				lines++
+			}
+			return text, lines, err
+		}
+		if strings.HasSuffix(chunk, winEventEnd) {
+			for _, ending := range []string{"\n", "\r\n"} {
+				if next, _ := reader.Peek(len(ending)); string(next) == ending {
+					reader.Discard(len(ending))
+					record.WriteString(ending)
+					break
+				}
+			}
+			text := record.String()
+			return text, strings.Count(text, "\n"), nil
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package logveil
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// workerRestarts is how many times a crashed worker is restarted for a
+// single request before the request fails
+const workerRestarts = 2
+
+// workerStopGrace is how long a worker may take to exit after its stdin is
+// closed before it is killed
+const workerStopGrace = 5 * time.Second
+
+// workerLineTimeout is how long a worker may take over one line before it
+// is taken to be hung and killed
+const workerLineTimeout = 30 * time.Second
+
+// errWorkerTimeout is returned by roundTrip when no response came within
+// workerLineTimeout
+var errWorkerTimeout = fmt.Errorf("no response within %v", workerLineTimeout)
+
+// workerRequest is one line sent to the agent running with --worker
+type workerRequest struct {
+	ID   int    `json:"id"`
+	Line string `json:"line"`
+}
+
+// workerResponse is the agent's reply to a workerRequest
+type workerResponse struct {
+	ID    int    `json:"id"`
+	Line  string `json:"line"`
+	Error string `json:"error,omitempty"`
+}
+
+// agentError is a failure reported by a healthy worker for one line
+type agentError struct {
+	message string
+}
+
+func (e *agentError) Error() string {
+	return "agent: " + e.message
+}
+
+// pythonWorker keeps a single agent process alive and exchanges one JSON
+// request/response pair per line with it. Requests are serialised, and a
+// worker that crashes, or takes longer than workerLineTimeout over a line,
+// is killed and restarted on the next request.
+type pythonWorker struct {
+	// command returns the command to start the process with, verified
+	// anew for every start, and a func to call once it has started
+	command func() (*exec.Cmd, func(), error)
+	// sandbox limits every process started
+	sandbox SandboxOptions
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	nextID int
+	// failures counts worker processes that failed to start or died
+	failures atomic.Int64
+}
+
+func newPythonWorker(command func() (*exec.Cmd, func(), error)) *pythonWorker {
+	return &pythonWorker{command: command}
+}
+
+// redact sends line to the worker, starting or restarting it as needed
+func (w *pythonWorker) redact(ctx context.Context, line string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if w.cmd == nil {
+			if err := w.start(); err != nil {
+				w.failures.Add(1)
+				return "", newError(CodeSubprocess, StageAgent, "start python worker: %v", err)
+			}
+		}
+
+		redacted, err := w.roundTrip(ctx, line)
+		if err == nil {
+			return redacted, nil
+		}
+
+		var agentErr *agentError
+		if errors.As(err, &agentErr) {
+			return "", err
+		}
+
+		w.stop()
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		w.failures.Add(1)
+		if errors.Is(err, errWorkerTimeout) {
+			// The line would likely hang a new worker too, so it fails and
+			// the next request starts one
+			return "", newError(CodeTimeout, StageAgent, "python worker: %v", err)
+		}
+		if attempt >= workerRestarts {
+			return "", newError(CodeSubprocess, StageAgent, "python worker failed after %d restarts: %v", attempt, err)
+		}
+	}
+}
+
+func (w *pythonWorker) start() error {
+	cmd, release, err := w.command()
+	if err != nil {
+		return err
+	}
+	defer release()
+	cmd = sandboxed(cmd, w.sandbox)
+	cmd.Stderr = os.Stderr
+	startInGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	w.cmd = cmd
+	w.stdin = stdin
+	w.stdout = bufio.NewReader(stdout)
+	return nil
+}
+
+// roundTrip writes one request and waits for its response, ctx or
+// workerLineTimeout
+func (w *pythonWorker) roundTrip(ctx context.Context, line string) (string, error) {
+	w.nextID++
+	request, err := json.Marshal(workerRequest{ID: w.nextID, Line: line})
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.stdin.Write(append(request, '\n')); err != nil {
+		return "", fmt.Errorf("write request: %v", err)
+	}
+
+	type reply struct {
+		response workerResponse
+		err      error
+	}
+	replies := make(chan reply, 1)
+	go func(stdout *bufio.Reader) {
+		var r reply
+		raw, err := stdout.ReadBytes('\n')
+		if err != nil {
+			r.err = fmt.Errorf("read response: %v", err)
+		} else if err := json.Unmarshal(raw, &r.response); err != nil {
+			r.err = fmt.Errorf("decode response: %v", err)
+		}
+		replies <- r
+	}(w.stdout)
+
+	timer := time.NewTimer(workerLineTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-timer.C:
+		return "", errWorkerTimeout
+	case r := <-replies:
+		if r.err != nil {
+			return "", r.err
+		}
+		if r.response.ID != w.nextID {
+			return "", fmt.Errorf("response id %d does not match request %d", r.response.ID, w.nextID)
+		}
+		if r.response.Error != "" {
+			return "", &agentError{message: r.response.Error}
+		}
+		return r.response.Line, nil
+	}
+}
+
+// stop kills the current worker process and its group, if any
+func (w *pythonWorker) stop() {
+	if w.cmd == nil {
+		return
+	}
+	w.stdin.Close()
+	killGroup(w.cmd)
+	w.cmd.Wait()
+	w.cmd = nil
+}
+
+// Close asks the worker to exit by closing its stdin, killing it if it does
+// not exit within workerStopGrace
+func (w *pythonWorker) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cmd == nil {
+		return nil
+	}
+	cmd := w.cmd
+	w.cmd = nil
+	w.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(workerStopGrace):
+		killGroup(cmd)
+		return <-done
+	}
+}
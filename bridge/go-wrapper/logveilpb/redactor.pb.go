@@ -0,0 +1,270 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v33.0.0
+// source: logveil/v1/redactor.proto
+
+// Streaming redaction service for sidecars and log agents. Regenerate the
+// Go code in logveilpb with:
+//
+//   protoc -I proto --go_out=. --go_opt=module=github.com/logveil/logveil/bridge/go-wrapper \
+//     --go-grpc_out=. --go-grpc_opt=module=github.com/logveil/logveil/bridge/go-wrapper \
+//     proto/logveil/v1/redactor.proto
+
+package logveilpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type LogLine struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Caller-chosen identifier echoed in the matching RedactedLine
+	Id            uint64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Line          string `protobuf:"bytes,2,opt,name=line,proto3" json:"line,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogLine) Reset() {
+	*x = LogLine{}
+	mi := &file_logveil_v1_redactor_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogLine) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogLine) ProtoMessage() {}
+
+func (x *LogLine) ProtoReflect() protoreflect.Message {
+	mi := &file_logveil_v1_redactor_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogLine.ProtoReflect.Descriptor instead.
+func (*LogLine) Descriptor() ([]byte, []int) {
+	return file_logveil_v1_redactor_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *LogLine) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *LogLine) GetLine() string {
+	if x != nil {
+		return x.Line
+	}
+	return ""
+}
+
+type Detection struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Name of the rule that matched
+	Rule          string `protobuf:"bytes,1,opt,name=rule,proto3" json:"rule,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Detection) Reset() {
+	*x = Detection{}
+	mi := &file_logveil_v1_redactor_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Detection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Detection) ProtoMessage() {}
+
+func (x *Detection) ProtoReflect() protoreflect.Message {
+	mi := &file_logveil_v1_redactor_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Detection.ProtoReflect.Descriptor instead.
+func (*Detection) Descriptor() ([]byte, []int) {
+	return file_logveil_v1_redactor_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Detection) GetRule() string {
+	if x != nil {
+		return x.Rule
+	}
+	return ""
+}
+
+type RedactedLine struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Redacted text; empty when error is set so nothing unredacted leaks
+	Line          string       `protobuf:"bytes,2,opt,name=line,proto3" json:"line,omitempty"`
+	Detections    []*Detection `protobuf:"bytes,3,rep,name=detections,proto3" json:"detections,omitempty"`
+	Error         string       `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RedactedLine) Reset() {
+	*x = RedactedLine{}
+	mi := &file_logveil_v1_redactor_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RedactedLine) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RedactedLine) ProtoMessage() {}
+
+func (x *RedactedLine) ProtoReflect() protoreflect.Message {
+	mi := &file_logveil_v1_redactor_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RedactedLine.ProtoReflect.Descriptor instead.
+func (*RedactedLine) Descriptor() ([]byte, []int) {
+	return file_logveil_v1_redactor_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RedactedLine) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *RedactedLine) GetLine() string {
+	if x != nil {
+		return x.Line
+	}
+	return ""
+}
+
+func (x *RedactedLine) GetDetections() []*Detection {
+	if x != nil {
+		return x.Detections
+	}
+	return nil
+}
+
+func (x *RedactedLine) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_logveil_v1_redactor_proto protoreflect.FileDescriptor
+
+const file_logveil_v1_redactor_proto_rawDesc = "" +
+	"\n" +
+	"\x19logveil/v1/redactor.proto\x12\n" +
+	"logveil.v1\"-\n" +
+	"\aLogLine\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x12\n" +
+	"\x04line\x18\x02 \x01(\tR\x04line\"\x1f\n" +
+	"\tDetection\x12\x12\n" +
+	"\x04rule\x18\x01 \x01(\tR\x04rule\"\x7f\n" +
+	"\fRedactedLine\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x12\n" +
+	"\x04line\x18\x02 \x01(\tR\x04line\x125\n" +
+	"\n" +
+	"detections\x18\x03 \x03(\v2\x15.logveil.v1.DetectionR\n" +
+	"detections\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error2G\n" +
+	"\bRedactor\x12;\n" +
+	"\x06Redact\x12\x13.logveil.v1.LogLine\x1a\x18.logveil.v1.RedactedLine(\x010\x01B8Z6github.com/logveil/logveil/bridge/go-wrapper/logveilpbb\x06proto3"
+
+var (
+	file_logveil_v1_redactor_proto_rawDescOnce sync.Once
+	file_logveil_v1_redactor_proto_rawDescData []byte
+)
+
+func file_logveil_v1_redactor_proto_rawDescGZIP() []byte {
+	file_logveil_v1_redactor_proto_rawDescOnce.Do(func() {
+		file_logveil_v1_redactor_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_logveil_v1_redactor_proto_rawDesc), len(file_logveil_v1_redactor_proto_rawDesc)))
+	})
+	return file_logveil_v1_redactor_proto_rawDescData
+}
+
+var file_logveil_v1_redactor_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_logveil_v1_redactor_proto_goTypes = []any{
+	(*LogLine)(nil),      // 0: logveil.v1.LogLine
+	(*Detection)(nil),    // 1: logveil.v1.Detection
+	(*RedactedLine)(nil), // 2: logveil.v1.RedactedLine
+}
+var file_logveil_v1_redactor_proto_depIdxs = []int32{
+	1, // 0: logveil.v1.RedactedLine.detections:type_name -> logveil.v1.Detection
+	0, // 1: logveil.v1.Redactor.Redact:input_type -> logveil.v1.LogLine
+	2, // 2: logveil.v1.Redactor.Redact:output_type -> logveil.v1.RedactedLine
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_logveil_v1_redactor_proto_init() }
+func file_logveil_v1_redactor_proto_init() {
+	if File_logveil_v1_redactor_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_logveil_v1_redactor_proto_rawDesc), len(file_logveil_v1_redactor_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_logveil_v1_redactor_proto_goTypes,
+		DependencyIndexes: file_logveil_v1_redactor_proto_depIdxs,
+		MessageInfos:      file_logveil_v1_redactor_proto_msgTypes,
+	}.Build()
+	File_logveil_v1_redactor_proto = out.File
+	file_logveil_v1_redactor_proto_goTypes = nil
+	file_logveil_v1_redactor_proto_depIdxs = nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runLoki implements `loki`, which serves Loki's push API and relays what
+// is pushed to the forward target, redacted, until interrupted
+func runLoki(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics) error {
+	if opts.Loki.Addr == "" {
+		return fmt.Errorf("--loki-addr is required, e.g. --loki-addr :3100")
+	}
+	var forward server.LokiForwarder
+	var err error
+	if sinkTarget(opts.Loki.Forward) {
+		var lines server.Forwarder
+		lines, err = dialForward(opts, "loki", opts.Loki.Forward)
+		forward = server.NewLokiLineForwarder(lines)
+	} else {
+		forward, err = server.DialLokiForwarder(opts.Loki.Forward)
+	}
+	if err != nil {
+		return fmt.Errorf("forward to %s: %v", opts.Loki.Forward, err)
+	}
+	defer forward.Close()
+	relay := server.NewLokiRelay(redactor, forward)
+	relay.Metrics = metrics
+	relay.ErrorLog = errorLog()
+
+	listener, err := net.Listen("tcp", opts.Loki.Addr)
+	if err != nil {
+		return fmt.Errorf("listen on tcp %s: %v", opts.Loki.Addr, err)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Receiving loki pushes", "url", "http://"+listener.Addr().String()+server.LokiPushPath)
+	serveErr := server.Serve(ctx, listener, relay.Handler())
+	if err := opts.writeResult(os.Stderr, relay.Stats()); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	return serveErr
+}
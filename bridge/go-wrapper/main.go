@@ -2,84 +2,914 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
 )
 
-// RedactedLine represents a processed log line
-type RedactedLine struct {
-	Line      string   `json:"line"`
-	Timestamp string   `json:"timestamp,omitempty"`
-	Errors    []string `json:"errors,omitempty"`
+// stdioPath selects stdin or stdout in place of a file path
+const stdioPath = "-"
+
+// backupSuffix names the copy --backup keeps of each original
+const backupSuffix = ".bak"
+
+// usageForms are the forms of the command line, after the program name
+var usageForms = []string{
+	"[flags] <input_file|-> [output_file|-]",
+	"[flags] <input_file|dir|glob>... <output_dir>",
+	"--dry-run [flags] <input_file|dir|glob>...",
+	"--in-place [--backup] [flags] <input_file|dir|glob>...",
+	"--watch <dir> [flags] <output_dir>",
+	"serve [flags]",
+	"listen --syslog <addr> [--forward <target>] [flags]",
+	"fluent --fluent <addr> [--fluent-forward <target>] [flags]",
+	"loki --loki-addr <addr> [--loki-forward <target>] [flags]",
+	"otlp [--otlp-grpc <addr>] [--otlp-http <addr>] [--otlp-forward <target>] [flags]",
+	"cloudwatch --cloudwatch-addr <addr> [--cloudwatch-forward <target>] [flags]",
+	"lambda [--lambda-output s3://bucket/prefix] [--lambda-forward <target>] [flags]",
+	"queue --queue <source> [--queue-output <prefix>] [--queue-notify <target>] [flags]",
+	"kafka --kafka-brokers <list> --kafka-input-topic <t> --kafka-output-topic <t> [flags]",
+	"k8s --selector <labels> [--namespace <ns>] [--follow] [flags] [output_dir]",
+	"tokens compact|rotate [flags] <store>",
+	"unveil --map <file> [flags] <input_file|-> [output_file|-]",
+	"test-rules [flags] <fixtures_dir>",
+	"rules lint [--strict] <rules_file>...",
+	"audit verify <audit_log>...",
+	"health [--mode <command>] [flags] [output_dir|output_file]...",
+	"version [--json] [flags]",
 }
 
-// ProcessResult represents the overall processing result
-type ProcessResult struct {
-	Success        bool     `json:"success"`
-	LinesProcessed int      `json:"lines_processed"`
-	Errors         []string `json:"errors,omitempty"`
-	Duration       string   `json:"duration"`
+// usageText lists usageForms, each after the program name
+func usageText() string {
+	var b strings.Builder
+	for i, form := range usageForms {
+		if i == 0 {
+			b.WriteString("Usage: ")
+		} else {
+			b.WriteString("\n       ")
+		}
+		b.WriteString(os.Args[0] + " " + form)
+	}
+	return b.String()
 }
 
 func main() {
-	if len(os.Args) < 3 {
-		log.Fatalf("Usage: %s <input_file> <output_file>", os.Args[0])
+	defaults := defaultSettings()
+	setupLogging(defaults.Log.Level, defaults.Log.Format)
+
+	args := os.Args[1:]
+	var command string
+	if len(args) > 0 {
+		switch args[0] {
+		case "tokens":
+			runTokens(args[1:])
+			return
+		case "unveil":
+			runUnveil(args[1:])
+			return
+		case "test-rules":
+			runTestRules(args[1:])
+			return
+		case "rules":
+			runRules(args[1:])
+			return
+		case "audit":
+			runAudit(args[1:])
+			return
+		case "health":
+			runHealth(args[1:])
+			return
+		case "version":
+			runVersion(args[1:])
+			return
+		case "serve", "listen", "fluent", "loki", "otlp", "cloudwatch", "lambda", "queue", "kafka", "k8s":
+			command, args = args[0], args[1:]
+		}
+	} else if filepath.Base(os.Args[0]) == "bootstrap" {
+		// Lambda's provided runtimes run the function's bootstrap executable
+		// without arguments
+		command = "lambda"
+	}
+
+	flagArgs := args
+	opts := defaults
+	cli := registerFlags(flag.CommandLine, &opts)
+	cli.parse(args)
+
+	if err := opts.load(*cli.configPath); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	// Parse again so explicit flags win over the config file and environment
+	cli.parse(args)
+	if err := setupLogging(opts.Log.Level, opts.Log.Format); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+
+	switch opts.Output.Report {
+	case "":
+	case "json", "sarif", "html", "csv":
+		opts.DryRun = true
+	default:
+		fatal("Invalid report format (expected json, sarif, html or csv)", "report", opts.Output.Report)
+	}
+
+	if command == "" && opts.Watch == "" && !((opts.DryRun || opts.InPlace) && flag.NArg() > 0) && (flag.NArg() < 1 || (flag.NArg() < 2 && flag.Arg(0) != stdioPath)) {
+		usage(usageText())
+	}
+
+	opts.applyCommandDefaults(command)
+
+	var failOn *failThreshold
+	if opts.FailOn != "" {
+		threshold, err := parseFailOn(opts.FailOn)
+		if err != nil {
+			fatal("Invalid --fail-on", "error", err)
+		}
+		if command != "" || opts.Watch != "" {
+			fatal("--fail-on applies to file, batch and dry runs")
+		}
+		if opts.Engine == "python" {
+			fatal("--fail-on needs detections, which the python engine does not report; use --engine native")
+		}
+		failOn = threshold
+	}
+
+	if opts.InPlace {
+		switch {
+		case command != "" || opts.Watch != "" || opts.Follow || opts.DryRun:
+			fatal("--in-place applies to file and batch runs")
+		case opts.Output.Compress != "":
+			fatal("--compress does not apply to --in-place; compressed files keep their compression")
+		case opts.Resume:
+			fatal("--resume does not apply to --in-place")
+		}
+	} else if opts.Backup {
+		fatal("--backup applies to --in-place")
+	}
+
+	// A dry run writes nothing, so it must not record tokens either
+	if opts.DryRun {
+		opts.Tokenize, opts.TokenStore, opts.SealMap = false, "", ""
+	}
+
+	if opts.Server.Metrics != "" && command == "" && opts.Watch == "" {
+		fatal("--metrics-addr applies to serve, listen, fluent, loki, otlp, cloudwatch, kafka, k8s and watch modes")
+	}
+	if opts.NotifyURL != "" {
+		if command != "" || opts.Watch != "" {
+			fatal("--notify-url applies to file, batch and dry runs")
+		}
+		if u, err := url.Parse(opts.NotifyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("Invalid --notify-url (expected an http or https URL)")
+		}
+	}
+	if opts.Server.Pprof && opts.Server.Metrics == "" {
+		fatal("--pprof is served on --metrics-addr, which is not set")
+	}
+
+	ctx, stopTelemetry := startTelemetry(opts.OTLPEndpoint)
+	redactor, tokenizer, stopRedactor := buildRedactor(&opts)
+	stopTenants := func() {}
+	shutdown := func() {
+		stopTenants()
+		stopRedactor()
+		stopTelemetry()
+	}
+	defer shutdown()
+	var tenants []servedTenant
+	if command == "serve" && len(opts.Server.Tenants) > 0 {
+		built, stop, err := buildTenants(&opts)
+		if err != nil {
+			shutdown()
+			fatal("Invalid configuration", "error", err)
+		}
+		tenants, stopTenants = built, stop
+	}
+	if command != "" || opts.Watch != "" {
+		reloadOnHangup(ctx, redactor, tokenizer, tenants, command, flagArgs)
+		if err := refreshRulesBundle(ctx, &opts, redactor, tokenizer, tenants, command, flagArgs); err != nil {
+			shutdown()
+			fatal("Invalid --rules-refresh", "error", err)
+		}
+	}
+
+	// Serve mode is ready once serving, its engines can redact and a job
+	// worker is free
+	var health *server.Health
+	if command == "serve" {
+		health = server.NewHealth()
+		health.Add("rules", redactor.Check)
+		for _, t := range tenants {
+			health.Add("tenant "+t.name, t.redactor.Check)
+		}
+	}
+	var metrics *server.Metrics
+	if opts.Server.Metrics != "" {
+		var pprof http.Handler
+		if opts.Server.Pprof {
+			token, err := readSecret(opts.Server.PprofTokenFile, "LOGVEIL_PPROF_TOKEN", "--pprof-token-file")
+			if err != nil {
+				shutdown()
+				fatal("Invalid pprof token", "error", err)
+			}
+			pprof = server.PprofHandler(string(token))
+		}
+		metrics = startMetrics(redactor, opts.Server.Metrics, pprof, health)
+	}
+
+	switch command {
+	case "serve":
+		if err := runServe(ctx, redactor, tenants, &opts, metrics, health); err != nil {
+			shutdown()
+			fatal("Server failed", "error", err)
+		}
+		return
+	case "listen":
+		if err := runListen(ctx, redactor, &opts, metrics); err != nil {
+			shutdown()
+			fatal("Listener failed", "error", err)
+		}
+		return
+	case "fluent":
+		if err := runFluent(ctx, redactor, &opts, metrics); err != nil {
+			shutdown()
+			fatal("Fluent relay failed", "error", err)
+		}
+		return
+	case "loki":
+		if err := runLoki(ctx, redactor, &opts, metrics); err != nil {
+			shutdown()
+			fatal("Loki relay failed", "error", err)
+		}
+		return
+	case "otlp":
+		if err := runOTLP(ctx, redactor, &opts, metrics); err != nil {
+			shutdown()
+			fatal("OTLP relay failed", "error", err)
+		}
+		return
+	case "cloudwatch":
+		if err := runCloudWatch(ctx, redactor, &opts, metrics); err != nil {
+			shutdown()
+			fatal("CloudWatch relay failed", "error", err)
+		}
+		return
+	case "lambda":
+		if err := runLambda(ctx, redactor, &opts, metrics); err != nil {
+			shutdown()
+			fatal("Lambda handler failed", "error", err)
+		}
+		return
+	case "queue":
+		if err := runQueue(ctx, redactor, &opts); err != nil {
+			shutdown()
+			fatal("Queue worker failed", "error", err)
+		}
+		return
+	case "kafka":
+		if err := runKafka(ctx, redactor, &opts, metrics); err != nil {
+			shutdown()
+			fatal("Kafka pipeline failed", "error", err)
+		}
+		return
+	case "k8s":
+		if flag.NArg() > 1 {
+			shutdown()
+			fatal("k8s takes at most one output directory")
+		}
+		if err := runK8s(ctx, redactor, &opts, metrics, flag.Arg(0)); err != nil {
+			shutdown()
+			fatal("Kubernetes log collection failed", "error", err)
+		}
+		return
+	}
+
+	// Interrupting a file, batch or dry run stops it between lines: the
+	// output so far is kept, marked as truncated, and the result still
+	// reports what was done. A second signal exits at once.
+	ctx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	context.AfterFunc(ctx, stopSignals)
+
+	args = flag.Args()
+
+	if opts.DryRun {
+		report, err := runDryRun(ctx, redactor, &opts, args)
+		if err != nil {
+			notify(ctx, opts.NotifyURL, notification{Mode: "dry_run", Error: err.Error()})
+			shutdown()
+			fatal("Dry run failed", "error", err)
+		}
+		notify(ctx, opts.NotifyURL, notification{Mode: "dry_run", Success: report.Success, Result: report})
+		if !report.Success {
+			shutdown()
+			os.Exit(1)
+		}
+		checkFailOn(failOn, redactor, report.Detections, len(report.Skipped), shutdown)
+		return
+	}
+
+	if opts.Watch != "" {
+		if len(args) != 1 {
+			shutdown()
+			fatal("--watch takes a single output directory")
+		}
+		if err := runWatch(ctx, redactor, &opts, metrics, args[0]); err != nil {
+			shutdown()
+			fatal("Watch failed", "error", err)
+		}
+		return
+	}
+
+	if opts.InPlace {
+		batch := runInPlace(ctx, redactor, &opts, args)
+		notify(ctx, opts.NotifyURL, notification{Mode: "in_place", Success: batch.Success, Result: batch})
+		if !batch.Success {
+			shutdown()
+			os.Exit(1)
+		}
+		checkFailOn(failOn, redactor, batch.Detections, batch.FilesSkipped, shutdown)
+		return
+	}
+
+	if isBatch(args) {
+		if opts.Follow {
+			shutdown()
+			fatal("--follow takes a single input file")
+		}
+		batch := runBatch(ctx, redactor, &opts, args[:len(args)-1], args[len(args)-1])
+		notify(ctx, opts.NotifyURL, notification{Mode: "batch", Success: batch.Success, Output: args[len(args)-1], Result: batch})
+		if !batch.Success {
+			shutdown()
+			os.Exit(1)
+		}
+		checkFailOn(failOn, redactor, batch.Detections, batch.FilesSkipped, shutdown)
+		return
+	}
+
+	inputFile := args[0]
+	outputFile := stdioPath
+	if len(args) >= 2 {
+		outputFile = args[1]
+	}
+
+	// Validate input file exists; a followed file may appear later
+	if inputFile != stdioPath && !opts.Follow && !logveil.IsRemote(inputFile) {
+		if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+			shutdown()
+			fatal("Input file does not exist", "path", inputFile)
+		}
+	}
+
+	var result *logveil.ProcessResult
+	var err error
+	switch {
+	case opts.Follow:
+		if inputFile == stdioPath {
+			shutdown()
+			fatal("--follow needs an input file, not stdin")
+		}
+		result, err = follow(ctx, redactor, &opts, inputFile, outputFile)
+	case inputFile == stdioPath || outputFile == stdioPath:
+		if opts.Resume {
+			shutdown()
+			fatal("--resume needs input and output files, not stdin or stdout")
+		}
+		result, err = processStdio(ctx, redactor, inputFile, outputFile)
+	default:
+		result, err = redactor.ProcessFile(ctx, inputFile, outputFile)
+	}
+	fileRun := notification{Mode: "file", Success: err == nil && result != nil && result.Success, Error: errorText(err), Input: inputFile, Output: outputFile}
+	if result != nil {
+		fileRun.Result = result
+	}
+	notify(ctx, opts.NotifyURL, fileRun)
+	if err != nil && result == nil {
+		shutdown()
+		fatal("Processing failed", "error", err)
+	}
+
+	// Output result as JSON for structured logging, keeping stdout free for
+	// redacted lines when streaming to it. A failed or interrupted run still
+	// reports how far it got.
+	var resultOut io.Writer = os.Stdout
+	if outputFile == stdioPath {
+		resultOut = os.Stderr
+	}
+	if err := opts.writeResult(resultOut, result); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	if err != nil {
+		shutdown()
+		fatal("Processing failed", "error", err)
+	}
+	if result.Skipped != "" {
+		shutdown()
+		fatal("Input was not redacted", "reason", result.Skipped)
+	}
+	checkFailOn(failOn, redactor, result.Detections, 0, shutdown)
+}
+
+// buildRedactor builds the Redactor described by opts, exiting on invalid
+// settings, along with the tokenizer reloads keep. The returned shutdown
+// func stops the engine and saves the token ledger and seal map; os.Exit
+// skips deferred calls, so failure paths call it explicitly.
+func buildRedactor(opts *settings) (*logveil.Redactor, *logveil.Tokenizer, func()) {
+	var tokenizer *logveil.Tokenizer
+	var store *logveil.TokenStore
+	var err error
+	switch {
+	case opts.TokenStore != "":
+		if opts.TokenizeKey != "" {
+			fatal("Invalid configuration: --tokenize-key cannot be combined with --token-store, which keeps its own key")
+		}
+		if store, err = logveil.OpenTokenStore(opts.TokenStore); err != nil {
+			fatal("Tokenization unavailable", "error", err)
+		}
+		tokenizer = store.Tokenizer()
+	case opts.Tokenize || opts.SealMap != "":
+		if tokenizer, err = logveil.NewTokenizer([]byte(opts.TokenizeKey)); err != nil {
+			fatal("Tokenization unavailable", "error", err)
+		}
+	}
+
+	var sealer *logveil.Sealer
+	var sealKey []byte
+	if opts.SealMap != "" {
+		if sealKey, err = readSealKey(opts.SealKeyFile); err != nil {
+			fatal("Invalid seal key", "error", err)
+		}
+		// Check the key against an existing map now rather than after the
+		// values it would hold have been redacted
+		if _, err := logveil.LoadSealedMap(opts.SealMap, sealKey); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fatal("Invalid seal map", "error", err)
+		}
+		sealer = logveil.NewSealer()
+		tokenizer.SealTo(sealer)
+	}
+
+	redactor, err := newRedactor(opts, tokenizer)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	if reason := redactor.Fallback(); reason != "" {
+		slog.Warn("Falling back to the native engine", "reason", reason)
+	}
+
+	shutdown := func() {
+		redactor.Close()
+		if store != nil {
+			if err := store.Close(); err != nil {
+				slog.Error("Failed to save token store", "error", err)
+			}
+		}
+		if sealer != nil {
+			if err := sealer.WriteMap(opts.SealMap, sealKey); err != nil {
+				slog.Error("Failed to write seal map", "error", err)
+			}
+		}
+	}
+	slog.Debug("Redactor ready", "engine", opts.Engine, "format", opts.Format, "timeout", opts.Timeout)
+	return redactor, tokenizer, shutdown
+}
+
+// newRedactor builds the Redactor described by opts around tokenizer,
+// which may be nil
+func newRedactor(opts *settings, tokenizer *logveil.Tokenizer) (*logveil.Redactor, error) {
+	timeout, err := parseTimeout(opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout: %v", err)
+	}
+	sandbox, err := parseSandbox(opts.Sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sandbox: %v", err)
+	}
+	retry, err := parseRetry(opts.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry: %v", err)
+	}
+	lines, err := parseLines(opts.Lines)
+	if err != nil {
+		return nil, fmt.Errorf("invalid line limit: %v", err)
+	}
+	var numeric []logveil.NumericRule
+	for _, spec := range opts.Numeric {
+		rule, err := logveil.ParseNumericRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid numeric transform: %v", err)
+		}
+		numeric = append(numeric, rule)
+	}
+
+	var customRules []logveil.Rule
+	if opts.RulesBundle.URL != "" {
+		bundle, err := rulesBundle(opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules bundle: %v", err)
+		}
+		result, err := fetchRulesBundle(context.Background(), bundle)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules bundle: %v", err)
+		}
+		customRules = result.Rules
+	}
+	if opts.RulesFile != "" {
+		// Applied after the bundle's, so local rules override its own
+		rules, err := logveil.LoadRules(opts.RulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules file: %v", err)
+		}
+		customRules = append(customRules, rules...)
+	}
+	var plugins []logveil.Plugin
+	for _, spec := range opts.Plugins {
+		plugin, err := logveil.ParsePlugin(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin: %v", err)
+		}
+		plugins = append(plugins, plugin)
+	}
+	var allow []logveil.AllowEntry
+	if opts.AllowlistFile != "" {
+		if allow, err = logveil.LoadAllowlist(opts.AllowlistFile); err != nil {
+			return nil, fmt.Errorf("invalid allowlist: %v", err)
+		}
+	}
+
+	logveil.ConfigureSFTP(logveil.SFTPOptions{
+		KeyFile:    opts.SFTP.KeyFile,
+		KnownHosts: opts.SFTP.KnownHosts,
+	})
+	redactor, err := logveil.NewRedactor(logveil.Config{
+		Engine:  opts.Engine,
+		Timeout: timeout,
+		Native: logveil.NativeOptions{
+			Rules:          opts.Rules,
+			CustomRules:    customRules,
+			Plugins:        plugins,
+			Policy:         logveil.PolicyOptions{Paths: opts.Policy.Paths, Query: opts.Policy.Query},
+			IPAllowlist:    opts.IPAllowlist,
+			Allow:          allow,
+			DecodePayloads: opts.DecodePayloads,
+			Overlap:        opts.Overlap,
+			Entropy: logveil.EntropyOptions{
+				Threshold: opts.Entropy.Threshold,
+				MinLength: opts.Entropy.MinLength,
+				Allowlist: opts.Entropy.Allowlist,
+			},
+			DateShift: logveil.DateShiftOptions{
+				Enabled: opts.DateShift.Enabled,
+				MaxDays: opts.DateShift.MaxDays,
+				Seed:    opts.DateShift.Seed,
+			},
+		},
+		Profile:             opts.Profile,
+		Format:              opts.Format,
+		Tokenizer:           tokenizer,
+		PreserveFormat:      opts.PreserveFormat,
+		FakeData:            opts.FakeData,
+		FakeSeed:            opts.FakeSeed,
+		PlaceholderTemplate: opts.Placeholder,
+		CompressOutput:      opts.Output.Compress,
+		Resume:              opts.Resume,
+		Parallel:            opts.Parallel,
+		Mmap:                opts.Mmap,
+		CacheFile:           opts.CacheFile,
+		Quarantine: logveil.QuarantineOptions{
+			Dir:  opts.Quarantine.Dir,
+			Move: opts.Quarantine.Move,
+		},
+		Audit: logveil.AuditOptions{
+			Path:  opts.Audit.Log,
+			Actor: opts.Audit.Actor,
+		},
+		JSON: logveil.JSONOptions{
+			Fields: opts.JSONFields,
+			Nested: opts.JSONNested,
+		},
+		Logfmt: logveil.LogfmtOptions{
+			Keys: opts.LogfmtKeys,
+		},
+		AccessLog: logveil.AccessLogOptions{
+			QueryParams: opts.QueryParams,
+		},
+		Journal: logveil.JournalOptions{
+			Fields: opts.JournalFields,
+		},
+		CEF: logveil.CEFOptions{
+			Keys: opts.CEFKeys,
+		},
+		CSV: logveil.CSVOptions{
+			Columns:  opts.CSV.Columns,
+			NoHeader: opts.CSV.NoHeader,
+		},
+		Multiline: logveil.MultilineOptions{
+			Start:    opts.Multiline.Start,
+			MaxLines: opts.Multiline.MaxLines,
+		},
+		Lines:   lines,
+		Numeric: numeric,
+		Python: logveil.PythonOptions{
+			Interpreter: opts.Python,
+			Agent:       opts.Agent,
+			Persistent:  opts.PythonWorker,
+			AgentSHA256: opts.AgentSHA256,
+			Sandbox:     sandbox,
+			Retry:       retry,
+			NoFallback:  opts.NoFallback,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid engine: %v", err)
+	}
+	return redactor, nil
+}
+
+// parseRetry converts the retry settings into engine options
+func parseRetry(s retrySettings) (logveil.RetryOptions, error) {
+	opts := logveil.RetryOptions{Attempts: s.Attempts}
+	if s.Attempts < 1 {
+		return opts, fmt.Errorf("attempts must be at least 1: %d", s.Attempts)
+	}
+	if s.Backoff != "" {
+		d, err := time.ParseDuration(s.Backoff)
+		if err != nil || d < 0 {
+			return opts, fmt.Errorf("invalid backoff %q", s.Backoff)
+		}
+		opts.Backoff = d
+	}
+	return opts, nil
+}
+
+// parseLines converts the line settings into redactor options
+func parseLines(s lineSettings) (logveil.LineOptions, error) {
+	opts := logveil.LineOptions{Policy: s.Policy}
+	if s.MaxSize != "" {
+		size, err := parseSize(s.MaxSize)
+		if err != nil {
+			return opts, fmt.Errorf("invalid max line size: %v", err)
+		}
+		if size > math.MaxInt32 {
+			return opts, fmt.Errorf("max line size must be under 2G: %s", s.MaxSize)
+		}
+		opts.MaxBytes = int(size)
+	}
+	return opts, nil
+}
+
+// parseSandbox converts the sandbox settings into engine options
+func parseSandbox(s sandboxSettings) (logveil.SandboxOptions, error) {
+	opts := logveil.SandboxOptions{
+		OpenFiles:       s.OpenFiles,
+		NoNewPrivileges: s.NoNewPrivileges,
+		Seccomp:         s.Seccomp,
 	}
+	if s.CPUTime != "" {
+		d, err := time.ParseDuration(s.CPUTime)
+		if err != nil || d < 0 {
+			return opts, fmt.Errorf("invalid cpu time %q", s.CPUTime)
+		}
+		opts.CPUTime = d
+	}
+	if s.Memory != "" {
+		size, err := parseSize(s.Memory)
+		if err != nil {
+			return opts, fmt.Errorf("invalid memory limit: %v", err)
+		}
+		opts.Memory = size
+	}
+	if s.OpenFiles < 0 {
+		return opts, fmt.Errorf("open files must not be negative: %d", s.OpenFiles)
+	}
+	return opts, nil
+}
 
-	inputFile := os.Args[1]
-	outputFile := os.Args[2]
+// parseSize accepts a byte count with an optional K, M or G suffix, in
+// powers of 1024
+func parseSize(value string) (int64, error) {
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	shift := 0
+	switch {
+	case strings.HasSuffix(number, "K"):
+		shift = 10
+	case strings.HasSuffix(number, "M"):
+		shift = 20
+	case strings.HasSuffix(number, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		number = number[:len(number)-1]
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size %q (expected bytes or a number with K, M or G)", value)
+	}
+	return n << shift, nil
+}
 
-	// Validate input file exists
-	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
-		log.Fatalf("Input file does not exist: %s", inputFile)
+// parseTimeout accepts "auto" or a non-negative Go duration
+func parseTimeout(value string) (time.Duration, error) {
+	if value == "auto" {
+		return logveil.TimeoutAuto, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("timeout must not be negative: %s", value)
 	}
+	return timeout, nil
+}
+
+// isBatch reports whether args name several inputs, a directory or a glob
+// rather than a single input/output pair
+func isBatch(args []string) bool {
+	if len(args) > 2 {
+		return true
+	}
+	if len(args) < 2 || args[0] == stdioPath {
+		return false
+	}
+	if strings.ContainsAny(args[0], "*?[") {
+		return true
+	}
+	info, err := os.Stat(args[0])
+	return err == nil && info.IsDir()
+}
 
-	result, err := processLogFile(inputFile, outputFile)
+// runBatch redacts every file matched by inputs into outputDir and prints
+// the aggregated summary
+func runBatch(ctx context.Context, redactor *logveil.Redactor, opts *settings, inputs []string, outputDir string) *logveil.BatchResult {
+	jobs, err := logveil.ExpandInputsWith(inputs, outputDir, opts.walkOptions())
 	if err != nil {
-		log.Fatalf("Processing failed: %v", err)
+		failRun(ctx, opts.NotifyURL, "batch", err, "Failed to resolve inputs", "error", err)
 	}
+	if len(jobs) == 0 {
+		failRun(ctx, opts.NotifyURL, "batch", fmt.Errorf("no input files matched %q", inputs), "No input files matched", "inputs", inputs)
+	}
+
+	batch := redactor.ProcessBatch(ctx, jobs, opts.Workers)
 
-	// Output result as JSON for structured logging
-	if resultJSON, err := json.Marshal(result); err == nil {
-		fmt.Println(string(resultJSON))
+	if err := opts.writeResult(os.Stdout, batch); err != nil {
+		slog.Error("Failed to write result", "error", err)
 	}
+	return batch
 }
 
-func processLogFile(inputPath, outputPath string) (*ProcessResult, error) {
-	startTime := time.Now()
+// runInPlace redacts every file inputs resolve to in place and prints the
+// batch result
+func runInPlace(ctx context.Context, redactor *logveil.Redactor, opts *settings, inputs []string) *logveil.BatchResult {
+	jobs, err := logveil.ExpandInputsWith(inputs, "", opts.walkOptions())
+	if err != nil {
+		failRun(ctx, opts.NotifyURL, "in_place", err, "Failed to resolve inputs", "error", err)
+	}
+	if len(jobs) == 0 {
+		failRun(ctx, opts.NotifyURL, "in_place", fmt.Errorf("no input files matched %q", inputs), "No input files matched", "inputs", inputs)
+	}
+	paths := make([]string, len(jobs))
+	for i, job := range jobs {
+		paths[i] = job.Input
+	}
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	var inPlace logveil.InPlaceOptions
+	if opts.Backup {
+		inPlace.BackupSuffix = backupSuffix
+	}
+	batch := redactor.ProcessInPlace(ctx, paths, opts.Workers, inPlace)
 
-	// Prepare command
-	cmd := exec.CommandContext(ctx, "python3", "../cli/logveil_agent.py", inputPath, outputPath)
+	if err := opts.writeResult(os.Stdout, batch); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	return batch
+}
 
-	// Capture both stdout and stderr
-	output, err := cmd.CombinedOutput()
+// runDryRun scans inputs without redacting them and prints the findings
+// report
+func runDryRun(ctx context.Context, redactor *logveil.Redactor, opts *settings, inputs []string) (*logveil.ScanReport, error) {
+	var paths []string
+	for _, input := range inputs {
+		if logveil.IsRemote(input) {
+			paths = append(paths, input)
+			continue
+		}
+		jobs, err := logveil.ExpandInputsWith([]string{input}, "", opts.walkOptions())
+		if err != nil {
+			failRun(ctx, opts.NotifyURL, "dry_run", err, "Failed to resolve inputs", "error", err)
+		}
+		for _, job := range jobs {
+			paths = append(paths, job.Input)
+		}
+	}
+	if len(paths) == 0 {
+		failRun(ctx, opts.NotifyURL, "dry_run", fmt.Errorf("no input files matched %q", inputs), "No input files matched", "inputs", inputs)
+	}
 
-	result := &ProcessResult{
-		Success:  err == nil,
-		Duration: time.Since(startTime).String(),
+	report, err := redactor.Scan(ctx, paths)
+	if err != nil {
+		return nil, err
 	}
+	if err := opts.writeReport(os.Stdout, report); err != nil {
+		slog.Error("Failed to write report", "error", err)
+	}
+	return report, nil
+}
 
+// runWatch redacts files created or modified under opts.Watch into
+// outputDir until interrupted, reporting each file as a JSON line
+func runWatch(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics, outputDir string) error {
+	debounce, err := time.ParseDuration(opts.WatchDebounce)
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			result.Errors = append(result.Errors, "Process timed out after 30 seconds")
-		} else {
-			result.Errors = append(result.Errors, fmt.Sprintf("Process failed: %v", err))
+		return fmt.Errorf("invalid debounce: %v", err)
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Watching for files", "dir", opts.Watch)
+	return redactor.Watch(ctx, opts.Watch, outputDir, logveil.WatchOptions{
+		Debounce:  debounce,
+		StateFile: opts.WatchState,
+		OnResult: func(file logveil.FileResult) {
+			metrics.ObserveFile(file)
+			if err := opts.writeResult(os.Stdout, file); err != nil {
+				slog.Error("Failed to write result", "error", err)
+			}
+		},
+	})
+}
+
+// follow redacts lines appended to inputFile until interrupted. A file
+// output is appended to, so a restarted follower doesn't discard what it
+// already wrote.
+func follow(ctx context.Context, redactor *logveil.Redactor, opts *settings, inputFile, outputFile string) (*logveil.ProcessResult, error) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var out io.Writer = os.Stdout
+	if outputFile != stdioPath {
+		f, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open output: %v", err)
 		}
+		defer f.Close()
+		out = f
+	}
 
-		if len(output) > 0 {
-			result.Errors = append(result.Errors, fmt.Sprintf("Output: %s", string(output)))
+	return redactor.Follow(ctx, inputFile, out, logveil.FollowOptions{FromStart: opts.FollowFromStart})
+}
+
+// interruptible returns a reader of r whose pending read fails once ctx is
+// cancelled. Reads from a terminal or pipe block until input arrives, and
+// closing the file does not unblock them.
+func interruptible(ctx context.Context, r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(pw, r)
+		pw.CloseWithError(err)
+	}()
+	context.AfterFunc(ctx, func() { pr.CloseWithError(ctx.Err()) })
+	return pr
+}
+
+// processStdio streams between files and the standard streams when either
+// path is "-"
+func processStdio(ctx context.Context, redactor *logveil.Redactor, inputFile, outputFile string) (*logveil.ProcessResult, error) {
+	var in io.Reader = os.Stdin
+	if inputFile != stdioPath {
+		f, err := os.Open(inputFile)
+		if err != nil {
+			return nil, fmt.Errorf("open input: %v", err)
 		}
+		defer f.Close()
+		in = f
+	} else {
+		in = interruptible(ctx, os.Stdin)
+	}
 
-		return result, fmt.Errorf("command failed: %v", err)
+	var out io.Writer = os.Stdout
+	if outputFile != stdioPath {
+		f, err := os.Create(outputFile)
+		if err != nil {
+			return nil, fmt.Errorf("create output: %v", err)
+		}
+		defer f.Close()
+		out = f
 	}
 
-	return result, nil
+	return redactor.ProcessStream(ctx, in, out)
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// startMetrics serves Prometheus metrics for redactor on addr for the rest
+// of the process, exiting if addr cannot be listened on. pprof and the
+// probes of health, when set, are served alongside.
+func startMetrics(redactor *logveil.Redactor, addr string, pprof http.Handler, health *server.Health) *server.Metrics {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("Metrics unavailable", "addr", addr, "error", err)
+	}
+	metrics := server.NewMetrics(redactor)
+	go func() {
+		if err := server.ServeMetrics(context.Background(), listener, metrics, pprof, health); err != nil {
+			slog.Error("Metrics server failed", "error", err)
+		}
+	}()
+	slog.Info("Serving metrics", "url", "http://"+listener.Addr().String()+"/metrics")
+	if health != nil {
+		slog.Info("Serving health probes", "url", "http://"+listener.Addr().String()+server.ReadyzPath)
+	}
+	if pprof != nil {
+		slog.Info("Serving profiles", "url", "http://"+listener.Addr().String()+"/debug/pprof/")
+	}
+	return metrics
+}
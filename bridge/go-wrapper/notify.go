@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// notifyTimeout bounds delivering a notification, retries included, so
+	// a slow receiver cannot hold up the exit for long
+	notifyTimeout = 30 * time.Second
+	// notifyAttempts counts the first delivery
+	notifyAttempts = 3
+)
+
+// notification is the body POSTed to --notify-url when a run ends
+type notification struct {
+	// Mode is file, batch, in_place or dry_run
+	Mode    string    `json:"mode"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	Input   string    `json:"input,omitempty"`
+	Output  string    `json:"output,omitempty"`
+	Time    time.Time `json:"time"`
+	// Result is the ProcessResult, BatchResult or ScanReport also printed
+	Result any `json:"result,omitempty"`
+}
+
+// notify POSTs n to url, unless url is empty. Delivery failures are logged
+// rather than failing the run, whose outcome is reported regardless.
+func notify(ctx context.Context, url string, n notification) {
+	if url == "" {
+		return
+	}
+	n.Time = time.Now().UTC()
+	body, err := json.Marshal(n)
+	if err != nil {
+		slog.Error("Notification failed", "error", err)
+		return
+	}
+
+	// An interrupted run is still reported
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	defer cancel()
+	for attempt := 1; ; attempt++ {
+		err = postNotification(ctx, url, body)
+		if err == nil {
+			return
+		}
+		if attempt == notifyAttempts || ctx.Err() != nil {
+			slog.Error("Notification failed", "attempts", attempt, "error", err)
+			return
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// postNotification makes a single delivery of body to url
+func postNotification(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// failRun notifies url that a mode run failed with err before getting
+// anywhere, then exits like fatal
+func failRun(ctx context.Context, url, mode string, err error, msg string, args ...any) {
+	notify(ctx, url, notification{Mode: mode, Error: err.Error()})
+	fatal(msg, args...)
+}
+
+// errorText is err's message, or empty when err is nil
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
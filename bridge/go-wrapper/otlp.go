@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/grpc"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runOTLP implements `otlp`, which receives logs over OTLP/gRPC, OTLP/HTTP
+// or both and exports them to the forward target, redacted, until
+// interrupted
+func runOTLP(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics) error {
+	if opts.OTLP.GRPC == "" && opts.OTLP.HTTP == "" {
+		return fmt.Errorf("--otlp-grpc or --otlp-http is required, e.g. --otlp-grpc :4317")
+	}
+	var forward server.OTLPForwarder
+	var err error
+	if sinkTarget(opts.OTLP.Forward) {
+		var lines server.Forwarder
+		lines, err = dialForward(opts, "otlp", opts.OTLP.Forward)
+		forward = server.NewOTLPLineForwarder(lines)
+	} else {
+		forward, err = server.DialOTLPForwarder(opts.OTLP.Forward)
+	}
+	if err != nil {
+		return fmt.Errorf("forward to %s: %v", opts.OTLP.Forward, err)
+	}
+	defer forward.Close()
+	relay := server.NewOTLPRelay(redactor, forward)
+	relay.Metrics = metrics
+	relay.ErrorLog = errorLog()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 2)
+	var grpcServer *grpc.Server
+	if opts.OTLP.HTTP != "" {
+		listener, err := net.Listen("tcp", opts.OTLP.HTTP)
+		if err != nil {
+			return fmt.Errorf("listen on tcp %s: %v", opts.OTLP.HTTP, err)
+		}
+		go func() { errs <- server.Serve(ctx, listener, relay.Handler()) }()
+		slog.Info("Receiving OTLP/HTTP logs", "url", "http://"+listener.Addr().String()+server.OTLPLogsPath)
+	}
+	if opts.OTLP.GRPC != "" {
+		listener, err := net.Listen("tcp", opts.OTLP.GRPC)
+		if err != nil {
+			return fmt.Errorf("listen on tcp %s: %v", opts.OTLP.GRPC, err)
+		}
+		// Exports continue the trace of the sender
+		grpcServer = grpc.NewServer(
+			grpc.StatsHandler(otelgrpc.NewServerHandler()),
+			grpc.MaxRecvMsgSize(server.MaxOTLPRequest),
+		)
+		collogspb.RegisterLogsServiceServer(grpcServer, relay)
+		go func() { errs <- grpcServer.Serve(listener) }()
+		slog.Info("Receiving OTLP/gRPC logs", "addr", listener.Addr().String())
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errs:
+		stop()
+	case <-ctx.Done():
+	}
+	// Exports under way finish before the counts are written
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if err := opts.writeResult(os.Stderr, relay.Stats()); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	return serveErr
+}
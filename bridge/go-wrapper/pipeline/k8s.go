@@ -0,0 +1,350 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// K8sOptions configures a K8sCollector
+type K8sOptions struct {
+	// Namespace holds the pods; the kubeconfig context's namespace when
+	// empty
+	Namespace string
+	// Selector is a label selector such as app=foo; empty selects every
+	// pod in the namespace
+	Selector string
+	// Container limits collection to containers of this name
+	Container string
+	// Since skips lines older than this; zero collects everything the
+	// kubelet still has
+	Since time.Duration
+	// Follow keeps streaming, and picks up pods that start or restart,
+	// until the context is cancelled
+	Follow bool
+}
+
+// K8sStats counts what a K8sCollector has handled
+type K8sStats struct {
+	Streams    int64          `json:"streams"`
+	Lines      int64          `json:"lines_redacted"`
+	Dropped    int64          `json:"lines_dropped"`
+	Detections map[string]int `json:"detections,omitempty"`
+}
+
+// LineSink receives redacted lines along with the pod container they came
+// from, named namespace/pod/container. WriteLine must be safe for
+// concurrent use.
+type LineSink interface {
+	WriteLine(source, line string) error
+	Close() error
+}
+
+// K8sCollector streams the logs of every container of the selected pods
+// through a Redactor into a LineSink. A line that cannot be redacted is
+// logged and dropped rather than written as received.
+type K8sCollector struct {
+	redactor *logveil.Redactor
+	client   kubernetes.Interface
+	opts     K8sOptions
+	sink     LineSink
+	// ErrorLog receives per-stream and per-line errors; nil uses the
+	// standard logger
+	ErrorLog *log.Logger
+	// Metrics, when set, counts every line redacted
+	Metrics *server.Metrics
+
+	mu      sync.Mutex
+	stats   K8sStats
+	started map[string]bool
+}
+
+// NewK8sClient connects using kubeconfig, or the default loading rules
+// ($KUBECONFIG, ~/.kube/config, then the in-cluster service account) when
+// empty, and returns the client with the context's namespace
+func NewK8sClient(kubeconfig, kubeContext string) (kubernetes.Interface, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		rules.ExplicitPath = kubeconfig
+	}
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext})
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return nil, "", fmt.Errorf("k8s: %v", err)
+	}
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("k8s: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("k8s: %v", err)
+	}
+	return client, namespace, nil
+}
+
+// NewK8sCollector returns a collector described by opts that reads through
+// client, redacts with redactor and writes to sink
+func NewK8sCollector(redactor *logveil.Redactor, client kubernetes.Interface, opts K8sOptions, sink LineSink) (*K8sCollector, error) {
+	if opts.Namespace == "" {
+		return nil, fmt.Errorf("k8s: namespace is required")
+	}
+	if opts.Since < 0 {
+		return nil, fmt.Errorf("k8s: since must not be negative")
+	}
+	return &K8sCollector{
+		redactor: redactor,
+		client:   client,
+		opts:     opts,
+		sink:     sink,
+		started:  make(map[string]bool),
+	}, nil
+}
+
+// Run streams the logs of the pods running now and, when following, of
+// pods that start later. It returns once every stream has ended, or with
+// Follow when ctx is cancelled.
+func (c *K8sCollector) Run(ctx context.Context) error {
+	pods := c.client.CoreV1().Pods(c.opts.Namespace)
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: c.opts.Selector})
+	if err != nil {
+		return fmt.Errorf("k8s: list pods in %s: %v", c.opts.Namespace, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range list.Items {
+		c.startPod(ctx, &wg, &list.Items[i])
+	}
+	if !c.opts.Follow {
+		wg.Wait()
+		return nil
+	}
+
+	// Watch from the list's version so no pod starting in between is missed
+	watcher, err := pods.Watch(ctx, metav1.ListOptions{LabelSelector: c.opts.Selector, ResourceVersion: list.ResourceVersion})
+	if err != nil {
+		wg.Wait()
+		return fmt.Errorf("k8s: watch pods in %s: %v", c.opts.Namespace, err)
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				wg.Wait()
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("k8s: pod watch in %s closed", c.opts.Namespace)
+			}
+			if pod, isPod := event.Object.(*corev1.Pod); isPod && (event.Type == watch.Added || event.Type == watch.Modified) {
+				c.startPod(ctx, &wg, pod)
+			}
+		}
+	}
+}
+
+// startPod starts a stream for each running container of pod that has no
+// stream yet. A restarted container gets a new stream for its new
+// instance.
+func (c *K8sCollector) startPod(ctx context.Context, wg *sync.WaitGroup, pod *corev1.Pod) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil || (c.opts.Container != "" && status.Name != c.opts.Container) {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%d", pod.UID, status.Name, status.RestartCount)
+		c.mu.Lock()
+		seen := c.started[key]
+		c.started[key] = true
+		c.mu.Unlock()
+		if seen {
+			continue
+		}
+
+		source := pod.Namespace + "/" + pod.Name + "/" + status.Name
+		wg.Add(1)
+		go func(podName, container string) {
+			defer wg.Done()
+			c.count(func(s *K8sStats) { s.Streams++ })
+			if err := c.stream(ctx, podName, container, source); err != nil && ctx.Err() == nil {
+				c.logf("k8s: %s: %v", source, err)
+			}
+		}(pod.Name, status.Name)
+	}
+}
+
+// stream redacts the log of one container into the sink
+func (c *K8sCollector) stream(ctx context.Context, pod, container, source string) error {
+	logOpts := &corev1.PodLogOptions{Container: container, Follow: c.opts.Follow}
+	if c.opts.Since > 0 {
+		seconds := int64((c.opts.Since + time.Second - 1) / time.Second)
+		logOpts.SinceSeconds = &seconds
+	}
+	body, err := c.client.CoreV1().Pods(c.opts.Namespace).GetLogs(pod, logOpts).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// Lines are read whole however long they are, so a large JSON blob is
+	// redacted rather than failing the stream
+	reader := bufio.NewReader(body)
+	for {
+		line, readErr := reader.ReadString('\n')
+		if line == "" {
+			if readErr == io.EOF {
+				return nil
+			}
+			return readErr
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		start := time.Now()
+		redacted, detections, err := c.redactor.RedactLine(ctx, line)
+		c.Metrics.ObserveLine(len(line), detections, time.Since(start), err)
+		if err != nil {
+			c.count(func(s *K8sStats) { s.Dropped++ })
+			c.logf("k8s: %s: skipping line: %v", source, err)
+			continue
+		}
+		if err := c.sink.WriteLine(source, redacted); err != nil {
+			return fmt.Errorf("write: %v", err)
+		}
+		c.count(func(s *K8sStats) {
+			s.Lines++
+			for _, d := range detections {
+				if s.Detections == nil {
+					s.Detections = make(map[string]int)
+				}
+				s.Detections[d.Rule]++
+			}
+		})
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// Stats returns a snapshot of the collector's counters
+func (c *K8sCollector) Stats() K8sStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Detections = make(map[string]int, len(c.stats.Detections))
+	for rule, n := range c.stats.Detections {
+		stats.Detections[rule] = n
+	}
+	return stats
+}
+
+func (c *K8sCollector) count(update func(s *K8sStats)) {
+	c.mu.Lock()
+	update(&c.stats)
+	c.mu.Unlock()
+}
+
+func (c *K8sCollector) logf(format string, args ...any) {
+	if c.ErrorLog != nil {
+		c.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// dirSink writes each source to its own file, namespace_pod_container.log,
+// in a directory. Files are appended to so a rerun adds to them.
+type dirSink struct {
+	dir string
+
+	mu    sync.Mutex
+	files map[string]*syncFile
+}
+
+type syncFile struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewDirSink returns a LineSink writing one file per source under dir,
+// which is created if needed
+func NewDirSink(dir string) (LineSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &dirSink{dir: dir, files: make(map[string]*syncFile)}, nil
+}
+
+func (s *dirSink) WriteLine(source, line string) error {
+	s.mu.Lock()
+	file, ok := s.files[source]
+	if !ok {
+		name := strings.ReplaceAll(source, "/", "_") + ".log"
+		f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		file = &syncFile{f: f}
+		s.files[source] = file
+	}
+	s.mu.Unlock()
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	_, err := io.WriteString(file.f, line+"\n")
+	return err
+}
+
+func (s *dirSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, file := range s.files {
+		if closeErr := file.f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// forwardSink sends each line to a Forwarder prefixed with its source, as
+// kubectl logs --prefix does
+type forwardSink struct {
+	forward server.Forwarder
+}
+
+// NewForwardSink returns a LineSink that sends "[pod/name/container] line"
+// to forward
+func NewForwardSink(forward server.Forwarder) LineSink {
+	return forwardSink{forward: forward}
+}
+
+func (s forwardSink) WriteLine(source, line string) error {
+	_, rest, _ := strings.Cut(source, "/")
+	return s.forward.Forward("[pod/" + rest + "] " + line)
+}
+
+func (s forwardSink) Close() error {
+	return s.forward.Close()
+}
@@ -0,0 +1,263 @@
+// Package pipeline moves records between message systems, redacting them
+// on the way through.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// tracer starts the spans of the pipelines. It does nothing until the
+// program installs an OpenTelemetry tracer provider.
+var tracer = otel.Tracer("github.com/logveil/logveil/bridge/go-wrapper/pipeline")
+
+// flushTimeout bounds producing and committing a batch that was fetched
+// before shutdown began
+const flushTimeout = 30 * time.Second
+
+// KafkaOptions configures a KafkaPipeline
+type KafkaOptions struct {
+	Brokers     []string
+	InputTopic  string
+	OutputTopic string
+	// GroupID is the consumer group whose offsets track progress
+	GroupID string
+	// BatchSize is the most messages produced and committed together
+	BatchSize int
+	// BatchTimeout is how long a partial batch waits for more messages
+	BatchTimeout time.Duration
+}
+
+// KafkaStats counts what a KafkaPipeline has handled
+type KafkaStats struct {
+	Consumed   int64          `json:"messages_consumed"`
+	Produced   int64          `json:"messages_produced"`
+	Dropped    int64          `json:"messages_dropped"`
+	Detections map[string]int `json:"detections,omitempty"`
+}
+
+// KafkaPipeline consumes an input topic, redacts each message value and
+// produces it, with its key and headers, to an output topic. Offsets are
+// committed only after a batch has been produced, so every message is
+// delivered at least once. A message that cannot be redacted is logged and
+// skipped rather than produced as received.
+type KafkaPipeline struct {
+	redactor *logveil.Redactor
+	opts     KafkaOptions
+	reader   *kafka.Reader
+	writer   *kafka.Writer
+	// ErrorLog receives per-message errors; nil uses the standard logger
+	ErrorLog *log.Logger
+	// Metrics, when set, counts every message redacted
+	Metrics *server.Metrics
+
+	mu    sync.Mutex
+	stats KafkaStats
+}
+
+// NewKafkaPipeline returns a pipeline described by opts that redacts with
+// redactor
+func NewKafkaPipeline(redactor *logveil.Redactor, opts KafkaOptions) (*KafkaPipeline, error) {
+	switch {
+	case len(opts.Brokers) == 0:
+		return nil, fmt.Errorf("kafka: no brokers")
+	case opts.InputTopic == "" || opts.OutputTopic == "":
+		return nil, fmt.Errorf("kafka: input and output topics are required")
+	case opts.InputTopic == opts.OutputTopic:
+		return nil, fmt.Errorf("kafka: output topic must differ from input topic %q", opts.InputTopic)
+	case opts.GroupID == "":
+		return nil, fmt.Errorf("kafka: consumer group is required")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = time.Second
+	}
+
+	return &KafkaPipeline{
+		redactor: redactor,
+		opts:     opts,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: opts.Brokers,
+			Topic:   opts.InputTopic,
+			GroupID: opts.GroupID,
+			// Offsets are committed explicitly once a batch is produced
+			CommitInterval: 0,
+		}),
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(opts.Brokers...),
+			Topic:        opts.OutputTopic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchSize:    opts.BatchSize,
+			// Batches are assembled before WriteMessages, so don't wait
+			// for more
+			BatchTimeout: 10 * time.Millisecond,
+		},
+	}, nil
+}
+
+// Run processes messages until ctx is cancelled or producing fails
+func (p *KafkaPipeline) Run(ctx context.Context) error {
+	for {
+		batch, fetchErr := p.fetchBatch(ctx)
+		if len(batch) > 0 {
+			if err := p.flush(batch); err != nil {
+				return err
+			}
+		}
+		if fetchErr != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("kafka: fetch: %v", fetchErr)
+		}
+	}
+}
+
+// fetchBatch waits for a message and then gathers up to BatchSize more
+// for at most BatchTimeout
+func (p *KafkaPipeline) fetchBatch(ctx context.Context) ([]kafka.Message, error) {
+	msg, err := p.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []kafka.Message{msg}
+
+	batchCtx, cancel := context.WithTimeout(ctx, p.opts.BatchTimeout)
+	defer cancel()
+	for len(batch) < p.opts.BatchSize {
+		msg, err := p.reader.FetchMessage(batchCtx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				return batch, nil
+			}
+			return batch, err
+		}
+		batch = append(batch, msg)
+	}
+	return batch, nil
+}
+
+// flush produces the redacted batch and commits its offsets. It isn't tied
+// to the run context so a batch fetched before shutdown still completes.
+// The batch gets one span, linked to the trace of every message that
+// carries one in its headers.
+func (p *KafkaPipeline) flush(batch []kafka.Message) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "logveil.kafka/batch", trace.WithLinks(messageLinks(batch)...),
+		trace.WithAttributes(
+			attribute.String("messaging.source.name", p.opts.InputTopic),
+			attribute.String("messaging.destination.name", p.opts.OutputTopic),
+			attribute.Int("messaging.batch.message_count", len(batch)),
+		))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	out := make([]kafka.Message, 0, len(batch))
+	for _, msg := range batch {
+		start := time.Now()
+		value, detections, err := p.redactor.RedactLine(ctx, string(msg.Value))
+		p.Metrics.ObserveLine(len(msg.Value), detections, time.Since(start), err)
+		if err != nil {
+			p.count(func(s *KafkaStats) { s.Consumed++; s.Dropped++ })
+			p.logf("skipping %s[%d]@%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+			continue
+		}
+		p.count(func(s *KafkaStats) {
+			s.Consumed++
+			for _, d := range detections {
+				if s.Detections == nil {
+					s.Detections = make(map[string]int)
+				}
+				s.Detections[d.Rule]++
+			}
+		})
+		out = append(out, kafka.Message{Key: msg.Key, Value: []byte(value), Headers: msg.Headers, Time: msg.Time})
+	}
+
+	if len(out) > 0 {
+		if err := p.writer.WriteMessages(ctx, out...); err != nil {
+			return fmt.Errorf("kafka: produce to %s: %v", p.opts.OutputTopic, err)
+		}
+		p.count(func(s *KafkaStats) { s.Produced += int64(len(out)) })
+	}
+	if err := p.reader.CommitMessages(ctx, batch...); err != nil {
+		return fmt.Errorf("kafka: commit offsets: %v", err)
+	}
+	return nil
+}
+
+// messageLinks returns a link to each span context propagated in batch's
+// headers
+func messageLinks(batch []kafka.Message) []trace.Link {
+	var links []trace.Link
+	propagator := otel.GetTextMapPropagator()
+	for _, msg := range batch {
+		carrier := propagation.MapCarrier{}
+		for _, h := range msg.Headers {
+			carrier[strings.ToLower(h.Key)] = string(h.Value)
+		}
+		if sc := trace.SpanContextFromContext(propagator.Extract(context.Background(), carrier)); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return links
+}
+
+// Stats returns a snapshot of the pipeline's counters
+func (p *KafkaPipeline) Stats() KafkaStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Detections = make(map[string]int, len(p.stats.Detections))
+	for rule, n := range p.stats.Detections {
+		stats.Detections[rule] = n
+	}
+	return stats
+}
+
+// Close leaves the consumer group and flushes the producer
+func (p *KafkaPipeline) Close() error {
+	err := p.reader.Close()
+	if writeErr := p.writer.Close(); err == nil {
+		err = writeErr
+	}
+	return err
+}
+
+func (p *KafkaPipeline) count(update func(s *KafkaStats)) {
+	p.mu.Lock()
+	update(&p.stats)
+	p.mu.Unlock()
+}
+
+func (p *KafkaPipeline) logf(format string, args ...any) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
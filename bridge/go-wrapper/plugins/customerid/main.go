@@ -0,0 +1,47 @@
+//go:build wasip1
+
+// Command customerid is an example detector plugin, finding customer IDs
+// such as CUST-004217. Build it with
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o customerid.wasm ./plugins/customerid
+//
+// and load it with --plugin customer_id=customerid.wasm.
+package main
+
+import (
+	"regexp"
+	"unsafe"
+)
+
+var customerID = regexp.MustCompile(`\bCUST-[0-9]{6}\b`)
+
+var (
+	// line is the buffer the engine writes each line to, kept referenced
+	// so it is not collected while the engine holds its address
+	line []byte
+	// spans holds the start and end offsets of the last detection
+	spans []uint32
+)
+
+//go:wasmexport logveil_alloc
+func alloc(size uint32) uint32 {
+	if uint32(cap(line)) < size || size == 0 {
+		line = make([]byte, size+1)
+	}
+	line = line[:size]
+	return uint32(uintptr(unsafe.Pointer(unsafe.SliceData(line))))
+}
+
+//go:wasmexport logveil_detect
+func detect(ptr, size uint32) uint64 {
+	spans = spans[:0]
+	for _, m := range customerID.FindAllIndex(line[:size], -1) {
+		spans = append(spans, uint32(m[0]), uint32(m[1]))
+	}
+	if len(spans) == 0 {
+		return 0
+	}
+	return uint64(uintptr(unsafe.Pointer(unsafe.SliceData(spans))))<<32 | uint64(len(spans)/2)
+}
+
+func main() {}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runQueue implements `queue`, which redacts the objects "file ready"
+// messages from an SQS queue or Pub/Sub subscription refer to, --workers
+// at a time, until interrupted
+func runQueue(ctx context.Context, redactor *logveil.Redactor, opts *settings) error {
+	if opts.Queue.Source == "" {
+		return fmt.Errorf("--queue is required, e.g. --queue sqs:https://sqs.us-east-1.amazonaws.com/123456789012/files-ready")
+	}
+	source, err := server.DialQueueSource(ctx, opts.Queue.Source)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	worker := server.NewQueueWorker(redactor, source)
+	worker.Output = opts.Queue.Output
+	worker.ErrorLog = errorLog()
+	if opts.Queue.Notify != "" {
+		completions, err := server.DialPublisher(ctx, opts.Queue.Notify)
+		if err != nil {
+			return fmt.Errorf("notify %s: %v", opts.Queue.Notify, err)
+		}
+		defer completions.Close()
+		worker.Completions = completions
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Receiving file ready messages", "queue", opts.Queue.Source, "output", opts.Queue.Output, "workers", opts.Workers)
+	runErr := worker.Run(ctx, opts.Workers)
+	if err := opts.writeResult(os.Stderr, worker.Stats()); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+	return runErr
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// reloadOnHangup rebuilds redactor, and those of the serve mode tenants,
+// from the config file, environment and command line args, as at startup,
+// each time the process receives SIGHUP until ctx is cancelled. Work under
+// way finishes as it began. Rules, formats and the engine take effect;
+// listen addresses, limits, the set of tenants and tokenization, which
+// keeps tokenizer, stay as they started. A configuration that does not
+// load is logged and the running one kept.
+func reloadOnHangup(ctx context.Context, redactor *logveil.Redactor, tokenizer *logveil.Tokenizer, tenants []servedTenant, command string, args []string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangups)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				if err := reload(redactor, tokenizer, tenants, command, args); err != nil {
+					slog.Error("Reload failed, keeping the running configuration", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// reload loads the configuration afresh and swaps it into redactor and
+// those of tenants, all of them or none
+func reload(redactor *logveil.Redactor, tokenizer *logveil.Tokenizer, tenants []servedTenant, command string, args []string) error {
+	opts := defaultSettings()
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cli := registerFlags(fs, &opts)
+	if err := cli.parse(args); err != nil {
+		return err
+	}
+	if err := opts.load(*cli.configPath); err != nil {
+		return err
+	}
+	if err := cli.parse(args); err != nil {
+		return err
+	}
+	opts.applyCommandDefaults(command)
+
+	next, err := newRedactor(&opts, tokenizer)
+	if err != nil {
+		return err
+	}
+	if reason := next.Fallback(); reason != "" {
+		slog.Warn("Falling back to the native engine", "reason", reason)
+	}
+	tenantNext := make([]*logveil.Redactor, len(tenants))
+	for i, t := range tenants {
+		own, err := tenantOptions(&opts, t.name)
+		if err == nil {
+			tenantNext[i], err = newRedactor(own, t.tokenizer)
+		}
+		if err != nil {
+			err = fmt.Errorf("tenant %s: %v", t.name, err)
+			for _, built := range tenantNext[:i] {
+				built.Close()
+			}
+			next.Close()
+			return err
+		}
+	}
+	redactor.Reload(next)
+	for i, t := range tenants {
+		t.redactor.Reload(tenantNext[i])
+	}
+	// The pseudonyms issued so far are saved at every reload too
+	tokenizers := []*logveil.Tokenizer{tokenizer}
+	for _, t := range tenants {
+		tokenizers = append(tokenizers, t.tokenizer)
+	}
+	for _, t := range tokenizers {
+		if err := t.Flush(); err != nil {
+			slog.Error("Failed to save token store", "error", err)
+		}
+	}
+	slog.Info("Reloaded configuration", "engine", opts.Engine, "format", opts.Format, "rules_file", opts.RulesFile)
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// runRules implements `rules lint`, which checks custom rules files before
+// they are deployed
+func runRules(args []string) {
+	text := fmt.Sprintf("Usage: %s rules lint [--strict] <rules_file>...", os.Args[0])
+	if len(args) < 1 || args[0] != "lint" {
+		usage(text)
+	}
+
+	fs := flag.NewFlagSet("rules lint", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "fail on warnings as well as errors")
+	fs.Parse(args[1:])
+	if fs.NArg() < 1 {
+		usage(text)
+	}
+
+	report := logveil.LintRules(fs.Args())
+	for _, issue := range report.Issues {
+		if issue.Level == logveil.LintError {
+			slog.Error("Rule error", "file", issue.File, "rule", issue.Rule, "problem", issue.Message)
+		} else {
+			slog.Warn("Rule warning", "file", issue.File, "rule", issue.Rule, "problem", issue.Message)
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		fatal("Failed to write result", "error", err)
+	}
+	fmt.Println(string(data))
+	if report.Errors > 0 || (*strict && report.Warnings > 0) {
+		os.Exit(1)
+	}
+}
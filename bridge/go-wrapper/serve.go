@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/logveilpb"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// runServe implements `serve`, which redacts lines streamed over gRPC until
+// interrupted. In-flight streams are allowed to finish on shutdown. health
+// answers the gRPC health protocol and the job API's probes.
+func runServe(ctx context.Context, redactor *logveil.Redactor, served []servedTenant, opts *settings, metrics *server.Metrics, health *server.Health) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	auth, tlsConfig, err := serverSecurity(opts)
+	if err != nil {
+		return err
+	}
+	limiter, err := clientLimiter(opts)
+	if err != nil {
+		return err
+	}
+	tenants, err := serverTenants(opts, served)
+	if err != nil {
+		return err
+	}
+	// Without client identities every request would fall outside them
+	if tenants != nil && auth == nil && opts.Server.TLSClientCA == "" {
+		return fmt.Errorf("tenants need --api-keys-file or --tls-client-ca to tell their clients apart")
+	}
+	limiter.Tenants = tenants
+
+	listener, err := net.Listen("tcp", opts.Server.GRPC)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %v", opts.Server.GRPC, err)
+	}
+
+	// Streams continue the trace of the client that opened them
+	serverOpts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(auth.UnaryInterceptor(), limiter.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(auth.StreamInterceptor(), limiter.StreamInterceptor()),
+	}
+	if tlsConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(serverOpts...)
+	service := server.NewRedactorService(redactor)
+	service.Metrics = metrics
+	service.Tenants = tenants
+	logveilpb.RegisterRedactorServer(grpcServer, service)
+	healthpb.RegisterHealthServer(grpcServer, health.GRPC())
+
+	errs := make(chan error, 2)
+	if opts.Server.HTTP != "" {
+		jobs, cleanup, err := startJobs(ctx, redactor, opts, metrics, auth, limiter, tenants, tlsConfig, health, errs)
+		if err != nil {
+			grpcServer.Stop()
+			return err
+		}
+		health.Add("workers", jobs.Available)
+		defer cleanup()
+		// Interrupted jobs wind down before their files are removed
+		defer jobs.Wait()
+	}
+	go func() { errs <- grpcServer.Serve(listener) }()
+	slog.Info("Serving gRPC", "addr", listener.Addr().String(), "tls", tlsConfig != nil)
+	health.Serving()
+
+	select {
+	case err := <-errs:
+		stop()
+		grpcServer.Stop()
+		return err
+	case <-ctx.Done():
+		grpcServer.GracefulStop()
+		return nil
+	}
+}
+
+// serverSecurity loads the API keys and TLS configuration of serve mode;
+// either may be nil when not configured
+func serverSecurity(opts *settings) (*server.Auth, *tls.Config, error) {
+	var auth *server.Auth
+	if opts.Server.APIKeysFile != "" {
+		var err error
+		if auth, err = server.LoadAPIKeys(opts.Server.APIKeysFile); err != nil {
+			return nil, nil, fmt.Errorf("load API keys: %v", err)
+		}
+	}
+	if opts.Server.TLSCert == "" && opts.Server.TLSKey == "" {
+		if opts.Server.TLSClientCA != "" {
+			return nil, nil, fmt.Errorf("--tls-client-ca needs --tls-cert and --tls-key")
+		}
+		return auth, nil, nil
+	}
+	if opts.Server.TLSCert == "" || opts.Server.TLSKey == "" {
+		return nil, nil, fmt.Errorf("--tls-cert and --tls-key go together")
+	}
+	tlsConfig, err := server.TLSConfig(opts.Server.TLSCert, opts.Server.TLSKey, opts.Server.TLSClientCA)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load TLS configuration: %v", err)
+	}
+	return auth, tlsConfig, nil
+}
+
+// clientLimiter builds the per-client limits of serve mode
+func clientLimiter(opts *settings) (*server.Limiter, error) {
+	defaults := server.ClientLimits{Rate: opts.Server.ClientRate, Burst: opts.Server.ClientBurst, MaxJobs: opts.Server.ClientMaxJobs}
+	if defaults.Rate < 0 || defaults.Burst < 0 || defaults.MaxJobs < 0 {
+		return nil, fmt.Errorf("client limits cannot be negative")
+	}
+	clients := make(map[string]server.ClientLimits, len(opts.Server.Clients))
+	for client, c := range opts.Server.Clients {
+		limits := server.ClientLimits{Rate: c.Rate, Burst: c.Burst, MaxJobs: c.MaxJobs}
+		if c.MaxSize != "" {
+			size, err := parseSize(c.MaxSize)
+			if err != nil {
+				return nil, fmt.Errorf("client %s: invalid max size: %v", client, err)
+			}
+			limits.MaxBytes = size
+		}
+		if limits.Rate < 0 || limits.Burst < 0 || limits.MaxJobs < 0 {
+			return nil, fmt.Errorf("client %s: limits cannot be negative", client)
+		}
+		clients[client] = limits
+	}
+	return server.NewLimiter(defaults, clients), nil
+}
+
+// startJobs serves the job API on opts.Server.HTTP, with the probes of
+// health beside it, until ctx is cancelled, sending errs why it stopped
+// early. The returned func removes the temporary job directory, if one was
+// made.
+func startJobs(ctx context.Context, redactor *logveil.Redactor, opts *settings, metrics *server.Metrics, auth *server.Auth, limiter *server.Limiter, tenants *server.Tenants, tlsConfig *tls.Config, health *server.Health, errs chan<- error) (*server.Jobs, func(), error) {
+	var ttl time.Duration
+	if opts.Server.JobTTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(opts.Server.JobTTL); err != nil || ttl < 0 {
+			return nil, nil, fmt.Errorf("invalid job TTL %q", opts.Server.JobTTL)
+		}
+	}
+	var maxBytes int64
+	if opts.Server.JobMaxSize != "" {
+		var err error
+		if maxBytes, err = parseSize(opts.Server.JobMaxSize); err != nil {
+			return nil, nil, fmt.Errorf("invalid job max size: %v", err)
+		}
+	}
+	dir, cleanup := opts.Server.JobDir, func() {}
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "logveil-jobs-"); err != nil {
+			return nil, nil, fmt.Errorf("create job directory: %v", err)
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+	} else if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, nil, fmt.Errorf("create job directory: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", opts.Server.HTTP)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("listen on %s: %v", opts.Server.HTTP, err)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		listener, scheme = tls.NewListener(listener, tlsConfig), "https"
+	}
+	jobs := server.NewJobs(ctx, redactor, server.JobOptions{Dir: dir, Workers: opts.Workers, TTL: ttl, MaxBytes: maxBytes})
+	jobs.Metrics = metrics
+	jobs.Limiter = limiter
+	jobs.Tenants = tenants
+	// Probes are answered without credentials
+	mux := http.NewServeMux()
+	health.Register(mux)
+	mux.Handle("/", auth.HTTP(limiter.HTTP(jobs.Handler())))
+	go func() {
+		if err := server.Serve(ctx, listener, mux); err != nil {
+			errs <- fmt.Errorf("job API: %v", err)
+		}
+	}()
+	slog.Info("Serving job API", "url", scheme+"://"+listener.Addr().String()+"/jobs")
+	return jobs, cleanup, nil
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// hashedKeyPrefix marks a key given as its SHA-256 rather than in full
+const hashedKeyPrefix = "sha256:"
+
+var (
+	errMissingKey = errors.New("missing API key")
+	errInvalidKey = errors.New("invalid API key")
+)
+
+// Auth identifies the clients of the job API and the gRPC service, by API
+// key when keys are configured and otherwise by their verified client
+// certificate, and logs every request with the client it came from. A nil
+// *Auth lets every client through; with mutual TLS configured, the TLS
+// handshake has already turned away clients without a certificate.
+type Auth struct {
+	// keys maps the SHA-256 of each API key to the client holding it
+	keys map[[sha256.Size]byte]string
+}
+
+// LoadAPIKeys reads the API keys in path, one "<client> <key>" pair per
+// line, where the key may be written as sha256:<hex digest> so that the
+// file need not hold it. Blank lines and lines starting with # are
+// ignored.
+func LoadAPIKeys(path string) (*Auth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &Auth{keys: make(map[[sha256.Size]byte]string)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<client> <key>\"", path, n)
+		}
+		var sum [sha256.Size]byte
+		if digest, ok := strings.CutPrefix(fields[1], hashedKeyPrefix); ok {
+			b, err := hex.DecodeString(digest)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("%s:%d: invalid SHA-256 digest", path, n)
+			}
+			copy(sum[:], b)
+		} else {
+			sum = sha256.Sum256([]byte(fields[1]))
+		}
+		if client, ok := a.keys[sum]; ok {
+			return nil, fmt.Errorf("%s:%d: key already held by %s", path, n, client)
+		}
+		a.keys[sum] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(a.keys) == 0 {
+		return nil, fmt.Errorf("%s: no API keys", path)
+	}
+	return a, nil
+}
+
+// clientKey is the context key of the client identity
+type clientKey struct{}
+
+// ClientID returns the client a request came from, as its Auth identified
+// it, or "" for an anonymous client
+func ClientID(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// identify returns the client presenting key over a connection in state,
+// which is nil for plain connections
+func (a *Auth) identify(key string, state *tls.ConnectionState) (string, error) {
+	if a != nil && a.keys != nil {
+		if key == "" {
+			return "", errMissingKey
+		}
+		// Keys are looked up by hash, so the lookup reveals nothing of them
+		client, ok := a.keys[sha256.Sum256([]byte(key))]
+		if !ok {
+			return "", errInvalidKey
+		}
+		return client, nil
+	}
+	if state != nil && len(state.VerifiedChains) > 0 {
+		return certificateName(state.VerifiedChains[0][0]), nil
+	}
+	return "", nil
+}
+
+// certificateName names the client holding cert by its common name, or
+// its first DNS, email or URI name
+func certificateName(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return cert.SerialNumber.String()
+}
+
+// bearerKey returns the API key in an "Authorization: Bearer" value, or
+// in an X-API-Key one
+func bearerKey(authorization, apiKey string) string {
+	if key, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return strings.TrimSpace(key)
+	}
+	return apiKey
+}
+
+// HTTP authenticates requests to next, rejecting unidentified ones with
+// 401, and logs each with its client
+func (a *Auth) HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := a.identify(bearerKey(r.Header.Get("Authorization"), r.Header.Get("X-API-Key")), r.TLS)
+		if err != nil {
+			slog.Warn("Rejected request", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="logveil"`)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+		slog.Info("Request", "client", client, "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "status", recorder.code)
+	})
+}
+
+// statusRecorder notes the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the connection, to flush
+// event streams
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// identifyRPC returns ctx carrying the client of the call it belongs to
+func (a *Auth) identifyRPC(ctx context.Context, method string) (context.Context, error) {
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		var authorization, apiKey string
+		if v := md.Get("authorization"); len(v) > 0 {
+			authorization = v[0]
+		}
+		if v := md.Get("x-api-key"); len(v) > 0 {
+			apiKey = v[0]
+		}
+		key = bearerKey(authorization, apiKey)
+	}
+	var state *tls.ConnectionState
+	remote := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	client, err := a.identify(key, state)
+	if err != nil {
+		slog.Warn("Rejected call", "remote", remote, "method", method, "error", err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	slog.Info("Call", "client", client, "remote", remote, "method", method)
+	return context.WithValue(ctx, clientKey{}, client), nil
+}
+
+// UnaryInterceptor authenticates unary calls like HTTP does requests,
+// except health checks, which probes make without credentials
+func (a *Auth) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if info.FullMethod == healthpb.Health_Check_FullMethodName {
+			return handler(ctx, req)
+		}
+		ctx, err := a.identifyRPC(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor authenticates streams like HTTP does requests
+func (a *Auth) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.identifyRPC(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, identifiedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// identifiedStream is a stream whose context carries its client
+type identifiedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s identifiedStream) Context() context.Context {
+	return s.ctx
+}
+
+// TLSConfig returns the server TLS configuration for the certificate and
+// key in certFile and keyFile. When clientCAFile is set, clients must
+// present a certificate issued by one of the CAs in it.
+func TLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,586 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+const (
+	// maxCloudWatchRequest caps a delivery, decompressed
+	maxCloudWatchRequest = 64 << 20
+	// firehoseAccessKeyHeader carries the access key configured on a
+	// Firehose HTTP endpoint destination
+	firehoseAccessKeyHeader = "X-Amz-Firehose-Access-Key"
+
+	// PutLogEvents takes at most this many events, of this many bytes
+	// counting an overhead per event, spanning at most a day
+	maxPutLogEvents     = 10000
+	maxPutLogEventBytes = 1 << 20
+	putLogEventOverhead = 26
+	maxPutLogEventSpan  = 24 * time.Hour
+)
+
+// CloudWatchPayload is what a CloudWatch Logs subscription filter
+// delivers: the events of one log stream that matched it
+type CloudWatchPayload struct {
+	MessageType         string            `json:"messageType"`
+	Owner               string            `json:"owner"`
+	LogGroup            string            `json:"logGroup"`
+	LogStream           string            `json:"logStream"`
+	SubscriptionFilters []string          `json:"subscriptionFilters"`
+	LogEvents           []CloudWatchEvent `json:"logEvents"`
+}
+
+// CloudWatchEvent is one log event; Timestamp is in milliseconds
+type CloudWatchEvent struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// CloudWatchStats counts what a CloudWatchRelay has handled
+type CloudWatchStats struct {
+	Payloads   int64          `json:"payloads_received"`
+	Received   int64          `json:"events_received"`
+	Forwarded  int64          `json:"events_forwarded"`
+	Dropped    int64          `json:"events_dropped"`
+	Detections map[string]int `json:"detections,omitempty"`
+}
+
+// CloudWatchRelay redacts the events of CloudWatch Logs subscription
+// payloads and writes them to a sink, such as another log group or S3.
+// Payloads arrive gzipped, as subscriptions deliver them, and base64
+// encoded where they travel in JSON: Handler takes Kinesis Data Firehose
+// HTTP endpoint deliveries, the awslogs events Lambda subscriptions
+// receive, and bare payloads, such as Kinesis record data. A delivery is
+// answered once written, with an error when the sink failed, so that it is
+// retried; an event that cannot be redacted is dropped rather than written
+// as received. Control messages, which subscriptions send to check the
+// destination, are acknowledged and not written.
+type CloudWatchRelay struct {
+	redactor *logveil.Redactor
+	sink     CloudWatchSink
+	// AccessKey, when set, must be given in the X-Amz-Firehose-Access-Key
+	// header of every delivery
+	AccessKey string
+	// ErrorLog receives per-event and per-delivery errors; nil uses the
+	// standard logger
+	ErrorLog *log.Logger
+	// Metrics, when set, counts every event redacted
+	Metrics *Metrics
+
+	mu    sync.Mutex
+	stats CloudWatchStats
+}
+
+// NewCloudWatchRelay returns a relay that redacts with redactor and writes
+// to sink
+func NewCloudWatchRelay(redactor *logveil.Redactor, sink CloudWatchSink) *CloudWatchRelay {
+	return &CloudWatchRelay{redactor: redactor, sink: sink}
+}
+
+// Stats returns a snapshot of the relay's counters
+func (r *CloudWatchRelay) Stats() CloudWatchStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	stats.Detections = make(map[string]int, len(r.stats.Detections))
+	for rule, n := range r.stats.Detections {
+		stats.Detections[rule] = n
+	}
+	return stats
+}
+
+// Handler takes deliveries as POSTs to any path
+func (r *CloudWatchRelay) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /", r.serveHTTP)
+	return mux
+}
+
+// firehoseRequest is a Kinesis Data Firehose HTTP endpoint delivery
+type firehoseRequest struct {
+	RequestID string `json:"requestId"`
+	Timestamp int64  `json:"timestamp"`
+	Records   []struct {
+		Data string `json:"data"`
+	} `json:"records"`
+}
+
+func (r *CloudWatchRelay) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.AccessKey != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get(firehoseAccessKeyHeader)), []byte(r.AccessKey)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("missing or wrong access key"))
+		return
+	}
+	data, err := readCloudWatchBody(w, req)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("delivery exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		r.serveProcess(w, req, data)
+		return
+	}
+	var delivery struct {
+		firehoseRequest
+		Awslogs *struct {
+			Data string `json:"data"`
+		} `json:"awslogs"`
+	}
+	if err := json.Unmarshal(data, &delivery); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch {
+	case delivery.RequestID != "":
+		r.serveFirehose(w, req, delivery.firehoseRequest)
+		return
+	case delivery.Awslogs != nil:
+		data = []byte(delivery.Awslogs.Data)
+	}
+	r.serveProcess(w, req, data)
+}
+
+// serveProcess processes a delivery of one payload
+func (r *CloudWatchRelay) serveProcess(w http.ResponseWriter, req *http.Request, data []byte) {
+	if err := r.Process(req.Context(), data); err != nil {
+		var invalid *CloudWatchPayloadError
+		if errors.As(err, &invalid) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		r.logf("cloudwatch delivery from %s: %v", req.RemoteAddr, err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveFirehose processes each record of a Firehose delivery, answering as
+// Firehose expects: with the request ID, and an error message when the
+// records are to be delivered again. Records that are not payloads are
+// skipped, since delivering them again cannot help.
+func (r *CloudWatchRelay) serveFirehose(w http.ResponseWriter, req *http.Request, delivery firehoseRequest) {
+	code := http.StatusOK
+	var errMsg string
+	for i, record := range delivery.Records {
+		err := r.Process(req.Context(), []byte(record.Data))
+		var invalid *CloudWatchPayloadError
+		switch {
+		case err == nil:
+		case errors.As(err, &invalid):
+			r.logf("firehose delivery %s: skipping record %d: %v", delivery.RequestID, i, err)
+		default:
+			code = http.StatusInternalServerError
+			errMsg = fmt.Sprintf("record %d: %v", i, err)
+			r.logf("firehose delivery %s: %s", delivery.RequestID, errMsg)
+		}
+		if code != http.StatusOK {
+			break
+		}
+	}
+	writeJSON(w, code, struct {
+		RequestID    string `json:"requestId"`
+		Timestamp    int64  `json:"timestamp"`
+		ErrorMessage string `json:"errorMessage,omitempty"`
+	}{delivery.RequestID, time.Now().UnixMilli(), errMsg})
+}
+
+// readCloudWatchBody reads a delivery, decompressing it as its encoding
+// says, or as gzip when it is a bare payload
+func readCloudWatchBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	body := io.Reader(http.MaxBytesReader(w, req.Body, maxCloudWatchRequest))
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", req.Header.Get("Content-Encoding"))
+	}
+	data, err := readCloudWatchData(body)
+	if err != nil {
+		return nil, err
+	}
+	if isGzip(data) {
+		return gunzipCloudWatch(data)
+	}
+	return data, nil
+}
+
+// CloudWatchPayloadError is a payload that could not be read
+type CloudWatchPayloadError struct {
+	Err error
+}
+
+func (e *CloudWatchPayloadError) Error() string {
+	return "invalid subscription payload: " + e.Err.Error()
+}
+
+func (e *CloudWatchPayloadError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeCloudWatchPayload reads a subscription payload as gzipped JSON,
+// the base64 of that, or plain JSON
+func DecodeCloudWatchPayload(data []byte) (*CloudWatchPayload, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' && !isGzip(data) {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, &CloudWatchPayloadError{fmt.Errorf("neither JSON, gzip nor base64: %v", err)}
+		}
+		data = decoded
+	}
+	if isGzip(data) {
+		var err error
+		if data, err = gunzipCloudWatch(data); err != nil {
+			return nil, &CloudWatchPayloadError{err}
+		}
+	}
+	payload := &CloudWatchPayload{}
+	if err := json.Unmarshal(data, payload); err != nil {
+		return nil, &CloudWatchPayloadError{err}
+	}
+	if payload.MessageType != "DATA_MESSAGE" && payload.MessageType != "CONTROL_MESSAGE" {
+		return nil, &CloudWatchPayloadError{fmt.Errorf("unknown messageType %q", payload.MessageType)}
+	}
+	return payload, nil
+}
+
+func isGzip(data []byte) bool {
+	return len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+func gunzipCloudWatch(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return readCloudWatchData(gz)
+}
+
+// readCloudWatchData reads r up to maxCloudWatchRequest
+func readCloudWatchData(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxCloudWatchRequest+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCloudWatchRequest {
+		return nil, &http.MaxBytesError{Limit: maxCloudWatchRequest}
+	}
+	return data, nil
+}
+
+// Process decodes a subscription payload, redacts its events and writes
+// them to the sink. Errors reading the payload are CloudWatchPayloadErrors;
+// others mean the sink failed and the payload may be worth retrying.
+func (r *CloudWatchRelay) Process(ctx context.Context, data []byte) error {
+	payload, err := DecodeCloudWatchPayload(data)
+	if err != nil {
+		return err
+	}
+	r.count(func(s *CloudWatchStats) { s.Payloads++ })
+	if payload.MessageType == "CONTROL_MESSAGE" {
+		return nil
+	}
+
+	r.count(func(s *CloudWatchStats) { s.Received += int64(len(payload.LogEvents)) })
+	attr := attribute.String("logveil.cloudwatch.log_group", payload.LogGroup)
+	events := payload.LogEvents[:0]
+	var detections []logveil.Detection
+	for _, event := range payload.LogEvents {
+		redacted, found, err := redactLine(ctx, r.redactor, r.Metrics, "logveil.cloudwatch/event", event.Message, attr)
+		if err != nil {
+			r.count(func(s *CloudWatchStats) { s.Dropped++ })
+			r.logf("dropping cloudwatch event %s of %s: %v", event.ID, payload.LogGroup, err)
+			continue
+		}
+		event.Message = redacted
+		events = append(events, event)
+		detections = append(detections, found...)
+	}
+	payload.LogEvents = events
+	if len(events) == 0 {
+		return nil
+	}
+	if err := r.sink.WriteEvents(ctx, payload); err != nil {
+		r.count(func(s *CloudWatchStats) { s.Dropped += int64(len(events)) })
+		return err
+	}
+	r.count(func(s *CloudWatchStats) {
+		s.Forwarded += int64(len(events))
+		for _, d := range detections {
+			if s.Detections == nil {
+				s.Detections = make(map[string]int)
+			}
+			s.Detections[d.Rule]++
+		}
+	})
+	return nil
+}
+
+func (r *CloudWatchRelay) count(update func(s *CloudWatchStats)) {
+	r.mu.Lock()
+	update(&r.stats)
+	r.mu.Unlock()
+}
+
+func (r *CloudWatchRelay) logf(format string, args ...any) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// CloudWatchSink writes redacted subscription payloads. WriteEvents must be
+// safe for concurrent use.
+type CloudWatchSink interface {
+	WriteEvents(ctx context.Context, payload *CloudWatchPayload) error
+	Close() error
+}
+
+// IsCloudWatchTarget reports whether target names a destination only a
+// CloudWatchSink writes to: cloudwatch:<log group> or s3://bucket/prefix
+func IsCloudWatchTarget(target string) bool {
+	return strings.HasPrefix(target, "cloudwatch:") || strings.HasPrefix(target, "s3://")
+}
+
+// DialCloudWatchSink returns the sink for target: cloudwatch:<log group>
+// puts the events into that log group, in a stream named after the log
+// group and stream they came from, and s3://bucket/prefix writes each
+// payload under prefix as an object of gzipped JSON lines; any other
+// target is one DialForwarder accepts, sent one JSON object per event.
+// Credentials, region and endpoint come from the standard AWS
+// configuration chain.
+func DialCloudWatchSink(ctx context.Context, target string) (CloudWatchSink, error) {
+	if !IsCloudWatchTarget(target) {
+		forward, err := DialForwarder(target)
+		if err != nil {
+			return nil, err
+		}
+		return NewCloudWatchLineSink(forward), nil
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if group, ok := strings.CutPrefix(target, "cloudwatch:"); ok {
+		if group == "" {
+			return nil, fmt.Errorf("cloudwatch target %q names no log group", target)
+		}
+		return &logGroupSink{client: cloudwatchlogs.NewFromConfig(cfg), group: group, streams: make(map[string]bool)}, nil
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(target, "s3://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 target %q names no bucket", target)
+	}
+	return &s3PayloadSink{client: s3.NewFromConfig(cfg), bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+// logGroupSink puts events into a CloudWatch Logs log group
+type logGroupSink struct {
+	client *cloudwatchlogs.Client
+	group  string
+
+	mu      sync.Mutex
+	streams map[string]bool
+}
+
+// destinationStream names the stream the events of payload go to, which
+// keeps sources with the same stream name in different log groups apart
+func destinationStream(payload *CloudWatchPayload) string {
+	return strings.TrimPrefix(payload.LogGroup, "/") + "/" + payload.LogStream
+}
+
+func (s *logGroupSink) WriteEvents(ctx context.Context, payload *CloudWatchPayload) error {
+	stream := destinationStream(payload)
+	if err := s.createStream(ctx, stream); err != nil {
+		return err
+	}
+	// Events must be in order, and a request within PutLogEvents' limits
+	events := make([]cwtypes.InputLogEvent, len(payload.LogEvents))
+	for i, event := range payload.LogEvents {
+		events[i] = cwtypes.InputLogEvent{Message: aws.String(event.Message), Timestamp: aws.Int64(event.Timestamp)}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return *events[i].Timestamp < *events[j].Timestamp })
+	for len(events) > 0 {
+		n, size := 0, 0
+		for n < len(events) && n < maxPutLogEvents {
+			eventSize := len(*events[n].Message) + putLogEventOverhead
+			span := time.Duration(*events[n].Timestamp-*events[0].Timestamp) * time.Millisecond
+			if n > 0 && (size+eventSize > maxPutLogEventBytes || span > maxPutLogEventSpan) {
+				break
+			}
+			size += eventSize
+			n++
+		}
+		out, err := s.client.PutLogEvents(ctx, &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(s.group),
+			LogStreamName: aws.String(stream),
+			LogEvents:     events[:n],
+		})
+		if err != nil {
+			return fmt.Errorf("put log events to %s: %v", s.group, err)
+		}
+		if rejected := out.RejectedLogEventsInfo; rejected != nil {
+			return fmt.Errorf("log group %s rejected events: too old before %d, too new from %d, expired before %d",
+				s.group, aws.ToInt32(rejected.TooOldLogEventEndIndex), aws.ToInt32(rejected.TooNewLogEventStartIndex), aws.ToInt32(rejected.ExpiredLogEventEndIndex))
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+// createStream creates stream in the log group unless it was created
+// before
+func (s *logGroupSink) createStream(ctx context.Context, stream string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[stream] {
+		return nil
+	}
+	_, err := s.client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(s.group),
+		LogStreamName: aws.String(stream),
+	})
+	var exists *cwtypes.ResourceAlreadyExistsException
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("create log stream %s in %s: %v", stream, s.group, err)
+	}
+	s.streams[stream] = true
+	return nil
+}
+
+func (s *logGroupSink) Close() error {
+	return nil
+}
+
+// s3PayloadSink writes each payload to S3 as an object
+type s3PayloadSink struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// WriteEvents writes payload to
+// <prefix>/<log group>/<log stream>/<yyyy>/<mm>/<dd>/<first event ID>.json.gz,
+// so that a payload delivered again replaces the object it wrote before
+func (s *s3PayloadSink) WriteEvents(ctx context.Context, payload *CloudWatchPayload) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := writeCloudWatchLines(payload, func(line []byte) error {
+		_, err := gz.Write(append(line, '\n'))
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	first := payload.LogEvents[0]
+	key := strings.TrimPrefix(strings.Join([]string{
+		s.prefix,
+		destinationStream(payload),
+		time.UnixMilli(first.Timestamp).UTC().Format("2006/01/02"),
+		first.ID + ".json.gz",
+	}, "/"), "/")
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		Body:            bytes.NewReader(body.Bytes()),
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String("gzip"),
+	})
+	if err != nil {
+		return fmt.Errorf("put s3://%s/%s: %v", s.bucket, key, err)
+	}
+	return nil
+}
+
+func (s *s3PayloadSink) Close() error {
+	return nil
+}
+
+// lineCloudWatchSink sends each event as a line of JSON
+type lineCloudWatchSink struct {
+	forward Forwarder
+}
+
+// NewCloudWatchLineSink returns a CloudWatchSink sending forward one JSON
+// object per event, with its log group, log stream, owner, ID and time
+func NewCloudWatchLineSink(forward Forwarder) CloudWatchSink {
+	return lineCloudWatchSink{forward}
+}
+
+func (s lineCloudWatchSink) WriteEvents(_ context.Context, payload *CloudWatchPayload) error {
+	return writeCloudWatchLines(payload, func(line []byte) error {
+		return s.forward.Forward(string(line))
+	})
+}
+
+func (s lineCloudWatchSink) Close() error {
+	return s.forward.Close()
+}
+
+// writeCloudWatchLines passes write the JSON line of each event of payload
+func writeCloudWatchLines(payload *CloudWatchPayload, write func(line []byte) error) error {
+	for _, event := range payload.LogEvents {
+		line, err := json.Marshal(struct {
+			LogGroup  string `json:"log_group"`
+			LogStream string `json:"log_stream"`
+			Owner     string `json:"owner,omitempty"`
+			ID        string `json:"id"`
+			Time      string `json:"time"`
+			Message   string `json:"message"`
+		}{
+			LogGroup:  payload.LogGroup,
+			LogStream: payload.LogStream,
+			Owner:     payload.Owner,
+			ID:        event.ID,
+			Time:      time.UnixMilli(event.Timestamp).UTC().Format(time.RFC3339Nano),
+			Message:   event.Message,
+		})
+		if err != nil {
+			return err
+		}
+		if err := write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBulkBackoff caps the wait between attempts at a bulk request
+const maxBulkBackoff = 30 * time.Second
+
+// BulkOptions configures a BulkForwarder
+type BulkOptions struct {
+	// Username and Password authenticate with basic auth, unless APIKey
+	// is set, which is sent as "Authorization: ApiKey <key>"
+	Username string
+	Password string
+	APIKey   string
+	// Action is index, the default, or create, as data streams require
+	Action string
+	// BatchSize caps the documents sent in one request; 0 or 1 sends each
+	// as it is forwarded
+	BatchSize int
+	// FlushInterval bounds how long a partial batch waits for more
+	// documents
+	FlushInterval time.Duration
+	// Retries is how many times documents the cluster could not take for
+	// now, rejected with 429 or a server error, are sent again, backing
+	// off between attempts
+	Retries int
+	// DeadLetter is a file documents the cluster would not take are
+	// appended to as JSON lines, with the reason; empty drops them
+	DeadLetter string
+}
+
+// IsBulkTarget reports whether target names an Elasticsearch or
+// OpenSearch index, as an es+https:// or es+http:// URL
+func IsBulkTarget(target string) bool {
+	return strings.HasPrefix(target, "es+https://") || strings.HasPrefix(target, "es+http://")
+}
+
+// BulkForwarder is a Forwarder that writes messages to an Elasticsearch or
+// OpenSearch index with the bulk API. Each message is parsed as a JSON
+// object, which becomes the document, or else becomes its message field;
+// documents without an @timestamp get the time they were forwarded.
+// Forward queues a document and, once a batch is full, sends it before
+// returning; partial batches are sent every FlushInterval. Documents the
+// cluster refuses outright, or still cannot take once the retries run out,
+// go to the dead letter file.
+type BulkForwarder struct {
+	url    string
+	index  string
+	opts   BulkOptions
+	client *http.Client
+	// ErrorLog receives the errors of batches sent in the background, and
+	// documents sent to the dead letter file; nil uses the standard logger
+	ErrorLog *log.Logger
+
+	mu         sync.Mutex
+	docs       [][]byte
+	deadLetter *os.File
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBulkForwarder returns a forwarder to the index target names, an
+// es+https:// or es+http:// URL of the cluster whose path is the index
+func NewBulkForwarder(target string, opts BulkOptions) (*BulkForwarder, error) {
+	if !IsBulkTarget(target) {
+		return nil, fmt.Errorf("unsupported bulk target %q (expected es+https:// or es+http://)", target)
+	}
+	u, err := url.Parse(strings.TrimPrefix(target, "es+"))
+	if err != nil {
+		return nil, err
+	}
+	index := strings.Trim(u.Path, "/")
+	if u.Host == "" || index == "" || strings.Contains(index, "/") {
+		return nil, fmt.Errorf("bulk target %q must be the cluster URL followed by the index, e.g. es+https://search:9200/logs", target)
+	}
+	switch opts.Action {
+	case "":
+		opts.Action = "index"
+	case "index", "create":
+	default:
+		return nil, fmt.Errorf("unknown bulk action %q (expected index or create)", opts.Action)
+	}
+	if opts.Retries < 0 {
+		return nil, errors.New("bulk retries cannot be negative")
+	}
+	u.Path = "/_bulk"
+	f := &BulkForwarder{
+		url:    u.String(),
+		index:  index,
+		opts:   opts,
+		client: &http.Client{Timeout: 60 * time.Second},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if opts.DeadLetter != "" {
+		if f.deadLetter, err = os.OpenFile(opts.DeadLetter, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+			return nil, fmt.Errorf("dead letter file: %v", err)
+		}
+	}
+	if opts.BatchSize > 1 && opts.FlushInterval > 0 {
+		go f.flushEvery(opts.FlushInterval)
+	} else {
+		close(f.done)
+	}
+	return f, nil
+}
+
+// Forward queues msg as a document, sending the batch when it is full
+func (f *BulkForwarder) Forward(msg string) error {
+	doc, err := bulkDocument(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.docs = append(f.docs, doc)
+	if len(f.docs) < max(f.opts.BatchSize, 1) {
+		return nil
+	}
+	return f.flushLocked()
+}
+
+// bulkDocument returns the document of msg, forwarded at now
+func bulkDocument(msg string, now time.Time) ([]byte, error) {
+	var doc map[string]any
+	if trimmed := strings.TrimSpace(msg); !strings.HasPrefix(trimmed, "{") || json.Unmarshal([]byte(trimmed), &doc) != nil {
+		doc = map[string]any{"message": msg}
+	}
+	if _, ok := doc["@timestamp"]; !ok {
+		doc["@timestamp"] = now.UTC().Format(time.RFC3339Nano)
+	}
+	return json.Marshal(doc)
+}
+
+// flushEvery sends the batch under way every interval until Close
+func (f *BulkForwarder) flushEvery(interval time.Duration) {
+	defer close(f.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.mu.Lock()
+			err := f.flushLocked()
+			f.mu.Unlock()
+			if err != nil {
+				f.logf("elasticsearch: %v", err)
+			}
+		}
+	}
+}
+
+// flushLocked sends the batch under way, if any, sending again what the
+// cluster could not take for now. An error means documents were dropped.
+func (f *BulkForwarder) flushLocked() error {
+	docs := f.docs
+	f.docs = nil
+
+	backoff := 500 * time.Millisecond
+	var lastErr error
+	for attempt := 0; len(docs) > 0 && attempt <= f.opts.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff = min(2*backoff, maxBulkBackoff)
+		}
+		retry, refused, err := f.send(docs)
+		if err != nil {
+			lastErr = err
+		}
+		if len(refused) > 0 {
+			if err := f.deadLetterDocs(refused); err != nil {
+				return err
+			}
+		}
+		docs = retry
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	reason := "cluster still unavailable"
+	if lastErr != nil {
+		reason = lastErr.Error()
+	}
+	refused := make([]bulkFailure, len(docs))
+	for i, doc := range docs {
+		refused[i] = bulkFailure{doc: doc, reason: reason}
+	}
+	return f.deadLetterDocs(refused)
+}
+
+// bulkFailure is a document the cluster did not take, and why
+type bulkFailure struct {
+	doc    []byte
+	status int
+	reason string
+}
+
+// send posts docs in one bulk request, returning those to send again and
+// those refused for good
+func (f *BulkForwarder) send(docs [][]byte) ([][]byte, []bulkFailure, error) {
+	action, err := json.Marshal(map[string]map[string]string{f.opts.Action: {"_index": f.index}})
+	if err != nil {
+		return nil, nil, err
+	}
+	var body bytes.Buffer
+	for _, doc := range docs {
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, f.url, &body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case f.opts.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+f.opts.APIKey)
+	case f.opts.Username != "":
+		req.SetBasicAuth(f.opts.Username, f.opts.Password)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return docs, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		io.Copy(io.Discard, resp.Body)
+		return docs, nil, fmt.Errorf("cluster answered %d", resp.StatusCode)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		reason := fmt.Sprintf("cluster answered %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+		refused := make([]bulkFailure, len(docs))
+		for i, doc := range docs {
+			refused[i] = bulkFailure{doc: doc, status: resp.StatusCode, reason: reason}
+		}
+		return nil, refused, nil
+	}
+
+	var result struct {
+		Errors bool                        `json:"errors"`
+		Items  []map[string]bulkItemResult `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return docs, nil, fmt.Errorf("bulk response: %v", err)
+	}
+	if !result.Errors {
+		return nil, nil, nil
+	}
+	if len(result.Items) != len(docs) {
+		return docs, nil, fmt.Errorf("bulk response has %d items for %d documents", len(result.Items), len(docs))
+	}
+	var retry [][]byte
+	var refused []bulkFailure
+	for i, item := range result.Items {
+		r := item[f.opts.Action]
+		switch {
+		case r.Status/100 == 2:
+		case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+			retry = append(retry, docs[i])
+		default:
+			refused = append(refused, bulkFailure{doc: docs[i], status: r.Status, reason: r.reason()})
+		}
+	}
+	err = nil
+	if len(retry) > 0 {
+		err = fmt.Errorf("cluster could not take %d documents for now", len(retry))
+	}
+	return retry, refused, err
+}
+
+// bulkItemResult is the outcome of one document of a bulk request
+type bulkItemResult struct {
+	Status int `json:"status"`
+	Error  struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// reason describes why the cluster refused the document
+func (r bulkItemResult) reason() string {
+	if r.Error.Type == "" {
+		return fmt.Sprintf("cluster answered %d", r.Status)
+	}
+	return fmt.Sprintf("%s: %s", r.Error.Type, r.Error.Reason)
+}
+
+// deadLetterDocs appends refused to the dead letter file, with why each was
+// refused, returning an error when there is no file to keep them
+func (f *BulkForwarder) deadLetterDocs(refused []bulkFailure) error {
+	if f.deadLetter == nil {
+		return fmt.Errorf("dropping %d documents: %s", len(refused), refused[0].reason)
+	}
+	var buf bytes.Buffer
+	for _, r := range refused {
+		line, err := json.Marshal(struct {
+			Time     string          `json:"time"`
+			Index    string          `json:"index"`
+			Status   int             `json:"status,omitempty"`
+			Reason   string          `json:"reason"`
+			Document json.RawMessage `json:"document"`
+		}{time.Now().UTC().Format(time.RFC3339Nano), f.index, r.status, r.reason, r.doc})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if _, err := f.deadLetter.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("dropping %d documents: dead letter file: %v", len(refused), err)
+	}
+	f.logf("elasticsearch: %d documents sent to %s: %s", len(refused), f.opts.DeadLetter, refused[0].reason)
+	return nil
+}
+
+// Close sends what is left of the batch under way
+func (f *BulkForwarder) Close() error {
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+	<-f.done
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.flushLocked()
+	f.client.CloseIdleConnections()
+	if f.deadLetter != nil {
+		if closeErr := f.deadLetter.Close(); err == nil {
+			err = closeErr
+		}
+		f.deadLetter = nil
+	}
+	return err
+}
+
+func (f *BulkForwarder) logf(format string, args ...any) {
+	if f.ErrorLog != nil {
+		f.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// eventPoll is how often an event stream looks for more output
+	eventPoll = 200 * time.Millisecond
+	// eventStatusInterval is how often an event stream reports progress
+	eventStatusInterval = time.Second
+)
+
+// eventLine is the data of a line event
+type eventLine struct {
+	Line string `json:"line"`
+}
+
+// events streams a job as Server-Sent Events: a line event for each
+// redacted line as it is written, a status event with the job's progress
+// every second, and a done event with its final status before the stream
+// ends. A line event's ID is the output offset after the line, so a client
+// reconnecting with Last-Event-ID carries on where it left off. Compressed
+// and archive results have no lines to stream, only status.
+func (j *Jobs) events(w http.ResponseWriter, r *http.Request) {
+	jb, status := j.lookup(w, r)
+	if jb == nil {
+		return
+	}
+	var offset int64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid Last-Event-ID"))
+			return
+		}
+		offset = n
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	lines := jb.redactor.WritesLines(status.Name)
+	var out *os.File
+	defer func() {
+		if out != nil {
+			out.Close()
+		}
+	}()
+	var pending []byte
+	buf := make([]byte, 64<<10)
+	var reported time.Time
+	poll := time.NewTicker(eventPoll)
+	defer poll.Stop()
+	for {
+		j.mu.Lock()
+		status = jb.status
+		deleted := j.jobs[status.ID] != jb
+		j.mu.Unlock()
+		if deleted {
+			writeEvent(w, "error", "", map[string]string{"error": "job deleted"})
+			return
+		}
+
+		// The status is read first, so once it says finished the output
+		// read after it is complete
+		if lines && out == nil {
+			if f, err := os.Open(jb.output); err == nil {
+				out = f
+				out.Seek(offset, io.SeekStart)
+			}
+		}
+		for out != nil {
+			n, err := out.Read(buf)
+			pending = append(pending, buf[:n]...)
+			for {
+				i := bytes.IndexByte(pending, '\n')
+				if i < 0 {
+					break
+				}
+				offset += int64(i + 1)
+				if err := writeEvent(w, "line", strconv.FormatInt(offset, 10), eventLine{Line: string(pending[:i])}); err != nil {
+					return
+				}
+				pending = pending[i+1:]
+			}
+			if n == 0 || err != nil {
+				break
+			}
+		}
+
+		if status.Finished != nil {
+			if len(pending) > 0 {
+				// The last line, without a newline
+				offset += int64(len(pending))
+				writeEvent(w, "line", strconv.FormatInt(offset, 10), eventLine{Line: string(pending)})
+			}
+			writeEvent(w, "done", "", status)
+			rc.Flush()
+			return
+		}
+		if time.Since(reported) >= eventStatusInterval {
+			if err := writeEvent(w, "status", "", j.progress(jb, status)); err != nil {
+				return
+			}
+			reported = time.Now()
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-j.ctx.Done():
+			return
+		case <-poll.C:
+		}
+	}
+}
+
+// writeEvent writes one event with data as JSON, and id unless empty
+func writeEvent(w io.Writer, event, id string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
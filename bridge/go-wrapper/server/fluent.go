@@ -0,0 +1,621 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+const (
+	// fluentEventTime is the msgpack extension type of EventTime, seconds
+	// and nanoseconds as two big-endian uint32s
+	fluentEventTime = 0
+	// fluentAckTimeout bounds the wait for a downstream receiver to
+	// acknowledge a chunk
+	fluentAckTimeout = 30 * time.Second
+)
+
+// FluentEntry is one event of the Fluent Forward protocol
+type FluentEntry struct {
+	Time   time.Time
+	Record map[string]any
+}
+
+// FluentStats counts what a FluentRelay has handled
+type FluentStats struct {
+	Received   int64          `json:"records_received"`
+	Forwarded  int64          `json:"records_forwarded"`
+	Dropped    int64          `json:"records_dropped"`
+	Detections map[string]int `json:"detections,omitempty"`
+}
+
+// FluentRelay receives events over the Fluent Forward protocol, as Fluentd
+// and Fluent Bit send with their forward outputs, redacts each record and
+// forwards the result. Message, Forward, PackedForward and
+// CompressedPackedForward modes are accepted. A chunk that asks for an ack
+// gets one only once its records were forwarded, so that the sender
+// retries it if they were not; a record that cannot be redacted is dropped
+// rather than forwarded as received. Shared key authentication is not
+// supported.
+type FluentRelay struct {
+	redactor *logveil.Redactor
+	forward  FluentForwarder
+	// ErrorLog receives per-record and per-connection errors; nil uses the
+	// standard logger
+	ErrorLog *log.Logger
+	// Metrics, when set, counts every record redacted
+	Metrics *Metrics
+
+	mu    sync.Mutex
+	stats FluentStats
+}
+
+// NewFluentRelay returns a relay that redacts with redactor and sends to
+// forward
+func NewFluentRelay(redactor *logveil.Redactor, forward FluentForwarder) *FluentRelay {
+	return &FluentRelay{redactor: redactor, forward: forward}
+}
+
+// Stats returns a snapshot of the relay's counters
+func (r *FluentRelay) Stats() FluentStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	stats.Detections = make(map[string]int, len(r.stats.Detections))
+	for rule, n := range r.stats.Detections {
+		stats.Detections[rule] = n
+	}
+	return stats
+}
+
+// ServeTCP accepts connections on listener until ctx is cancelled
+func (r *FluentRelay) ServeTCP(ctx context.Context, listener net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (r *FluentRelay) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	dec := msgpack.NewDecoder(bufio.NewReader(conn))
+	for {
+		tag, entries, chunk, err := decodeFluentMessage(dec)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				r.logf("fluent connection from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if err := r.handle(ctx, tag, entries); err != nil {
+			// Closing without an ack has the sender retry the chunk
+			r.logf("fluent connection from %s: forward: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if chunk != "" {
+			ack, err := msgpack.Marshal(map[string]string{"ack": chunk})
+			if err == nil {
+				_, err = conn.Write(ack)
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					r.logf("fluent connection from %s: ack: %v", conn.RemoteAddr(), err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// handle redacts the entries of one message and forwards those redacted
+func (r *FluentRelay) handle(ctx context.Context, tag string, entries []FluentEntry) error {
+	r.count(func(s *FluentStats) { s.Received += int64(len(entries)) })
+
+	redacted := make([]FluentEntry, 0, len(entries))
+	var detections []logveil.Detection
+	for _, entry := range entries {
+		record, found, err := r.redactRecord(ctx, tag, entry.Record)
+		if err != nil {
+			r.count(func(s *FluentStats) { s.Dropped++ })
+			r.logf("dropping fluent record tagged %s: %v", tag, err)
+			continue
+		}
+		redacted = append(redacted, FluentEntry{Time: entry.Time, Record: record})
+		detections = append(detections, found...)
+	}
+	if len(redacted) == 0 {
+		return nil
+	}
+	if err := r.forward.ForwardEntries(tag, redacted); err != nil {
+		r.count(func(s *FluentStats) { s.Dropped += int64(len(redacted)) })
+		return err
+	}
+	r.count(func(s *FluentStats) {
+		s.Forwarded += int64(len(redacted))
+		for _, d := range detections {
+			if s.Detections == nil {
+				s.Detections = make(map[string]int)
+			}
+			s.Detections[d.Rule]++
+		}
+	})
+	return nil
+}
+
+// redactRecord redacts record as a line of JSON, under the format
+// configured, and reads the result back
+func (r *FluentRelay) redactRecord(ctx context.Context, tag string, record map[string]any) (map[string]any, []logveil.Detection, error) {
+	line, err := json.Marshal(jsonValue(record))
+	if err != nil {
+		return nil, nil, err
+	}
+	redacted, detections, err := redactLine(ctx, r.redactor, r.Metrics, "logveil.fluent/record", string(line), attribute.String("logveil.fluent.tag", tag))
+	if err != nil {
+		return nil, nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(redacted))
+	dec.UseNumber()
+	var out map[string]any
+	if err := dec.Decode(&out); err != nil {
+		return nil, nil, fmt.Errorf("redacted record is not a JSON object: %v", err)
+	}
+	return numberValue(out).(map[string]any), detections, nil
+}
+
+// jsonValue returns v with msgpack binary strings as text, as some
+// senders write record values
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case map[string]any:
+		for k, item := range v {
+			v[k] = jsonValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = jsonValue(item)
+		}
+	}
+	return v
+}
+
+// numberValue returns v with JSON numbers as integers where they are
+// whole and floats otherwise
+func numberValue(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, item := range v {
+			v[k] = numberValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = numberValue(item)
+		}
+	}
+	return v
+}
+
+// decodeFluentMessage reads one message, in any of the protocol's modes,
+// returning its tag and entries and the chunk to acknowledge, "" when no
+// ack was asked for
+func decodeFluentMessage(dec *msgpack.Decoder) (string, []FluentEntry, string, error) {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return "", nil, "", err
+	}
+	if n < 2 {
+		return "", nil, "", fmt.Errorf("message is an array of %d items, not a forward protocol message", n)
+	}
+	tag, err := dec.DecodeString()
+	if err != nil {
+		return "", nil, "", fmt.Errorf("tag: %v", err)
+	}
+	code, err := dec.PeekCode()
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	var entries []FluentEntry
+	var packed []byte
+	rest := n - 2
+	switch {
+	case msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32:
+		// Forward: [tag, [[time, record], ...], option]
+		if entries, err = decodeFluentEntries(dec); err != nil {
+			return "", nil, "", err
+		}
+	case msgpcode.IsString(code) || msgpcode.IsBin(code):
+		// PackedForward: [tag, entries as one msgpack stream, option]
+		if packed, err = dec.DecodeBytes(); err != nil {
+			return "", nil, "", fmt.Errorf("packed entries: %v", err)
+		}
+	default:
+		// Message: [tag, time, record, option]
+		if n < 3 {
+			return "", nil, "", errors.New("message has no record")
+		}
+		entry, err := decodeFluentEntryFields(dec)
+		if err != nil {
+			return "", nil, "", err
+		}
+		entries = []FluentEntry{entry}
+		rest--
+	}
+
+	var option map[string]any
+	if rest > 0 {
+		v, err := dec.DecodeInterface()
+		if err != nil {
+			return "", nil, "", fmt.Errorf("option: %v", err)
+		}
+		option, _ = v.(map[string]any)
+	}
+	for range rest - 1 {
+		if err := dec.Skip(); err != nil {
+			return "", nil, "", err
+		}
+	}
+
+	if packed != nil {
+		var src io.Reader = bytes.NewReader(packed)
+		if compressed, _ := option["compressed"].(string); compressed == "gzip" {
+			gz, err := gzip.NewReader(src)
+			if err != nil {
+				return "", nil, "", fmt.Errorf("compressed entries: %v", err)
+			}
+			defer gz.Close()
+			src = gz
+		} else if compressed != "" && compressed != "text" {
+			return "", nil, "", fmt.Errorf("unsupported compression %q", compressed)
+		}
+		if entries, err = decodePackedEntries(src); err != nil {
+			return "", nil, "", err
+		}
+	}
+	chunk, _ := option["chunk"].(string)
+	return tag, entries, chunk, nil
+}
+
+// decodeFluentEntries reads an array of [time, record] entries
+func decodeFluentEntries(dec *msgpack.Decoder) ([]FluentEntry, error) {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]FluentEntry, 0, min(max(n, 0), 1024))
+	for range n {
+		entry, err := decodeFluentEntry(dec)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// decodePackedEntries reads [time, record] entries until src ends
+func decodePackedEntries(src io.Reader) ([]FluentEntry, error) {
+	dec := msgpack.NewDecoder(src)
+	var entries []FluentEntry
+	for {
+		entry, err := decodeFluentEntry(dec)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("packed entries: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// decodeFluentEntry reads one [time, record] entry
+func decodeFluentEntry(dec *msgpack.Decoder) (FluentEntry, error) {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return FluentEntry{}, err
+	}
+	if n < 2 {
+		return FluentEntry{}, fmt.Errorf("entry is an array of %d items, not [time, record]", n)
+	}
+	entry, err := decodeFluentEntryFields(dec)
+	if err != nil {
+		return FluentEntry{}, err
+	}
+	for range n - 2 {
+		if err := dec.Skip(); err != nil {
+			return FluentEntry{}, err
+		}
+	}
+	return entry, nil
+}
+
+// decodeFluentEntryFields reads the time and record of an entry
+func decodeFluentEntryFields(dec *msgpack.Decoder) (FluentEntry, error) {
+	t, err := decodeFluentTime(dec)
+	if err != nil {
+		return FluentEntry{}, fmt.Errorf("time: %v", err)
+	}
+	v, err := dec.DecodeInterface()
+	if err != nil {
+		return FluentEntry{}, fmt.Errorf("record: %v", err)
+	}
+	record, ok := v.(map[string]any)
+	if !ok {
+		return FluentEntry{}, fmt.Errorf("record is a %T, not a map", v)
+	}
+	return FluentEntry{Time: t, Record: record}, nil
+}
+
+// decodeFluentTime reads an EventTime, or a time in seconds as older
+// senders write it
+func decodeFluentTime(dec *msgpack.Decoder) (time.Time, error) {
+	code, err := dec.PeekCode()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !msgpcode.IsExt(code) {
+		seconds, err := dec.DecodeFloat64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		whole, frac := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(frac*1e9)), nil
+	}
+	id, length, err := dec.DecodeExtHeader()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if id != fluentEventTime || length != 8 {
+		return time.Time{}, fmt.Errorf("extension type %d of %d bytes is not an EventTime", id, length)
+	}
+	var b [8]byte
+	if err := dec.ReadFull(b[:]); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(binary.BigEndian.Uint32(b[:4])), int64(binary.BigEndian.Uint32(b[4:]))), nil
+}
+
+// encodeFluentTime writes t as an EventTime
+func encodeFluentTime(enc *msgpack.Encoder, buf *bytes.Buffer, t time.Time) error {
+	if err := enc.EncodeExtHeader(fluentEventTime, 8); err != nil {
+		return err
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	buf.Write(b[:])
+	return nil
+}
+
+func (r *FluentRelay) count(update func(s *FluentStats)) {
+	r.mu.Lock()
+	update(&r.stats)
+	r.mu.Unlock()
+}
+
+func (r *FluentRelay) logf(format string, args ...any) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// FluentForwarder sends redacted events downstream. ForwardEntries must be
+// safe for concurrent use.
+type FluentForwarder interface {
+	ForwardEntries(tag string, entries []FluentEntry) error
+	Close() error
+}
+
+// DialFluentForwarder returns a FluentForwarder for target:
+// fluent://host:port sends Forward mode messages to another forward
+// receiver, waiting for each to be acknowledged, and reconnects after
+// errors; any other target is one DialForwarder accepts, sent one JSON
+// object with the tag, time and record per event.
+func DialFluentForwarder(target string) (FluentForwarder, error) {
+	if addr, ok := strings.CutPrefix(target, "fluent://"); ok {
+		f := &fluentForwarder{addr: addr}
+		if err := f.connect(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	if strings.Contains(target, "://") && !strings.HasPrefix(target, "udp://") && !strings.HasPrefix(target, "tcp://") {
+		return nil, fmt.Errorf("unsupported forward target %q (expected fluent://, udp://, tcp:// or a file)", target)
+	}
+	forward, err := DialForwarder(target)
+	if err != nil {
+		return nil, err
+	}
+	return NewFluentLineForwarder(forward), nil
+}
+
+type fluentForwarder struct {
+	addr string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *msgpack.Decoder
+}
+
+func (f *fluentForwarder) connect() error {
+	conn, err := net.Dial("tcp", f.addr)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.reader = msgpack.NewDecoder(bufio.NewReader(conn))
+	return nil
+}
+
+// ForwardEntries sends entries as one chunk and waits for its ack,
+// reconnecting once if the connection was lost
+func (f *fluentForwarder) ForwardEntries(tag string, entries []FluentEntry) error {
+	chunk, frame, err := encodeFluentForward(tag, entries)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != nil {
+		if err := f.send(chunk, frame); err == nil {
+			return nil
+		}
+		f.conn.Close()
+		f.conn = nil
+	}
+	if err := f.connect(); err != nil {
+		return err
+	}
+	if err := f.send(chunk, frame); err != nil {
+		f.conn.Close()
+		f.conn = nil
+		return err
+	}
+	return nil
+}
+
+// send writes frame and reads the ack of chunk
+func (f *fluentForwarder) send(chunk string, frame []byte) error {
+	f.conn.SetDeadline(time.Now().Add(fluentAckTimeout))
+	defer f.conn.SetDeadline(time.Time{})
+	if _, err := f.conn.Write(frame); err != nil {
+		return err
+	}
+	v, err := f.reader.DecodeInterface()
+	if err != nil {
+		return fmt.Errorf("ack: %v", err)
+	}
+	response, _ := v.(map[string]any)
+	if ack, _ := response["ack"].(string); ack != chunk {
+		return fmt.Errorf("ack for chunk %q, expected %q", ack, chunk)
+	}
+	return nil
+}
+
+func (f *fluentForwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// encodeFluentForward returns a random chunk ID and the Forward mode
+// message of entries asking for its ack
+func encodeFluentForward(tag string, entries []FluentEntry) (string, []byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	chunk := base64.StdEncoding.EncodeToString(id)
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	err := enc.EncodeArrayLen(3)
+	if err == nil {
+		err = enc.EncodeString(tag)
+	}
+	if err == nil {
+		err = enc.EncodeArrayLen(len(entries))
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	for _, entry := range entries {
+		if err := enc.EncodeArrayLen(2); err != nil {
+			return "", nil, err
+		}
+		if err := encodeFluentTime(enc, &buf, entry.Time); err != nil {
+			return "", nil, err
+		}
+		if err := enc.Encode(entry.Record); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := enc.Encode(map[string]any{"chunk": chunk, "size": len(entries)}); err != nil {
+		return "", nil, err
+	}
+	return chunk, buf.Bytes(), nil
+}
+
+// lineFluentForwarder sends each event as a line of JSON
+type lineFluentForwarder struct {
+	forward Forwarder
+}
+
+// NewFluentLineForwarder returns a FluentForwarder sending forward one
+// JSON object with the tag, time and record per event
+func NewFluentLineForwarder(forward Forwarder) FluentForwarder {
+	return lineFluentForwarder{forward}
+}
+
+func (f lineFluentForwarder) ForwardEntries(tag string, entries []FluentEntry) error {
+	for _, entry := range entries {
+		line, err := json.Marshal(struct {
+			Tag    string         `json:"tag"`
+			Time   string         `json:"time"`
+			Record map[string]any `json:"record"`
+		}{tag, entry.Time.UTC().Format(time.RFC3339Nano), entry.Record})
+		if err != nil {
+			return err
+		}
+		if err := f.forward.Forward(string(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f lineFluentForwarder) Close() error {
+	return f.forward.Close()
+}
@@ -0,0 +1,85 @@
+// Package server exposes a logveil.Redactor over the network for sidecars
+// and log agents.
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/logveilpb"
+)
+
+// RedactorService implements logveilpb.RedactorServer on top of a Redactor
+type RedactorService struct {
+	logveilpb.UnimplementedRedactorServer
+	redactor *logveil.Redactor
+	// Metrics, when set, counts every line redacted
+	Metrics *Metrics
+	// Tenants, when set, redact for their clients in place of redactor
+	Tenants *Tenants
+}
+
+// NewRedactorService returns a service that redacts with redactor
+func NewRedactorService(redactor *logveil.Redactor) *RedactorService {
+	return &RedactorService{redactor: redactor}
+}
+
+// Redact answers each LogLine with a RedactedLine as soon as it is
+// redacted. A line that fails is reported in its response without ending
+// the stream. Each line gets a span under the stream's, and the stream as
+// a whole an entry in the audit log.
+func (s *RedactorService) Redact(stream logveilpb.Redactor_RedactServer) (err error) {
+	client := ClientID(stream.Context())
+	ctx, redactor := s.Tenants.redactor(logveil.WithActor(stream.Context(), client), client, s.redactor)
+	entry := logveil.AuditEntry{Operation: "grpc Redact", Success: true}
+	defer auditStream(ctx, redactor, &entry, time.Now(), &err)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := &logveilpb.RedactedLine{Id: req.GetId()}
+		line, detections, err := redactLine(ctx, redactor, s.Metrics, "logveil.Redact/line", req.GetLine(),
+			attribute.Int64("logveil.line_id", int64(req.GetId())))
+		entry.Lines++
+		entry.BytesRead += int64(len(req.GetLine()))
+		if err != nil {
+			resp.Error = err.Error()
+			entry.Success = false
+		} else {
+			resp.Line = line
+			entry.BytesWritten += int64(len(line))
+			for _, d := range detections {
+				resp.Detections = append(resp.Detections, &logveilpb.Detection{Rule: d.Rule})
+				if entry.Detections == nil {
+					entry.Detections = make(map[string]int)
+				}
+				entry.Detections[d.Rule]++
+			}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// auditStream records a stream that began at start and ended with *err in
+// the audit log of redactor
+func auditStream(ctx context.Context, redactor *logveil.Redactor, entry *logveil.AuditEntry, start time.Time, err *error) {
+	if *err != nil {
+		entry.Success, entry.Error = false, (*err).Error()
+	}
+	// Recorded even when the client went away
+	if auditErr := redactor.Audit(context.WithoutCancel(ctx), *entry, start); auditErr != nil {
+		slog.Error("Failed to write audit log", "client", ClientID(ctx), "error", auditErr)
+	}
+}
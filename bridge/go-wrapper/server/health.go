@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Paths Health is served on
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+// healthCheckTimeout bounds all the readiness checks of one probe
+const healthCheckTimeout = 5 * time.Second
+
+// HealthStatus is what /readyz reports: each check that ran, with "ok" or
+// why it failed
+type HealthStatus struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Health answers liveness and readiness probes:
+//
+//	GET /healthz  200 while the process serves requests
+//	GET /readyz   200 when every check passes, 503 when any fails,
+//	              with a HealthStatus either way
+//
+// and the Check of the gRPC health protocol, SERVING when every check
+// passes. Probes need no credentials, so that orchestrators can make them.
+type Health struct {
+	mu      sync.Mutex
+	serving bool
+	names   []string
+	checks  map[string]func(context.Context) error
+}
+
+// NewHealth returns a Health that is not ready until Serving is called
+func NewHealth() *Health {
+	return &Health{checks: make(map[string]func(context.Context) error)}
+}
+
+// Serving marks the server started, so that it is ready whenever its
+// checks pass
+func (h *Health) Serving() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.serving = true
+}
+
+// Add makes readiness depend on check, which reports why the part of the
+// server it names cannot take work, or nil when it can. Adding a name again
+// replaces its check.
+func (h *Health) Add(name string, check func(context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// Ready runs every check, once the server is serving
+func (h *Health) Ready(ctx context.Context) HealthStatus {
+	h.mu.Lock()
+	serving := h.serving
+	names := append([]string(nil), h.names...)
+	checks := make([]func(context.Context) error, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.Unlock()
+
+	if !serving {
+		return HealthStatus{Checks: map[string]string{"server": "starting"}}
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	result := HealthStatus{Ready: true, Checks: map[string]string{"server": "ok"}}
+	for i, name := range names {
+		if err := checks[i](ctx); err != nil {
+			result.Ready = false
+			result.Checks[name] = err.Error()
+			continue
+		}
+		result.Checks[name] = "ok"
+	}
+	return result
+}
+
+// Register serves the probes on mux, alongside what else it serves
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+HealthzPath, func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET "+ReadyzPath, func(w http.ResponseWriter, r *http.Request) {
+		result := h.Ready(r.Context())
+		code := http.StatusOK
+		if !result.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, result)
+	})
+}
+
+// GRPC returns the gRPC health service of h. Only the overall status, the
+// empty service name, is known.
+func (h *Health) GRPC() healthpb.HealthServer {
+	return grpcHealth{health: h}
+}
+
+// grpcHealth implements healthpb.HealthServer on top of Health
+type grpcHealth struct {
+	healthpb.UnimplementedHealthServer
+	health *Health
+}
+
+func (g grpcHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if req.GetService() != "" {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	resp := &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}
+	if !g.health.Ready(ctx).Ready {
+		resp.Status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return resp, nil
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// hecEventPath is where a collector receives JSON events
+	hecEventPath = "/services/collector/event"
+	// maxHECBackoff caps the wait between attempts at a batch
+	maxHECBackoff = 30 * time.Second
+)
+
+// HECOptions configures a HECForwarder
+type HECOptions struct {
+	// Token authenticates to the collector
+	Token string
+	// Index, Sourcetype, Source and Host are set on every event; empty
+	// ones take the token's defaults
+	Index      string
+	Sourcetype string
+	Source     string
+	Host       string
+	// BatchSize caps the events sent in one request; 0 or 1 sends each
+	// as it is forwarded
+	BatchSize int
+	// FlushInterval bounds how long a partial batch waits for more events
+	FlushInterval time.Duration
+	// Retries is how many times a batch the collector could not take is
+	// sent again, backing off between attempts, before it is dropped
+	Retries int
+}
+
+// IsHECTarget reports whether target names a Splunk HTTP Event Collector,
+// as an hec+https:// or hec+http:// URL
+func IsHECTarget(target string) bool {
+	return strings.HasPrefix(target, "hec+https://") || strings.HasPrefix(target, "hec+http://")
+}
+
+// HECForwarder is a Forwarder that sends messages to a Splunk HTTP Event
+// Collector as events, in batches. Forward queues a message and, once a
+// batch is full, sends it before returning, so a collector that falls
+// behind slows the input rather than events piling up; partial batches
+// are sent every FlushInterval.
+type HECForwarder struct {
+	url    string
+	opts   HECOptions
+	client *http.Client
+	// ErrorLog receives the errors of batches sent in the background; nil
+	// uses the standard logger
+	ErrorLog *log.Logger
+
+	mu     sync.Mutex
+	batch  bytes.Buffer
+	events int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewHECForwarder returns a forwarder to the collector at target, an
+// hec+https:// or hec+http:// URL whose path defaults to the JSON event
+// endpoint
+func NewHECForwarder(target string, opts HECOptions) (*HECForwarder, error) {
+	if !IsHECTarget(target) {
+		return nil, fmt.Errorf("unsupported HEC target %q (expected hec+https:// or hec+http://)", target)
+	}
+	u, err := url.Parse(strings.TrimPrefix(target, "hec+"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("HEC target %q has no host", target)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = hecEventPath
+	}
+	if opts.Token == "" {
+		return nil, errors.New("HEC forwarding needs a token")
+	}
+	if opts.Retries < 0 {
+		return nil, errors.New("HEC retries cannot be negative")
+	}
+	f := &HECForwarder{
+		url:    u.String(),
+		opts:   opts,
+		client: &http.Client{Timeout: 30 * time.Second},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if opts.BatchSize > 1 && opts.FlushInterval > 0 {
+		go f.flushEvery(opts.FlushInterval)
+	} else {
+		close(f.done)
+	}
+	return f, nil
+}
+
+// hecEvent is one event as the collector takes it
+type hecEvent struct {
+	Time       float64 `json:"time"`
+	Host       string  `json:"host,omitempty"`
+	Source     string  `json:"source,omitempty"`
+	Sourcetype string  `json:"sourcetype,omitempty"`
+	Index      string  `json:"index,omitempty"`
+	Event      string  `json:"event"`
+}
+
+// Forward queues msg as an event, sending the batch when it is full
+func (f *HECForwarder) Forward(msg string) error {
+	event, err := json.Marshal(hecEvent{
+		Time:       float64(time.Now().UnixMilli()) / 1000,
+		Host:       f.opts.Host,
+		Source:     f.opts.Source,
+		Sourcetype: f.opts.Sourcetype,
+		Index:      f.opts.Index,
+		Event:      msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batch.Write(event)
+	f.events++
+	if f.events < max(f.opts.BatchSize, 1) {
+		return nil
+	}
+	return f.flushLocked()
+}
+
+// flushEvery sends the batch under way every interval until Close
+func (f *HECForwarder) flushEvery(interval time.Duration) {
+	defer close(f.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.mu.Lock()
+			err := f.flushLocked()
+			f.mu.Unlock()
+			if err != nil {
+				f.logf("splunk HEC: %v", err)
+			}
+		}
+	}
+}
+
+// flushLocked sends the batch under way, if any, retrying as configured.
+// The batch is dropped when every attempt fails.
+func (f *HECForwarder) flushLocked() error {
+	if f.events == 0 {
+		return nil
+	}
+	body := bytes.Clone(f.batch.Bytes())
+	events := f.events
+	f.batch.Reset()
+	f.events = 0
+
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 0; attempt <= f.opts.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff = min(2*backoff, maxHECBackoff)
+		}
+		var retry bool
+		if retry, err = f.send(body); err == nil || !retry {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("dropping %d events: %v", events, err)
+	}
+	return nil
+}
+
+// send posts one batch, reporting whether a failure is worth retrying
+func (f *HECForwarder) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Splunk "+f.opts.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	// The collector answers {"text": "...", "code": n} on failure
+	var failure struct {
+		Text string `json:"text"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&failure)
+	err = fmt.Errorf("collector answered %d", resp.StatusCode)
+	if failure.Text != "" {
+		err = fmt.Errorf("collector answered %d: %s", resp.StatusCode, failure.Text)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// Close sends what is left of the batch under way
+func (f *HECForwarder) Close() error {
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+	<-f.done
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.flushLocked()
+	f.client.CloseIdleConnections()
+	return err
+}
+
+func (f *HECForwarder) logf(format string, args ...any) {
+	if f.ErrorLog != nil {
+		f.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,454 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// JobState is how far a job has got
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// defaultJobName names uploads that do not name themselves
+const defaultJobName = "upload.log"
+
+// JobStatus is what GET /jobs/{id} reports about a job
+type JobStatus struct {
+	ID    string   `json:"id"`
+	State JobState `json:"state"`
+	// Name is the uploaded file's name, whose extension selects archive
+	// handling, and the name its result is downloaded as
+	Name     string     `json:"name"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// BytesTotal is the size of the upload, and BytesWritten how much of
+	// the redacted result has been written so far
+	BytesTotal   int64                  `json:"bytes_total"`
+	BytesWritten int64                  `json:"bytes_written"`
+	Result       *logveil.ProcessResult `json:"result,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+// JobOptions configures Jobs
+type JobOptions struct {
+	// Dir holds each job's upload and result in a directory of its own
+	Dir string
+	// Workers is how many jobs are redacted at once; the rest queue
+	Workers int
+	// TTL is how long a finished job and its result are kept; zero keeps
+	// them until deleted
+	TTL time.Duration
+	// MaxBytes is the largest upload accepted; zero accepts any size
+	MaxBytes int64
+}
+
+// job is a Jobs entry; its status is guarded by Jobs.mu
+type job struct {
+	status JobStatus
+	dir    string
+	input  string
+	output string
+	// owner is the client that submitted the job, the only one that may
+	// see it
+	owner string
+	// tenant is the owner's tenant, or nil, and redactor the one its
+	// files are redacted with
+	tenant   *Tenant
+	redactor *logveil.Redactor
+	cancel   context.CancelFunc
+}
+
+// Jobs redacts uploaded files in the background, for uploads too large to
+// wait on:
+//
+//	POST   /jobs?name=<file>   upload a file; 202 with its status
+//	GET    /jobs/{id}          status and progress
+//	GET    /jobs/{id}/events   redacted lines and progress as they come,
+//	                           as Server-Sent Events
+//	GET    /jobs/{id}/result   the redacted file, once succeeded
+//	DELETE /jobs/{id}          cancel the job and delete its files
+//
+// Without authentication anyone who can reach the server can submit jobs,
+// and fetch the result of any job whose ID they hold; behind Auth, clients
+// only see their own jobs, and those in a tenant have its files redacted
+// under its configuration.
+type Jobs struct {
+	redactor *logveil.Redactor
+	opts     JobOptions
+	// Metrics, when set, counts every file redacted
+	Metrics *Metrics
+	// Limiter, when set, caps each client's jobs and upload size; a
+	// client's MaxBytes takes the place of JobOptions.MaxBytes
+	Limiter *Limiter
+	// Tenants, when set, redact the jobs of their clients, each tenant's
+	// limits capping its clients' jobs together
+	Tenants *Tenants
+
+	ctx   context.Context
+	slots chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	jobs  map[string]*job
+}
+
+// NewJobs returns Jobs redacting with redactor. Jobs still queued or
+// running when ctx is cancelled fail, and finished jobs stop expiring.
+func NewJobs(ctx context.Context, redactor *logveil.Redactor, opts JobOptions) *Jobs {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	j := &Jobs{
+		redactor: redactor,
+		opts:     opts,
+		ctx:      ctx,
+		slots:    make(chan struct{}, opts.Workers),
+		jobs:     make(map[string]*job),
+	}
+	if opts.TTL > 0 {
+		go j.expire()
+	}
+	return j
+}
+
+// Wait returns once every job started has stopped
+func (j *Jobs) Wait() {
+	j.wg.Wait()
+}
+
+// Available reports why no job would start at once, every worker being
+// busy, or nil when one is free
+func (j *Jobs) Available(context.Context) error {
+	if busy := len(j.slots); busy >= cap(j.slots) {
+		return fmt.Errorf("all %d job workers busy", busy)
+	}
+	return nil
+}
+
+// Handler serves the job API
+func (j *Jobs) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", j.submit)
+	mux.HandleFunc("GET /jobs/{id}", j.get)
+	mux.HandleFunc("GET /jobs/{id}/events", j.events)
+	mux.HandleFunc("GET /jobs/{id}/result", j.result)
+	mux.HandleFunc("DELETE /jobs/{id}", j.delete)
+	return mux
+}
+
+// submit spools the request body and queues a job for it
+func (j *Jobs) submit(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(r.URL.Query().Get("name"))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = defaultJobName
+	}
+	id, err := newJobID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(logveil.WithActor(j.ctx, ClientID(r.Context())))
+	jb := &job{dir: filepath.Join(j.opts.Dir, id), owner: ClientID(r.Context()), cancel: cancel}
+	jb.tenant = j.Tenants.Lookup(jb.owner)
+	ctx, jb.redactor = j.Tenants.redactor(ctx, jb.owner, j.redactor)
+	jb.input = filepath.Join(jb.dir, "input", name)
+	jb.output = filepath.Join(jb.dir, "output", name)
+
+	limits := j.Limiter.Limits(jb.owner)
+	if err := j.overLimit(jb, limits); err != nil {
+		cancel()
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+	maxBytes := j.opts.MaxBytes
+	if limits.MaxBytes > 0 {
+		maxBytes = limits.MaxBytes
+	}
+	if jb.tenant != nil && jb.tenant.Limits.MaxBytes > 0 && (maxBytes <= 0 || jb.tenant.Limits.MaxBytes < maxBytes) {
+		maxBytes = jb.tenant.Limits.MaxBytes
+	}
+
+	size, err := spool(jb, r, w, maxBytes)
+	if err != nil {
+		cancel()
+		os.RemoveAll(jb.dir)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("upload exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	jb.status = JobStatus{ID: id, State: JobQueued, Name: name, Created: time.Now().UTC(), BytesTotal: size}
+	j.mu.Lock()
+	// Checked again, as other uploads may have finished meanwhile
+	if err := j.overLimitLocked(jb, limits); err != nil {
+		j.mu.Unlock()
+		cancel()
+		os.RemoveAll(jb.dir)
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+	j.jobs[id] = jb
+	status := jb.status
+	j.mu.Unlock()
+
+	slog.Info("Job submitted", "id", id, "client", jb.owner, "tenant", tenantName(jb.tenant), "name", name, "bytes", size)
+	j.wg.Add(1)
+	go j.run(ctx, jb)
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// overLimit refuses jb when its owner already has as many jobs queued or
+// running as limits allow, or its tenant as many as its own limits do
+func (j *Jobs) overLimit(jb *job, limits ClientLimits) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.overLimitLocked(jb, limits)
+}
+
+// overLimitLocked is overLimit with j.mu held
+func (j *Jobs) overLimitLocked(jb *job, limits ClientLimits) error {
+	if limits.MaxJobs > 0 && j.activeJobs(func(other *job) bool { return other.owner == jb.owner }) >= limits.MaxJobs {
+		return jobLimitError(limits.MaxJobs)
+	}
+	if tenant := jb.tenant; tenant != nil && tenant.Limits.MaxJobs > 0 && j.activeJobs(func(other *job) bool { return other.tenant == tenant }) >= tenant.Limits.MaxJobs {
+		return fmt.Errorf("tenant %s: %v", tenant.Name, jobLimitError(tenant.Limits.MaxJobs))
+	}
+	return nil
+}
+
+// jobLimitError refuses a job over a limit of max
+func jobLimitError(max int) error {
+	return fmt.Errorf("limit of %d queued or running jobs reached", max)
+}
+
+// activeJobs counts the jobs still queued or running that match; j.mu
+// must be held
+func (j *Jobs) activeJobs(match func(*job) bool) int {
+	n := 0
+	for _, jb := range j.jobs {
+		if match(jb) && jb.status.Finished == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// tenantName names tenant, "" for none
+func tenantName(tenant *Tenant) string {
+	if tenant == nil {
+		return ""
+	}
+	return tenant.Name
+}
+
+// spool writes the body of r to jb's input, returning its size
+func spool(jb *job, r *http.Request, w http.ResponseWriter, maxBytes int64) (int64, error) {
+	for _, dir := range []string{filepath.Dir(jb.input), filepath.Dir(jb.output)} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return 0, err
+		}
+	}
+	body := io.Reader(r.Body)
+	if maxBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, maxBytes)
+	}
+	f, err := os.OpenFile(jb.input, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// run redacts jb once a worker slot is free
+func (j *Jobs) run(ctx context.Context, jb *job) {
+	defer j.wg.Done()
+	defer jb.cancel()
+	select {
+	case j.slots <- struct{}{}:
+		defer func() { <-j.slots }()
+	case <-ctx.Done():
+		j.finish(jb, nil, fmt.Errorf("cancelled before starting: %v", ctx.Err()))
+		return
+	}
+
+	j.mu.Lock()
+	started := time.Now().UTC()
+	jb.status.State = JobRunning
+	jb.status.Started = &started
+	j.mu.Unlock()
+
+	result, err := jb.redactor.ProcessFile(ctx, jb.input, jb.output)
+	os.Remove(jb.input)
+	j.finish(jb, result, err)
+}
+
+// finish records how jb ended, deleting its files if it was deleted while
+// it ran
+func (j *Jobs) finish(jb *job, result *logveil.ProcessResult, err error) {
+	file := logveil.FileResult{FileJob: logveil.FileJob{Input: jb.input, Output: jb.output}, Result: result}
+	if err != nil {
+		file.Error = err.Error()
+	}
+	j.Metrics.ObserveFile(file)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	finished := time.Now().UTC()
+	jb.status.Finished = &finished
+	jb.status.Result = result
+	jb.status.Error = file.Error
+	jb.status.State = JobSucceeded
+	if err != nil || result == nil || !result.Success {
+		jb.status.State = JobFailed
+	}
+	if result != nil {
+		jb.status.BytesWritten = result.BytesWritten
+	}
+	if j.jobs[jb.status.ID] != jb {
+		os.RemoveAll(jb.dir)
+	}
+}
+
+// lookup returns the job named in r and a copy of its status, or writes a
+// 404 and returns nil
+func (j *Jobs) lookup(w http.ResponseWriter, r *http.Request) (*job, JobStatus) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	jb, ok := j.jobs[r.PathValue("id")]
+	if !ok || jb.owner != ClientID(r.Context()) {
+		writeError(w, http.StatusNotFound, errors.New("no such job"))
+		return nil, JobStatus{}
+	}
+	return jb, jb.status
+}
+
+// get reports a job's status
+func (j *Jobs) get(w http.ResponseWriter, r *http.Request) {
+	jb, status := j.lookup(w, r)
+	if jb == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, j.progress(jb, status))
+}
+
+// progress returns status, a copy of jb's, with the output written so far
+// while jb runs
+func (j *Jobs) progress(jb *job, status JobStatus) JobStatus {
+	if status.State == JobRunning {
+		if info, err := os.Stat(jb.output); err == nil {
+			status.BytesWritten = info.Size()
+		}
+	}
+	return status
+}
+
+// result serves the redacted file of a succeeded job, and the status of
+// any other with 409 Conflict
+func (j *Jobs) result(w http.ResponseWriter, r *http.Request) {
+	jb, status := j.lookup(w, r)
+	if jb == nil {
+		return
+	}
+	if status.State != JobSucceeded {
+		writeJSON(w, http.StatusConflict, status)
+		return
+	}
+	f, err := os.Open(jb.output)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": status.Name}))
+	http.ServeContent(w, r, status.Name, *status.Finished, f)
+}
+
+// delete cancels a job and deletes its files, at once when it has
+// finished and otherwise once it stops
+func (j *Jobs) delete(w http.ResponseWriter, r *http.Request) {
+	jb, status := j.lookup(w, r)
+	if jb == nil {
+		return
+	}
+	j.mu.Lock()
+	delete(j.jobs, status.ID)
+	if jb.status.Finished != nil {
+		os.RemoveAll(jb.dir)
+	}
+	j.mu.Unlock()
+	jb.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// expire deletes finished jobs older than the TTL until the Jobs' context
+// is cancelled
+func (j *Jobs) expire() {
+	ticker := time.NewTicker(min(j.opts.TTL, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.ctx.Done():
+			return
+		case now := <-ticker.C:
+			j.mu.Lock()
+			for id, jb := range j.jobs {
+				if jb.status.Finished != nil && now.Sub(*jb.status.Finished) > j.opts.TTL {
+					delete(j.jobs, id)
+					os.RemoveAll(jb.dir)
+				}
+			}
+			j.mu.Unlock()
+		}
+	}
+}
+
+// newJobID returns a random job ID, unguessable so that holding one is
+// what grants access to the job
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeJSON writes v as the JSON body of a code response
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error body of a code response
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	kinesistypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+const (
+	// PutRecords takes at most this many records, of this many bytes
+	maxPutRecords     = 500
+	maxPutRecordBytes = 5 << 20
+)
+
+// LambdaStats counts what a LambdaHandler has handled
+type LambdaStats struct {
+	Invocations int64          `json:"invocations"`
+	Objects     int64          `json:"objects_redacted"`
+	Records     int64          `json:"records_received"`
+	Forwarded   int64          `json:"records_forwarded"`
+	Dropped     int64          `json:"records_dropped"`
+	Failed      int64          `json:"records_failed"`
+	Detections  map[string]int `json:"detections,omitempty"`
+}
+
+// LambdaHandler handles the events of an AWS Lambda function that
+// redacts: S3 notifications of objects put, whose objects are redacted
+// into ObjectOutput under the same key, and Kinesis records, redacted line
+// by line and put to the record sink. Kinesis records holding CloudWatch
+// Logs subscription payloads, and the awslogs events of a subscription
+// with the function as its destination, go through the CloudWatch relay
+// instead. For Kinesis the function answers with the records that could
+// not be put, so that with ReportBatchItemFailures only those are retried;
+// a record that cannot be redacted is dropped rather than put as received.
+type LambdaHandler struct {
+	redactor *logveil.Redactor
+	// ObjectOutput is the s3://bucket/prefix redacted objects are written
+	// under; objects already under it are not redacted again, so it may be
+	// in the bucket that notifies. Empty refuses S3 notifications.
+	ObjectOutput string
+	// Records receives redacted Kinesis records; nil refuses them
+	Records RecordSink
+	// CloudWatch redacts subscription payloads; nil refuses them
+	CloudWatch *CloudWatchRelay
+	// ErrorLog receives per-object and per-record errors; nil uses the
+	// standard logger
+	ErrorLog *log.Logger
+	// Metrics, when set, counts every line of a record redacted
+	Metrics *Metrics
+
+	mu    sync.Mutex
+	stats LambdaStats
+}
+
+// NewLambdaHandler returns a handler that redacts with redactor
+func NewLambdaHandler(redactor *logveil.Redactor) *LambdaHandler {
+	return &LambdaHandler{redactor: redactor}
+}
+
+// Stats returns a snapshot of the handler's counters
+func (h *LambdaHandler) Stats() LambdaStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := h.stats
+	stats.Detections = make(map[string]int, len(h.stats.Detections))
+	for rule, n := range h.stats.Detections {
+		stats.Detections[rule] = n
+	}
+	return stats
+}
+
+// lambdaEvent holds what tells the events a function receives apart
+type lambdaEvent struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+	} `json:"Records"`
+	Awslogs *events.CloudwatchLogsRawData `json:"awslogs"`
+}
+
+// Handle handles one invocation, as lambda.Start takes it
+func (h *LambdaHandler) Handle(ctx context.Context, payload json.RawMessage) (any, error) {
+	h.count(func(s *LambdaStats) { s.Invocations++ })
+	var event lambdaEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	switch {
+	case event.Awslogs != nil:
+		if h.CloudWatch == nil {
+			return nil, errors.New("cloudwatch logs events are not handled: no cloudwatch forward target")
+		}
+		return nil, h.CloudWatch.Process(ctx, []byte(event.Awslogs.Data))
+	case len(event.Records) == 0:
+		return nil, errors.New("unsupported event: expected S3 notifications, Kinesis records or CloudWatch Logs data")
+	case event.Records[0].EventSource == "aws:s3":
+		var s3Event events.S3Event
+		if err := json.Unmarshal(payload, &s3Event); err != nil {
+			return nil, fmt.Errorf("invalid S3 event: %v", err)
+		}
+		return nil, h.handleS3(ctx, s3Event)
+	case event.Records[0].EventSource == "aws:kinesis":
+		var kinesisEvent events.KinesisEvent
+		if err := json.Unmarshal(payload, &kinesisEvent); err != nil {
+			return nil, fmt.Errorf("invalid Kinesis event: %v", err)
+		}
+		return h.handleKinesis(ctx, kinesisEvent)
+	}
+	return nil, fmt.Errorf("unsupported event source %q", event.Records[0].EventSource)
+}
+
+// handleS3 redacts the objects of the notifications in event. An error
+// fails the invocation, so that Lambda delivers it again.
+func (h *LambdaHandler) handleS3(ctx context.Context, event events.S3Event) error {
+	if h.ObjectOutput == "" {
+		return errors.New("S3 events are not handled: no object output")
+	}
+	var failed []string
+	for _, input := range s3EventObjects(event.Records) {
+		output, ok := outputUnder(h.ObjectOutput, input)
+		if !ok {
+			continue
+		}
+		result, err := h.redactor.ProcessFile(ctx, input, output)
+		if err != nil {
+			h.logf("redact %s: %v", input, err)
+			failed = append(failed, input)
+			continue
+		}
+		h.count(func(s *LambdaStats) {
+			s.Objects++
+			for rule, n := range result.Detections {
+				if s.Detections == nil {
+					s.Detections = make(map[string]int)
+				}
+				s.Detections[rule] += n
+			}
+		})
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not redact %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// handleKinesis redacts the records of event, answering with those to
+// retry
+func (h *LambdaHandler) handleKinesis(ctx context.Context, event events.KinesisEvent) (events.KinesisEventResponse, error) {
+	var resp events.KinesisEventResponse
+	fail := func(record events.KinesisEventRecord) {
+		h.count(func(s *LambdaStats) { s.Failed++ })
+		resp.BatchItemFailures = append(resp.BatchItemFailures, events.KinesisBatchItemFailure{ItemIdentifier: record.Kinesis.SequenceNumber})
+	}
+
+	var put []KinesisRecord
+	var sources []events.KinesisEventRecord
+	var detections []logveil.Detection
+	for _, record := range event.Records {
+		h.count(func(s *LambdaStats) { s.Records++ })
+		data := record.Kinesis.Data
+		if isGzip(data) {
+			// CloudWatch Logs subscriptions put gzipped payloads
+			if h.CloudWatch == nil {
+				h.count(func(s *LambdaStats) { s.Dropped++ })
+				h.logf("dropping kinesis record %s: cloudwatch logs payloads are not handled: no cloudwatch forward target", record.Kinesis.SequenceNumber)
+				continue
+			}
+			err := h.CloudWatch.Process(ctx, data)
+			var invalid *CloudWatchPayloadError
+			switch {
+			case err == nil:
+				h.count(func(s *LambdaStats) { s.Forwarded++ })
+			case errors.As(err, &invalid):
+				h.count(func(s *LambdaStats) { s.Dropped++ })
+				h.logf("dropping kinesis record %s: %v", record.Kinesis.SequenceNumber, err)
+			default:
+				h.logf("kinesis record %s: %v", record.Kinesis.SequenceNumber, err)
+				fail(record)
+			}
+			continue
+		}
+		if h.Records == nil {
+			return resp, errors.New("kinesis records are not handled: no record forward target")
+		}
+		redacted, found, err := h.redactRecord(ctx, record)
+		if err != nil {
+			h.count(func(s *LambdaStats) { s.Dropped++ })
+			h.logf("dropping kinesis record %s: %v", record.Kinesis.SequenceNumber, err)
+			continue
+		}
+		put = append(put, KinesisRecord{PartitionKey: record.Kinesis.PartitionKey, Data: redacted})
+		sources = append(sources, record)
+		detections = append(detections, found...)
+	}
+	if len(put) == 0 {
+		return resp, nil
+	}
+
+	failed, err := h.Records.PutRecords(ctx, put)
+	if err != nil {
+		h.logf("could not put %d of %d kinesis records: %v", len(failed), len(put), err)
+	}
+	for _, i := range failed {
+		fail(sources[i])
+	}
+	h.count(func(s *LambdaStats) {
+		s.Forwarded += int64(len(put) - len(failed))
+		for _, d := range detections {
+			if s.Detections == nil {
+				s.Detections = make(map[string]int)
+			}
+			s.Detections[d.Rule]++
+		}
+	})
+	return resp, nil
+}
+
+// redactRecord redacts the data of record line by line
+func (h *LambdaHandler) redactRecord(ctx context.Context, record events.KinesisEventRecord) ([]byte, []logveil.Detection, error) {
+	attr := attribute.String("logveil.kinesis.partition_key", record.Kinesis.PartitionKey)
+	lines := strings.Split(string(record.Kinesis.Data), "\n")
+	var detections []logveil.Detection
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		redacted, found, err := redactLine(ctx, h.redactor, h.Metrics, "logveil.lambda/record", line, attr)
+		if err != nil {
+			return nil, nil, err
+		}
+		lines[i] = redacted
+		detections = append(detections, found...)
+	}
+	return []byte(strings.Join(lines, "\n")), detections, nil
+}
+
+func (h *LambdaHandler) count(update func(s *LambdaStats)) {
+	h.mu.Lock()
+	update(&h.stats)
+	h.mu.Unlock()
+}
+
+func (h *LambdaHandler) logf(format string, args ...any) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// KinesisRecord is a record to put to a Kinesis data stream
+type KinesisRecord struct {
+	PartitionKey string
+	Data         []byte
+}
+
+// RecordSink puts redacted records. PutRecords returns the indexes of the
+// records it could not put, with the error of the first.
+type RecordSink interface {
+	PutRecords(ctx context.Context, records []KinesisRecord) (failed []int, err error)
+	Close() error
+}
+
+// DialRecordSink returns the sink for target: kinesis:<stream> puts
+// records to that Kinesis data stream, named or given by ARN, under the
+// partition key they came with; any other target is one DialForwarder
+// accepts, sent the lines of each record. Credentials, region and
+// endpoint come from the standard AWS configuration chain.
+func DialRecordSink(ctx context.Context, target string) (RecordSink, error) {
+	stream, ok := strings.CutPrefix(target, "kinesis:")
+	if !ok {
+		forward, err := DialForwarder(target)
+		if err != nil {
+			return nil, err
+		}
+		return NewRecordLineSink(forward), nil
+	}
+	if stream == "" {
+		return nil, fmt.Errorf("kinesis target %q names no stream", target)
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sink := &kinesisSink{client: kinesis.NewFromConfig(cfg)}
+	if strings.HasPrefix(stream, "arn:") {
+		sink.streamARN = stream
+	} else {
+		sink.stream = stream
+	}
+	return sink, nil
+}
+
+// kinesisSink puts records to a Kinesis data stream
+type kinesisSink struct {
+	client    *kinesis.Client
+	stream    string
+	streamARN string
+}
+
+func (s *kinesisSink) PutRecords(ctx context.Context, records []KinesisRecord) ([]int, error) {
+	var failed []int
+	var first error
+	for start := 0; start < len(records); {
+		end, size := start, 0
+		for end < len(records) && end-start < maxPutRecords {
+			recordSize := len(records[end].Data) + len(records[end].PartitionKey)
+			if end > start && size+recordSize > maxPutRecordBytes {
+				break
+			}
+			size += recordSize
+			end++
+		}
+		entries := make([]kinesistypes.PutRecordsRequestEntry, 0, end-start)
+		for _, record := range records[start:end] {
+			entries = append(entries, kinesistypes.PutRecordsRequestEntry{Data: record.Data, PartitionKey: aws.String(record.PartitionKey)})
+		}
+		input := &kinesis.PutRecordsInput{Records: entries}
+		if s.streamARN != "" {
+			input.StreamARN = aws.String(s.streamARN)
+		} else {
+			input.StreamName = aws.String(s.stream)
+		}
+		out, err := s.client.PutRecords(ctx, input)
+		switch {
+		case err != nil:
+			for i := start; i < end; i++ {
+				failed = append(failed, i)
+			}
+			if first == nil {
+				first = err
+			}
+		case aws.ToInt32(out.FailedRecordCount) > 0:
+			for i, result := range out.Records {
+				if result.ErrorCode == nil {
+					continue
+				}
+				failed = append(failed, start+i)
+				if first == nil {
+					first = fmt.Errorf("%s: %s", aws.ToString(result.ErrorCode), aws.ToString(result.ErrorMessage))
+				}
+			}
+		}
+		start = end
+	}
+	return failed, first
+}
+
+func (s *kinesisSink) Close() error {
+	return nil
+}
+
+// lineRecordSink sends the lines of each record
+type lineRecordSink struct {
+	forward Forwarder
+}
+
+// NewRecordLineSink returns a RecordSink sending forward the non-empty
+// lines of each record
+func NewRecordLineSink(forward Forwarder) RecordSink {
+	return lineRecordSink{forward}
+}
+
+func (s lineRecordSink) PutRecords(_ context.Context, records []KinesisRecord) ([]int, error) {
+	for i, record := range records {
+		for _, line := range strings.Split(string(record.Data), "\n") {
+			if line == "" {
+				continue
+			}
+			if err := s.forward.Forward(line); err != nil {
+				failed := make([]int, 0, len(records)-i)
+				for j := i; j < len(records); j++ {
+					failed = append(failed, j)
+				}
+				return failed, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s lineRecordSink) Close() error {
+	return s.forward.Close()
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// ClientLimits caps what a single client may use of serve mode, so that
+// one noisy producer cannot starve the rest. Zero fields are unlimited.
+type ClientLimits struct {
+	// Rate is the requests per second a client may sustain, counting each
+	// line streamed over gRPC, and Burst how many may come at once
+	Rate  float64
+	Burst int
+	// MaxJobs is how many of a client's jobs may be queued or running
+	MaxJobs int
+	// MaxBytes is the largest job upload a client may make
+	MaxBytes int64
+}
+
+// Limiter holds every client to its ClientLimits, and to those of its
+// tenant. HTTP requests and unary calls over the rate are refused, while
+// gRPC streams are slowed to it. A nil *Limiter limits nothing.
+type Limiter struct {
+	defaults ClientLimits
+	clients  map[string]ClientLimits
+	// Tenants, when set, share the limits of each tenant among its clients
+	Tenants *Tenants
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewLimiter returns a limiter applying defaults to every client, with the
+// non-zero fields of clients, keyed by client ID, taking their place
+func NewLimiter(defaults ClientLimits, clients map[string]ClientLimits) *Limiter {
+	return &Limiter{defaults: defaults, clients: clients, limiters: make(map[string]*rate.Limiter)}
+}
+
+// Limits returns the limits of client
+func (l *Limiter) Limits(client string) ClientLimits {
+	if l == nil {
+		return ClientLimits{}
+	}
+	limits := l.defaults
+	if own, ok := l.clients[client]; ok {
+		if own.Rate != 0 {
+			limits.Rate = own.Rate
+		}
+		if own.Burst != 0 {
+			limits.Burst = own.Burst
+		}
+		if own.MaxJobs != 0 {
+			limits.MaxJobs = own.MaxJobs
+		}
+		if own.MaxBytes != 0 {
+			limits.MaxBytes = own.MaxBytes
+		}
+	}
+	return limits
+}
+
+// limiter returns the rate limiter of client, or nil when its rate is
+// unlimited
+func (l *Limiter) limiter(client string) *rate.Limiter {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limiter, ok := l.limiters[client]; ok {
+		return limiter
+	}
+	limiter := newRateLimiter(l.Limits(client))
+	l.limiters[client] = limiter
+	return limiter
+}
+
+// newRateLimiter returns a rate limiter enforcing limits, or nil when
+// their rate is unlimited
+func newRateLimiter(limits ClientLimits) *rate.Limiter {
+	if limits.Rate <= 0 {
+		return nil
+	}
+	burst := limits.Burst
+	if burst < 1 {
+		burst = int(math.Ceil(limits.Rate))
+	}
+	return rate.NewLimiter(rate.Limit(limits.Rate), burst)
+}
+
+// rateLimiters returns the rate limiters client is held to: its own and
+// its tenant's, where limited
+func (l *Limiter) rateLimiters(client string) []*rate.Limiter {
+	if l == nil {
+		return nil
+	}
+	var limiters []*rate.Limiter
+	for _, limiter := range []*rate.Limiter{l.limiter(client), l.Tenants.limiter(l.Tenants.Lookup(client))} {
+		if limiter != nil {
+			limiters = append(limiters, limiter)
+		}
+	}
+	return limiters
+}
+
+// allow reports whether client may make a request now
+func (l *Limiter) allow(client string) bool {
+	for _, limiter := range l.rateLimiters(client) {
+		if !limiter.Allow() {
+			return false
+		}
+	}
+	return true
+}
+
+// HTTP refuses requests over their client's rate with 429. It goes inside
+// Auth.HTTP, which identifies the client.
+func (l *Limiter) HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(ClientID(r.Context())) {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusTooManyRequests, errRateLimited)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UnaryInterceptor refuses calls over their client's rate. It is chained
+// after Auth's.
+func (l *Limiter) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !l.allow(ClientID(ctx)) {
+			return nil, status.Error(codes.ResourceExhausted, errRateLimited.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor holds each message received on a stream back to its
+// client's rate. It is chained after Auth's.
+func (l *Limiter) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		limiters := l.rateLimiters(ClientID(stream.Context()))
+		if len(limiters) == 0 {
+			return handler(srv, stream)
+		}
+		return handler(srv, limitedStream{ServerStream: stream, limiters: limiters})
+	}
+}
+
+// limitedStream waits for its limiters before each message it receives
+type limitedStream struct {
+	grpc.ServerStream
+	limiters []*rate.Limiter
+}
+
+func (s limitedStream) RecvMsg(m any) error {
+	for _, limiter := range s.limiters {
+		if err := limiter.Wait(s.Context()); err != nil {
+			return status.FromContextError(err).Err()
+		}
+	}
+	return s.ServerStream.RecvMsg(m)
+}
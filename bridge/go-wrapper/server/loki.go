@@ -0,0 +1,630 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+const (
+	// LokiPushPath is where Loki, and so a LokiRelay, receives pushes
+	LokiPushPath = "/loki/api/v1/push"
+	// maxLokiPush caps the body of a push, decompressed
+	maxLokiPush = 64 << 20
+	// lokiOrgHeader names the tenant of a push to a multi-tenant Loki
+	lokiOrgHeader = "X-Scope-OrgID"
+)
+
+// LokiStream is the entries of one stream pushed to Loki, with its labels
+// in Prometheus form, such as {app="shop", env="prod"}
+type LokiStream struct {
+	Labels  string
+	Entries []LokiEntry
+}
+
+// LokiEntry is one line of a stream, with the structured metadata attached
+// to it
+type LokiEntry struct {
+	Time     time.Time
+	Line     string
+	Metadata []LokiLabel
+}
+
+// LokiLabel is a name and value of structured metadata
+type LokiLabel struct {
+	Name  string
+	Value string
+}
+
+// LokiStats counts what a LokiRelay has handled
+type LokiStats struct {
+	Pushes     int64          `json:"pushes_received"`
+	Received   int64          `json:"lines_received"`
+	Forwarded  int64          `json:"lines_forwarded"`
+	Dropped    int64          `json:"lines_dropped"`
+	Detections map[string]int `json:"detections,omitempty"`
+}
+
+// LokiRelay serves Loki's push API, so that promtail and other Loki
+// clients can push through it: the lines of each push, and their
+// structured metadata, are redacted and forwarded with their labels as
+// they came. Pushes are accepted as snappy-compressed protobuf, as
+// promtail sends them, or as JSON. A push is answered once forwarded, with
+// the status the downstream Loki answered it with, so that clients retry
+// what it did not take; a line that cannot be redacted is dropped rather
+// than forwarded as received.
+type LokiRelay struct {
+	redactor *logveil.Redactor
+	forward  LokiForwarder
+	// ErrorLog receives per-line and per-push errors; nil uses the
+	// standard logger
+	ErrorLog *log.Logger
+	// Metrics, when set, counts every line redacted
+	Metrics *Metrics
+
+	mu    sync.Mutex
+	stats LokiStats
+}
+
+// NewLokiRelay returns a relay that redacts with redactor and sends to
+// forward
+func NewLokiRelay(redactor *logveil.Redactor, forward LokiForwarder) *LokiRelay {
+	return &LokiRelay{redactor: redactor, forward: forward}
+}
+
+// Stats returns a snapshot of the relay's counters
+func (r *LokiRelay) Stats() LokiStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	stats.Detections = make(map[string]int, len(r.stats.Detections))
+	for rule, n := range r.stats.Detections {
+		stats.Detections[rule] = n
+	}
+	return stats
+}
+
+// Handler returns the push API, and the readiness endpoint Loki clients may
+// probe
+func (r *LokiRelay) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+LokiPushPath, r.push)
+	mux.HandleFunc("GET /ready", func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "ready\n")
+	})
+	return mux
+}
+
+func (r *LokiRelay) push(w http.ResponseWriter, req *http.Request) {
+	streams, err := readLokiPush(w, req)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("push exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	r.count(func(s *LokiStats) { s.Pushes++ })
+
+	redacted, lines, detections := r.redactStreams(req.Context(), streams)
+	if lines == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := r.forward.PushStreams(req.Context(), req.Header.Get(lokiOrgHeader), redacted); err != nil {
+		r.count(func(s *LokiStats) { s.Dropped += int64(lines) })
+		r.logf("loki push from %s: forward: %v", req.RemoteAddr, err)
+		code := http.StatusBadGateway
+		var status *LokiStatusError
+		if errors.As(err, &status) {
+			code = status.Code
+		}
+		writeError(w, code, err)
+		return
+	}
+	r.count(func(s *LokiStats) {
+		s.Forwarded += int64(lines)
+		for _, d := range detections {
+			if s.Detections == nil {
+				s.Detections = make(map[string]int)
+			}
+			s.Detections[d.Rule]++
+		}
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// redactStreams redacts the lines and metadata of streams, returning the
+// streams left, how many lines they hold and what was found in them
+func (r *LokiRelay) redactStreams(ctx context.Context, streams []LokiStream) ([]LokiStream, int, []logveil.Detection) {
+	var out []LokiStream
+	var detections []logveil.Detection
+	total := 0
+	for _, stream := range streams {
+		r.count(func(s *LokiStats) { s.Received += int64(len(stream.Entries)) })
+		attr := attribute.String("logveil.loki.labels", stream.Labels)
+		entries := make([]LokiEntry, 0, len(stream.Entries))
+		for _, entry := range stream.Entries {
+			redacted, found, err := r.redactEntry(ctx, entry, attr)
+			if err != nil {
+				r.count(func(s *LokiStats) { s.Dropped++ })
+				r.logf("dropping loki line of stream %s: %v", stream.Labels, err)
+				continue
+			}
+			entries = append(entries, redacted)
+			detections = append(detections, found...)
+		}
+		if len(entries) > 0 {
+			out = append(out, LokiStream{Labels: stream.Labels, Entries: entries})
+			total += len(entries)
+		}
+	}
+	return out, total, detections
+}
+
+// redactEntry redacts the line and metadata values of entry
+func (r *LokiRelay) redactEntry(ctx context.Context, entry LokiEntry, attr attribute.KeyValue) (LokiEntry, []logveil.Detection, error) {
+	line, detections, err := redactLine(ctx, r.redactor, r.Metrics, "logveil.loki/line", entry.Line, attr)
+	if err != nil {
+		return LokiEntry{}, nil, err
+	}
+	redacted := LokiEntry{Time: entry.Time, Line: line}
+	for _, label := range entry.Metadata {
+		value, found, err := redactLine(ctx, r.redactor, r.Metrics, "logveil.loki/metadata", label.Value, attr)
+		if err != nil {
+			return LokiEntry{}, nil, fmt.Errorf("metadata %s: %v", label.Name, err)
+		}
+		redacted.Metadata = append(redacted.Metadata, LokiLabel{Name: label.Name, Value: value})
+		detections = append(detections, found...)
+	}
+	return redacted, detections, nil
+}
+
+// readLokiPush reads the streams of a push, as protobuf when its content
+// type says so and as JSON otherwise
+func readLokiPush(w http.ResponseWriter, req *http.Request) ([]LokiStream, error) {
+	body := io.Reader(http.MaxBytesReader(w, req.Body, maxLokiPush))
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, maxLokiPush+1)
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", req.Header.Get("Content-Encoding"))
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxLokiPush {
+		return nil, &http.MaxBytesError{Limit: maxLokiPush}
+	}
+
+	contentType, _, _ := strings.Cut(req.Header.Get("Content-Type"), ";")
+	if strings.TrimSpace(contentType) == "application/x-protobuf" {
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, fmt.Errorf("snappy: %v", err)
+		}
+		if n > maxLokiPush {
+			return nil, &http.MaxBytesError{Limit: maxLokiPush}
+		}
+		if data, err = snappy.Decode(nil, data); err != nil {
+			return nil, fmt.Errorf("snappy: %v", err)
+		}
+		return decodeLokiProto(data)
+	}
+	return decodeLokiJSON(data)
+}
+
+// lokiJSONPush is the JSON form of a push. Each value is a timestamp in
+// nanoseconds and a line, then optionally an object of structured metadata.
+type lokiJSONPush struct {
+	Streams []struct {
+		Stream map[string]string   `json:"stream"`
+		Values [][]json.RawMessage `json:"values"`
+	} `json:"streams"`
+}
+
+func decodeLokiJSON(data []byte) ([]LokiStream, error) {
+	var push lokiJSONPush
+	if err := json.Unmarshal(data, &push); err != nil {
+		return nil, err
+	}
+	streams := make([]LokiStream, 0, len(push.Streams))
+	for _, s := range push.Streams {
+		stream := LokiStream{Labels: formatLokiLabels(s.Stream)}
+		for _, value := range s.Values {
+			if len(value) < 2 {
+				return nil, fmt.Errorf("stream %s: value of %d items, not [timestamp, line]", stream.Labels, len(value))
+			}
+			var ts, line string
+			if err := json.Unmarshal(value[0], &ts); err != nil {
+				return nil, fmt.Errorf("stream %s: timestamp: %v", stream.Labels, err)
+			}
+			nanos, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("stream %s: timestamp: %v", stream.Labels, err)
+			}
+			if err := json.Unmarshal(value[1], &line); err != nil {
+				return nil, fmt.Errorf("stream %s: line: %v", stream.Labels, err)
+			}
+			entry := LokiEntry{Time: time.Unix(0, nanos), Line: line}
+			if len(value) > 2 {
+				var metadata map[string]string
+				if err := json.Unmarshal(value[2], &metadata); err != nil {
+					return nil, fmt.Errorf("stream %s: structured metadata: %v", stream.Labels, err)
+				}
+				for _, name := range sortedKeys(metadata) {
+					entry.Metadata = append(entry.Metadata, LokiLabel{Name: name, Value: metadata[name]})
+				}
+			}
+			stream.Entries = append(stream.Entries, entry)
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// formatLokiLabels writes labels in Prometheus form
+func formatLokiLabels(labels map[string]string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range sortedKeys(labels) {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// The protobuf form of a push is logproto.PushRequest:
+//
+//	PushRequest   { repeated Stream streams = 1; }
+//	Stream        { string labels = 1; repeated Entry entries = 2; uint64 hash = 3; }
+//	Entry         { Timestamp timestamp = 1; string line = 2; repeated LabelPair structuredMetadata = 3; }
+//	Timestamp     { int64 seconds = 1; int32 nanos = 2; }
+//	LabelPair     { string name = 1; string value = 2; }
+//
+// It is read and written field by field, so that the bridge does not
+// depend on Loki's own modules.
+
+func decodeLokiProto(data []byte) ([]LokiStream, error) {
+	var streams []LokiStream
+	err := walkProto(data, func(num protowire.Number, value []byte) error {
+		if num != 1 {
+			return nil
+		}
+		stream, err := decodeLokiStream(value)
+		if err != nil {
+			return err
+		}
+		streams = append(streams, stream)
+		return nil
+	})
+	return streams, err
+}
+
+func decodeLokiStream(data []byte) (LokiStream, error) {
+	var stream LokiStream
+	err := walkProto(data, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			stream.Labels = string(value)
+		case 2:
+			entry, err := decodeLokiEntry(value)
+			if err != nil {
+				return err
+			}
+			stream.Entries = append(stream.Entries, entry)
+		}
+		return nil
+	})
+	return stream, err
+}
+
+func decodeLokiEntry(data []byte) (LokiEntry, error) {
+	var entry LokiEntry
+	err := walkProto(data, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			var seconds, nanos int64
+			err := walkProtoVarints(value, func(num protowire.Number, v uint64) {
+				switch num {
+				case 1:
+					seconds = int64(v)
+				case 2:
+					nanos = int64(int32(v))
+				}
+			})
+			if err != nil {
+				return fmt.Errorf("timestamp: %v", err)
+			}
+			entry.Time = time.Unix(seconds, nanos)
+		case 2:
+			entry.Line = string(value)
+		case 3:
+			var label LokiLabel
+			err := walkProto(value, func(num protowire.Number, v []byte) error {
+				switch num {
+				case 1:
+					label.Name = string(v)
+				case 2:
+					label.Value = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("structured metadata: %v", err)
+			}
+			entry.Metadata = append(entry.Metadata, label)
+		}
+		return nil
+	})
+	return entry, err
+}
+
+// walkProto calls field with the number and contents of each
+// length-delimited field of the message in data, skipping the others
+func walkProto(data []byte, field func(num protowire.Number, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := field(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkProtoVarints calls field with the number and value of each varint
+// field of the message in data, skipping the others
+func walkProtoVarints(data []byte, field func(num protowire.Number, value uint64)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ != protowire.VarintType {
+			if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		value, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		field(num, value)
+	}
+	return nil
+}
+
+// encodeLokiProto returns the protobuf form of a push of streams
+func encodeLokiProto(streams []LokiStream) []byte {
+	var push []byte
+	for _, stream := range streams {
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.BytesType)
+		s = protowire.AppendString(s, stream.Labels)
+		for _, entry := range stream.Entries {
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(entry.Time.Unix()))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(entry.Time.Nanosecond()))
+
+			var e []byte
+			e = protowire.AppendTag(e, 1, protowire.BytesType)
+			e = protowire.AppendBytes(e, ts)
+			e = protowire.AppendTag(e, 2, protowire.BytesType)
+			e = protowire.AppendString(e, entry.Line)
+			for _, label := range entry.Metadata {
+				var l []byte
+				l = protowire.AppendTag(l, 1, protowire.BytesType)
+				l = protowire.AppendString(l, label.Name)
+				l = protowire.AppendTag(l, 2, protowire.BytesType)
+				l = protowire.AppendString(l, label.Value)
+				e = protowire.AppendTag(e, 3, protowire.BytesType)
+				e = protowire.AppendBytes(e, l)
+			}
+			s = protowire.AppendTag(s, 2, protowire.BytesType)
+			s = protowire.AppendBytes(s, e)
+		}
+		push = protowire.AppendTag(push, 1, protowire.BytesType)
+		push = protowire.AppendBytes(push, s)
+	}
+	return push
+}
+
+func (r *LokiRelay) count(update func(s *LokiStats)) {
+	r.mu.Lock()
+	update(&r.stats)
+	r.mu.Unlock()
+}
+
+func (r *LokiRelay) logf(format string, args ...any) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// LokiForwarder sends redacted pushes downstream, for the Loki tenant
+// orgID names, "" when the push named none. PushStreams must be safe for
+// concurrent use.
+type LokiForwarder interface {
+	PushStreams(ctx context.Context, orgID string, streams []LokiStream) error
+	Close() error
+}
+
+// LokiStatusError is a push the downstream Loki refused
+type LokiStatusError struct {
+	Code int
+	Body string
+}
+
+func (e *LokiStatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("loki answered %d", e.Code)
+	}
+	return fmt.Sprintf("loki answered %d: %s", e.Code, e.Body)
+}
+
+// DialLokiForwarder returns a LokiForwarder for target: an http:// or
+// https:// URL of a Loki, with or without the push path, is sent each push
+// as snappy-compressed protobuf; any other target is one DialForwarder
+// accepts, sent one JSON object with the labels, time, line and metadata
+// per line.
+func DialLokiForwarder(target string) (LokiForwarder, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = LokiPushPath
+		}
+		return &httpLokiForwarder{url: u.String(), client: &http.Client{Timeout: 30 * time.Second}}, nil
+	}
+	if strings.Contains(target, "://") && !strings.HasPrefix(target, "udp://") && !strings.HasPrefix(target, "tcp://") {
+		return nil, fmt.Errorf("unsupported forward target %q (expected http://, https://, udp://, tcp:// or a file)", target)
+	}
+	forward, err := DialForwarder(target)
+	if err != nil {
+		return nil, err
+	}
+	return NewLokiLineForwarder(forward), nil
+}
+
+type httpLokiForwarder struct {
+	url    string
+	client *http.Client
+}
+
+func (f *httpLokiForwarder) PushStreams(ctx context.Context, orgID string, streams []LokiStream) error {
+	body := snappy.Encode(nil, encodeLokiProto(streams))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if orgID != "" {
+		req.Header.Set(lokiOrgHeader, orgID)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &LokiStatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+}
+
+func (f *httpLokiForwarder) Close() error {
+	f.client.CloseIdleConnections()
+	return nil
+}
+
+// lineLokiForwarder sends each line as a line of JSON
+type lineLokiForwarder struct {
+	forward Forwarder
+}
+
+// NewLokiLineForwarder returns a LokiForwarder sending forward one JSON
+// object with the labels, time, line and metadata per line
+func NewLokiLineForwarder(forward Forwarder) LokiForwarder {
+	return lineLokiForwarder{forward}
+}
+
+func (f lineLokiForwarder) PushStreams(_ context.Context, orgID string, streams []LokiStream) error {
+	for _, stream := range streams {
+		for _, entry := range stream.Entries {
+			var metadata map[string]string
+			for _, label := range entry.Metadata {
+				if metadata == nil {
+					metadata = make(map[string]string, len(entry.Metadata))
+				}
+				metadata[label.Name] = label.Value
+			}
+			line, err := json.Marshal(struct {
+				Tenant   string            `json:"tenant,omitempty"`
+				Labels   string            `json:"labels"`
+				Time     string            `json:"time"`
+				Line     string            `json:"line"`
+				Metadata map[string]string `json:"metadata,omitempty"`
+			}{orgID, stream.Labels, entry.Time.UTC().Format(time.RFC3339Nano), entry.Line, metadata})
+			if err != nil {
+				return err
+			}
+			if err := f.forward.Forward(string(line)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f lineLokiForwarder) Close() error {
+	return f.forward.Close()
+}
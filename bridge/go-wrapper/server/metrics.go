@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// Metrics counts what the long-running modes redact and serves the counts
+// for Prometheus. A nil *Metrics ignores observations, so services can
+// take one unconditionally.
+type Metrics struct {
+	registry   *prometheus.Registry
+	lines      prometheus.Counter
+	bytes      prometheus.Counter
+	errors     prometheus.Counter
+	detections *prometheus.CounterVec
+	latency    prometheus.Histogram
+}
+
+// NewMetrics returns metrics for redactor, including its subprocess
+// failures and the Go runtime and process collectors
+func NewMetrics(redactor *logveil.Redactor) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		lines: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "logveil_lines_processed_total",
+			Help: "Lines, messages and records redacted.",
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "logveil_bytes_processed_total",
+			Help: "Bytes of input redacted.",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "logveil_line_errors_total",
+			Help: "Lines that could not be redacted and were dropped, and files that failed.",
+		}),
+		detections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "logveil_detections_total",
+			Help: "Sensitive values redacted, by rule.",
+		}, []string{"rule"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "logveil_line_duration_seconds",
+			Help:    "Time taken to redact one line.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}),
+	}
+	m.registry.MustRegister(
+		m.lines, m.bytes, m.errors, m.detections, m.latency,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "logveil_subprocess_failures_total",
+			Help: "Python agent processes that failed, died or could not start.",
+		}, func() float64 { return float64(redactor.SubprocessFailures()) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// ObserveLine records one line of size bytes redacted in elapsed, or the
+// error that stopped it
+func (m *Metrics) ObserveLine(size int, detections []logveil.Detection, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.latency.Observe(elapsed.Seconds())
+	if err != nil {
+		m.errors.Inc()
+		return
+	}
+	m.lines.Inc()
+	m.bytes.Add(float64(size))
+	for _, d := range detections {
+		m.detections.WithLabelValues(d.Rule).Inc()
+	}
+}
+
+// ObserveFile records a whole file, for modes that redact files rather
+// than lines. A failed file counts as one error.
+func (m *Metrics) ObserveFile(file logveil.FileResult) {
+	if m == nil {
+		return
+	}
+	if result := file.Result; result != nil {
+		m.lines.Add(float64(result.LinesProcessed))
+		m.bytes.Add(float64(result.BytesRead))
+		for rule, n := range result.Detections {
+			m.detections.WithLabelValues(rule).Add(float64(n))
+		}
+	}
+	if file.Error != "" || file.Result == nil || !file.Result.Success {
+		m.errors.Inc()
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ServeMetrics serves m on /metrics from listener until ctx is cancelled,
+// the runtime profiles on /debug/pprof/ when pprof is set, such as a
+// PprofHandler, and the probes of health when it is set
+func ServeMetrics(ctx context.Context, listener net.Listener, m *Metrics, pprof http.Handler, health *Health) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	if pprof != nil {
+		mux.Handle("/debug/pprof/", pprof)
+	}
+	if health != nil {
+		health.Register(mux)
+	}
+	return Serve(ctx, listener, mux)
+}
+
+// Serve serves handler over HTTP from listener until ctx is cancelled,
+// then lets requests in flight finish for a few seconds
+func Serve(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,584 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	// OTLP exporters compress with gzip by default
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+const (
+	// OTLPLogsPath is where OTLP/HTTP receives logs
+	OTLPLogsPath = "/v1/logs"
+	// MaxOTLPRequest caps an export request, decompressed
+	MaxOTLPRequest = 64 << 20
+)
+
+// OTLPStats counts what an OTLPRelay has handled
+type OTLPStats struct {
+	Requests   int64          `json:"requests_received"`
+	Received   int64          `json:"records_received"`
+	Forwarded  int64          `json:"records_forwarded"`
+	Dropped    int64          `json:"records_dropped"`
+	Detections map[string]int `json:"detections,omitempty"`
+}
+
+// OTLPRelay receives logs over OTLP, as the logs service over gRPC and as
+// POSTs to OTLPLogsPath over HTTP, redacts the body and attributes of each
+// log record and exports the result downstream, so that it can stand in a
+// pipeline where a collector processor would. Resource and scope
+// attributes are passed on as they came. A request is answered once
+// exported, with a retryable error when the export failed, so that the
+// sender tries it again; a record that cannot be redacted is dropped
+// rather than exported as received, and reported as rejected.
+type OTLPRelay struct {
+	collogspb.UnimplementedLogsServiceServer
+	redactor *logveil.Redactor
+	forward  OTLPForwarder
+	// ErrorLog receives per-record and per-request errors; nil uses the
+	// standard logger
+	ErrorLog *log.Logger
+	// Metrics, when set, counts every value redacted
+	Metrics *Metrics
+
+	mu    sync.Mutex
+	stats OTLPStats
+}
+
+// NewOTLPRelay returns a relay that redacts with redactor and exports to
+// forward
+func NewOTLPRelay(redactor *logveil.Redactor, forward OTLPForwarder) *OTLPRelay {
+	return &OTLPRelay{redactor: redactor, forward: forward}
+}
+
+// Stats returns a snapshot of the relay's counters
+func (r *OTLPRelay) Stats() OTLPStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	stats.Detections = make(map[string]int, len(r.stats.Detections))
+	for rule, n := range r.stats.Detections {
+		stats.Detections[rule] = n
+	}
+	return stats
+}
+
+// Export implements the OTLP logs service
+func (r *OTLPRelay) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	resp, err := r.export(ctx, req)
+	if err != nil {
+		return nil, status.Error(grpcCode(err), err.Error())
+	}
+	return resp, nil
+}
+
+// Handler returns OTLP/HTTP, taking requests as protobuf or JSON and
+// answering in kind
+func (r *OTLPRelay) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+OTLPLogsPath, r.serveHTTP)
+	return mux
+}
+
+func (r *OTLPRelay) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	contentType, _, _ := strings.Cut(req.Header.Get("Content-Type"), ";")
+	isJSON := strings.TrimSpace(contentType) == "application/json"
+	data, err := readOTLPBody(w, req)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	export := &collogspb.ExportLogsServiceRequest{}
+	if isJSON {
+		err = unmarshalOTLPJSON(data, export)
+	} else {
+		err = proto.Unmarshal(data, export)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp, err := r.export(req.Context(), export)
+	if err != nil {
+		writeError(w, httpCode(err), err)
+		return
+	}
+	var body []byte
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		body, err = protojson.Marshal(resp)
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		body, err = proto.Marshal(resp)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// readOTLPBody reads a request body, decompressing it as its encoding says
+func readOTLPBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	body := io.Reader(http.MaxBytesReader(w, req.Body, MaxOTLPRequest))
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, MaxOTLPRequest+1)
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", req.Header.Get("Content-Encoding"))
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxOTLPRequest {
+		return nil, &http.MaxBytesError{Limit: MaxOTLPRequest}
+	}
+	return data, nil
+}
+
+// unmarshalOTLPJSON reads the JSON form of a request. OTLP writes trace and
+// span IDs in hex where protojson expects base64, so they are converted
+// first.
+func unmarshalOTLPJSON(data []byte, req *collogspb.ExportLogsServiceRequest) error {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	for _, resourceLogs := range jsonObjects(doc, "resourceLogs", "resource_logs") {
+		for _, scopeLogs := range jsonObjects(resourceLogs, "scopeLogs", "scope_logs") {
+			for _, record := range jsonObjects(scopeLogs, "logRecords", "log_records") {
+				for _, key := range []string{"traceId", "trace_id", "spanId", "span_id"} {
+					if id, ok := record[key].(string); ok {
+						raw, err := hex.DecodeString(id)
+						if err != nil {
+							return fmt.Errorf("%s: %v", key, err)
+						}
+						record[key] = base64.StdEncoding.EncodeToString(raw)
+					}
+				}
+			}
+		}
+	}
+	converted, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(converted, req)
+}
+
+// jsonObjects returns the objects in the array under the first of keys
+// that obj has
+func jsonObjects(obj map[string]any, keys ...string) []map[string]any {
+	for _, key := range keys {
+		items, ok := obj[key].([]any)
+		if !ok {
+			continue
+		}
+		var objects []map[string]any
+		for _, item := range items {
+			if o, ok := item.(map[string]any); ok {
+				objects = append(objects, o)
+			}
+		}
+		return objects
+	}
+	return nil
+}
+
+// export redacts req in place and exports what is left of it
+func (r *OTLPRelay) export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	r.count(func(s *OTLPStats) { s.Requests++ })
+	var detections []logveil.Detection
+	var kept, rejected int64
+	var lastErr error
+	for _, resourceLogs := range req.GetResourceLogs() {
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			records := scopeLogs.GetLogRecords()[:0]
+			for _, record := range scopeLogs.GetLogRecords() {
+				found, err := r.redactRecord(ctx, record)
+				if err != nil {
+					rejected++
+					lastErr = err
+					r.logf("dropping otlp log record: %v", err)
+					continue
+				}
+				records = append(records, record)
+				detections = append(detections, found...)
+			}
+			scopeLogs.LogRecords = records
+			kept += int64(len(records))
+		}
+	}
+	r.count(func(s *OTLPStats) {
+		s.Received += kept + rejected
+		s.Dropped += rejected
+	})
+
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if rejected > 0 {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{
+			RejectedLogRecords: rejected,
+			ErrorMessage:       fmt.Sprintf("%d log records could not be redacted: %v", rejected, lastErr),
+		}
+	}
+	if kept == 0 {
+		return resp, nil
+	}
+	if err := r.forward.ExportLogs(ctx, req); err != nil {
+		r.count(func(s *OTLPStats) { s.Dropped += kept })
+		r.logf("otlp export: %v", err)
+		return nil, err
+	}
+	r.count(func(s *OTLPStats) {
+		s.Forwarded += kept
+		for _, d := range detections {
+			if s.Detections == nil {
+				s.Detections = make(map[string]int)
+			}
+			s.Detections[d.Rule]++
+		}
+	})
+	return resp, nil
+}
+
+// redactRecord redacts the body and attributes of record in place
+func (r *OTLPRelay) redactRecord(ctx context.Context, record *logspb.LogRecord) ([]logveil.Detection, error) {
+	var found []logveil.Detection
+	if err := r.redactValue(ctx, record.GetBody(), "body", &found); err != nil {
+		return nil, err
+	}
+	for _, kv := range record.GetAttributes() {
+		if err := r.redactValue(ctx, kv.GetValue(), kv.GetKey(), &found); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// redactValue redacts the strings in v, the value of field, in place
+func (r *OTLPRelay) redactValue(ctx context.Context, v *commonpb.AnyValue, field string, found *[]logveil.Detection) error {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		redacted, detections, err := redactLine(ctx, r.redactor, r.Metrics, "logveil.otlp/value", value.StringValue, attribute.String("logveil.otlp.field", field))
+		if err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+		value.StringValue = redacted
+		*found = append(*found, detections...)
+	case *commonpb.AnyValue_ArrayValue:
+		for _, item := range value.ArrayValue.GetValues() {
+			if err := r.redactValue(ctx, item, field, found); err != nil {
+				return err
+			}
+		}
+	case *commonpb.AnyValue_KvlistValue:
+		for _, kv := range value.KvlistValue.GetValues() {
+			if err := r.redactValue(ctx, kv.GetValue(), field+"."+kv.GetKey(), found); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *OTLPRelay) count(update func(s *OTLPStats)) {
+	r.mu.Lock()
+	update(&r.stats)
+	r.mu.Unlock()
+}
+
+func (r *OTLPRelay) logf(format string, args ...any) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// OTLPStatusError is an export the downstream OTLP/HTTP receiver refused
+type OTLPStatusError struct {
+	Code int
+	Body string
+}
+
+func (e *OTLPStatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("receiver answered %d", e.Code)
+	}
+	return fmt.Sprintf("receiver answered %d: %s", e.Code, e.Body)
+}
+
+// grpcCode returns the gRPC status answering a failed export with err,
+// keeping whether the sender should retry
+func grpcCode(err error) codes.Code {
+	var httpErr *OTLPStatusError
+	switch {
+	case errors.As(err, &httpErr):
+		switch httpErr.Code {
+		case http.StatusBadRequest:
+			return codes.InvalidArgument
+		case http.StatusTooManyRequests:
+			return codes.ResourceExhausted
+		}
+	default:
+		if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+			return s.Code()
+		}
+	}
+	return codes.Unavailable
+}
+
+// httpCode returns the HTTP status answering a failed export with err,
+// keeping whether the sender should retry
+func httpCode(err error) int {
+	var httpErr *OTLPStatusError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.InvalidArgument:
+			return http.StatusBadRequest
+		case codes.ResourceExhausted:
+			return http.StatusTooManyRequests
+		}
+	}
+	return http.StatusServiceUnavailable
+}
+
+// OTLPForwarder exports redacted logs downstream. ExportLogs must be safe
+// for concurrent use.
+type OTLPForwarder interface {
+	ExportLogs(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error
+	Close() error
+}
+
+// DialOTLPForwarder returns an OTLPForwarder for target: grpc://host:port
+// exports over OTLP/gRPC, grpcs://host:port the same over TLS, and an
+// http:// or https:// URL over OTLP/HTTP, its path defaulting to
+// OTLPLogsPath; any other target is one DialForwarder accepts, sent one
+// JSON object per log record.
+func DialOTLPForwarder(target string) (OTLPForwarder, error) {
+	switch {
+	case strings.HasPrefix(target, "grpc://"), strings.HasPrefix(target, "grpcs://"):
+		addr, secure := strings.TrimPrefix(target, "grpc://"), false
+		if strings.HasPrefix(target, "grpcs://") {
+			addr, secure = strings.TrimPrefix(target, "grpcs://"), true
+		}
+		creds := insecure.NewCredentials()
+		if secure {
+			creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		}
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		return &grpcOTLPForwarder{conn: conn, client: collogspb.NewLogsServiceClient(conn)}, nil
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = OTLPLogsPath
+		}
+		return &httpOTLPForwarder{url: u.String(), client: &http.Client{Timeout: 30 * time.Second}}, nil
+	case strings.Contains(target, "://") && !strings.HasPrefix(target, "udp://") && !strings.HasPrefix(target, "tcp://"):
+		return nil, fmt.Errorf("unsupported forward target %q (expected grpc://, grpcs://, http://, https://, udp://, tcp:// or a file)", target)
+	}
+	forward, err := DialForwarder(target)
+	if err != nil {
+		return nil, err
+	}
+	return NewOTLPLineForwarder(forward), nil
+}
+
+type grpcOTLPForwarder struct {
+	conn   *grpc.ClientConn
+	client collogspb.LogsServiceClient
+}
+
+func (f *grpcOTLPForwarder) ExportLogs(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	_, err := f.client.Export(ctx, req)
+	return err
+}
+
+func (f *grpcOTLPForwarder) Close() error {
+	return f.conn.Close()
+}
+
+type httpOTLPForwarder struct {
+	url    string
+	client *http.Client
+}
+
+func (f *httpOTLPForwarder) ExportLogs(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := f.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &OTLPStatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+}
+
+func (f *httpOTLPForwarder) Close() error {
+	f.client.CloseIdleConnections()
+	return nil
+}
+
+// lineOTLPForwarder sends each log record as a line of JSON
+type lineOTLPForwarder struct {
+	forward Forwarder
+}
+
+// NewOTLPLineForwarder returns an OTLPForwarder sending forward one JSON
+// object per log record, with its time, severity, body, attributes, trace
+// context and the attributes of its resource
+func NewOTLPLineForwarder(forward Forwarder) OTLPForwarder {
+	return lineOTLPForwarder{forward}
+}
+
+func (f lineOTLPForwarder) ExportLogs(_ context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	for _, resourceLogs := range req.GetResourceLogs() {
+		resource := otlpAttributes(resourceLogs.GetResource().GetAttributes())
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, record := range scopeLogs.GetLogRecords() {
+				nanos := record.GetTimeUnixNano()
+				if nanos == 0 {
+					nanos = record.GetObservedTimeUnixNano()
+				}
+				var at string
+				if nanos != 0 {
+					at = time.Unix(0, int64(nanos)).UTC().Format(time.RFC3339Nano)
+				}
+				severity := record.GetSeverityText()
+				if severity == "" && record.GetSeverityNumber() != logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED {
+					severity = strings.TrimPrefix(record.GetSeverityNumber().String(), "SEVERITY_NUMBER_")
+				}
+				line, err := json.Marshal(struct {
+					Time       string         `json:"time,omitempty"`
+					Severity   string         `json:"severity,omitempty"`
+					Body       any            `json:"body"`
+					Attributes map[string]any `json:"attributes,omitempty"`
+					TraceID    string         `json:"trace_id,omitempty"`
+					SpanID     string         `json:"span_id,omitempty"`
+					Scope      string         `json:"scope,omitempty"`
+					Resource   map[string]any `json:"resource,omitempty"`
+				}{
+					Time:       at,
+					Severity:   severity,
+					Body:       otlpValue(record.GetBody()),
+					Attributes: otlpAttributes(record.GetAttributes()),
+					TraceID:    hex.EncodeToString(record.GetTraceId()),
+					SpanID:     hex.EncodeToString(record.GetSpanId()),
+					Scope:      scopeLogs.GetScope().GetName(),
+					Resource:   resource,
+				})
+				if err != nil {
+					return err
+				}
+				if err := f.forward.Forward(string(line)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (f lineOTLPForwarder) Close() error {
+	return f.forward.Close()
+}
+
+// otlpAttributes returns attributes as a JSON object
+func otlpAttributes(attributes []*commonpb.KeyValue) map[string]any {
+	if len(attributes) == 0 {
+		return nil
+	}
+	m := make(map[string]any, len(attributes))
+	for _, kv := range attributes {
+		m[kv.GetKey()] = otlpValue(kv.GetValue())
+	}
+	return m
+}
+
+// otlpValue returns v as a JSON value
+func otlpValue(v *commonpb.AnyValue) any {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return value.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return value.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return value.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return value.BytesValue
+	case *commonpb.AnyValue_ArrayValue:
+		items := make([]any, 0, len(value.ArrayValue.GetValues()))
+		for _, item := range value.ArrayValue.GetValues() {
+			items = append(items, otlpValue(item))
+		}
+		return items
+	case *commonpb.AnyValue_KvlistValue:
+		return otlpAttributes(value.KvlistValue.GetValues())
+	}
+	return nil
+}
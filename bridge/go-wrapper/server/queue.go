@@ -0,0 +1,535 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub/v2"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+const (
+	// sqsVisibility is how long a message being redacted stays hidden from
+	// other consumers, renewed every sqsHeartbeat while the work lasts
+	sqsVisibility = 2 * time.Minute
+	sqsHeartbeat  = time.Minute
+	// sqsWait is how long a receive waits for messages
+	sqsWait = 20
+)
+
+// ObjectRef is an object a message says is ready: Input is its URI, and
+// Output, when the message gives one, where it is redacted to
+type ObjectRef struct {
+	Input  string `json:"input"`
+	Output string `json:"output,omitempty"`
+}
+
+// ParseObjectMessage returns the objects a "file ready" message refers
+// to. It takes S3 event notifications, whether sent to the queue directly,
+// through SNS or through EventBridge, Cloud Storage Pub/Sub notifications,
+// whose object is in attributes, and {"input": uri, "output": uri}
+// objects. Notifications of anything but newly written objects, such as
+// the test event S3 sends when notifications are set up, refer to none.
+func ParseObjectMessage(body []byte, attributes map[string]string) ([]ObjectRef, error) {
+	if eventType, ok := attributes["eventType"]; ok && attributes["bucketId"] != "" {
+		if eventType != "OBJECT_FINALIZE" {
+			return nil, nil
+		}
+		return []ObjectRef{{Input: "gs://" + attributes["bucketId"] + "/" + attributes["objectId"]}}, nil
+	}
+
+	var msg struct {
+		ObjectRef
+		// SNS wraps what it delivers
+		Type    string `json:"Type"`
+		Message string `json:"Message"`
+		// EventBridge
+		DetailType string `json:"detail-type"`
+		Detail     struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"detail"`
+		Event   string                 `json:"Event"`
+		Records []events.S3EventRecord `json:"Records"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %v", err)
+	}
+	switch {
+	case msg.Type == "Notification" && msg.Message != "":
+		return ParseObjectMessage([]byte(msg.Message), nil)
+	case msg.DetailType != "":
+		if msg.DetailType != "Object Created" {
+			return nil, nil
+		}
+		return []ObjectRef{{Input: "s3://" + msg.Detail.Bucket.Name + "/" + msg.Detail.Object.Key}}, nil
+	case msg.Event == "s3:TestEvent":
+		return nil, nil
+	case len(msg.Records) > 0:
+		var refs []ObjectRef
+		for _, uri := range s3EventObjects(msg.Records) {
+			refs = append(refs, ObjectRef{Input: uri})
+		}
+		return refs, nil
+	case msg.Input != "":
+		return []ObjectRef{msg.ObjectRef}, nil
+	}
+	return nil, errors.New("message refers to no object: expected an S3 or Cloud Storage notification, or {\"input\": uri}")
+}
+
+// s3EventObjects returns the URIs of the objects records notify were
+// created
+func s3EventObjects(records []events.S3EventRecord) []string {
+	var uris []string
+	for _, record := range records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+		// Keys arrive URL encoded, with spaces as +
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			key = record.S3.Object.Key
+		}
+		uris = append(uris, "s3://"+record.S3.Bucket.Name+"/"+key)
+	}
+	return uris
+}
+
+// outputUnder returns where input is redacted to under prefix, keeping its
+// key, and false when input is already under prefix, so that redacted
+// objects written to the bucket that notifies are not redacted again
+func outputUnder(prefix, input string) (string, bool) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	if strings.HasPrefix(input, prefix) {
+		return "", false
+	}
+	key := input
+	if _, rest, ok := strings.Cut(input, "://"); ok {
+		_, key, _ = strings.Cut(rest, "/")
+	}
+	return prefix + strings.TrimPrefix(key, "/"), true
+}
+
+// QueueCompletion is the message published once an object is redacted, or
+// could not be
+type QueueCompletion struct {
+	Input   string                 `json:"input"`
+	Output  string                 `json:"output"`
+	Success bool                   `json:"success"`
+	Error   string                 `json:"error,omitempty"`
+	Time    time.Time              `json:"time"`
+	Result  *logveil.ProcessResult `json:"result,omitempty"`
+}
+
+// QueueStats counts what a QueueWorker has handled
+type QueueStats struct {
+	Messages   int64          `json:"messages_received"`
+	Objects    int64          `json:"objects_redacted"`
+	Failed     int64          `json:"objects_failed"`
+	Skipped    int64          `json:"messages_skipped"`
+	Detections map[string]int `json:"detections,omitempty"`
+}
+
+// QueueWorker redacts the objects "file ready" messages from a queue refer
+// to, publishing a QueueCompletion for each. A message is acknowledged once
+// its objects are redacted; one whose objects could not all be is left to
+// the queue to deliver again, or to dead-letter, and messages that refer
+// to no object are acknowledged and skipped.
+type QueueWorker struct {
+	redactor *logveil.Redactor
+	source   QueueSource
+	// Output is the prefix objects are redacted under, keeping their keys,
+	// unless a message gives the output itself
+	Output string
+	// Completions, when set, receives a QueueCompletion for every object
+	Completions Publisher
+	// ErrorLog receives per-message and per-object errors; nil uses the
+	// standard logger
+	ErrorLog *log.Logger
+
+	mu    sync.Mutex
+	stats QueueStats
+}
+
+// NewQueueWorker returns a worker that redacts with redactor what source
+// delivers
+func NewQueueWorker(redactor *logveil.Redactor, source QueueSource) *QueueWorker {
+	return &QueueWorker{redactor: redactor, source: source}
+}
+
+// Stats returns a snapshot of the worker's counters
+func (w *QueueWorker) Stats() QueueStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.Detections = make(map[string]int, len(w.stats.Detections))
+	for rule, n := range w.stats.Detections {
+		stats.Detections[rule] = n
+	}
+	return stats
+}
+
+// Run handles messages, workers at a time, until ctx is cancelled
+func (w *QueueWorker) Run(ctx context.Context, workers int) error {
+	return w.source.Receive(ctx, max(workers, 1), w.handle)
+}
+
+// handle redacts the objects msg refers to, returning an error when the
+// message should be delivered again
+func (w *QueueWorker) handle(ctx context.Context, msg QueueMessage) error {
+	w.count(func(s *QueueStats) { s.Messages++ })
+	refs, err := ParseObjectMessage(msg.Body, msg.Attributes)
+	if err != nil {
+		w.count(func(s *QueueStats) { s.Skipped++ })
+		w.logf("skipping message %s: %v", msg.ID, err)
+		return nil
+	}
+	if len(refs) == 0 {
+		w.count(func(s *QueueStats) { s.Skipped++ })
+		return nil
+	}
+	var failed int
+	for _, ref := range refs {
+		if err := w.redact(ctx, ref); err != nil {
+			w.logf("message %s: redact %s: %v", msg.ID, ref.Input, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d objects failed", failed, len(refs))
+	}
+	return nil
+}
+
+// redact redacts the object ref refers to and publishes its completion
+func (w *QueueWorker) redact(ctx context.Context, ref ObjectRef) error {
+	output := ref.Output
+	if output == "" {
+		if w.Output == "" {
+			return errors.New("the message gives no output and no output prefix is set")
+		}
+		var ok bool
+		if output, ok = outputUnder(w.Output, ref.Input); !ok {
+			return nil
+		}
+	}
+	if !logveil.IsRemote(output) {
+		if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+			return err
+		}
+	}
+
+	result, err := w.redactor.ProcessFile(ctx, ref.Input, output)
+	completion := QueueCompletion{Input: ref.Input, Output: output, Success: err == nil, Time: time.Now().UTC(), Result: result}
+	if err != nil {
+		completion.Error = err.Error()
+		w.count(func(s *QueueStats) { s.Failed++ })
+	} else {
+		w.count(func(s *QueueStats) {
+			s.Objects++
+			for rule, n := range result.Detections {
+				if s.Detections == nil {
+					s.Detections = make(map[string]int)
+				}
+				s.Detections[rule] += n
+			}
+		})
+	}
+	if w.Completions != nil {
+		body, marshalErr := json.Marshal(completion)
+		if marshalErr == nil {
+			marshalErr = w.Completions.Publish(ctx, body)
+		}
+		if marshalErr != nil {
+			w.logf("publish completion of %s: %v", ref.Input, marshalErr)
+		}
+	}
+	return err
+}
+
+func (w *QueueWorker) count(update func(s *QueueStats)) {
+	w.mu.Lock()
+	update(&w.stats)
+	w.mu.Unlock()
+}
+
+func (w *QueueWorker) logf(format string, args ...any) {
+	if w.ErrorLog != nil {
+		w.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// QueueMessage is a message received from a queue
+type QueueMessage struct {
+	ID         string
+	Body       []byte
+	Attributes map[string]string
+}
+
+// QueueSource delivers messages. Receive calls handle for each, up to
+// workers at a time, acknowledging the message when handle returns nil,
+// until ctx is cancelled.
+type QueueSource interface {
+	Receive(ctx context.Context, workers int, handle func(context.Context, QueueMessage) error) error
+	Close() error
+}
+
+// DialQueueSource returns the source for target: sqs:<queue URL> polls an
+// SQS queue, and pubsub:projects/<project>/subscriptions/<subscription>
+// pulls from a Pub/Sub subscription. AWS credentials and region come from
+// the standard configuration chain, and Google Cloud ones from Application
+// Default Credentials.
+func DialQueueSource(ctx context.Context, target string) (QueueSource, error) {
+	if queueURL, ok := strings.CutPrefix(target, "sqs:"); ok {
+		if queueURL == "" {
+			return nil, fmt.Errorf("sqs target %q names no queue URL", target)
+		}
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &sqsSource{client: sqs.NewFromConfig(cfg), url: queueURL}, nil
+	}
+	if name, ok := strings.CutPrefix(target, "pubsub:"); ok {
+		project, err := pubsubProject(name, "subscriptions")
+		if err != nil {
+			return nil, err
+		}
+		client, err := pubsub.NewClient(ctx, project)
+		if err != nil {
+			return nil, err
+		}
+		return &pubsubSource{client: client, subscription: client.Subscriber(name)}, nil
+	}
+	return nil, fmt.Errorf("unsupported queue %q (expected sqs:<queue URL> or pubsub:projects/<project>/subscriptions/<subscription>)", target)
+}
+
+// pubsubProject returns the project of a full Pub/Sub resource name of
+// kind, such as projects/p/topics/t
+func pubsubProject(name, kind string) (string, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != kind || parts[3] == "" {
+		return "", fmt.Errorf("invalid Pub/Sub name %q (expected projects/<project>/%s/<name>)", name, kind)
+	}
+	return parts[1], nil
+}
+
+// sqsSource long-polls an SQS queue, deleting the messages handled and
+// keeping those being handled hidden for as long as they take
+type sqsSource struct {
+	client *sqs.Client
+	url    string
+}
+
+func (s *sqsSource) Receive(ctx context.Context, workers int, handle func(context.Context, QueueMessage) error) error {
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for ctx.Err() == nil {
+		// Ask for no more messages than there are workers free
+		slots <- struct{}{}
+		free := 1
+	fill:
+		for free < min(workers, 10) {
+			select {
+			case slots <- struct{}{}:
+				free++
+			default:
+				break fill
+			}
+		}
+		out, err := s.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(s.url),
+			MaxNumberOfMessages:   int32(free),
+			WaitTimeSeconds:       sqsWait,
+			VisibilityTimeout:     int32(sqsVisibility / time.Second),
+			MessageAttributeNames: []string{"All"},
+		})
+		var messages []sqstypes.Message
+		if out != nil {
+			messages = out.Messages
+		}
+		for range free - len(messages) {
+			<-slots
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("receive from %s: %v", s.url, err)
+		}
+		for _, m := range messages {
+			wg.Add(1)
+			go func(m sqstypes.Message) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				s.handleMessage(ctx, m, handle)
+			}(m)
+		}
+	}
+	return nil
+}
+
+// handleMessage handles m, renewing its visibility until done
+func (s *sqsSource) handleMessage(ctx context.Context, m sqstypes.Message, handle func(context.Context, QueueMessage) error) {
+	msg := QueueMessage{ID: aws.ToString(m.MessageId), Body: []byte(aws.ToString(m.Body)), Attributes: make(map[string]string)}
+	for name, value := range m.MessageAttributes {
+		msg.Attributes[name] = aws.ToString(value.StringValue)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(sqsHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.client.ChangeMessageVisibility(context.WithoutCancel(ctx), &sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          aws.String(s.url),
+					ReceiptHandle:     m.ReceiptHandle,
+					VisibilityTimeout: int32(sqsVisibility / time.Second),
+				})
+			}
+		}
+	}()
+	err := handle(ctx, msg)
+	close(done)
+	if err != nil {
+		// Left hidden until its visibility runs out, then delivered again
+		return
+	}
+	s.client.DeleteMessage(context.WithoutCancel(ctx), &sqs.DeleteMessageInput{QueueUrl: aws.String(s.url), ReceiptHandle: m.ReceiptHandle})
+}
+
+func (s *sqsSource) Close() error {
+	return nil
+}
+
+// pubsubSource pulls from a Pub/Sub subscription; the client extends the
+// ack deadline of messages being handled
+type pubsubSource struct {
+	client       *pubsub.Client
+	subscription *pubsub.Subscriber
+}
+
+func (s *pubsubSource) Receive(ctx context.Context, workers int, handle func(context.Context, QueueMessage) error) error {
+	s.subscription.ReceiveSettings.MaxOutstandingMessages = workers
+	err := s.subscription.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		if err := handle(ctx, QueueMessage{ID: m.ID, Body: m.Data, Attributes: m.Attributes}); err != nil {
+			m.Nack()
+			return
+		}
+		m.Ack()
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (s *pubsubSource) Close() error {
+	return s.client.Close()
+}
+
+// Publisher publishes messages. Publish must be safe for concurrent use.
+type Publisher interface {
+	Publish(ctx context.Context, body []byte) error
+	Close() error
+}
+
+// DialPublisher returns the publisher for target: sqs:<queue URL> sends
+// to an SQS queue, pubsub:projects/<project>/topics/<topic> publishes to a
+// Pub/Sub topic, and any other target is one DialForwarder accepts, sent
+// each message as a line
+func DialPublisher(ctx context.Context, target string) (Publisher, error) {
+	if queueURL, ok := strings.CutPrefix(target, "sqs:"); ok {
+		if queueURL == "" {
+			return nil, fmt.Errorf("sqs target %q names no queue URL", target)
+		}
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &sqsPublisher{client: sqs.NewFromConfig(cfg), url: queueURL}, nil
+	}
+	if name, ok := strings.CutPrefix(target, "pubsub:"); ok {
+		project, err := pubsubProject(name, "topics")
+		if err != nil {
+			return nil, err
+		}
+		client, err := pubsub.NewClient(ctx, project)
+		if err != nil {
+			return nil, err
+		}
+		return &pubsubPublisher{client: client, topic: client.Publisher(name)}, nil
+	}
+	forward, err := DialForwarder(target)
+	if err != nil {
+		return nil, err
+	}
+	return linePublisher{forward}, nil
+}
+
+type sqsPublisher struct {
+	client *sqs.Client
+	url    string
+}
+
+func (p *sqsPublisher) Publish(ctx context.Context, body []byte) error {
+	_, err := p.client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: aws.String(p.url), MessageBody: aws.String(string(body))})
+	return err
+}
+
+func (p *sqsPublisher) Close() error {
+	return nil
+}
+
+type pubsubPublisher struct {
+	client *pubsub.Client
+	topic  *pubsub.Publisher
+}
+
+func (p *pubsubPublisher) Publish(ctx context.Context, body []byte) error {
+	_, err := p.topic.Publish(ctx, &pubsub.Message{Data: body}).Get(ctx)
+	return err
+}
+
+func (p *pubsubPublisher) Close() error {
+	p.topic.Stop()
+	return p.client.Close()
+}
+
+type linePublisher struct {
+	forward Forwarder
+}
+
+func (p linePublisher) Publish(_ context.Context, body []byte) error {
+	return p.forward.Forward(string(body))
+}
+
+func (p linePublisher) Close() error {
+	return p.forward.Close()
+}
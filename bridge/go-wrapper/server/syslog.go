@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// maxSyslogMessage caps a single message. UDP datagrams cannot exceed it
+// and octet-counted TCP frames claiming more are rejected.
+const maxSyslogMessage = 64 * 1024
+
+// SyslogStats counts what a SyslogRelay has handled
+type SyslogStats struct {
+	Received   int64          `json:"messages_received"`
+	Forwarded  int64          `json:"messages_forwarded"`
+	Dropped    int64          `json:"messages_dropped"`
+	Detections map[string]int `json:"detections,omitempty"`
+}
+
+// SyslogRelay receives syslog messages over UDP or TCP, redacts them and
+// forwards the result. A message that cannot be redacted is dropped rather
+// than forwarded as received.
+type SyslogRelay struct {
+	redactor *logveil.Redactor
+	forward  Forwarder
+	// ErrorLog receives per-message and per-connection errors; nil uses the
+	// standard logger
+	ErrorLog *log.Logger
+	// Metrics, when set, counts every message redacted
+	Metrics *Metrics
+
+	mu    sync.Mutex
+	stats SyslogStats
+}
+
+// NewSyslogRelay returns a relay that redacts with redactor and sends to
+// forward
+func NewSyslogRelay(redactor *logveil.Redactor, forward Forwarder) *SyslogRelay {
+	return &SyslogRelay{redactor: redactor, forward: forward}
+}
+
+// Stats returns a snapshot of the relay's counters
+func (r *SyslogRelay) Stats() SyslogStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	stats.Detections = make(map[string]int, len(r.stats.Detections))
+	for rule, n := range r.stats.Detections {
+		stats.Detections[rule] = n
+	}
+	return stats
+}
+
+// ServeUDP handles one message per datagram on conn until ctx is cancelled
+func (r *SyslogRelay) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		r.handle(ctx, string(buf[:n]))
+	}
+}
+
+// ServeTCP accepts connections on listener until ctx is cancelled. Both
+// RFC 6587 framings are accepted: octet counting and newline-delimited.
+func (r *SyslogRelay) ServeTCP(ctx context.Context, listener net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (r *SyslogRelay) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	reader := bufio.NewReader(conn)
+	for {
+		msg, err := readSyslogFrame(reader)
+		if msg != "" {
+			r.handle(ctx, msg)
+		}
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				r.logf("syslog connection from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// readSyslogFrame reads one message, choosing the framing by its first
+// byte: a digit starts an octet count, anything else runs to a newline
+func readSyslogFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		line, err := reader.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	count, err := reader.ReadString(' ')
+	if err != nil {
+		return "", fmt.Errorf("incomplete octet count: %v", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(count, " "))
+	if err != nil || n <= 0 || n > maxSyslogMessage {
+		return "", fmt.Errorf("invalid octet count %q", strings.TrimSpace(count))
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(reader, msg); err != nil {
+		return "", fmt.Errorf("truncated message: %v", err)
+	}
+	return strings.TrimRight(string(msg), "\r\n"), nil
+}
+
+// handle redacts and forwards one message
+func (r *SyslogRelay) handle(ctx context.Context, msg string) {
+	msg = strings.TrimRight(msg, "\r\n\x00")
+	if msg == "" {
+		return
+	}
+	r.count(func(s *SyslogStats) { s.Received++ })
+
+	redacted, detections, err := redactLine(ctx, r.redactor, r.Metrics, "logveil.syslog/message", msg)
+	if err != nil {
+		r.count(func(s *SyslogStats) { s.Dropped++ })
+		r.logf("dropping syslog message: %v", err)
+		return
+	}
+	if err := r.forward.Forward(redacted); err != nil {
+		r.count(func(s *SyslogStats) { s.Dropped++ })
+		r.logf("dropping syslog message: forward: %v", err)
+		return
+	}
+	r.count(func(s *SyslogStats) {
+		s.Forwarded++
+		for _, d := range detections {
+			if s.Detections == nil {
+				s.Detections = make(map[string]int)
+			}
+			s.Detections[d.Rule]++
+		}
+	})
+}
+
+func (r *SyslogRelay) count(update func(s *SyslogStats)) {
+	r.mu.Lock()
+	update(&r.stats)
+	r.mu.Unlock()
+}
+
+func (r *SyslogRelay) logf(format string, args ...any) {
+	if r.ErrorLog != nil {
+		r.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// Forwarder sends redacted messages downstream. Forward must be safe for
+// concurrent use.
+type Forwarder interface {
+	Forward(msg string) error
+	Close() error
+}
+
+// DialForwarder returns a Forwarder for target: udp://host:port sends one
+// datagram per message, tcp://host:port sends newline-delimited messages
+// and reconnects after errors, and anything else is a file path ("-" for
+// stdout) that messages are appended to.
+func DialForwarder(target string) (Forwarder, error) {
+	switch {
+	case strings.HasPrefix(target, "udp://"):
+		conn, err := net.Dial("udp", strings.TrimPrefix(target, "udp://"))
+		if err != nil {
+			return nil, err
+		}
+		return &udpForwarder{conn: conn}, nil
+	case strings.HasPrefix(target, "tcp://"):
+		f := &tcpForwarder{addr: strings.TrimPrefix(target, "tcp://")}
+		if err := f.connect(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case strings.Contains(target, "://"):
+		return nil, fmt.Errorf("unsupported forward target %q (expected udp://, tcp:// or a file)", target)
+	default:
+		return newWriterForwarder(target)
+	}
+}
+
+type udpForwarder struct {
+	conn net.Conn
+}
+
+func (f *udpForwarder) Forward(msg string) error {
+	_, err := f.conn.Write([]byte(msg))
+	return err
+}
+
+func (f *udpForwarder) Close() error {
+	return f.conn.Close()
+}
+
+type tcpForwarder struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (f *tcpForwarder) connect() error {
+	conn, err := net.Dial("tcp", f.addr)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+// Forward writes msg, reconnecting once if the connection was lost
+func (f *tcpForwarder) Forward(msg string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	frame := []byte(msg + "\n")
+	if f.conn != nil {
+		if _, err := f.conn.Write(frame); err == nil {
+			return nil
+		}
+		f.conn.Close()
+		f.conn = nil
+	}
+	if err := f.connect(); err != nil {
+		return err
+	}
+	_, err := f.conn.Write(frame)
+	return err
+}
+
+func (f *tcpForwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+type writerForwarder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func newWriterForwarder(path string) (*writerForwarder, error) {
+	if path == "-" {
+		return &writerForwarder{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &writerForwarder{w: f, closer: f}, nil
+}
+
+func (f *writerForwarder) Forward(msg string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := io.WriteString(f.w, msg+"\n")
+	return err
+}
+
+func (f *writerForwarder) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// Tenant is a namespace of serve mode, so that one deployment can serve
+// several teams: the clients in it redact with its own redactor, and so
+// its own rules and token store, and share its limits
+type Tenant struct {
+	Name string
+	// Clients are the client IDs in the tenant, as Auth identifies them
+	Clients []string
+	// Redactor redacts for the tenant's clients
+	Redactor *logveil.Redactor
+	// Limits cap the tenant's clients together, on top of the limits each
+	// is held to alone; zero fields are unlimited
+	Limits ClientLimits
+}
+
+// Tenants finds the tenant of each client. Clients in no tenant redact
+// with the server's own redactor. A nil *Tenants puts every client in
+// none.
+type Tenants struct {
+	byClient map[string]*Tenant
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewTenants returns the tenants in tenants, in which no client may appear
+// twice
+func NewTenants(tenants []Tenant) (*Tenants, error) {
+	t := &Tenants{byClient: make(map[string]*Tenant), limiters: make(map[string]*rate.Limiter)}
+	names := make(map[string]bool, len(tenants))
+	for i := range tenants {
+		tenant := &tenants[i]
+		switch {
+		case tenant.Name == "":
+			return nil, fmt.Errorf("tenant %d has no name", i+1)
+		case names[tenant.Name]:
+			return nil, fmt.Errorf("tenant %s: defined twice", tenant.Name)
+		case tenant.Redactor == nil:
+			return nil, fmt.Errorf("tenant %s: no redactor", tenant.Name)
+		case len(tenant.Clients) == 0:
+			return nil, fmt.Errorf("tenant %s: no clients", tenant.Name)
+		}
+		names[tenant.Name] = true
+		for _, client := range tenant.Clients {
+			if other, ok := t.byClient[client]; ok {
+				return nil, fmt.Errorf("tenant %s: client %s is already in tenant %s", tenant.Name, client, other.Name)
+			}
+			t.byClient[client] = tenant
+		}
+	}
+	return t, nil
+}
+
+// Lookup returns the tenant of client, or nil when it is in none
+func (t *Tenants) Lookup(client string) *Tenant {
+	if t == nil {
+		return nil
+	}
+	return t.byClient[client]
+}
+
+// redactor returns the redactor for client, fallback when it is in no
+// tenant, and ctx attributing what is redacted under it to the client's
+// tenant
+func (t *Tenants) redactor(ctx context.Context, client string, fallback *logveil.Redactor) (context.Context, *logveil.Redactor) {
+	tenant := t.Lookup(client)
+	if tenant == nil {
+		return ctx, fallback
+	}
+	return logveil.WithTenant(ctx, tenant.Name), tenant.Redactor
+}
+
+// limiter returns the rate limiter the clients of tenant share, or nil
+// when its rate is unlimited
+func (t *Tenants) limiter(tenant *Tenant) *rate.Limiter {
+	if tenant == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if limiter, ok := t.limiters[tenant.Name]; ok {
+		return limiter
+	}
+	limiter := newRateLimiter(tenant.Limits)
+	t.limiters[tenant.Name] = limiter
+	return limiter
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// tracer starts the spans of the network services. It does nothing until
+// the program installs an OpenTelemetry tracer provider.
+var tracer = otel.Tracer("github.com/logveil/logveil/bridge/go-wrapper/server")
+
+// redactLine redacts line with redactor in a span named name, and records
+// it in m
+func redactLine(ctx context.Context, redactor *logveil.Redactor, m *Metrics, name, line string, attrs ...attribute.KeyValue) (string, []logveil.Detection, error) {
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	defer span.End()
+
+	start := time.Now()
+	redacted, detections, err := redactor.RedactLine(ctx, line)
+	m.ObserveLine(len(line), detections, time.Since(start), err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return redacted, detections, err
+	}
+	span.SetAttributes(attribute.Int("logveil.bytes_read", len(line)), attribute.Int("logveil.detections", len(detections)))
+	return redacted, detections, nil
+}
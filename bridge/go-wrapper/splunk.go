@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// splunkInputs are the inputs whose events splunk.inputs can map
+var splunkInputs = map[string]bool{"listen": true, "fluent": true, "loki": true, "otlp": true, "cloudwatch": true, "lambda": true, "k8s": true}
+
+// hecForwarder returns the forwarder to the Splunk HTTP Event Collector
+// target names for the input command, set up from the splunk settings and
+// the command's entry in splunk.inputs
+func hecForwarder(opts *settings, command, target string) (server.Forwarder, error) {
+	for input := range opts.Splunk.Inputs {
+		if !splunkInputs[input] {
+			return nil, fmt.Errorf("splunk input %q: unknown (expected listen, fluent, loki, otlp, cloudwatch, lambda or k8s)", input)
+		}
+	}
+	token, err := readSecret(opts.Splunk.TokenFile, "LOGVEIL_SPLUNK_TOKEN", "--splunk-token-file")
+	if err != nil {
+		return nil, fmt.Errorf("splunk token: %v", err)
+	}
+	interval, err := time.ParseDuration(opts.Splunk.FlushInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --splunk-flush-interval: %v", err)
+	}
+	hec := server.HECOptions{
+		Token:         string(token),
+		Index:         opts.Splunk.Index,
+		Sourcetype:    opts.Splunk.Sourcetype,
+		BatchSize:     opts.Splunk.BatchSize,
+		FlushInterval: interval,
+		Retries:       opts.Splunk.Retries,
+	}
+	if input, ok := opts.Splunk.Inputs[command]; ok {
+		if input.Index != "" {
+			hec.Index = input.Index
+		}
+		if input.Sourcetype != "" {
+			hec.Sourcetype = input.Sourcetype
+		}
+		hec.Source, hec.Host = input.Source, input.Host
+	}
+	forward, err := server.NewHECForwarder(target, hec)
+	if err != nil {
+		return nil, err
+	}
+	forward.ErrorLog = errorLog()
+	return forward, nil
+}
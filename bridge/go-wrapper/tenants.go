@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+	"github.com/logveil/logveil/bridge/go-wrapper/server"
+)
+
+// servedTenant is a serve mode tenant and the redactor built for it
+type servedTenant struct {
+	name      string
+	redactor  *logveil.Redactor
+	tokenizer *logveil.Tokenizer
+}
+
+// tenantOptions returns opts with the settings of the tenant name in place
+// of the server-wide ones
+func tenantOptions(opts *settings, name string) (*settings, error) {
+	t, ok := opts.Server.Tenants[name]
+	if !ok {
+		return nil, fmt.Errorf("tenant %s: no longer configured", name)
+	}
+	// Tokens of one tenant must not be resolvable from another's store
+	if t.TokenStore == "" && (opts.TokenStore != "" || opts.SealMap != "") {
+		return nil, fmt.Errorf("tenant %s: needs a token_store of its own, as tenants do not share --token-store or --seal-map", name)
+	}
+	if t.AuditLog == "" && opts.Audit.Log != "" {
+		return nil, fmt.Errorf("tenant %s: needs an audit_log of its own, as tenants do not share --audit-log", name)
+	}
+	own := *opts
+	own.SealMap = ""
+	own.TokenStore = t.TokenStore
+	own.Audit.Log = t.AuditLog
+	if len(t.Rules) > 0 {
+		own.Rules = t.Rules
+	}
+	if t.RulesFile != "" {
+		own.RulesFile = t.RulesFile
+	}
+	if len(t.Policy) > 0 {
+		own.Policy.Paths = t.Policy
+	}
+	return &own, nil
+}
+
+// buildTenants builds a redactor for each tenant in opts. The returned
+// func closes them and saves their token stores.
+func buildTenants(opts *settings) ([]servedTenant, func(), error) {
+	names := make([]string, 0, len(opts.Server.Tenants))
+	for name := range opts.Server.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tenants []servedTenant
+	var stores []*logveil.TokenStore
+	shutdown := func() {
+		for _, t := range tenants {
+			t.redactor.Close()
+		}
+		for _, store := range stores {
+			if err := store.Close(); err != nil {
+				slog.Error("Failed to save token store", "error", err)
+			}
+		}
+	}
+	for _, name := range names {
+		own, err := tenantOptions(opts, name)
+		if err != nil {
+			shutdown()
+			return nil, nil, err
+		}
+		var tokenizer *logveil.Tokenizer
+		switch {
+		case own.TokenStore != "":
+			store, err := logveil.OpenTokenStore(own.TokenStore)
+			if err != nil {
+				shutdown()
+				return nil, nil, fmt.Errorf("tenant %s: %v", name, err)
+			}
+			stores = append(stores, store)
+			tokenizer = store.Tokenizer()
+		case own.Tokenize:
+			if tokenizer, err = logveil.NewTokenizer([]byte(own.TokenizeKey)); err != nil {
+				shutdown()
+				return nil, nil, fmt.Errorf("tenant %s: %v", name, err)
+			}
+		}
+		redactor, err := newRedactor(own, tokenizer)
+		if err != nil {
+			shutdown()
+			return nil, nil, fmt.Errorf("tenant %s: %v", name, err)
+		}
+		tenants = append(tenants, servedTenant{name: name, redactor: redactor, tokenizer: tokenizer})
+		slog.Debug("Tenant ready", "tenant", name, "clients", len(opts.Server.Tenants[name].Clients))
+	}
+	return tenants, shutdown, nil
+}
+
+// serverTenants returns the tenants served for their clients, with their
+// limits
+func serverTenants(opts *settings, served []servedTenant) (*server.Tenants, error) {
+	if len(served) == 0 {
+		return nil, nil
+	}
+	var tenants []server.Tenant
+	for _, t := range served {
+		config := opts.Server.Tenants[t.name]
+		limits := server.ClientLimits{Rate: config.Limits.Rate, Burst: config.Limits.Burst, MaxJobs: config.Limits.MaxJobs}
+		if config.Limits.MaxSize != "" {
+			size, err := parseSize(config.Limits.MaxSize)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %s: invalid max size: %v", t.name, err)
+			}
+			limits.MaxBytes = size
+		}
+		if limits.Rate < 0 || limits.Burst < 0 || limits.MaxJobs < 0 {
+			return nil, fmt.Errorf("tenant %s: limits cannot be negative", t.name)
+		}
+		tenants = append(tenants, server.Tenant{Name: t.name, Clients: config.Clients, Redactor: t.redactor, Limits: limits})
+	}
+	return server.NewTenants(tenants)
+}
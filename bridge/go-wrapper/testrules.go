@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// runTestRules implements `test-rules`, which checks rule files against
+// fixture cases, each an input line and the output it must redact to
+func runTestRules(args []string) {
+	fs := flag.NewFlagSet("test-rules", flag.ExitOnError)
+	rulesFiles := fs.String("rules-file", "", "comma-separated custom rules files to test, applied in order")
+	rules := fs.String("rules", "", "comma-separated built-in detectors or rule packs the custom rules run after (default: the default pack)")
+	format := fs.String("format", "", "line format the inputs are in, such as json (default: text)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage(fmt.Sprintf("Usage: %s test-rules [--rules-file <files>] [--rules <names>] [--format <format>] <fixtures_dir|file>", os.Args[0]))
+	}
+
+	var customRules []logveil.Rule
+	for _, path := range splitList(*rulesFiles) {
+		loaded, err := logveil.LoadRules(path)
+		if err != nil {
+			fatal("Invalid rules file", "error", err)
+		}
+		customRules = append(customRules, loaded...)
+	}
+	redactor, err := logveil.NewRedactor(logveil.Config{
+		Engine: "native",
+		Format: *format,
+		Native: logveil.NativeOptions{
+			Rules:       splitList(*rules),
+			CustomRules: customRules,
+		},
+	})
+	if err != nil {
+		fatal("Invalid engine", "error", err)
+	}
+
+	cases, err := logveil.LoadRuleCases(fs.Arg(0))
+	if err != nil {
+		fatal("Invalid test cases", "error", err)
+	}
+	report := redactor.TestRules(context.Background(), cases)
+	for _, result := range report.Results {
+		if !result.Passed {
+			slog.Error("Rule test failed", "file", result.File, "case", result.Name, "expected", result.Expected, "got", result.Got, "error", result.Error)
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		fatal("Failed to write result", "error", err)
+	}
+	fmt.Println(string(data))
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// runTokens implements `tokens compact|rotate`, which maintain a token store
+// between runs
+func runTokens(args []string) {
+	text := fmt.Sprintf("Usage: %s tokens compact [--max-age 720h] <store>\n       %s tokens rotate <store>", os.Args[0], os.Args[0])
+	if len(args) < 1 {
+		usage(text)
+	}
+
+	fs := flag.NewFlagSet("tokens "+args[0], flag.ExitOnError)
+	var maxAge time.Duration
+	if args[0] == "compact" {
+		fs.DurationVar(&maxAge, "max-age", 0, "drop pseudonyms not seen for this long; 0 keeps all of them")
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		usage(text)
+	}
+	path := fs.Arg(0)
+
+	var stats *logveil.TokenStoreStats
+	var err error
+	switch args[0] {
+	case "compact":
+		if maxAge < 0 {
+			fatal("Invalid max age: must not be negative", "max_age", maxAge.String())
+		}
+		stats, err = logveil.CompactTokenStore(path, maxAge)
+	case "rotate":
+		stats, err = logveil.RotateTokenStore(path)
+	default:
+		usage(text)
+	}
+	if err != nil {
+		fatal("Token store "+args[0]+" failed", "store", path, "error", err)
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		fatal("Failed to write result", "error", err)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// telemetryShutdownTimeout bounds flushing spans and metrics on exit
+const telemetryShutdownTimeout = 5 * time.Second
+
+// startTelemetry exports spans and metrics over OTLP/gRPC to endpoint, or
+// to wherever the standard OTEL_EXPORTER_OTLP_* variables point when it is
+// empty. With neither set nothing is exported. The returned context
+// continues the trace in TRACEPARENT, so a pipeline that starts logveil-go
+// sees its spans under its own; the returned func flushes the exporters.
+func startTelemetry(endpoint string) (context.Context, func()) {
+	propagator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	otel.SetTextMapPropagator(propagator)
+	ctx := propagator.Extract(context.Background(), propagation.MapCarrier{
+		"traceparent": os.Getenv("TRACEPARENT"),
+		"tracestate":  os.Getenv("TRACESTATE"),
+		"baggage":     os.Getenv("BAGGAGE"),
+	})
+
+	if endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" &&
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" &&
+		os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT") == "" {
+		return ctx, func() {}
+	}
+
+	var traceOpts []otlptracegrpc.Option
+	var metricOpts []otlpmetricgrpc.Option
+	if endpoint != "" {
+		traceOpts = append(traceOpts, otlptracegrpc.WithEndpointURL(endpoint))
+		metricOpts = append(metricOpts, otlpmetricgrpc.WithEndpointURL(endpoint))
+	}
+	traces, err := otlptracegrpc.New(ctx, traceOpts...)
+	if err != nil {
+		fatal("Telemetry unavailable", "error", err)
+	}
+	metrics, err := otlpmetricgrpc.New(ctx, metricOpts...)
+	if err != nil {
+		fatal("Telemetry unavailable", "error", err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName("logveil-go")),
+		resource.Environment(),
+	)
+	if err != nil {
+		res = resource.Default()
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traces), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metrics)), sdkmetric.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Error("Telemetry export failed", "error", err)
+	}))
+
+	return ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+		defer cancel()
+		if err := tracerProvider.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to flush spans", "error", err)
+		}
+		if err := meterProvider.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to flush metrics", "error", err)
+		}
+	}
+}
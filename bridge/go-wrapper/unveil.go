@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// runUnveil implements `unveil`, which restores the originals behind the
+// pseudonyms in a redacted file from its sealed mapping file
+func runUnveil(args []string) {
+	fs := flag.NewFlagSet("unveil", flag.ExitOnError)
+	mapPath := fs.String("map", "", "sealed mapping file written by --seal-map")
+	keyFile := fs.String("key-file", "", "file holding the seal key (default $LOGVEIL_SEAL_KEY)")
+	fs.Parse(args)
+	if *mapPath == "" || fs.NArg() < 1 || fs.NArg() > 2 {
+		usage(fmt.Sprintf("Usage: %s unveil --map <file> [--key-file <file>] <input_file|-> [output_file|-]", os.Args[0]))
+	}
+
+	key, err := readSealKey(*keyFile)
+	if err != nil {
+		fatal("Invalid seal key", "error", err)
+	}
+	values, err := logveil.LoadSealedMap(*mapPath, key)
+	if err != nil {
+		fatal("Invalid seal map", "error", err)
+	}
+
+	var in io.Reader = os.Stdin
+	if input := fs.Arg(0); input != stdioPath {
+		f, err := os.Open(input)
+		if err != nil {
+			fatal("Failed to open input", "error", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var out io.Writer = os.Stdout
+	resultOut := os.Stderr
+	if output := fs.Arg(1); output != "" && output != stdioPath {
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			fatal("Failed to create output", "error", err)
+		}
+		defer f.Close()
+		out = f
+		resultOut = os.Stdout
+	}
+
+	result, err := values.UnveilStream(in, out)
+	if err != nil {
+		fatal("Unveil failed", "error", err)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		fatal("Failed to write result", "error", err)
+	}
+	fmt.Fprintln(resultOut, string(data))
+}
+
+// readSealKey returns the seal key from path, or from $LOGVEIL_SEAL_KEY
+// when path is empty
+func readSealKey(path string) ([]byte, error) {
+	return readSecret(path, "LOGVEIL_SEAL_KEY", "--seal-key-file")
+}
+
+// readSecret returns the secret in the file at path, or in the environment
+// variable env when path is empty; flag names the option setting path.
+// Secrets are never taken from flags so they stay out of process listings
+// and shell history.
+func readSecret(path, env, flag string) ([]byte, error) {
+	if path == "" {
+		secret := os.Getenv(env)
+		if secret == "" {
+			return nil, fmt.Errorf("set %s or %s", flag, env)
+		}
+		return []byte(secret), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return []byte(secret), nil
+}
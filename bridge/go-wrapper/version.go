@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/logveil/logveil/bridge/go-wrapper/logveil"
+)
+
+// Set at build time, as `make build-go` does, with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...";
+// unset, they are taken from the module and VCS details Go embeds
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// versionInfo is what `version` reports
+type versionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	// Modified reports uncommitted changes in the tree that was built
+	Modified  bool   `json:"modified,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+	// Engines are those built in, with whether each can run here
+	Engines []engineInfo `json:"engines"`
+	// Engine is the one the configuration redacts with, after any fallback
+	Engine string `json:"engine,omitempty"`
+	// Ruleset fingerprints the rules and settings of the configuration,
+	// as audit log entries record it
+	Ruleset     string                      `json:"ruleset,omitempty"`
+	RulesFile   *rulesFileInfo              `json:"rules_file,omitempty"`
+	RulesBundle *logveil.RulesBundleVersion `json:"rules_bundle,omitempty"`
+	// Error is why the configuration could not be described in full
+	Error string `json:"error,omitempty"`
+}
+
+// engineInfo is an engine `version` reports
+type engineInfo struct {
+	Name      string               `json:"name"`
+	Available bool                 `json:"available"`
+	Python    *logveil.PythonAgent `json:"python,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+// rulesFileInfo identifies the custom rules file of the configuration
+type rulesFileInfo struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// runVersion implements `version`, which reports the build and the
+// engines, rules and rules bundle the configuration loads, so that audit
+// records can be traced to the rule set that redacted an artifact. It exits
+// 1 when the configuration cannot be loaded.
+func runVersion(args []string) {
+	opts := defaultSettings()
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	cli := registerFlags(fs, &opts)
+	asJSON := fs.Bool("json", false, "print the report as JSON rather than text")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s version [--json] [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	cli.parse(args)
+
+	info := buildVersion()
+	err := opts.load(*cli.configPath)
+	if err == nil {
+		// Explicit flags win over the config file and environment
+		err = cli.parse(args)
+	}
+	if err == nil {
+		err = describeConfig(&info, &opts)
+	}
+	if err != nil {
+		info.Error = err.Error()
+	}
+
+	if *asJSON {
+		data, err := json.Marshal(info)
+		if err != nil {
+			fatal("Failed to write result", "error", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		writeVersionText(os.Stdout, info)
+	}
+	if info.Error != "" {
+		os.Exit(1)
+	}
+}
+
+// buildVersion describes the running binary
+func buildVersion() versionInfo {
+	info := versionInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version
+		}
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// describeConfig fills in the engines, rules and rules bundle opts loads
+func describeConfig(info *versionInfo, opts *settings) error {
+	info.Engines = append(info.Engines, engineInfo{Name: "native", Available: true})
+	python := engineInfo{Name: "python", Available: true}
+	agent, err := logveil.LocatePythonAgent(logveil.PythonOptions{Interpreter: opts.Python, Agent: opts.Agent, AgentSHA256: opts.AgentSHA256})
+	if agent.Agent != "" {
+		python.Python = &agent
+	}
+	if err != nil {
+		python.Available, python.Error = false, err.Error()
+	}
+	info.Engines = append(info.Engines, python)
+
+	// Built as a run would, short of opening the audit log
+	own := *opts
+	own.Audit.Log = ""
+	redactor, err := newRedactor(&own, nil)
+	if err != nil {
+		return err
+	}
+	defer redactor.Close()
+	info.Engine = redactor.Engine().Name()
+	info.Ruleset = redactor.Ruleset()
+
+	if opts.RulesFile != "" {
+		digest, err := sha256File(opts.RulesFile)
+		if err != nil {
+			return err
+		}
+		info.RulesFile = &rulesFileInfo{Path: opts.RulesFile, SHA256: digest}
+	}
+	if opts.RulesBundle.URL != "" {
+		bundle, err := rulesBundle(opts)
+		if err != nil {
+			return err
+		}
+		cached, err := bundle.Version()
+		if err != nil {
+			return fmt.Errorf("rules bundle: %v", err)
+		}
+		info.RulesBundle = &cached
+	}
+	return nil
+}
+
+// sha256File returns the hex SHA-256 of the file at path
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeVersionText writes info for people to read
+func writeVersionText(w io.Writer, info versionInfo) {
+	fmt.Fprintf(w, "logveil-go %s\n", info.Version)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if info.Commit != "" {
+		modified := ""
+		if info.Modified {
+			modified = " (modified)"
+		}
+		fmt.Fprintf(tw, "  commit\t%s%s\n", info.Commit, modified)
+	}
+	if info.BuildDate != "" {
+		fmt.Fprintf(tw, "  built\t%s\n", info.BuildDate)
+	}
+	fmt.Fprintf(tw, "  go\t%s %s\n", info.GoVersion, info.Platform)
+	for _, engine := range info.Engines {
+		details := []string{"available"}
+		switch {
+		case !engine.Available:
+			details = []string{"unavailable: " + engine.Error}
+		case engine.Python != nil:
+			details = append(details, "interpreter "+engine.Python.Interpreter, "agent "+engine.Python.Agent, "sha256 "+engine.Python.SHA256)
+		}
+		fmt.Fprintf(tw, "  engine %s\t%s\n", engine.Name, strings.Join(details, ", "))
+	}
+	if info.Engine != "" {
+		fmt.Fprintf(tw, "  redacts with\t%s\n", info.Engine)
+	}
+	if info.Ruleset != "" {
+		fmt.Fprintf(tw, "  ruleset\t%s\n", info.Ruleset)
+	}
+	if info.RulesFile != nil {
+		fmt.Fprintf(tw, "  rules file\t%s, sha256 %s\n", info.RulesFile.Path, info.RulesFile.SHA256)
+	}
+	if b := info.RulesBundle; b != nil {
+		etag := ""
+		if b.ETag != "" {
+			etag = ", etag " + b.ETag
+		}
+		fmt.Fprintf(tw, "  rules bundle\t%s%s, sha256 %s, fetched %s\n", b.URL, etag, b.SHA256, b.Fetched.Format(time.RFC3339))
+	}
+	if info.Error != "" {
+		fmt.Fprintf(tw, "  error\t%s\n", info.Error)
+	}
+	tw.Flush()
+}
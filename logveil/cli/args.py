@@ -156,6 +156,12 @@ Examples:
         help="Host for API server (default: 127.0.0.1)"
     )
 
+    parser.add_argument(
+        "--worker",
+        action="store_true",
+        help="Serve line redaction requests as JSON over stdin/stdout (used by the Go bridge)"
+    )
+
     # Processing Options
     parser.add_argument(
         "--progress",
@@ -257,8 +263,8 @@ Examples:
     args = parser.parse_args()
 
     # Validation
-    if not args.serve and not args.list_profiles and not args.list_engines and not args.benchmark and not args.input:
-        parser.error("Input file/directory is required unless using --serve, --list-profiles, --list-engines, or --benchmark")
+    if not args.serve and not args.worker and not args.list_profiles and not args.list_engines and not args.benchmark and not args.input:
+        parser.error("Input file/directory is required unless using --serve, --worker, --list-profiles, --list-engines, or --benchmark")
 
     if args.inplace and args.output:
         parser.error("Cannot use --inplace and --output together")
@@ -266,6 +272,9 @@ Examples:
     if args.serve and args.input:
         parser.error("Cannot specify input when using --serve mode")
 
+    if args.worker and args.input:
+        parser.error("Cannot specify input when using --worker mode")
+
     if args.dry_run and args.inplace:
         parser.error("Cannot use --dry-run with --inplace")
 
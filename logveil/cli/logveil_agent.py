@@ -28,7 +28,8 @@ class LogVeilAgent:
     
     def __init__(self, args):
         self.args = args
-        self.console = Console(color_system=None if args.no_color else "auto")
+        # Worker mode owns stdout for the protocol, so diagnostics go to stderr
+        self.console = Console(color_system=None if args.no_color else "auto", stderr=args.worker)
         
         # Initialize core components
         self.dispatcher = EngineDispatcher()
@@ -91,6 +92,9 @@ class LogVeilAgent:
             if self.args.serve:
                 return self._start_server()
             
+            if self.args.worker:
+                return self._run_worker()
+            
             # Main sanitization mode
             return self._sanitize_files()
             
@@ -219,6 +223,31 @@ class LogVeilAgent:
             self.console.print(f"[red]Server error:[/red] {str(e)}")
             return 1
     
+    def _run_worker(self) -> int:
+        """
+        Serve redaction requests from the Go bridge over stdio.
+        
+        Each request is a JSON object {"id": int, "line": str} on its own line;
+        each response echoes the id with either "line" or "error".
+        """
+        for raw in sys.stdin:
+            if not raw.strip():
+                continue
+            
+            request_id = None
+            try:
+                request = json.loads(raw)
+                request_id = request.get("id")
+                redacted_line, _ = self.redaction_engine.redact_line(request["line"])
+                response = {"id": request_id, "line": redacted_line}
+            except Exception as e:
+                response = {"id": request_id, "error": str(e)}
+            
+            sys.stdout.write(json.dumps(response) + "\n")
+            sys.stdout.flush()
+        
+        return 0
+    
     def _sanitize_files(self) -> int:
         """Main file sanitization process."""
         input_path = Path(self.args.input)